@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -12,6 +14,8 @@ import (
 	"superagent/internal/agent"
 	"superagent/internal/api"
 	"superagent/internal/config"
+	"superagent/internal/deploy"
+	"superagent/internal/deploy/docker"
 	"superagent/internal/logging"
 
 	"github.com/sirupsen/logrus"
@@ -54,6 +58,15 @@ deployment capabilities similar to Vercel but with enterprise security and gover
 	rootCmd.AddCommand(deployCmd())
 	rootCmd.AddCommand(listCmd())
 	rootCmd.AddCommand(logsCmd())
+	rootCmd.AddCommand(rollbackCmd())
+	rootCmd.AddCommand(cancelCmd())
+	rootCmd.AddCommand(revisionsCmd())
+	rootCmd.AddCommand(applyCmd())
+	rootCmd.AddCommand(gcCmd())
+	rootCmd.AddCommand(recommendCmd())
+	rootCmd.AddCommand(composeCmd())
+	rootCmd.AddCommand(jobCmd())
+	rootCmd.AddCommand(secretCmd())
 	rootCmd.AddCommand(installCmd())
 	rootCmd.AddCommand(uninstallCmd())
 
@@ -136,6 +149,8 @@ func deployCmd() *cobra.Command {
 		source     string
 		branch     string
 		tag        string
+		detach     bool
+		dryRun     bool
 	)
 
 	cmd := &cobra.Command{
@@ -164,8 +179,8 @@ func deployCmd() *cobra.Command {
 					"replicas": 1,
 				},
 				"resource_limits": map[string]interface{}{
-					"cpu_limit":    "1",
-					"memory_limit": "1G",
+					"cpu_limit":    1.0,
+					"memory_limit": 1 << 30,
 				},
 				"health_check": map[string]interface{}{
 					"enabled": true,
@@ -173,8 +188,17 @@ func deployCmd() *cobra.Command {
 					"path":    "/health",
 					"port":    8080,
 				},
+				"triggered_by": cliUser(),
 			}
 			
+			if dryRun {
+				plan, err := client.PlanDeployment(deploymentRequest)
+				if err != nil {
+					return fmt.Errorf("failed to plan deployment: %w", err)
+				}
+				return printPlan(plan)
+			}
+
 			fmt.Printf("Deploying %s version %s...\n", appID, version)
 			fmt.Printf("Source: %s (%s)\n", source, sourceType)
 			if branch != "" {
@@ -183,126 +207,1522 @@ func deployCmd() *cobra.Command {
 			if tag != "" {
 				fmt.Printf("Tag: %s\n", tag)
 			}
-			
-			deployment, err := client.CreateDeployment(deploymentRequest)
+			
+			deployment, err := client.CreateDeployment(deploymentRequest)
+			if err != nil {
+				return fmt.Errorf("failed to create deployment: %w", err)
+			}
+			
+			fmt.Printf("Deployment created successfully: %s\n", deployment.ID)
+			fmt.Printf("Status: %s\n", deployment.Status)
+			fmt.Printf("Message: %s\n", deployment.Message)
+
+			if detach {
+				return nil
+			}
+
+			return watchDeployment(client, deployment.ID)
+		},
+	}
+
+	cmd.Flags().StringVar(&appID, "app", "", "Application ID (required)")
+	cmd.Flags().StringVar(&version, "version", "", "Application version (required)")
+	cmd.Flags().StringVar(&sourceType, "source-type", "git", "Source type (git or docker)")
+	cmd.Flags().StringVar(&source, "source", "", "Source repository URL or Docker image (required)")
+	cmd.Flags().StringVar(&branch, "branch", "", "Git branch (for git source)")
+	cmd.Flags().StringVar(&tag, "tag", "", "Git tag or Docker tag")
+	cmd.Flags().BoolVarP(&detach, "detach", "d", false, "Return once the deployment is created instead of following its progress")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Validate the deployment and show the containers it would create without deploying")
+
+	cmd.MarkFlagRequired("app")
+	cmd.MarkFlagRequired("version")
+	cmd.MarkFlagRequired("source")
+
+	return cmd
+}
+
+func listCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List deployments",
+		Long:  "List all active deployments managed by SuperAgent",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+			
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+			
+			deployments, err := client.ListDeployments()
+			if err != nil {
+				return fmt.Errorf("failed to list deployments: %w", err)
+			}
+			
+			if len(deployments) == 0 {
+				fmt.Println("No active deployments found")
+				return nil
+			}
+			
+			fmt.Println("Active Deployments:")
+			fmt.Printf("  %-20s %-12s %-10s %-12s %-20s\n", "ID", "APP", "VERSION", "STATUS", "CREATED")
+			fmt.Println("  " + strings.Repeat("-", 76))
+			
+			for _, d := range deployments {
+				createdAt := d.CreatedAt.Format("2006-01-02 15:04:05")
+				fmt.Printf("  %-20s %-12s %-10s %-12s %-20s\n", 
+					truncateString(d.ID, 20),
+					truncateString(d.AppID, 12),
+					truncateString(d.Version, 10),
+					d.Status,
+					createdAt)
+			}
+			
+			return nil
+		},
+	}
+}
+
+func logsCmd() *cobra.Command {
+	var (
+		deploymentID string
+		follow       bool
+		tail         int
+		since        string
+		until        string
+		source       string
+		limit        int
+		nextToken    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Show deployment logs",
+		Long:  "Show the build, deployment and container logs of a deployment. Container output is kept in rotated files per deployment, so it survives agent restarts.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			options := api.LogsOptions{
+				Tail:      tail,
+				Since:     since,
+				Until:     until,
+				Source:    source,
+				Limit:     limit,
+				NextToken: nextToken,
+			}
+
+			if follow {
+				ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+				defer stop()
+
+				fmt.Printf("Following logs for deployment: %s (Ctrl+C to stop)...\n", deploymentID)
+				err := client.FollowDeploymentLogs(ctx, deploymentID, options, func(entry api.LogEntry) bool {
+					printLogEntry(entry)
+					return true
+				})
+				if err != nil {
+					return fmt.Errorf("failed to follow logs: %w", err)
+				}
+				return nil
+			}
+
+			logs, err := client.GetDeploymentLogs(deploymentID, options)
+			if err != nil {
+				return fmt.Errorf("failed to get logs: %w", err)
+			}
+
+			fmt.Printf("Showing logs for deployment: %s\n", deploymentID)
+
+			if len(logs.Logs) == 0 {
+				fmt.Println("No logs found for this deployment")
+				return nil
+			}
+
+			for _, log := range logs.Logs {
+				printLogEntry(log)
+			}
+
+			if logs.HasMore {
+				fmt.Printf("\nMore logs available, continue with --next-token %s\n", logs.NextToken)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&deploymentID, "deployment", "", "Deployment ID (required)")
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow log output")
+	cmd.Flags().IntVarP(&tail, "tail", "t", 100, "Number of lines to show, 0 to page from the start")
+	cmd.Flags().StringVar(&since, "since", "", "Show logs since a time (RFC 3339) or for a duration back (e.g. 30m, 2d)")
+	cmd.Flags().StringVar(&until, "until", "", "Show logs until a time (RFC 3339) or a duration back (e.g. 30m, 2d)")
+	cmd.Flags().StringVar(&source, "source", "", "Only show build, deployment or container logs")
+	cmd.Flags().IntVar(&limit, "limit", 0, "Container log lines per page when paging")
+	cmd.Flags().StringVar(&nextToken, "next-token", "", "Continue from where a previous page ended")
+
+	cmd.MarkFlagRequired("deployment")
+
+	return cmd
+}
+
+// printLogEntry prints a log entry, with the container and stream it came
+// from for container output
+func printLogEntry(entry api.LogEntry) {
+	source := entry.Source
+	if entry.ContainerID != "" {
+		source = fmt.Sprintf("%s %s", entry.ContainerID[:min(12, len(entry.ContainerID))], entry.Stream)
+	}
+
+	fmt.Printf("[%s] [%s] [%s] %s\n",
+		entry.Timestamp.Format("2006-01-02 15:04:05"),
+		entry.Level,
+		source,
+		entry.Message)
+}
+
+func rollbackCmd() *cobra.Command {
+	var deploymentID string
+
+	cmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Roll back a deployment",
+		Long:  "Roll back a deployment to the previously deployed version of its application",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			rollback, err := client.RollbackDeployment(deploymentID)
+			if err != nil {
+				return fmt.Errorf("failed to rollback deployment: %w", err)
+			}
+
+			fmt.Printf("Deployment %s rolled back\n", rollback.ID)
+			fmt.Printf("Previous version: %s\n", rollback.PreviousVersion)
+			fmt.Printf("Restored version: %s\n", rollback.RestoredVersion)
+			fmt.Printf("Restored image: %s\n", rollback.RestoredImageID)
+			fmt.Printf("Container ID: %s\n", rollback.ContainerID)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&deploymentID, "deployment", "", "Deployment ID (required)")
+
+	cmd.MarkFlagRequired("deployment")
+
+	return cmd
+}
+
+func cancelCmd() *cobra.Command {
+	var (
+		deploymentID string
+		wait         bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "cancel",
+		Short: "Cancel an in-flight deployment",
+		Long:  "Cancel a deployment that is still being built, pulled or rolled out and clean up what it created",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			if err := client.CancelDeployment(deploymentID); err != nil {
+				return fmt.Errorf("failed to cancel deployment: %w", err)
+			}
+
+			fmt.Printf("Cancellation of deployment %s requested\n", deploymentID)
+
+			if !wait {
+				return nil
+			}
+
+			return watchDeployment(client, deploymentID)
+		},
+	}
+
+	cmd.Flags().StringVar(&deploymentID, "deployment", "", "Deployment ID (required)")
+	cmd.Flags().BoolVar(&wait, "wait", false, "Follow the deployment until the cancellation completes")
+
+	cmd.MarkFlagRequired("deployment")
+
+	return cmd
+}
+
+func revisionsCmd() *cobra.Command {
+	var appID string
+
+	revisionsCmd := &cobra.Command{
+		Use:   "revisions",
+		Short: "Show the revision history of an application",
+		Long:  "List every deployment of an application with its image, source commit, trigger and outcome",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			revisions, err := client.ListRevisions(appID)
+			if err != nil {
+				return fmt.Errorf("failed to list revisions: %w", err)
+			}
+
+			fmt.Printf("Revisions of %s:\n", appID)
+			fmt.Printf("  %-5s %-10s %-11s %-12s %-14s %-20s %-20s\n", "REV", "VERSION", "OUTCOME", "COMMIT", "IMAGE", "TRIGGERED BY", "CREATED")
+			fmt.Println("  " + strings.Repeat("-", 98))
+
+			for _, revision := range revisions {
+				number := fmt.Sprintf("%d", revision.Number)
+				if revision.RedeployOf > 0 {
+					number = fmt.Sprintf("%d<%d", revision.Number, revision.RedeployOf)
+				}
+				fmt.Printf("  %-5s %-10s %-11s %-12s %-14s %-20s %-20s\n",
+					number,
+					truncateString(revision.Version, 10),
+					revision.Outcome,
+					truncateString(revision.SourceCommit, 12),
+					truncateString(strings.TrimPrefix(revision.ImageID, "sha256:"), 14),
+					truncateString(revision.TriggeredBy, 20),
+					revision.CreatedAt.Format("2006-01-02 15:04:05"))
+			}
+
+			return nil
+		},
+	}
+
+	revisionsCmd.PersistentFlags().StringVar(&appID, "app", "", "Application ID (required)")
+	revisionsCmd.MarkPersistentFlagRequired("app")
+
+	var from, to int
+	diffCmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Show what changed between two revisions",
+		Long:  "Compare the image, source and configuration of two revisions of an application (environment values are not shown)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			diff, err := client.DiffRevisions(appID, from, to)
+			if err != nil {
+				return fmt.Errorf("failed to diff revisions: %w", err)
+			}
+
+			if len(diff.Changes) == 0 {
+				fmt.Printf("Revisions %d and %d of %s are identical\n", from, to, appID)
+				return nil
+			}
+
+			fmt.Printf("Changes from revision %d to %d of %s:\n", from, to, appID)
+			printChanges(diff.Changes)
+
+			return nil
+		},
+	}
+	diffCmd.Flags().IntVar(&from, "from", 0, "Revision to compare from (required)")
+	diffCmd.Flags().IntVar(&to, "to", 0, "Revision to compare to (required)")
+	diffCmd.MarkFlagRequired("from")
+	diffCmd.MarkFlagRequired("to")
+	revisionsCmd.AddCommand(diffCmd)
+
+	var (
+		revision int
+		detach   bool
+	)
+	redeployCmd := &cobra.Command{
+		Use:   "redeploy",
+		Short: "Deploy a past revision again",
+		Long:  "Deploy a past revision of an application again with the same image, source commit and configuration",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			deployment, err := client.RedeployRevision(appID, revision, cliUser())
+			if err != nil {
+				return fmt.Errorf("failed to redeploy revision: %w", err)
+			}
+
+			fmt.Printf("Redeploying revision %d of %s as deployment %s\n", revision, appID, deployment.ID)
+			fmt.Printf("Version: %s\n", deployment.Version)
+
+			if detach {
+				return nil
+			}
+
+			return watchDeployment(client, deployment.ID)
+		},
+	}
+	redeployCmd.Flags().IntVar(&revision, "revision", 0, "Revision to redeploy (required)")
+	redeployCmd.Flags().BoolVarP(&detach, "detach", "d", false, "Return once the deployment is created instead of following its progress")
+	redeployCmd.MarkFlagRequired("revision")
+	revisionsCmd.AddCommand(redeployCmd)
+
+	return revisionsCmd
+}
+
+func applyCmd() *cobra.Command {
+	var (
+		files  []string
+		detach bool
+		dryRun bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Deploy applications from manifests",
+		Long: `Deploy the applications described in YAML or JSON manifests. Each manifest is compared
+with the revision its application currently runs and only deployed when something changed.
+A file may hold several manifests separated by "---". Use "-" to read from stdin.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			var manifests []deploy.DeploymentRequest
+			seen := make(map[string]string)
+
+			for _, file := range files {
+				data, err := readManifestFile(file)
+				if err != nil {
+					return err
+				}
+
+				parsed, err := deploy.ParseManifests(data)
+				if err != nil {
+					return fmt.Errorf("failed to parse %s: %w", file, err)
+				}
+
+				for _, manifest := range parsed {
+					if other, exists := seen[manifest.AppID]; exists {
+						return fmt.Errorf("app %s is defined in both %s and %s", manifest.AppID, other, file)
+					}
+					seen[manifest.AppID] = file
+				}
+				manifests = append(manifests, parsed...)
+			}
+
+			var (
+				started []string
+				invalid []string
+			)
+			for i := range manifests {
+				manifest := &manifests[i]
+				if manifest.TriggeredBy == "" {
+					manifest.TriggeredBy = cliUser()
+				}
+
+				result, err := client.Apply(manifest, dryRun)
+				if err != nil {
+					return fmt.Errorf("failed to apply %s: %w", manifest.AppID, err)
+				}
+
+				action := "deploying"
+				if dryRun {
+					action = "would deploy"
+				}
+
+				switch {
+				case !result.Changed:
+					fmt.Printf("%s: unchanged, revision %d is up to date\n", result.AppID, result.Revision)
+					continue
+				case result.Revision == 0:
+					fmt.Printf("%s: not running yet, %s version %s\n", result.AppID, action, manifest.Version)
+				default:
+					fmt.Printf("%s: %d changes from revision %d, %s version %s\n", result.AppID, len(result.Changes), result.Revision, action, manifest.Version)
+					printChanges(result.Changes)
+				}
+
+				if result.Plan != nil {
+					if err := printPlan(result.Plan); err != nil {
+						invalid = append(invalid, result.AppID)
+					}
+					fmt.Println()
+					continue
+				}
+
+				fmt.Printf("Deployment created successfully: %s\n", result.Deployment.ID)
+				started = append(started, result.Deployment.ID)
+			}
+
+			if len(invalid) > 0 {
+				return fmt.Errorf("dry run rejected %s", strings.Join(invalid, ", "))
+			}
+
+			if detach || dryRun {
+				return nil
+			}
+
+			for _, deploymentID := range started {
+				if err := watchDeployment(client, deploymentID); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&files, "filename", "f", nil, "Manifest file to apply, may be repeated (required)")
+	cmd.Flags().BoolVarP(&detach, "detach", "d", false, "Return once the deployments are created instead of following their progress")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would change and the containers it would create without deploying")
+	cmd.MarkFlagRequired("filename")
+
+	return cmd
+}
+
+// readManifestFile reads a manifest from a file, or from stdin for "-"
+func readManifestFile(file string) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", file, err)
+	}
+	return data, nil
+}
+
+// printPlan describes what a dry run found. It returns an error when the
+// deployment would be rejected.
+func printPlan(plan *deploy.DeploymentPlan) error {
+	fmt.Printf("Dry run of %s version %s\n", plan.AppID, plan.Version)
+
+	strategy := plan.Strategy
+	if strategy == "" {
+		strategy = "default"
+	}
+	if plan.Replaces != "" {
+		fmt.Printf("  Strategy: %s, replacing %s\n", strategy, plan.Replaces)
+	} else {
+		fmt.Printf("  Strategy: %s\n", strategy)
+	}
+
+	fmt.Printf("  Image:    %s (%s)\n", plan.Image, plan.ImageAction)
+	if plan.ImageID != "" {
+		fmt.Printf("  Image ID: %s\n", plan.ImageID)
+	}
+	if plan.SourceCommit != "" {
+		fmt.Printf("  Commit:   %s\n", plan.SourceCommit)
+	}
+
+	fmt.Println("  Containers:")
+	for _, container := range plan.Containers {
+		printPlannedContainer(container)
+	}
+	if len(plan.HookContainers) > 0 {
+		fmt.Println("  Hook containers:")
+		for _, container := range plan.HookContainers {
+			printPlannedContainer(container)
+		}
+	}
+
+	for _, warning := range plan.Warnings {
+		fmt.Printf("  Warning: %s\n", warning)
+	}
+	for _, problem := range plan.Errors {
+		fmt.Printf("  Error: %s\n", problem)
+	}
+
+	if !plan.Valid {
+		return fmt.Errorf("dry run found %d problems, nothing was deployed", len(plan.Errors))
+	}
+
+	fmt.Println("Dry run passed, nothing was deployed")
+	return nil
+}
+
+// printPlannedContainer describes a container of a dry run. Environment
+// values are left out.
+func printPlannedContainer(container docker.ContainerConfig) {
+	fmt.Printf("    %s\n", container.Name)
+	fmt.Printf("      image: %s\n", container.Image)
+	if len(container.Command) > 0 || len(container.Args) > 0 {
+		fmt.Printf("      command: %s\n", strings.Join(append(append([]string{}, container.Command...), container.Args...), " "))
+	}
+	for _, port := range container.Ports {
+		fmt.Printf("      port: %s:%d -> %d/%s\n", port.HostIP, port.HostPort, port.ContainerPort, port.Protocol)
+	}
+	for _, volume := range container.Volumes {
+		mode := "rw"
+		if volume.ReadOnly {
+			mode = "ro"
+		}
+		fmt.Printf("      volume: %s -> %s (%s)\n", volume.Source, volume.Target, mode)
+	}
+	if len(container.Networks) > 0 {
+		fmt.Printf("      networks: %s\n", strings.Join(container.Networks, ", "))
+	}
+	if container.User != "" {
+		fmt.Printf("      user: %s\n", container.User)
+	}
+	if container.ResourceLimits.CPULimit > 0 || container.ResourceLimits.MemoryLimit > 0 {
+		fmt.Printf("      limits: %.2f CPUs, %d bytes memory\n", container.ResourceLimits.CPULimit, container.ResourceLimits.MemoryLimit)
+	}
+	if container.Privileged {
+		fmt.Println("      privileged: true")
+	}
+	if len(container.SecurityOpts) > 0 {
+		fmt.Printf("      security: %s\n", strings.Join(container.SecurityOpts, ", "))
+	}
+	if len(container.Environment) > 0 {
+		keys := make([]string, 0, len(container.Environment))
+		for key := range container.Environment {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Printf("      environment: %s\n", strings.Join(keys, ", "))
+	}
+}
+
+// printChanges lists the changes between two revisions
+func printChanges(changes []deploy.RevisionChange) {
+	for _, change := range changes {
+		switch change.Change {
+		case "added":
+			fmt.Printf("  + %s %s\n", change.Field, change.To)
+		case "removed":
+			fmt.Printf("  - %s %s\n", change.Field, change.From)
+		default:
+			if change.From == "" && change.To == "" {
+				fmt.Printf("  ~ %s (value changed)\n", change.Field)
+			} else {
+				fmt.Printf("  ~ %s: %s -> %s\n", change.Field, change.From, change.To)
+			}
+		}
+	}
+}
+
+func gcCmd() *cobra.Command {
+	var reportOnly bool
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove old deployments, revisions and images",
+		Long: `Apply the retention policy now: remove deployment records, revisions and images beyond
+the configured revision count, age or image disk usage. Revisions that are running or needed
+to roll back are always kept.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			report, err := client.CollectGarbage(reportOnly)
+			if err != nil {
+				return fmt.Errorf("failed to collect garbage: %w", err)
+			}
+
+			verb := "Removed"
+			if report.ReportOnly {
+				verb = "Would remove"
+			}
+
+			if len(report.Revisions) == 0 && len(report.Images) == 0 {
+				fmt.Println("Nothing to remove")
+			}
+
+			if len(report.Revisions) > 0 {
+				fmt.Printf("%s %d revisions:\n", verb, len(report.Revisions))
+				fmt.Printf("  %-20s %-5s %-10s %-20s %-7s\n", "APP", "REV", "VERSION", "DEPLOYMENT", "REASON")
+				fmt.Println("  " + strings.Repeat("-", 66))
+				for _, revision := range report.Revisions {
+					number := "-"
+					if revision.Number > 0 {
+						number = fmt.Sprintf("%d", revision.Number)
+					}
+					fmt.Printf("  %-20s %-5s %-10s %-20s %-7s\n",
+						truncateString(revision.AppID, 20),
+						number,
+						truncateString(revision.Version, 10),
+						truncateString(revision.DeploymentID, 20),
+						revision.Reason)
+				}
+			}
+
+			if len(report.Images) > 0 {
+				fmt.Printf("%s %d images:\n", verb, len(report.Images))
+				for _, image := range report.Images {
+					fmt.Printf("  %-14s %-40s %s\n", truncateString(strings.TrimPrefix(image.ID, "sha256:"), 14), image.Tag, formatBytes(image.Size))
+				}
+			}
+
+			fmt.Printf("Reclaimed: %s\n", formatBytes(report.ReclaimedBytes))
+			fmt.Printf("Kept: %d revisions, %d images (%s)\n", report.KeptRevisions, report.KeptImages, formatBytes(report.ImageDiskUsage))
+			if report.PrunedDangling {
+				fmt.Println("Pruned untagged images")
+			}
+
+			for _, message := range report.Errors {
+				fmt.Printf("Error: %s\n", message)
+			}
+			if len(report.Errors) > 0 {
+				return fmt.Errorf("garbage collection finished with %d errors", len(report.Errors))
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&reportOnly, "report-only", false, "Only report what would be removed")
+
+	return cmd
+}
+
+func recommendCmd() *cobra.Command {
+	var window string
+	var percentile float64
+	var apply bool
+
+	cmd := &cobra.Command{
+		Use:   "recommend <deployment-id>",
+		Short: "Suggest resource limits from recorded usage",
+		Long: `Size the CPU and memory limits of a deployment to a percentile of the usage of its busiest
+replica, with headroom, and show how efficiently the current and suggested limits are used.
+With --apply the running revision is deployed again with the suggested limits.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			var recommendation *deploy.ResourceRecommendation
+			var err error
+			if apply {
+				recommendation, err = client.ApplyRecommendation(args[0], window, percentile, cliUser())
+			} else {
+				recommendation, err = client.RecommendResources(args[0], window, percentile)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to recommend resources: %w", err)
+			}
+
+			fmt.Printf("Deployment: %s (app %s)\n", recommendation.DeploymentID, recommendation.AppID)
+			fmt.Printf("Usage: p%g of %d samples over %s\n", recommendation.Percentile, recommendation.Samples, recommendation.Window)
+			fmt.Println()
+			fmt.Printf("%-8s %-14s %-14s %-14s\n", "", "USAGE", "CURRENT", "RECOMMENDED")
+			fmt.Printf("%-8s %-14s %-14s %-14s\n", "CPU",
+				fmt.Sprintf("%.2f cores", recommendation.Usage.CPUUsage/100),
+				formatCPULimit(recommendation.Current.CPULimit),
+				formatCPULimit(recommendation.Recommended.CPULimit))
+			fmt.Printf("%-8s %-14s %-14s %-14s\n", "Memory",
+				formatBytes(recommendation.Usage.MemoryUsage),
+				formatMemoryLimit(recommendation.Current.MemoryLimit),
+				formatMemoryLimit(recommendation.Recommended.MemoryLimit))
+			fmt.Println()
+			fmt.Printf("Efficiency: %s now, %s recommended\n",
+				formatEfficiency(recommendation.Efficiency),
+				formatEfficiency(recommendation.RecommendedEfficiency))
+
+			if recommendation.AppliedDeploymentID != "" {
+				fmt.Printf("Deploying the recommended limits as %s\n", recommendation.AppliedDeploymentID)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&window, "window", "", "Usage to look at, e.g. 24h or 7d (default 7d)")
+	cmd.Flags().Float64Var(&percentile, "percentile", 0, "Usage percentile to size limits to (default 95)")
+	cmd.Flags().BoolVar(&apply, "apply", false, "Deploy the running revision again with the recommended limits")
+
+	return cmd
+}
+
+// formatCPULimit prints a CPU limit in cores
+func formatCPULimit(cores float64) string {
+	if cores <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%.2f cores", cores)
+}
+
+// formatMemoryLimit prints a memory limit
+func formatMemoryLimit(size int64) string {
+	if size <= 0 {
+		return "unlimited"
+	}
+	return formatBytes(size)
+}
+
+// formatEfficiency prints how much of its limits a usage takes
+func formatEfficiency(efficiency map[string]float64) string {
+	var parts []string
+	for _, resource := range []string{"cpu", "memory", "overall"} {
+		if value, exists := efficiency[resource]; exists {
+			parts = append(parts, fmt.Sprintf("%s %.0f%%", resource, value*100))
+		}
+	}
+	if len(parts) == 0 {
+		return "unknown"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func composeCmd() *cobra.Command {
+	composeCmd := &cobra.Command{
+		Use:   "compose",
+		Short: "Manage applications deployed from docker-compose files",
+		Long: `Deploy the services of a docker-compose file as a group of linked applications. Each
+service runs as application <app>-<service> on networks of the group and reaches the others
+by their service name.`,
+	}
+
+	var (
+		file        string
+		appID       string
+		appVersion  string
+		repository  string
+		branch      string
+		buildPath   string
+		environment []string
+		detach      bool
+	)
+	upCmd := &cobra.Command{
+		Use:   "up",
+		Short: "Deploy the services of a compose file",
+		Long: `Deploy the services of a compose file in dependency order. Services whose definition did
+not change keep running. Services built from source are built from --repo. Variables in the
+file are taken from --env.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read compose file %s: %w", file, err)
+			}
+
+			env := make(map[string]string, len(environment))
+			for _, entry := range environment {
+				key, value, found := strings.Cut(entry, "=")
+				if !found {
+					return fmt.Errorf("invalid environment variable %q, expected KEY=VALUE", entry)
+				}
+				env[key] = value
+			}
+
+			request := &deploy.DeploymentRequest{
+				AppID:   appID,
+				Version: appVersion,
+				Source: deploy.DeploymentSource{
+					Type:       "compose",
+					Repository: repository,
+					Branch:     branch,
+					BuildPath:  buildPath,
+					Compose:    string(data),
+				},
+				Environment: env,
+				TriggeredBy: cliUser(),
+			}
+
+			group, err := client.DeployGroup(request)
+			if err != nil {
+				return fmt.Errorf("failed to deploy group: %w", err)
+			}
+
+			for _, warning := range group.Warnings {
+				fmt.Printf("Warning: %s\n", warning)
+			}
+			fmt.Printf("Deploying %d services of %s version %s\n", len(group.Services), group.AppID, group.Version)
+
+			if detach {
+				return nil
+			}
+
+			return watchGroup(client, group.AppID)
+		},
+	}
+	upCmd.Flags().StringVarP(&file, "file", "f", "docker-compose.yml", "Compose file")
+	upCmd.Flags().StringVar(&appID, "app", "", "Application ID of the group (required)")
+	upCmd.Flags().StringVar(&appVersion, "version", "", "Application version (required)")
+	upCmd.Flags().StringVar(&repository, "repo", "", "Git repository services with a build section are built from")
+	upCmd.Flags().StringVar(&branch, "branch", "", "Git branch to build from")
+	upCmd.Flags().StringVar(&buildPath, "build-path", "", "Directory of the compose file within the repository")
+	upCmd.Flags().StringArrayVarP(&environment, "env", "e", nil, "Variable for the compose file as KEY=VALUE, may be repeated")
+	upCmd.Flags().BoolVarP(&detach, "detach", "d", false, "Return once the group deployment started instead of following its progress")
+	upCmd.MarkFlagRequired("app")
+	upCmd.MarkFlagRequired("version")
+	composeCmd.AddCommand(upCmd)
+
+	composeCmd.AddCommand(&cobra.Command{
+		Use:   "ps [app]",
+		Short: "Show groups and the status of their services",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			var groups []*deploy.DeploymentGroup
+			if len(args) == 1 {
+				group, err := client.GetGroup(args[0])
+				if err != nil {
+					return fmt.Errorf("failed to get group: %w", err)
+				}
+				groups = append(groups, group)
+			} else {
+				var err error
+				if groups, err = client.ListGroups(); err != nil {
+					return fmt.Errorf("failed to list groups: %w", err)
+				}
+			}
+
+			if len(groups) == 0 {
+				fmt.Println("No groups found")
+				return nil
+			}
+
+			for _, group := range groups {
+				printGroup(group)
+			}
+
+			return nil
+		},
+	})
+
+	composeCmd.AddCommand(&cobra.Command{
+		Use:   "stop <app>",
+		Short: "Stop the services of a group",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			if err := client.StopGroup(args[0]); err != nil {
+				return fmt.Errorf("failed to stop group: %w", err)
+			}
+
+			fmt.Printf("Group %s stopped\n", args[0])
+			return nil
+		},
+	})
+
+	composeCmd.AddCommand(&cobra.Command{
+		Use:   "down <app>",
+		Short: "Remove the services and networks of a group",
+		Long:  "Remove the services and networks of a group. Named volumes are kept.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			if err := client.RemoveGroup(args[0]); err != nil {
+				return fmt.Errorf("failed to remove group: %w", err)
+			}
+
+			fmt.Printf("Group %s removed\n", args[0])
+			return nil
+		},
+	})
+
+	composeCmd.AddCommand(&cobra.Command{
+		Use:   "rollback <app>",
+		Short: "Roll back the last release of a group",
+		Long:  "Roll back every service the last release of a group changed. Services it added are removed.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			group, err := client.RollbackGroup(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to rollback group: %w", err)
+			}
+
+			fmt.Printf("Group %s rolled back\n", group.AppID)
+			printGroup(group)
+			return nil
+		},
+	})
+
+	return composeCmd
+}
+
+func jobCmd() *cobra.Command {
+	jobCmd := &cobra.Command{
+		Use:   "job",
+		Short: "Manage jobs that run to completion",
+		Long: `Run containers to completion, once or on a cron schedule. Failed attempts are retried with
+a growing delay up to the backoff limit. The exit code, duration and logs of every run are kept.`,
+	}
+
+	var (
+		files  []string
+		detach bool
+	)
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create or update jobs from spec files",
+		Long: `Create or update the jobs described in YAML or JSON files. A job without a schedule runs
+right away. A file may hold several jobs separated by "---". Use "-" to read from stdin.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			var specs []deploy.JobSpec
+			for _, file := range files {
+				data, err := readManifestFile(file)
+				if err != nil {
+					return err
+				}
+
+				parsed, err := deploy.ParseJobSpecs(data)
+				if err != nil {
+					return fmt.Errorf("failed to parse %s: %w", file, err)
+				}
+				specs = append(specs, parsed...)
+			}
+
+			var started []*deploy.JobRun
+			for i := range specs {
+				spec := &specs[i]
+				if spec.TriggeredBy == "" {
+					spec.TriggeredBy = cliUser()
+				}
+
+				job, run, err := client.CreateJob(spec)
+				if err != nil {
+					return fmt.Errorf("failed to create job %s: %w", spec.Name, err)
+				}
+
+				switch {
+				case run != nil:
+					fmt.Printf("%s: running as %s\n", job.Spec.Name, run.ID)
+					started = append(started, run)
+				case job.NextRunAt != nil:
+					fmt.Printf("%s: scheduled %q, next run at %s\n", job.Spec.Name, job.Spec.Schedule, job.NextRunAt.Format("2006-01-02 15:04:05 MST"))
+				default:
+					fmt.Printf("%s: scheduled %q, suspended\n", job.Spec.Name, job.Spec.Schedule)
+				}
+			}
+
+			if detach {
+				return nil
+			}
+
+			for _, run := range started {
+				if err := watchJobRun(client, run.Job, run.ID); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+	createCmd.Flags().StringSliceVarP(&files, "filename", "f", nil, "Job spec file, may be repeated (required)")
+	createCmd.Flags().BoolVarP(&detach, "detach", "d", false, "Return once the jobs are created instead of following the runs they started")
+	createCmd.MarkFlagRequired("filename")
+	jobCmd.AddCommand(createCmd)
+
+	jobCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List jobs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			jobs, err := client.ListJobs()
+			if err != nil {
+				return fmt.Errorf("failed to list jobs: %w", err)
+			}
+
+			if len(jobs) == 0 {
+				fmt.Println("No jobs found")
+				return nil
+			}
+
+			fmt.Printf("  %-20s %-16s %-8s %-20s %-20s %-10s\n", "NAME", "SCHEDULE", "POLICY", "NEXT RUN", "LAST RUN", "STATUS")
+			fmt.Println("  " + strings.Repeat("-", 99))
+			for _, job := range jobs {
+				schedule, next := "-", "-"
+				if job.Spec.Schedule != "" {
+					schedule = job.Spec.Schedule
+				}
+				switch {
+				case job.Spec.Suspended:
+					next = "suspended"
+				case job.NextRunAt != nil:
+					next = job.NextRunAt.Format("2006-01-02 15:04:05")
+				}
+
+				last, status := "-", "-"
+				if len(job.Runs) > 0 {
+					last = job.Runs[0].ID
+					status = job.Runs[0].Status
+				}
+
+				fmt.Printf("  %-20s %-16s %-8s %-20s %-20s %-10s\n",
+					truncateString(job.Spec.Name, 20),
+					truncateString(schedule, 16),
+					job.Spec.ConcurrencyPolicy,
+					next,
+					truncateString(last, 20),
+					status)
+			}
+
+			return nil
+		},
+	})
+
+	jobCmd.AddCommand(&cobra.Command{
+		Use:   "history <name>",
+		Short: "Show the runs of a job",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			job, err := client.GetJob(args[0])
 			if err != nil {
-				return fmt.Errorf("failed to create deployment: %w", err)
+				return fmt.Errorf("failed to get job: %w", err)
 			}
-			
-			fmt.Printf("Deployment created successfully: %s\n", deployment.ID)
-			fmt.Printf("Status: %s\n", deployment.Status)
-			fmt.Printf("Message: %s\n", deployment.Message)
+
+			fmt.Printf("Runs of %s:\n", job.Spec.Name)
+			fmt.Printf("  %-20s %-9s %-10s %-8s %-5s %-10s %-20s\n", "RUN", "TRIGGER", "STATUS", "ATTEMPTS", "EXIT", "DURATION", "STARTED")
+			fmt.Println("  " + strings.Repeat("-", 88))
+			for _, run := range job.Runs {
+				exitCode, started := "-", "-"
+				if run.ExitCode >= 0 {
+					exitCode = fmt.Sprintf("%d", run.ExitCode)
+				}
+				if run.StartedAt != nil {
+					started = run.StartedAt.Format("2006-01-02 15:04:05")
+				}
+
+				fmt.Printf("  %-20s %-9s %-10s %-8d %-5s %-10s %-20s\n",
+					truncateString(run.ID, 20),
+					run.Trigger,
+					run.Status,
+					len(run.Attempts),
+					exitCode,
+					run.Duration.Round(time.Second),
+					started)
+				if run.Error != "" {
+					fmt.Printf("    %s\n", run.Error)
+				}
+			}
+
 			return nil
 		},
-	}
+	})
 
-	cmd.Flags().StringVar(&appID, "app", "", "Application ID (required)")
-	cmd.Flags().StringVar(&version, "version", "", "Application version (required)")
-	cmd.Flags().StringVar(&sourceType, "source-type", "git", "Source type (git or docker)")
-	cmd.Flags().StringVar(&source, "source", "", "Source repository URL or Docker image (required)")
-	cmd.Flags().StringVar(&branch, "branch", "", "Git branch (for git source)")
-	cmd.Flags().StringVar(&tag, "tag", "", "Git tag or Docker tag")
+	jobCmd.AddCommand(&cobra.Command{
+		Use:   "logs <name> <run>",
+		Short: "Show the logs of a run",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
 
-	cmd.MarkFlagRequired("app")
-	cmd.MarkFlagRequired("version")
-	cmd.MarkFlagRequired("source")
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
 
-	return cmd
-}
+			run, err := client.GetJobRun(args[0], args[1])
+			if err != nil {
+				return fmt.Errorf("failed to get job run: %w", err)
+			}
 
-func listCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "list",
-		Short: "List deployments",
-		Long:  "List all active deployments managed by SuperAgent",
+			if run.DroppedLogLines > 0 {
+				fmt.Printf("(%d earlier lines dropped)\n", run.DroppedLogLines)
+			}
+			for _, entry := range run.Logs {
+				printJobLog(entry)
+			}
+
+			return nil
+		},
+	})
+
+	var runDetach bool
+	runCmd := &cobra.Command{
+		Use:   "run <name>",
+		Short: "Run a job now",
+		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client := api.NewCLIClient(8080)
-			
+
 			if !client.IsAgentRunning() {
 				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
 			}
-			
-			deployments, err := client.ListDeployments()
+
+			run, err := client.RunJob(args[0], cliUser())
 			if err != nil {
-				return fmt.Errorf("failed to list deployments: %w", err)
+				return fmt.Errorf("failed to run job: %w", err)
 			}
-			
-			if len(deployments) == 0 {
-				fmt.Println("No active deployments found")
+
+			fmt.Printf("Job %s running as %s\n", args[0], run.ID)
+
+			if runDetach {
 				return nil
 			}
-			
-			fmt.Println("Active Deployments:")
-			fmt.Printf("  %-20s %-12s %-10s %-12s %-20s\n", "ID", "APP", "VERSION", "STATUS", "CREATED")
-			fmt.Println("  " + strings.Repeat("-", 76))
-			
-			for _, d := range deployments {
-				createdAt := d.CreatedAt.Format("2006-01-02 15:04:05")
-				fmt.Printf("  %-20s %-12s %-10s %-12s %-20s\n", 
-					truncateString(d.ID, 20),
-					truncateString(d.AppID, 12),
-					truncateString(d.Version, 10),
-					d.Status,
-					createdAt)
+
+			return watchJobRun(client, args[0], run.ID)
+		},
+	}
+	runCmd.Flags().BoolVarP(&runDetach, "detach", "d", false, "Return once the run started instead of following it")
+	jobCmd.AddCommand(runCmd)
+
+	jobCmd.AddCommand(&cobra.Command{
+		Use:   "cancel <name> <run>",
+		Short: "Cancel a run of a job",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
 			}
-			
+
+			if err := client.CancelJobRun(args[0], args[1]); err != nil {
+				return fmt.Errorf("failed to cancel job run: %w", err)
+			}
+
+			fmt.Printf("Cancellation of %s requested\n", args[1])
+			return nil
+		},
+	})
+
+	jobCmd.AddCommand(&cobra.Command{
+		Use:   "delete <name>",
+		Short: "Delete a job with its history",
+		Long:  "Delete a job with its run history. Runs still in progress are cancelled.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			if err := client.DeleteJob(args[0]); err != nil {
+				return fmt.Errorf("failed to delete job: %w", err)
+			}
+
+			fmt.Printf("Job %s deleted\n", args[0])
 			return nil
 		},
+	})
+
+	return jobCmd
+}
+
+// watchJobRun prints the logs of a run as they come in until it finishes
+func watchJobRun(client *api.CLIClient, name, runID string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Following run %s (Ctrl+C to stop)...\n", runID)
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	printed := 0
+	for {
+		run, err := client.GetJobRun(name, runID)
+		if err != nil {
+			return fmt.Errorf("failed to follow job run: %w", err)
+		}
+
+		// Lines are counted from the first the run wrote, dropped ones included
+		first := printed - run.DroppedLogLines
+		if first < 0 {
+			first = 0
+		}
+		for _, entry := range run.Logs[min(first, len(run.Logs)):] {
+			printJobLog(entry)
+		}
+		printed = run.DroppedLogLines + len(run.Logs)
+
+		if run.Finished() {
+			if run.Status != deploy.JobRunSucceeded {
+				return fmt.Errorf("job run %s %s: %s", runID, run.Status, run.Error)
+			}
+			fmt.Printf("Job run %s succeeded in %s\n", runID, run.Duration.Round(time.Millisecond))
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Println("Stopped following job run before it finished")
+			return nil
+		case <-ticker.C:
+		}
 	}
 }
 
-func logsCmd() *cobra.Command {
-	var (
-		deploymentID string
-		follow       bool
-		tail         int
-	)
+func printJobLog(entry deploy.LogEntry) {
+	fmt.Printf("[%s] [%s] [%s] %s\n", entry.Timestamp.Format("15:04:05"), entry.Level, entry.Source, entry.Message)
+}
 
-	cmd := &cobra.Command{
-		Use:   "logs",
-		Short: "Show deployment logs",
-		Long:  "Show logs for a specific deployment",
+func secretCmd() *cobra.Command {
+	secretCmd := &cobra.Command{
+		Use:   "secret",
+		Short: "Manage secrets",
+		Long: `Store values that must not be kept in plain text. Reference a secret from an environment as
+secret://<name>; its value is only resolved when the container is created and is never passed
+to image builds. Values are read from a file or stdin, never from the command line.`,
+	}
+
+	var createFile string
+	createCmd := &cobra.Command{
+		Use:   "create <name>",
+		Short: "Create a secret",
+		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			client := api.NewCLIClient(8080)
-			
+
 			if !client.IsAgentRunning() {
 				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
 			}
-			
-			logs, err := client.GetDeploymentLogs(deploymentID, tail)
+
+			value, err := readSecretValue(createFile)
 			if err != nil {
-				return fmt.Errorf("failed to get logs: %w", err)
+				return err
 			}
-			
-			fmt.Printf("Showing logs for deployment: %s\n", deploymentID)
-			if follow {
-				fmt.Println("Following logs (Ctrl+C to stop)...")
+
+			secret, err := client.CreateSecret(args[0], value, cliUser())
+			if err != nil {
+				return fmt.Errorf("failed to create secret: %w", err)
 			}
-			
-			if len(logs.Logs) == 0 {
-				fmt.Println("No logs found for this deployment")
+
+			fmt.Printf("Secret %s created, reference it as %s%s\n", secret.Name, deploy.SecretRefPrefix, secret.Name)
+			return nil
+		},
+	}
+	createCmd.Flags().StringVar(&createFile, "from-file", "", "Read the value from a file instead of stdin")
+	secretCmd.AddCommand(createCmd)
+
+	var rotateFile string
+	rotateCmd := &cobra.Command{
+		Use:   "rotate <name>",
+		Short: "Replace the value of a secret",
+		Long:  "Replace the value of a secret. Running containers keep the old value until they are redeployed.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			value, err := readSecretValue(rotateFile)
+			if err != nil {
+				return err
+			}
+
+			secret, err := client.RotateSecret(args[0], value, cliUser())
+			if err != nil {
+				return fmt.Errorf("failed to rotate secret: %w", err)
+			}
+
+			fmt.Printf("Secret %s rotated to version %d\n", secret.Name, secret.Version)
+			if len(secret.UsedBy) > 0 {
+				fmt.Println("Redeploy these to pick up the new value:")
+				for _, user := range secret.UsedBy {
+					fmt.Printf("  %s\n", user)
+				}
+			}
+			return nil
+		},
+	}
+	rotateCmd.Flags().StringVar(&rotateFile, "from-file", "", "Read the value from a file instead of stdin")
+	secretCmd.AddCommand(rotateCmd)
+
+	secretCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List secrets without their values",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			secrets, err := client.ListSecrets()
+			if err != nil {
+				return fmt.Errorf("failed to list secrets: %w", err)
+			}
+
+			if len(secrets) == 0 {
+				fmt.Println("No secrets found")
 				return nil
 			}
-			
-			for _, log := range logs.Logs {
-				fmt.Printf("[%s] [%s] [%s] %s\n",
-					log.Timestamp.Format("2006-01-02 15:04:05"),
-					log.Level,
-					log.Source,
-					log.Message)
+
+			fmt.Printf("  %-24s %-8s %-20s %s\n", "NAME", "VERSION", "UPDATED", "USED BY")
+			fmt.Println("  " + strings.Repeat("-", 70))
+			for _, secret := range secrets {
+				usedBy := "-"
+				if len(secret.UsedBy) > 0 {
+					usedBy = strings.Join(secret.UsedBy, ", ")
+				}
+
+				fmt.Printf("  %-24s %-8d %-20s %s\n",
+					truncateString(secret.Name, 24),
+					secret.Version,
+					secret.UpdatedAt.Format("2006-01-02 15:04:05"),
+					usedBy)
 			}
-			
+
+			return nil
+		},
+	})
+
+	secretCmd.AddCommand(&cobra.Command{
+		Use:   "delete <name>",
+		Short: "Delete a secret no deployment or job references",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewCLIClient(8080)
+
+			if !client.IsAgentRunning() {
+				return fmt.Errorf("SuperAgent is not running. Please start the agent first")
+			}
+
+			if err := client.DeleteSecret(args[0], cliUser()); err != nil {
+				return fmt.Errorf("failed to delete secret: %w", err)
+			}
+
+			fmt.Printf("Secret %s deleted\n", args[0])
 			return nil
 		},
+	})
+
+	return secretCmd
+}
+
+// readSecretValue reads the value of a secret from a file, or from stdin
+// when no file is given. A single trailing newline is dropped.
+func readSecretValue(file string) (string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if file == "" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret value: %w", err)
 	}
 
-	cmd.Flags().StringVar(&deploymentID, "deployment", "", "Deployment ID (required)")
-	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow log output")
-	cmd.Flags().IntVarP(&tail, "tail", "t", 100, "Number of lines to show")
+	value := strings.TrimSuffix(string(data), "\n")
+	value = strings.TrimSuffix(value, "\r")
+	if value == "" {
+		return "", fmt.Errorf("secret value is empty")
+	}
+	return value, nil
+}
 
-	cmd.MarkFlagRequired("deployment")
+// watchGroup follows a group deployment until it runs or failed
+func watchGroup(client *api.CLIClient, appID string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	return cmd
+	fmt.Println("Following group progress (Ctrl+C to stop)...")
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	reported := make(map[string]string)
+	for {
+		group, err := client.GetGroup(appID)
+		if err != nil {
+			return fmt.Errorf("failed to follow group: %w", err)
+		}
+
+		for _, service := range group.Services {
+			state := service.DeploymentID + "/" + string(service.Status)
+			if service.Status != "" && reported[service.Name] != state {
+				reported[service.Name] = state
+				fmt.Printf("[%s] %s: %s\n", time.Now().Format("15:04:05"), service.Name, service.Status)
+			}
+		}
+
+		switch group.Status {
+		case deploy.GroupRunning:
+			fmt.Printf("Group %s is running\n", appID)
+			return nil
+		case deploy.GroupFailed:
+			return fmt.Errorf("group %s failed: %s", appID, group.Error)
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Println("Stopped following group before it finished")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// printGroup lists the services of a group in their start order
+func printGroup(group *deploy.DeploymentGroup) {
+	fmt.Printf("%s version %s: %s\n", group.AppID, group.Version, group.Status)
+	if group.Error != "" {
+		fmt.Printf("  Error: %s\n", group.Error)
+	}
+	fmt.Printf("  %-16s %-24s %-20s %-12s %-20s\n", "SERVICE", "APP", "DEPLOYMENT", "STATUS", "DEPENDS ON")
+	fmt.Println("  " + strings.Repeat("-", 94))
+	for _, service := range group.Services {
+		status := string(service.Status)
+		if status == "" {
+			status = "-"
+		}
+		fmt.Printf("  %-16s %-24s %-20s %-12s %-20s\n",
+			truncateString(service.Name, 16),
+			truncateString(service.AppID, 24),
+			truncateString(service.DeploymentID, 20),
+			status,
+			strings.Join(service.DependsOn, ","))
+	}
 }
 
 func installCmd() *cobra.Command {
@@ -530,6 +1950,78 @@ func initConfig() error {
 	return nil
 }
 
+// watchDeployment prints the progress of a deployment until it settles
+func watchDeployment(client *api.CLIClient, deploymentID string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("Following deployment progress (Ctrl+C to stop)...")
+
+	var final deploy.DeploymentStatus
+	err := client.WatchDeploymentEvents(ctx, deploymentID, func(event deploy.Event) bool {
+		switch event.Type {
+		case deploy.EventStatus:
+			fmt.Printf("[%s] status: %s\n", event.Timestamp.Format("15:04:05"), event.Status)
+			if event.Terminal {
+				final = event.Status
+				return false
+			}
+		case deploy.EventLog:
+			if event.Log != nil {
+				fmt.Printf("[%s] [%s] [%s] %s\n", event.Log.Timestamp.Format("15:04:05"), event.Log.Level, event.Log.Source, event.Log.Message)
+			}
+		case deploy.EventHealth:
+			if event.Health == nil {
+				break
+			}
+			if event.Health.Healthy {
+				fmt.Printf("[%s] health: replica %d healthy\n", event.Timestamp.Format("15:04:05"), event.Health.Replica)
+			} else {
+				fmt.Printf("[%s] health: replica %d unhealthy: %s\n", event.Timestamp.Format("15:04:05"), event.Health.Replica, event.Health.Error)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to follow deployment: %w", err)
+	}
+
+	switch final {
+	case "":
+		fmt.Println("Stopped following deployment before it finished")
+	case deploy.StatusFailed, deploy.StatusAborted:
+		return fmt.Errorf("deployment %s ended with status %s", deploymentID, final)
+	default:
+		fmt.Printf("Deployment %s is %s\n", deploymentID, final)
+	}
+
+	return nil
+}
+
+// cliUser identifies who runs the CLI in the revision history
+func cliUser() string {
+	if user := os.Getenv("USER"); user != "" {
+		return "cli:" + user
+	}
+	return "cli"
+}
+
+// formatBytes prints a byte count with a binary unit
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
 // truncateString truncates a string to a specified length
 func truncateString(s string, length int) string {
 	if len(s) <= length {
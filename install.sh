@@ -264,6 +264,8 @@ logging:
   max_backups: 10
   max_age: 30
   compress: true
+  container_log_max_size: 10
+  container_log_max_files: 5
 
 resources:
   cpu_quota: "80%"
@@ -3,6 +3,7 @@ package agent
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
@@ -70,7 +71,7 @@ func New(cfg *config.Config, auditLogger *logging.AuditLogger) (*Agent, error) {
 	monitor := monitoring.NewMonitor(auditLogger, cfg.GetMetricsPort())
 
 	// Create deployment engine
-	deploymentEngine, err := deploy.NewDeploymentEngine(store, auditLogger, monitor)
+	deploymentEngine, err := deploy.NewDeploymentEngine(cfg, store, auditLogger, monitor)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create deployment engine: %w", err)
 	}
@@ -117,6 +118,12 @@ func New(cfg *config.Config, auditLogger *logging.AuditLogger) (*Agent, error) {
 		startTime:        time.Now(),
 	}
 
+	// Let the backend know whenever the engine steps in for a failing deployment
+	deploymentEngine.SetRemediationReporter(agent.reportRemediation)
+
+	// Containers the backend deploys share the host with those of the engine
+	containerManager.SetAdmissionController(deploymentEngine.AdmitContainers)
+
 	return agent, nil
 }
 
@@ -360,6 +367,15 @@ func (a *Agent) executeCommand(command *api.DeploymentCommand) {
 		finalResponse.Success = false
 		finalResponse.Error = err.Error()
 		finalResponse.Status = "failed"
+
+		// Tell the backend which resource ran out so it can place the work elsewhere
+		if capacityErr, ok := deploy.IsCapacityError(err); ok {
+			if finalResponse.Data == nil {
+				finalResponse.Data = make(map[string]interface{})
+			}
+			finalResponse.Data["reason"] = "insufficient_capacity"
+			finalResponse.Data["capacity"] = capacityErr
+		}
 	}
 
 	if err := a.backendClient.SendCommandResponse(context.Background(), finalResponse); err != nil {
@@ -518,9 +534,40 @@ func (a *Agent) rollbackApplication(ctx context.Context, command *api.Deployment
 
 // scaleApplication scales an application
 func (a *Agent) scaleApplication(ctx context.Context, command *api.DeploymentCommand) (map[string]interface{}, error) {
-	// TODO: Implement scaling logic
+	deploymentID := command.Target
+	if deploymentID == "" {
+		return nil, fmt.Errorf("deployment ID is required for scaling")
+	}
+
+	var replicas int
+	switch value := command.Spec["replicas"].(type) {
+	case float64:
+		replicas = int(value)
+	case int:
+		replicas = value
+	default:
+		return nil, fmt.Errorf("replicas is required for scaling")
+	}
+
+	if err := a.deploymentEngine.Scale(deploymentID, replicas); err != nil {
+		return nil, fmt.Errorf("failed to scale deployment: %w", err)
+	}
+
+	deployment, err := a.deploymentEngine.GetDeployment(deploymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	containerIDs := make([]string, 0, len(deployment.Replicas))
+	for _, replica := range deployment.Replicas {
+		containerIDs = append(containerIDs, replica.ContainerID)
+	}
+
 	return map[string]interface{}{
-		"message": "Scaling functionality not yet implemented",
+		"deployment_id": deploymentID,
+		"replicas":      len(deployment.Replicas),
+		"container_ids": containerIDs,
+		"status":        string(deployment.Status),
 	}, nil
 }
 
@@ -761,6 +808,40 @@ func (a *Agent) reportStatus() {
 	}
 }
 
+// reportRemediation sends a remediation action of the deployment engine to
+// the backend as a status report of the deployment
+func (a *Agent) reportRemediation(deployment *deploy.Deployment, action deploy.RemediationAction) {
+	health := "unhealthy"
+	if action.Succeeded {
+		health = "healthy"
+	}
+
+	status := api.DeploymentStatus{
+		ID:       deployment.ID,
+		Name:     deployment.AppID,
+		Status:   string(deployment.Status),
+		Health:   health,
+		Version:  deployment.Version,
+		Replicas: len(deployment.Replicas),
+		Labels: map[string]string{
+			"remediation.action":    action.Action,
+			"remediation.attempt":   strconv.Itoa(action.Attempt),
+			"remediation.reason":    action.Reason,
+			"remediation.succeeded": strconv.FormatBool(action.Succeeded),
+			"remediation.error":     action.Error,
+		},
+		Created: deployment.CreatedAt,
+		Updated: action.Timestamp,
+	}
+
+	ctx, cancel := context.WithTimeout(a.ctx, 30*time.Second)
+	defer cancel()
+
+	if err := a.backendClient.SendStatusReport(ctx, nil, []api.DeploymentStatus{status}); err != nil {
+		logrus.Errorf("Failed to report remediation of deployment %s: %v", deployment.ID, err)
+	}
+}
+
 // rotateTokens rotates API tokens periodically
 func (a *Agent) rotateTokens() {
 	defer a.wg.Done()
@@ -1,12 +1,19 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"superagent/internal/deploy"
 )
 
 // CLIClient provides a client interface for the CLI to communicate with the API server
@@ -111,6 +118,62 @@ func (c *CLIClient) CreateDeployment(request interface{}) (*DeploymentResponse,
 	return &deployment, nil
 }
 
+// PlanDeployment runs a deployment request as a dry run and returns what it
+// would do
+func (c *CLIClient) PlanDeployment(request interface{}) (*deploy.DeploymentPlan, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal deployment request: %w", err)
+	}
+
+	resp, err := c.httpClient.Post(c.baseURL+"/deployments?dry_run=true", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan deployment: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnprocessableEntity {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("dry run failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var plan deploy.DeploymentPlan
+	if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
+		return nil, fmt.Errorf("failed to decode deployment plan: %w", err)
+	}
+
+	return &plan, nil
+}
+
+// Apply applies an app manifest, which deploys it when it differs from what
+// the app runs. A dry run only plans the deployment.
+func (c *CLIClient) Apply(request *deploy.DeploymentRequest, dryRun bool) (*ApplyResponse, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	resp, err := c.httpClient.Post(fmt.Sprintf("%s/apply?dry_run=%t", c.baseURL, dryRun), "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply manifest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusUnprocessableEntity:
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("apply failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var result ApplyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode apply response: %w", err)
+	}
+
+	return &result, nil
+}
+
 // ListDeployments lists all deployments
 func (c *CLIClient) ListDeployments() ([]DeploymentResponse, error) {
 	resp, err := c.httpClient.Get(c.baseURL + "/deployments")
@@ -151,17 +214,50 @@ func (c *CLIClient) GetDeployment(deploymentID string) (*DeploymentResponse, err
 	return &deployment, nil
 }
 
+// LogsOptions selects the logs of a deployment to retrieve. Since and until
+// take RFC 3339 times or how long ago, such as "1h".
+type LogsOptions struct {
+	Tail      int
+	Since     string
+	Until     string
+	Source    string // "build", "deployment" or "container", empty for all
+	Limit     int
+	NextToken string
+}
+
+// values encodes the options as query parameters
+func (o LogsOptions) values() url.Values {
+	query := url.Values{}
+	query.Set("tail", strconv.Itoa(o.Tail))
+	if o.Since != "" {
+		query.Set("since", o.Since)
+	}
+	if o.Until != "" {
+		query.Set("until", o.Until)
+	}
+	if o.Source != "" {
+		query.Set("source", o.Source)
+	}
+	if o.Limit > 0 {
+		query.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.NextToken != "" {
+		query.Set("next_token", o.NextToken)
+	}
+	return query
+}
+
 // GetDeploymentLogs retrieves logs for a deployment
-func (c *CLIClient) GetDeploymentLogs(deploymentID string, tail int) (*LogsResponse, error) {
-	url := fmt.Sprintf("%s/deployments/%s/logs?tail=%d", c.baseURL, deploymentID, tail)
-	resp, err := c.httpClient.Get(url)
+func (c *CLIClient) GetDeploymentLogs(deploymentID string, options LogsOptions) (*LogsResponse, error) {
+	resp, err := c.httpClient.Get(fmt.Sprintf("%s/deployments/%s/logs?%s", c.baseURL, url.PathEscape(deploymentID), options.values().Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get deployment logs: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("get deployment logs failed with status: %d", resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get deployment logs failed with status: %d, body: %s", resp.StatusCode, string(body))
 	}
 
 	var logs LogsResponse
@@ -172,6 +268,58 @@ func (c *CLIClient) GetDeploymentLogs(deploymentID string, tail int) (*LogsRespo
 	return &logs, nil
 }
 
+// FollowDeploymentLogs streams the logs of a deployment, starting with the
+// last options.Tail entries, and passes each to handler until it returns
+// false, the context is cancelled or the agent closes the stream
+func (c *CLIClient) FollowDeploymentLogs(ctx context.Context, deploymentID string, options LogsOptions, handler func(LogEntry) bool) error {
+	options.NextToken = ""
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/deployments/%s/logs/follow?%s", c.baseURL, url.PathEscape(deploymentID), options.values().Encode()), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create follow request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The stream stays open until it is interrupted, so the client timeout
+	// does not apply
+	streamClient := &http.Client{}
+
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to follow deployment logs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("follow deployment logs failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 2*1024*1024)
+
+	for scanner.Scan() {
+		data, found := strings.CutPrefix(scanner.Text(), "data:")
+		if !found {
+			continue
+		}
+
+		var entry LogEntry
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(data, " ")), &entry); err != nil {
+			return fmt.Errorf("failed to decode log entry: %w", err)
+		}
+
+		if !handler(entry) {
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to read deployment logs: %w", err)
+	}
+
+	return nil
+}
+
 // StopDeployment stops a deployment
 func (c *CLIClient) StopDeployment(deploymentID string) error {
 	req, err := http.NewRequest("POST", c.baseURL+"/deployments/"+deploymentID+"/stop", nil)
@@ -193,6 +341,27 @@ func (c *CLIClient) StopDeployment(deploymentID string) error {
 	return nil
 }
 
+// CancelDeployment cancels an in-flight deployment
+func (c *CLIClient) CancelDeployment(deploymentID string) error {
+	req, err := http.NewRequest("POST", c.baseURL+"/deployments/"+deploymentID+"/cancel", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create cancel request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to cancel deployment: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("cancel deployment failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
 // DeleteDeployment deletes a deployment
 func (c *CLIClient) DeleteDeployment(deploymentID string) error {
 	req, err := http.NewRequest("DELETE", c.baseURL+"/deployments/"+deploymentID, nil)
@@ -215,21 +384,534 @@ func (c *CLIClient) DeleteDeployment(deploymentID string) error {
 }
 
 // RollbackDeployment rolls back a deployment
-func (c *CLIClient) RollbackDeployment(deploymentID string) error {
+func (c *CLIClient) RollbackDeployment(deploymentID string) (*RollbackResponse, error) {
 	req, err := http.NewRequest("POST", c.baseURL+"/deployments/"+deploymentID+"/rollback", nil)
 	if err != nil {
-		return fmt.Errorf("failed to create rollback request: %w", err)
+		return nil, fmt.Errorf("failed to create rollback request: %w", err)
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to rollback deployment: %w", err)
+		return nil, fmt.Errorf("failed to rollback deployment: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("rollback deployment failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var rollback RollbackResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rollback); err != nil {
+		return nil, fmt.Errorf("failed to decode rollback response: %w", err)
+	}
+
+	return &rollback, nil
+}
+
+// ListRevisions retrieves the revision history of an app
+func (c *CLIClient) ListRevisions(appID string) ([]RevisionResponse, error) {
+	resp, err := c.httpClient.Get(c.baseURL + "/apps/" + url.PathEscape(appID) + "/revisions")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revisions: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("list revisions failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var revisions []RevisionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&revisions); err != nil {
+		return nil, fmt.Errorf("failed to decode revisions response: %w", err)
+	}
+
+	return revisions, nil
+}
+
+// DiffRevisions retrieves the changes between two revisions of an app
+func (c *CLIClient) DiffRevisions(appID string, from, to int) (*RevisionDiffResponse, error) {
+	resp, err := c.httpClient.Get(fmt.Sprintf("%s/apps/%s/revisions/diff?from=%d&to=%d", c.baseURL, url.PathEscape(appID), from, to))
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff revisions: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("rollback deployment failed with status: %d, body: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("diff revisions failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var diff RevisionDiffResponse
+	if err := json.NewDecoder(resp.Body).Decode(&diff); err != nil {
+		return nil, fmt.Errorf("failed to decode diff response: %w", err)
+	}
+
+	return &diff, nil
+}
+
+// RedeployRevision deploys a past revision of an app again
+func (c *CLIClient) RedeployRevision(appID string, revision int, triggeredBy string) (*DeploymentResponse, error) {
+	jsonData, err := json.Marshal(map[string]string{"triggered_by": triggeredBy})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal redeploy request: %w", err)
+	}
+
+	resp, err := c.httpClient.Post(fmt.Sprintf("%s/apps/%s/revisions/%d/redeploy", c.baseURL, url.PathEscape(appID), revision), "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeploy revision: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("redeploy revision failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var deployment DeploymentResponse
+	if err := json.NewDecoder(resp.Body).Decode(&deployment); err != nil {
+		return nil, fmt.Errorf("failed to decode deployment response: %w", err)
+	}
+
+	return &deployment, nil
+}
+
+// CollectGarbage runs the retention policy of the agent. In report-only mode
+// nothing is removed.
+func (c *CLIClient) CollectGarbage(reportOnly bool) (*deploy.GCReport, error) {
+	// Collection waits for running operations on each app, which can take
+	// longer than the client timeout
+	gcClient := &http.Client{Timeout: 15 * time.Minute}
+
+	resp, err := gcClient.Post(fmt.Sprintf("%s/gc?report_only=%t", c.baseURL, reportOnly), "application/json", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect garbage: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("garbage collection failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var report deploy.GCReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to decode garbage collection report: %w", err)
+	}
+
+	return &report, nil
+}
+
+// RecommendResources suggests resource limits for a deployment from its
+// usage history. An empty window and a zero percentile take the defaults.
+func (c *CLIClient) RecommendResources(deploymentID, window string, percentile float64) (*deploy.ResourceRecommendation, error) {
+	query := url.Values{}
+	if window != "" {
+		query.Set("window", window)
+	}
+	if percentile > 0 {
+		query.Set("percentile", strconv.FormatFloat(percentile, 'f', -1, 64))
+	}
+
+	resp, err := c.httpClient.Get(fmt.Sprintf("%s/deployments/%s/recommendation?%s", c.baseURL, url.PathEscape(deploymentID), query.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recommendation: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("recommendation failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var recommendation deploy.ResourceRecommendation
+	if err := json.NewDecoder(resp.Body).Decode(&recommendation); err != nil {
+		return nil, fmt.Errorf("failed to decode recommendation: %w", err)
+	}
+
+	return &recommendation, nil
+}
+
+// ApplyRecommendation deploys a deployment again with the recommended
+// resource limits
+func (c *CLIClient) ApplyRecommendation(deploymentID, window string, percentile float64, triggeredBy string) (*deploy.ResourceRecommendation, error) {
+	jsonData, err := json.Marshal(RecommendationRequest{
+		Window:      window,
+		Percentile:  percentile,
+		TriggeredBy: triggeredBy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal recommendation request: %w", err)
+	}
+
+	resp, err := c.httpClient.Post(fmt.Sprintf("%s/deployments/%s/recommendation/apply", c.baseURL, url.PathEscape(deploymentID)), "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply recommendation: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("applying recommendation failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var recommendation deploy.ResourceRecommendation
+	if err := json.NewDecoder(resp.Body).Decode(&recommendation); err != nil {
+		return nil, fmt.Errorf("failed to decode recommendation: %w", err)
+	}
+
+	return &recommendation, nil
+}
+
+// DeployGroup deploys the services of a compose source as a group
+func (c *CLIClient) DeployGroup(request *deploy.DeploymentRequest) (*deploy.DeploymentGroup, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal group request: %w", err)
+	}
+
+	resp, err := c.httpClient.Post(c.baseURL+"/groups", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to deploy group: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("group deployment failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var group deploy.DeploymentGroup
+	if err := json.NewDecoder(resp.Body).Decode(&group); err != nil {
+		return nil, fmt.Errorf("failed to decode group response: %w", err)
+	}
+
+	return &group, nil
+}
+
+// ListGroups retrieves all groups
+func (c *CLIClient) ListGroups() ([]*deploy.DeploymentGroup, error) {
+	resp, err := c.httpClient.Get(c.baseURL + "/groups")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list groups: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("list groups failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Groups []*deploy.DeploymentGroup `json:"groups"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode groups response: %w", err)
+	}
+
+	return response.Groups, nil
+}
+
+// GetGroup retrieves a group with the status of its services
+func (c *CLIClient) GetGroup(appID string) (*deploy.DeploymentGroup, error) {
+	resp, err := c.httpClient.Get(c.baseURL + "/groups/" + url.PathEscape(appID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get group failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var group deploy.DeploymentGroup
+	if err := json.NewDecoder(resp.Body).Decode(&group); err != nil {
+		return nil, fmt.Errorf("failed to decode group response: %w", err)
+	}
+
+	return &group, nil
+}
+
+// StopGroup stops the services of a group
+func (c *CLIClient) StopGroup(appID string) error {
+	resp, err := c.groupRequest("POST", appID, "/stop")
+	if err != nil {
+		return fmt.Errorf("failed to stop group: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("stop group failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// RollbackGroup rolls back the last release of a group
+func (c *CLIClient) RollbackGroup(appID string) (*deploy.DeploymentGroup, error) {
+	resp, err := c.groupRequest("POST", appID, "/rollback")
+	if err != nil {
+		return nil, fmt.Errorf("failed to rollback group: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("rollback group failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var group deploy.DeploymentGroup
+	if err := json.NewDecoder(resp.Body).Decode(&group); err != nil {
+		return nil, fmt.Errorf("failed to decode group response: %w", err)
+	}
+
+	return &group, nil
+}
+
+// RemoveGroup removes a group with its services and networks
+func (c *CLIClient) RemoveGroup(appID string) error {
+	resp, err := c.groupRequest("DELETE", appID, "")
+	if err != nil {
+		return fmt.Errorf("failed to remove group: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("remove group failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// groupRequest sends a request about a group. Stopping, rolling back and
+// removing handle one service after the other, which can take longer than
+// the client timeout.
+func (c *CLIClient) groupRequest(method, appID, action string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.baseURL+"/groups/"+url.PathEscape(appID)+action, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	groupClient := &http.Client{Timeout: 15 * time.Minute}
+	return groupClient.Do(req)
+}
+
+// CreateJob creates or updates a job. The run it started is returned for
+// jobs without a schedule.
+func (c *CLIClient) CreateJob(spec *deploy.JobSpec) (*deploy.Job, *deploy.JobRun, error) {
+	jsonData, err := json.Marshal(spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal job: %w", err)
+	}
+
+	resp, err := c.httpClient.Post(c.baseURL+"/jobs", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create job: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, nil, fmt.Errorf("job creation failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Job *deploy.Job    `json:"job"`
+		Run *deploy.JobRun `json:"run"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode job response: %w", err)
+	}
+
+	return response.Job, response.Run, nil
+}
+
+// ListJobs retrieves all jobs
+func (c *CLIClient) ListJobs() ([]*deploy.Job, error) {
+	resp, err := c.httpClient.Get(c.baseURL + "/jobs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("list jobs failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Jobs []*deploy.Job `json:"jobs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode jobs response: %w", err)
+	}
+
+	return response.Jobs, nil
+}
+
+// GetJob retrieves a job with its run history
+func (c *CLIClient) GetJob(name string) (*deploy.Job, error) {
+	resp, err := c.httpClient.Get(c.baseURL + "/jobs/" + url.PathEscape(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get job failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var job deploy.Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, fmt.Errorf("failed to decode job response: %w", err)
+	}
+
+	return &job, nil
+}
+
+// DeleteJob deletes a job and cancels its runs
+func (c *CLIClient) DeleteJob(name string) error {
+	req, err := http.NewRequest("DELETE", c.baseURL+"/jobs/"+url.PathEscape(name), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create delete request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete job: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("delete job failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// RunJob starts a run of a job right away
+func (c *CLIClient) RunJob(name, triggeredBy string) (*deploy.JobRun, error) {
+	runURL := fmt.Sprintf("%s/jobs/%s/run?triggered_by=%s", c.baseURL, url.PathEscape(name), url.QueryEscape(triggeredBy))
+	resp, err := c.httpClient.Post(runURL, "application/json", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run job: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("run job failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var run deploy.JobRun
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		return nil, fmt.Errorf("failed to decode job run response: %w", err)
+	}
+
+	return &run, nil
+}
+
+// GetJobRun retrieves a run of a job with its logs
+func (c *CLIClient) GetJobRun(name, runID string) (*deploy.JobRun, error) {
+	resp, err := c.httpClient.Get(c.baseURL + "/jobs/" + url.PathEscape(name) + "/runs/" + url.PathEscape(runID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job run: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("get job run failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var run deploy.JobRun
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		return nil, fmt.Errorf("failed to decode job run response: %w", err)
+	}
+
+	return &run, nil
+}
+
+// CancelJobRun cancels a run of a job
+func (c *CLIClient) CancelJobRun(name, runID string) error {
+	resp, err := c.httpClient.Post(c.baseURL+"/jobs/"+url.PathEscape(name)+"/runs/"+url.PathEscape(runID)+"/cancel", "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("failed to cancel job run: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("cancel job run failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// WatchDeploymentEvents streams the events of a deployment and passes each to
+// handler until it returns false, the context is cancelled or the agent
+// closes the stream
+func (c *CLIClient) WatchDeploymentEvents(ctx context.Context, deploymentID string, handler func(deploy.Event) bool) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/deployments/"+deploymentID+"/events", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create events request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The stream stays open for as long as the deployment is in progress,
+	// so the client timeout does not apply
+	streamClient := &http.Client{}
+
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to watch deployment events: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("watch deployment events failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case line == "":
+			// A blank line ends the event
+			if data.Len() == 0 {
+				continue
+			}
+
+			var event deploy.Event
+			if err := json.Unmarshal([]byte(data.String()), &event); err != nil {
+				return fmt.Errorf("failed to decode deployment event: %w", err)
+			}
+			data.Reset()
+
+			if !handler(event) {
+				return nil
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteString("\n")
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to read deployment events: %w", err)
 	}
 
 	return nil
@@ -264,4 +946,91 @@ func (c *CLIClient) IsAgentRunning() bool {
 	defer resp.Body.Close()
 
 	return resp.StatusCode == http.StatusOK
-}
\ No newline at end of file
+}
+// CreateSecret stores a new secret
+func (c *CLIClient) CreateSecret(name, value, triggeredBy string) (*deploy.SecretInfo, error) {
+	return c.secretRequest(c.baseURL+"/secrets", http.StatusCreated, &SecretRequest{
+		Name:        name,
+		Value:       value,
+		TriggeredBy: triggeredBy,
+	})
+}
+
+// RotateSecret replaces the value of a secret
+func (c *CLIClient) RotateSecret(name, value, triggeredBy string) (*deploy.SecretInfo, error) {
+	return c.secretRequest(c.baseURL+"/secrets/"+url.PathEscape(name)+"/rotate", http.StatusOK, &SecretRequest{
+		Value:       value,
+		TriggeredBy: triggeredBy,
+	})
+}
+
+// secretRequest posts the value of a secret
+func (c *CLIClient) secretRequest(requestURL string, expectedStatus int, request *SecretRequest) (*deploy.SecretInfo, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal secret: %w", err)
+	}
+
+	resp, err := c.httpClient.Post(requestURL, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to send secret: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expectedStatus {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("secret request failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var secret deploy.SecretInfo
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("failed to decode secret response: %w", err)
+	}
+
+	return &secret, nil
+}
+
+// ListSecrets retrieves every secret without its value
+func (c *CLIClient) ListSecrets() ([]*deploy.SecretInfo, error) {
+	resp, err := c.httpClient.Get(c.baseURL + "/secrets")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("list secrets failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Secrets []*deploy.SecretInfo `json:"secrets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode secrets response: %w", err)
+	}
+
+	return response.Secrets, nil
+}
+
+// DeleteSecret deletes a secret
+func (c *CLIClient) DeleteSecret(name, triggeredBy string) error {
+	deleteURL := fmt.Sprintf("%s/secrets/%s?triggered_by=%s", c.baseURL, url.PathEscape(name), url.QueryEscape(triggeredBy))
+	req, err := http.NewRequest("DELETE", deleteURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create delete request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("delete secret failed with status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
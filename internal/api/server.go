@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"superagent/internal/config"
@@ -51,6 +53,65 @@ type DeploymentResponse struct {
 	Metadata    map[string]interface{} `json:"metadata"`
 }
 
+// RollbackResponse represents a rollback response
+type RollbackResponse struct {
+	ID                   string    `json:"id"`
+	Message              string    `json:"message"`
+	PreviousVersion      string    `json:"previous_version"`
+	RestoredVersion      string    `json:"restored_version"`
+	RestoredImageID      string    `json:"restored_image_id"`
+	RestoredDeploymentID string    `json:"restored_deployment_id"`
+	ContainerID          string    `json:"container_id"`
+	Timestamp            time.Time `json:"timestamp"`
+}
+
+// RevisionResponse represents an entry in the revision history of an app
+type RevisionResponse struct {
+	Number           int        `json:"number"`
+	DeploymentID     string     `json:"deployment_id"`
+	AppID            string     `json:"app_id"`
+	Version          string     `json:"version"`
+	Outcome          string     `json:"outcome"`
+	Error            string     `json:"error,omitempty"`
+	TriggeredBy      string     `json:"triggered_by,omitempty"`
+	RedeployOf       int        `json:"redeploy_of,omitempty"`
+	SourceType       string     `json:"source_type,omitempty"`
+	SourceRepository string     `json:"source_repository,omitempty"`
+	SourceCommit     string     `json:"source_commit,omitempty"`
+	ImageID          string     `json:"image_id,omitempty"`
+	ImageDigest      string     `json:"image_digest,omitempty"`
+	Strategy         string     `json:"strategy,omitempty"`
+	Replicas         int        `json:"replicas,omitempty"`
+	EnvironmentKeys  []string   `json:"environment_keys"`
+	CreatedAt        time.Time  `json:"created_at"`
+	DeployedAt       *time.Time `json:"deployed_at,omitempty"`
+	FinishedAt       *time.Time `json:"finished_at,omitempty"`
+}
+
+// RevisionDiffResponse represents the changes between two revisions
+type RevisionDiffResponse struct {
+	AppID   string                  `json:"app_id"`
+	From    int                     `json:"from"`
+	To      int                     `json:"to"`
+	Changes []deploy.RevisionChange `json:"changes"`
+}
+
+// ApplyResponse represents the result of applying an app manifest
+type ApplyResponse struct {
+	AppID      string                  `json:"app_id"`
+	Changed    bool                    `json:"changed"`
+	Revision   int                     `json:"revision,omitempty"`
+	Changes    []deploy.RevisionChange `json:"changes"`
+	Deployment *DeploymentResponse     `json:"deployment,omitempty"`
+	Plan       *deploy.DeploymentPlan  `json:"plan,omitempty"`
+}
+
+// dryRunRequest is a deployment request that may ask for a dry run only
+type dryRunRequest struct {
+	deploy.DeploymentRequest
+	DryRun bool `json:"dry_run"`
+}
+
 // LogsResponse represents logs response
 type LogsResponse struct {
 	DeploymentID string     `json:"deployment_id"`
@@ -61,10 +122,12 @@ type LogsResponse struct {
 
 // LogEntry represents a log entry
 type LogEntry struct {
-	Timestamp time.Time `json:"timestamp"`
-	Level     string    `json:"level"`
-	Message   string    `json:"message"`
-	Source    string    `json:"source"`
+	Timestamp   time.Time `json:"timestamp"`
+	Level       string    `json:"level"`
+	Message     string    `json:"message"`
+	Source      string    `json:"source"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Stream      string    `json:"stream,omitempty"` // "stdout" or "stderr" for container output
 }
 
 // NewAPIServer creates a new API server
@@ -96,10 +159,57 @@ func (s *APIServer) setupRoutes() {
 	api.HandleFunc("/deployments/{id}", s.handleGetDeployment).Methods("GET")
 	api.HandleFunc("/deployments/{id}", s.handleDeleteDeployment).Methods("DELETE")
 	api.HandleFunc("/deployments/{id}/logs", s.handleGetLogs).Methods("GET")
+	api.HandleFunc("/deployments/{id}/logs/follow", s.handleFollowLogs).Methods("GET")
 	api.HandleFunc("/deployments/{id}/start", s.handleStartDeployment).Methods("POST")
 	api.HandleFunc("/deployments/{id}/stop", s.handleStopDeployment).Methods("POST")
 	api.HandleFunc("/deployments/{id}/restart", s.handleRestartDeployment).Methods("POST")
 	api.HandleFunc("/deployments/{id}/rollback", s.handleRollbackDeployment).Methods("POST")
+	api.HandleFunc("/deployments/{id}/cancel", s.handleCancelDeployment).Methods("POST")
+	api.HandleFunc("/deployments/{id}/events", s.handleDeploymentEvents).Methods("GET")
+	api.HandleFunc("/deployments/{id}/recommendation", s.handleRecommendResources).Methods("GET")
+	api.HandleFunc("/deployments/{id}/recommendation/apply", s.handleApplyRecommendation).Methods("POST")
+
+	// Manifest endpoint
+	api.HandleFunc("/apply", s.handleApply).Methods("POST")
+
+	// Revision history endpoints
+	api.HandleFunc("/apps/{app}/revisions", s.handleListRevisions).Methods("GET")
+	api.HandleFunc("/apps/{app}/revisions/diff", s.handleDiffRevisions).Methods("GET")
+	api.HandleFunc("/apps/{app}/revisions/{revision:[0-9]+}", s.handleGetRevision).Methods("GET")
+	api.HandleFunc("/apps/{app}/revisions/{revision:[0-9]+}/redeploy", s.handleRedeployRevision).Methods("POST")
+
+	// Garbage collection endpoint
+	api.HandleFunc("/gc", s.handleGarbageCollect).Methods("POST")
+
+	// Groups of services deployed from a compose file
+	api.HandleFunc("/groups", s.handleDeployGroup).Methods("POST")
+	api.HandleFunc("/groups", s.handleListGroups).Methods("GET")
+	api.HandleFunc("/groups/{app}", s.handleGetGroup).Methods("GET")
+	api.HandleFunc("/groups/{app}", s.handleRemoveGroup).Methods("DELETE")
+	api.HandleFunc("/groups/{app}/stop", s.handleStopGroup).Methods("POST")
+	api.HandleFunc("/groups/{app}/rollback", s.handleRollbackGroup).Methods("POST")
+
+	// Jobs that run to completion, once or on a schedule
+	api.HandleFunc("/jobs", s.handleCreateJob).Methods("POST")
+	api.HandleFunc("/jobs", s.handleListJobs).Methods("GET")
+	api.HandleFunc("/jobs/{name}", s.handleGetJob).Methods("GET")
+	api.HandleFunc("/jobs/{name}", s.handleDeleteJob).Methods("DELETE")
+	api.HandleFunc("/jobs/{name}/run", s.handleRunJob).Methods("POST")
+	api.HandleFunc("/jobs/{name}/runs/{run}", s.handleGetJobRun).Methods("GET")
+	api.HandleFunc("/jobs/{name}/runs/{run}/cancel", s.handleCancelJobRun).Methods("POST")
+
+	// Resource quotas and what apps hold against them
+	api.HandleFunc("/quotas", s.handleListQuotas).Methods("GET")
+	api.HandleFunc("/quotas/{app}", s.handleGetAppQuota).Methods("GET")
+
+	// Secrets referenced from environments as secret://<name>
+	api.HandleFunc("/secrets", s.handleCreateSecret).Methods("POST")
+	api.HandleFunc("/secrets", s.handleListSecrets).Methods("GET")
+	api.HandleFunc("/secrets/{name}", s.handleDeleteSecret).Methods("DELETE")
+	api.HandleFunc("/secrets/{name}/rotate", s.handleRotateSecret).Methods("POST")
+
+	// Event stream endpoint
+	api.HandleFunc("/events", s.handleEvents).Methods("GET")
 
 	// Metrics endpoint
 	api.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
@@ -211,15 +321,36 @@ func (s *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, http.StatusOK, health)
 }
 
-// handleCreateDeployment handles deployment creation
+// handleCreateDeployment handles deployment creation. A dry run returns the
+// plan of the deployment instead of starting it.
 func (s *APIServer) handleCreateDeployment(w http.ResponseWriter, r *http.Request) {
-	var req deploy.DeploymentRequest
+	var req dryRunRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
 		return
 	}
 
-	deployment, err := s.deploymentEngine.Deploy(&req)
+	if req.TriggeredBy == "" {
+		req.TriggeredBy = "api:" + r.RemoteAddr
+	}
+
+	if req.DryRun || queryFlag(r, "dry_run") {
+		if req.AppID == "" {
+			s.writeError(w, http.StatusBadRequest, "app_id is required")
+			return
+		}
+
+		plan, err := s.deploymentEngine.Plan(&req.DeploymentRequest)
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Dry run failed: %v", err))
+			return
+		}
+
+		s.writeJSON(w, planStatus(plan), plan)
+		return
+	}
+
+	deployment, err := s.deploymentEngine.Deploy(&req.DeploymentRequest)
 	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Deployment failed: %v", err))
 		return
@@ -240,6 +371,65 @@ func (s *APIServer) handleCreateDeployment(w http.ResponseWriter, r *http.Reques
 	s.writeJSON(w, http.StatusCreated, response)
 }
 
+// handleApply handles applying an app manifest, deploying it only when it
+// differs from what the app runs
+func (s *APIServer) handleApply(w http.ResponseWriter, r *http.Request) {
+	var req dryRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	if req.AppID == "" {
+		s.writeError(w, http.StatusBadRequest, "app_id is required")
+		return
+	}
+
+	if req.TriggeredBy == "" {
+		req.TriggeredBy = "api:" + r.RemoteAddr
+	}
+
+	result, err := s.deploymentEngine.Apply(&req.DeploymentRequest, req.DryRun || queryFlag(r, "dry_run"))
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Apply failed: %v", err))
+		return
+	}
+
+	response := &ApplyResponse{
+		AppID:    result.AppID,
+		Changed:  result.Changed,
+		Revision: result.Revision,
+		Changes:  result.Changes,
+		Plan:     result.Plan,
+	}
+
+	if result.Plan != nil {
+		s.writeJSON(w, planStatus(result.Plan), response)
+		return
+	}
+
+	if !result.Changed {
+		s.writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	deployment := result.Deployment
+	response.Deployment = &DeploymentResponse{
+		ID:        deployment.ID,
+		Status:    string(deployment.Status),
+		Message:   "Deployment created successfully",
+		AppID:     deployment.AppID,
+		Version:   deployment.Version,
+		CreatedAt: deployment.CreatedAt,
+		Metadata: map[string]interface{}{
+			"source":   deployment.Source,
+			"revision": deployment.Revision,
+		},
+	}
+
+	s.writeJSON(w, http.StatusCreated, response)
+}
+
 // handleListDeployments handles listing deployments
 func (s *APIServer) handleListDeployments(w http.ResponseWriter, r *http.Request) {
 	deployments := s.deploymentEngine.ListDeployments()
@@ -254,8 +444,14 @@ func (s *APIServer) handleListDeployments(w http.ResponseWriter, r *http.Request
 			ContainerID: d.ContainerID,
 			CreatedAt:   d.CreatedAt,
 			Metadata: map[string]interface{}{
-				"source": d.Source,
-				"ports":  d.Ports,
+				"source":   d.Source,
+				"ports":    d.Ports,
+				"replicas": d.Replicas,
+				"canary":   d.Canary,
+				"queue": map[string]interface{}{
+					"position": d.QueuePosition,
+					"wait":     d.QueueWait.String(),
+				},
 			},
 		})
 	}
@@ -286,6 +482,20 @@ func (s *APIServer) handleGetDeployment(w http.ResponseWriter, r *http.Request)
 			"ports":        deployment.Ports,
 			"environment":  deployment.Environment,
 			"health_check": deployment.HealthCheck,
+			"replicas":     deployment.Replicas,
+			"canary":       deployment.Canary,
+			"remediation":  deployment.Remediation,
+			"drift":        deployment.Drift,
+			"autoscaling":  deployment.Autoscaling,
+			"hook_runs":    deployment.HookRuns,
+			"revision":     deployment.Revision,
+			"source_commit": deployment.SourceCommit,
+			"queue": map[string]interface{}{
+				"position":  deployment.QueuePosition,
+				"queued_at": deployment.QueuedAt,
+				"wait":      deployment.QueueWait.String(),
+			},
+			"metrics":      deployment.Metrics,
 		},
 	}
 
@@ -308,7 +518,9 @@ func (s *APIServer) handleDeleteDeployment(w http.ResponseWriter, r *http.Reques
 	})
 }
 
-// handleGetLogs handles getting deployment logs
+// handleGetLogs handles getting deployment logs. Build and deployment logs
+// are kept in memory and come with the first page; the captured output of
+// the containers is paged through with next_token.
 func (s *APIServer) handleGetLogs(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	deploymentID := vars["id"]
@@ -319,45 +531,97 @@ func (s *APIServer) handleGetLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get query parameters
-	tail := 100
-	if t := r.URL.Query().Get("tail"); t != "" {
-		if parsed, err := strconv.Atoi(t); err == nil {
-			tail = parsed
+	query, source, err := parseLogQuery(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response := &LogsResponse{
+		DeploymentID: deploymentID,
+		Logs:         []LogEntry{},
+	}
+
+	// Get logs (combine build, deployment and container logs). The tail
+	// count limits the in-memory logs here; the container page is already
+	// cut by the store and its token points past every entry it returned.
+	if query.Token == "" {
+		response.Logs = deploymentLogEntries(deployment, source, query)
+		if query.Tail > 0 && len(response.Logs) > query.Tail {
+			response.Logs = response.Logs[len(response.Logs)-query.Tail:]
 		}
 	}
 
-	// Get logs (combine build and deployment logs)
-	var logs []LogEntry
-	for _, log := range deployment.BuildLogs {
-		logs = append(logs, LogEntry{
-			Timestamp: log.Timestamp,
-			Level:     log.Level,
-			Message:   log.Message,
-			Source:    "build",
-		})
+	if source == "" || source == "container" {
+		page, err := s.deploymentEngine.ContainerLogs(deploymentID, query)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Failed to read container logs: %v", err))
+			return
+		}
+
+		for _, entry := range page.Entries {
+			response.Logs = append(response.Logs, containerLogEntry(entry))
+		}
+		response.HasMore = page.HasMore
+		response.NextToken = page.NextToken
 	}
-	for _, log := range deployment.DeploymentLogs {
-		logs = append(logs, LogEntry{
-			Timestamp: log.Timestamp,
-			Level:     log.Level,
-			Message:   log.Message,
-			Source:    "deployment",
-		})
+
+	sort.SliceStable(response.Logs, func(i, j int) bool {
+		return response.Logs[i].Timestamp.Before(response.Logs[j].Timestamp)
+	})
+
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// handleFollowLogs streams the logs of a deployment as Server-Sent Events,
+// starting with the last tail entries
+func (s *APIServer) handleFollowLogs(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentID := vars["id"]
+
+	query, source, err := parseLogQuery(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	// Limit logs to tail count
-	if len(logs) > tail {
-		logs = logs[len(logs)-tail:]
+	// Subscribe first so no entry is lost between the backlog and the stream
+	events, unsubscribe := s.deploymentEngine.SubscribeEvents(deploymentID)
+	defer unsubscribe()
+
+	deployment, err := s.deploymentEngine.GetDeployment(deploymentID)
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, fmt.Sprintf("Deployment not found: %v", err))
+		return
 	}
 
-	response := &LogsResponse{
-		DeploymentID: deploymentID,
-		Logs:         logs,
-		HasMore:      false,
+	backlog := deploymentLogEntries(deployment, source, query)
+
+	var lines <-chan logging.LogEntry
+	if source == "" || source == "container" {
+		entries, followed, stop, err := s.deploymentEngine.FollowContainerLogs(deploymentID, query.Tail)
+		if err != nil {
+			s.writeError(w, http.StatusNotFound, fmt.Sprintf("Failed to follow container logs: %v", err))
+			return
+		}
+		defer stop()
+
+		for _, entry := range entries {
+			if query.Matches(entry) {
+				backlog = append(backlog, containerLogEntry(entry))
+			}
+		}
+		lines = followed
 	}
 
-	s.writeJSON(w, http.StatusOK, response)
+	sort.SliceStable(backlog, func(i, j int) bool {
+		return backlog[i].Timestamp.Before(backlog[j].Timestamp)
+	})
+	if query.Tail > 0 && len(backlog) > query.Tail {
+		backlog = backlog[len(backlog)-query.Tail:]
+	}
+
+	s.streamLogs(w, r, backlog, lines, events, source)
 }
 
 // handleStartDeployment handles starting a deployment
@@ -365,9 +629,18 @@ func (s *APIServer) handleStartDeployment(w http.ResponseWriter, r *http.Request
 	vars := mux.Vars(r)
 	deploymentID := vars["id"]
 
-	// TODO: Implement start functionality
+	if _, err := s.deploymentEngine.GetDeployment(deploymentID); err != nil {
+		s.writeError(w, http.StatusNotFound, fmt.Sprintf("Deployment not found: %v", err))
+		return
+	}
+
+	if err := s.deploymentEngine.StartDeployment(deploymentID); err != nil {
+		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to start deployment: %v", err))
+		return
+	}
+
 	s.writeJSON(w, http.StatusOK, map[string]interface{}{
-		"message": "Deployment start initiated",
+		"message": "Deployment started successfully",
 		"id":      deploymentID,
 	})
 }
@@ -393,9 +666,18 @@ func (s *APIServer) handleRestartDeployment(w http.ResponseWriter, r *http.Reque
 	vars := mux.Vars(r)
 	deploymentID := vars["id"]
 
-	// TODO: Implement restart functionality
+	if _, err := s.deploymentEngine.GetDeployment(deploymentID); err != nil {
+		s.writeError(w, http.StatusNotFound, fmt.Sprintf("Deployment not found: %v", err))
+		return
+	}
+
+	if err := s.deploymentEngine.RestartDeployment(deploymentID); err != nil {
+		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to restart deployment: %v", err))
+		return
+	}
+
 	s.writeJSON(w, http.StatusOK, map[string]interface{}{
-		"message": "Deployment restart initiated",
+		"message": "Deployment restarted successfully",
 		"id":      deploymentID,
 	})
 }
@@ -405,110 +687,1018 @@ func (s *APIServer) handleRollbackDeployment(w http.ResponseWriter, r *http.Requ
 	vars := mux.Vars(r)
 	deploymentID := vars["id"]
 
-	if err := s.deploymentEngine.Rollback(deploymentID, "Manual rollback via API"); err != nil {
+	if _, err := s.deploymentEngine.GetDeployment(deploymentID); err != nil {
+		s.writeError(w, http.StatusNotFound, "Deployment not found")
+		return
+	}
+
+	rollbackInfo, err := s.deploymentEngine.Rollback(deploymentID, "Manual rollback via API")
+	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to rollback deployment: %v", err))
 		return
 	}
 
-	s.writeJSON(w, http.StatusOK, map[string]interface{}{
-		"message": "Deployment rollback initiated",
+	// A blue-green rollback moves traffic to a different deployment
+	deployment, _ := s.deploymentEngine.GetDeployment(deploymentID)
+	if deployment != nil && deployment.Status == deploy.StatusStopped && rollbackInfo.RestoredDeploymentID != deploymentID {
+		if restored, err := s.deploymentEngine.GetDeployment(rollbackInfo.RestoredDeploymentID); err == nil && restored.Status == deploy.StatusRunning {
+			deployment = restored
+		}
+	}
+
+	response := RollbackResponse{
+		ID:                   deploymentID,
+		Message:              fmt.Sprintf("Deployment rolled back to version %s", rollbackInfo.RestoredVersion),
+		PreviousVersion:      rollbackInfo.PreviousVersion,
+		RestoredVersion:      rollbackInfo.RestoredVersion,
+		RestoredImageID:      rollbackInfo.RestoredImageID,
+		RestoredDeploymentID: rollbackInfo.RestoredDeploymentID,
+		Timestamp:            rollbackInfo.Timestamp,
+	}
+	if deployment != nil {
+		response.ContainerID = deployment.ContainerID
+	}
+
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// handleCancelDeployment handles cancelling an in-flight deployment
+func (s *APIServer) handleCancelDeployment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentID := vars["id"]
+
+	if _, err := s.deploymentEngine.GetDeployment(deploymentID); err != nil {
+		s.writeError(w, http.StatusNotFound, fmt.Sprintf("Deployment not found: %v", err))
+		return
+	}
+
+	if err := s.deploymentEngine.Cancel(deploymentID); err != nil {
+		s.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to cancel deployment: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"message": "Deployment cancellation requested",
 		"id":      deploymentID,
 	})
 }
 
-// handleMetrics handles metrics requests
-func (s *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
-	deployments := s.deploymentEngine.ListDeployments()
-	
-	metrics := map[string]interface{}{
-		"total_deployments":    len(deployments),
-		"running_deployments":  0,
-		"failed_deployments":   0,
-		"pending_deployments":  0,
-		"uptime_seconds":       time.Since(s.startTime).Seconds(),
-		"last_update":          time.Now(),
+// handleListRevisions handles listing the revision history of an app
+func (s *APIServer) handleListRevisions(w http.ResponseWriter, r *http.Request) {
+	appID := mux.Vars(r)["app"]
+
+	revisions, err := s.deploymentEngine.ListRevisions(appID)
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
 	}
 
-	for _, deployment := range deployments {
-		switch deployment.Status {
-		case deploy.StatusRunning:
-			metrics["running_deployments"] = metrics["running_deployments"].(int) + 1
-		case deploy.StatusFailed:
-			metrics["failed_deployments"] = metrics["failed_deployments"].(int) + 1
-		case deploy.StatusPending:
-			metrics["pending_deployments"] = metrics["pending_deployments"].(int) + 1
-		}
+	response := make([]RevisionResponse, 0, len(revisions))
+	for _, revision := range revisions {
+		response = append(response, newRevisionResponse(revision))
 	}
 
-	s.writeJSON(w, http.StatusOK, metrics)
+	s.writeJSON(w, http.StatusOK, response)
 }
 
-// writeJSON writes JSON response
-func (s *APIServer) writeJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(data)
-}
+// handleGetRevision handles getting a single revision of an app
+func (s *APIServer) handleGetRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	number, _ := strconv.Atoi(vars["revision"])
 
-// writeError writes error response
-func (s *APIServer) writeError(w http.ResponseWriter, status int, message string) {
-	s.writeJSON(w, status, map[string]interface{}{
-		"error":   message,
-		"status":  status,
-		"timestamp": time.Now(),
-	})
+	revision, err := s.deploymentEngine.GetRevision(vars["app"], number)
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, newRevisionResponse(revision))
 }
 
-// loggingMiddleware logs HTTP requests
-func (s *APIServer) loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		
-		// Create a response writer wrapper to capture status
-		wrapper := &responseWriter{ResponseWriter: w}
-		
-		next.ServeHTTP(wrapper, r)
-		
-		duration := time.Since(start)
-		
-		logrus.WithFields(logrus.Fields{
-			"method":     r.Method,
-			"path":       r.URL.Path,
-			"status":     wrapper.status,
-			"duration":   duration,
-			"remote_addr": r.RemoteAddr,
-		}).Info("HTTP request")
-		
-		s.auditLogger.LogEvent("HTTP_REQUEST", map[string]interface{}{
-			"method":     r.Method,
-			"path":       r.URL.Path,
-			"status":     wrapper.status,
-			"duration":   duration.Milliseconds(),
-			"remote_addr": r.RemoteAddr,
-		})
+// handleDiffRevisions handles comparing two revisions of an app
+func (s *APIServer) handleDiffRevisions(w http.ResponseWriter, r *http.Request) {
+	appID := mux.Vars(r)["app"]
+
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid from revision")
+		return
+	}
+	to, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid to revision")
+		return
+	}
+
+	changes, err := s.deploymentEngine.DiffRevisions(appID, from, to)
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, RevisionDiffResponse{
+		AppID:   appID,
+		From:    from,
+		To:      to,
+		Changes: changes,
 	})
 }
 
-// corsMiddleware adds CORS headers
-func (s *APIServer) corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+// handleRedeployRevision handles deploying a past revision of an app again
+func (s *APIServer) handleRedeployRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	appID := vars["app"]
+	number, _ := strconv.Atoi(vars["revision"])
+
+	var body struct {
+		TriggeredBy string `json:"triggered_by"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
 			return
 		}
-		
-		next.ServeHTTP(w, r)
+	}
+	if body.TriggeredBy == "" {
+		body.TriggeredBy = "api:" + r.RemoteAddr
+	}
+
+	if _, err := s.deploymentEngine.GetRevision(appID, number); err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	deployment, err := s.deploymentEngine.RedeployRevision(appID, number, body.TriggeredBy)
+	if err != nil {
+		s.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to redeploy revision: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusCreated, &DeploymentResponse{
+		ID:        deployment.ID,
+		Status:    string(deployment.Status),
+		Message:   fmt.Sprintf("Redeploying revision %d", number),
+		AppID:     deployment.AppID,
+		Version:   deployment.Version,
+		CreatedAt: deployment.CreatedAt,
+		Metadata: map[string]interface{}{
+			"source":      deployment.Source,
+			"revision":    deployment.Revision,
+			"redeploy_of": number,
+		},
 	})
 }
 
-// responseWriter wraps http.ResponseWriter to capture status code
-type responseWriter struct {
-	http.ResponseWriter
-	status int
+// planStatus answers a dry run with 422 when the request would be rejected
+func planStatus(plan *deploy.DeploymentPlan) int {
+	if plan.Valid {
+		return http.StatusOK
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// handleGarbageCollect runs the retention policy right away. With
+// report_only=true it only reports what would be removed.
+func (s *APIServer) handleGarbageCollect(w http.ResponseWriter, r *http.Request) {
+	report, err := s.deploymentEngine.CollectGarbage(queryFlag(r, "report_only"))
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to collect garbage: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, report)
+}
+
+// handleDeployGroup handles deploying the services of a compose source as
+// a group. The services start in the background.
+func (s *APIServer) handleDeployGroup(w http.ResponseWriter, r *http.Request) {
+	var req deploy.DeploymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	if req.TriggeredBy == "" {
+		req.TriggeredBy = "api:" + r.RemoteAddr
+	}
+
+	group, err := s.deploymentEngine.DeployGroup(&req)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Group deployment failed: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusAccepted, group)
+}
+
+// handleListGroups handles listing groups
+func (s *APIServer) handleListGroups(w http.ResponseWriter, r *http.Request) {
+	groups := s.deploymentEngine.ListGroups()
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"groups": groups,
+		"total":  len(groups),
+	})
+}
+
+// handleGetGroup handles getting a group with the status of its services
+func (s *APIServer) handleGetGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := s.deploymentEngine.GetGroup(mux.Vars(r)["app"])
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, group)
+}
+
+// handleStopGroup handles stopping the services of a group
+func (s *APIServer) handleStopGroup(w http.ResponseWriter, r *http.Request) {
+	appID := mux.Vars(r)["app"]
+
+	if _, err := s.deploymentEngine.GetGroup(appID); err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if err := s.deploymentEngine.StopGroup(appID); err != nil {
+		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to stop group: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Group stopped successfully",
+		"app_id":  appID,
+	})
+}
+
+// handleRollbackGroup handles rolling back the last release of a group
+func (s *APIServer) handleRollbackGroup(w http.ResponseWriter, r *http.Request) {
+	appID := mux.Vars(r)["app"]
+
+	if _, err := s.deploymentEngine.GetGroup(appID); err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	group, err := s.deploymentEngine.RollbackGroup(appID, "Manual rollback of group via API")
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to rollback group: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, group)
+}
+
+// handleRemoveGroup handles removing a group with its services and networks
+func (s *APIServer) handleRemoveGroup(w http.ResponseWriter, r *http.Request) {
+	appID := mux.Vars(r)["app"]
+
+	if _, err := s.deploymentEngine.GetGroup(appID); err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if err := s.deploymentEngine.RemoveGroup(appID); err != nil {
+		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to remove group: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Group removed successfully",
+		"app_id":  appID,
+	})
+}
+
+// handleCreateJob handles creating or updating a job. A job without a
+// schedule starts running right away.
+func (s *APIServer) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	var spec deploy.JobSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	if spec.TriggeredBy == "" {
+		spec.TriggeredBy = "api:" + r.RemoteAddr
+	}
+
+	job, run, err := s.deploymentEngine.CreateJob(&spec)
+	if err != nil {
+		// The job was stored but its run was refused
+		if job != nil {
+			s.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to run job: %v", err))
+			return
+		}
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid job: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"job": job,
+		"run": run,
+	})
+}
+
+// handleListJobs handles listing jobs
+func (s *APIServer) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := s.deploymentEngine.ListJobs()
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"jobs":  jobs,
+		"total": len(jobs),
+	})
+}
+
+// handleGetJob handles getting a job with its run history
+func (s *APIServer) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.deploymentEngine.GetJob(mux.Vars(r)["name"])
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, job)
+}
+
+// handleDeleteJob handles deleting a job and cancelling its runs
+func (s *APIServer) handleDeleteJob(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if err := s.deploymentEngine.DeleteJob(name); err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Job deleted successfully",
+		"name":    name,
+	})
+}
+
+// handleRunJob handles starting a run of a job right away
+func (s *APIServer) handleRunJob(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if _, err := s.deploymentEngine.GetJob(name); err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	triggeredBy := r.URL.Query().Get("triggered_by")
+	if triggeredBy == "" {
+		triggeredBy = "api:" + r.RemoteAddr
+	}
+
+	run, err := s.deploymentEngine.RunJob(name, triggeredBy)
+	if err != nil {
+		s.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to run job: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusAccepted, run)
+}
+
+// handleGetJobRun handles getting a run of a job with its logs
+func (s *APIServer) handleGetJobRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	run, err := s.deploymentEngine.GetJobRun(vars["name"], vars["run"])
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, run)
+}
+
+// handleCancelJobRun handles cancelling a run of a job
+func (s *APIServer) handleCancelJobRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if _, err := s.deploymentEngine.GetJobRun(vars["name"], vars["run"]); err != nil {
+		s.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if err := s.deploymentEngine.CancelJobRun(vars["name"], vars["run"]); err != nil {
+		s.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to cancel job run: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"message": "Job run cancellation requested",
+		"id":      vars["run"],
+	})
+}
+
+// handleListQuotas handles listing the configured quotas with their usage
+func (s *APIServer) handleListQuotas(w http.ResponseWriter, r *http.Request) {
+	quotas := s.deploymentEngine.Quotas(r.Context())
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"quotas": quotas,
+		"total":  len(quotas),
+	})
+}
+
+// handleGetAppQuota handles getting the allocations of an app and the quotas
+// that apply to it
+func (s *APIServer) handleGetAppQuota(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.deploymentEngine.AppQuota(r.Context(), mux.Vars(r)["app"]))
+}
+
+// RecommendationRequest tells how to size the limits of a deployment
+type RecommendationRequest struct {
+	Window      string  `json:"window,omitempty"` // e.g. "24h" or "7d"
+	Percentile  float64 `json:"percentile,omitempty"`
+	TriggeredBy string  `json:"triggered_by,omitempty"`
+}
+
+// handleRecommendResources handles suggesting resource limits for a
+// deployment from its usage history
+func (s *APIServer) handleRecommendResources(w http.ResponseWriter, r *http.Request) {
+	deploymentID := mux.Vars(r)["id"]
+
+	if _, err := s.deploymentEngine.GetDeployment(deploymentID); err != nil {
+		s.writeError(w, http.StatusNotFound, fmt.Sprintf("Deployment not found: %v", err))
+		return
+	}
+
+	window, err := parseWindow(r.URL.Query().Get("window"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var percentile float64
+	if value := r.URL.Query().Get("percentile"); value != "" {
+		if percentile, err = strconv.ParseFloat(value, 64); err != nil {
+			s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid percentile: %v", err))
+			return
+		}
+	}
+
+	recommendation, err := s.deploymentEngine.RecommendResources(deploymentID, window, percentile)
+	if err != nil {
+		s.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to recommend resources: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, recommendation)
+}
+
+// handleApplyRecommendation handles deploying a deployment again with the
+// recommended limits
+func (s *APIServer) handleApplyRecommendation(w http.ResponseWriter, r *http.Request) {
+	deploymentID := mux.Vars(r)["id"]
+
+	var req RecommendationRequest
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+	}
+	if req.TriggeredBy == "" {
+		req.TriggeredBy = "api:" + r.RemoteAddr
+	}
+
+	if _, err := s.deploymentEngine.GetDeployment(deploymentID); err != nil {
+		s.writeError(w, http.StatusNotFound, fmt.Sprintf("Deployment not found: %v", err))
+		return
+	}
+
+	window, err := parseWindow(req.Window)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	recommendation, err := s.deploymentEngine.ApplyRecommendation(deploymentID, window, req.Percentile, req.TriggeredBy)
+	if err != nil {
+		s.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to apply recommendation: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusCreated, recommendation)
+}
+
+// SecretRequest carries the value of a secret
+type SecretRequest struct {
+	Name        string `json:"name,omitempty"`
+	Value       string `json:"value"`
+	TriggeredBy string `json:"triggered_by,omitempty"`
+}
+
+// handleCreateSecret handles creating a secret
+func (s *APIServer) handleCreateSecret(w http.ResponseWriter, r *http.Request) {
+	var req SecretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	if req.TriggeredBy == "" {
+		req.TriggeredBy = "api:" + r.RemoteAddr
+	}
+
+	secret, err := s.deploymentEngine.CreateSecret(req.Name, req.Value, req.TriggeredBy)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Failed to create secret: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusCreated, secret)
+}
+
+// handleListSecrets handles listing secrets without their values
+func (s *APIServer) handleListSecrets(w http.ResponseWriter, r *http.Request) {
+	secrets, err := s.deploymentEngine.ListSecrets()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to list secrets: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"secrets": secrets,
+		"total":   len(secrets),
+	})
+}
+
+// handleRotateSecret handles replacing the value of a secret
+func (s *APIServer) handleRotateSecret(w http.ResponseWriter, r *http.Request) {
+	var req SecretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	if req.TriggeredBy == "" {
+		req.TriggeredBy = "api:" + r.RemoteAddr
+	}
+
+	secret, err := s.deploymentEngine.RotateSecret(mux.Vars(r)["name"], req.Value, req.TriggeredBy)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Failed to rotate secret: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, secret)
+}
+
+// handleDeleteSecret handles deleting a secret nothing references
+func (s *APIServer) handleDeleteSecret(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	triggeredBy := r.URL.Query().Get("triggered_by")
+	if triggeredBy == "" {
+		triggeredBy = "api:" + r.RemoteAddr
+	}
+
+	if err := s.deploymentEngine.DeleteSecret(name, triggeredBy); err != nil {
+		s.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to delete secret: %v", err))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Secret deleted successfully",
+		"name":    name,
+	})
+}
+
+// parseWindow reads a time window such as "12h" or "7d". Empty means the
+// default window.
+func parseWindow(text string) (time.Duration, error) {
+	if text == "" {
+		return 0, nil
+	}
+
+	if days, found := strings.CutSuffix(text, "d"); found {
+		count, err := strconv.Atoi(days)
+		if err != nil || count <= 0 {
+			return 0, fmt.Errorf("invalid window %q", text)
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+
+	window, err := time.ParseDuration(text)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("invalid window %q", text)
+	}
+	return window, nil
+}
+
+// queryFlag reports whether a boolean query parameter is set
+func queryFlag(r *http.Request, name string) bool {
+	value, _ := strconv.ParseBool(r.URL.Query().Get(name))
+	return value
+}
+
+// newRevisionResponse describes a revision without the values of its
+// environment
+func newRevisionResponse(revision *deploy.Revision) RevisionResponse {
+	response := RevisionResponse{
+		Number:           revision.Number,
+		DeploymentID:     revision.DeploymentID,
+		AppID:            revision.AppID,
+		Version:          revision.Version,
+		Outcome:          revision.Outcome,
+		Error:            revision.Error,
+		TriggeredBy:      revision.TriggeredBy,
+		RedeployOf:       revision.RedeployOf,
+		SourceType:       revision.Request.Source.Type,
+		SourceRepository: revision.Request.Source.Repository,
+		SourceCommit:     revision.SourceCommit,
+		ImageID:          revision.ImageID,
+		ImageDigest:      revision.ImageDigest,
+		Strategy:         revision.Request.Config.Strategy,
+		Replicas:         revision.Request.Config.Replicas,
+		EnvironmentKeys:  revision.EnvironmentKeys(),
+		CreatedAt:        revision.CreatedAt,
+		FinishedAt:       revision.FinishedAt,
+	}
+	if !revision.DeployedAt.IsZero() {
+		deployedAt := revision.DeployedAt
+		response.DeployedAt = &deployedAt
+	}
+
+	return response
+}
+
+// handleEvents streams the events of all deployments
+func (s *APIServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := s.deploymentEngine.SubscribeEvents("")
+	defer unsubscribe()
+
+	s.streamEvents(w, r, events, nil)
+}
+
+// handleDeploymentEvents streams the events of a single deployment, starting
+// with its current status
+func (s *APIServer) handleDeploymentEvents(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentID := vars["id"]
+
+	// Subscribe first so no transition is lost between the lookup and the stream
+	events, unsubscribe := s.deploymentEngine.SubscribeEvents(deploymentID)
+	defer unsubscribe()
+
+	deployment, err := s.deploymentEngine.GetDeployment(deploymentID)
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, fmt.Sprintf("Deployment not found: %v", err))
+		return
+	}
+
+	current := &deploy.Event{
+		Type:         deploy.EventStatus,
+		DeploymentID: deployment.ID,
+		AppID:        deployment.AppID,
+		Status:       deployment.Status,
+		Terminal:     deployment.Status.IsTerminal(),
+		Timestamp:    time.Now(),
+	}
+
+	s.streamEvents(w, r, events, current)
+}
+
+// streamEvents writes events as Server-Sent Events until the client goes
+// away or the event bus is closed
+func (s *APIServer) streamEvents(w http.ResponseWriter, r *http.Request, events <-chan deploy.Event, first *deploy.Event) {
+	controller := http.NewResponseController(w)
+
+	// The stream outlives the server's write timeout
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		logrus.Debugf("Failed to clear write deadline for event stream: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if first != nil {
+		if err := writeEvent(w, *first); err != nil {
+			return
+		}
+	}
+	if err := controller.Flush(); err != nil {
+		logrus.Warnf("Event stream not supported by response writer: %v", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		}
+
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes a single Server-Sent Event
+func writeEvent(w http.ResponseWriter, event deploy.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Sequence, event.Type, data)
+	return err
+}
+
+// streamLogs writes log entries as Server-Sent Events until the client goes
+// away or the logs of the deployment end
+func (s *APIServer) streamLogs(w http.ResponseWriter, r *http.Request, backlog []LogEntry, lines <-chan logging.LogEntry, events <-chan deploy.Event, source string) {
+	controller := http.NewResponseController(w)
+
+	// The stream outlives the server's write timeout
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		logrus.Debugf("Failed to clear write deadline for log stream: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, entry := range backlog {
+		if err := writeLogEntry(w, entry); err != nil {
+			return
+		}
+	}
+	if err := controller.Flush(); err != nil {
+		logrus.Warnf("Log stream not supported by response writer: %v", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if err := writeLogEntry(w, containerLogEntry(line)); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.Type != deploy.EventLog || event.Log == nil || (source != "" && source != event.Log.Source) {
+				continue
+			}
+			if err := writeLogEntry(w, LogEntry{
+				Timestamp: event.Log.Timestamp,
+				Level:     event.Log.Level,
+				Message:   event.Log.Message,
+				Source:    event.Log.Source,
+			}); err != nil {
+				return
+			}
+		}
+
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeLogEntry writes a single log entry as a Server-Sent Event
+func writeLogEntry(w http.ResponseWriter, entry LogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: log\ndata: %s\n\n", data)
+	return err
+}
+
+// parseLogQuery reads the filters of a logs request. Since and until take a
+// time or how long ago, such as "1h" or "2d". Without a token, since or tail
+// the last 100 entries are returned.
+func parseLogQuery(r *http.Request) (logging.LogQuery, string, error) {
+	values := r.URL.Query()
+	now := time.Now()
+
+	query := logging.LogQuery{
+		Token: values.Get("next_token"),
+	}
+
+	source := values.Get("source")
+	switch source {
+	case "", "build", "deployment", "container":
+	default:
+		return query, "", fmt.Errorf("invalid source %q, expected build, deployment or container", source)
+	}
+
+	var err error
+	if query.Since, err = parseLogTime(values.Get("since"), now); err != nil {
+		return query, "", err
+	}
+	if query.Until, err = parseLogTime(values.Get("until"), now); err != nil {
+		return query, "", err
+	}
+
+	if query.Token == "" && query.Since.IsZero() {
+		query.Tail = 100
+	}
+	if t := values.Get("tail"); t != "" {
+		tail, err := strconv.Atoi(t)
+		if err != nil || tail < 0 {
+			return query, "", fmt.Errorf("invalid tail %q", t)
+		}
+		query.Tail = tail
+	}
+
+	if l := values.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			return query, "", fmt.Errorf("invalid limit %q", l)
+		}
+		query.Limit = limit
+	}
+
+	return query, source, nil
+}
+
+// parseLogTime reads a point in time given as RFC 3339 or as how long ago
+func parseLogTime(text string, now time.Time) (time.Time, error) {
+	if text == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return t, nil
+	}
+
+	ago, err := parseWindow(text)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or a duration such as 1h", text)
+	}
+	return now.Add(-ago), nil
+}
+
+// deploymentLogEntries returns the build and deployment logs of a deployment
+// within the bounds of a query
+func deploymentLogEntries(deployment *deploy.Deployment, source string, query logging.LogQuery) []LogEntry {
+	var logs []LogEntry
+
+	add := func(entries []deploy.LogEntry, kind string) {
+		if source != "" && source != kind {
+			return
+		}
+		for _, log := range entries {
+			if !query.Covers(log.Timestamp) {
+				continue
+			}
+			logs = append(logs, LogEntry{
+				Timestamp: log.Timestamp,
+				Level:     log.Level,
+				Message:   log.Message,
+				Source:    kind,
+			})
+		}
+	}
+	add(deployment.BuildLogs, "build")
+	add(deployment.DeploymentLogs, "deployment")
+
+	return logs
+}
+
+// containerLogEntry converts a line of container output
+func containerLogEntry(entry logging.LogEntry) LogEntry {
+	stream, _ := entry.Fields["stream"].(string)
+	return LogEntry{
+		Timestamp:   entry.Timestamp,
+		Level:       entry.Level,
+		Message:     entry.Message,
+		Source:      "container",
+		ContainerID: entry.ContainerID,
+		Stream:      stream,
+	}
+}
+
+// handleMetrics handles metrics requests
+func (s *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	deployments := s.deploymentEngine.ListDeployments()
+	
+	metrics := map[string]interface{}{
+		"total_deployments":    len(deployments),
+		"running_deployments":  0,
+		"failed_deployments":   0,
+		"pending_deployments":  0,
+		"uptime_seconds":       time.Since(s.startTime).Seconds(),
+		"last_update":          time.Now(),
+	}
+
+	for _, deployment := range deployments {
+		switch deployment.Status {
+		case deploy.StatusRunning:
+			metrics["running_deployments"] = metrics["running_deployments"].(int) + 1
+		case deploy.StatusFailed:
+			metrics["failed_deployments"] = metrics["failed_deployments"].(int) + 1
+		case deploy.StatusPending:
+			metrics["pending_deployments"] = metrics["pending_deployments"].(int) + 1
+		}
+	}
+
+	s.writeJSON(w, http.StatusOK, metrics)
+}
+
+// writeJSON writes JSON response
+func (s *APIServer) writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// writeError writes error response
+func (s *APIServer) writeError(w http.ResponseWriter, status int, message string) {
+	s.writeJSON(w, status, map[string]interface{}{
+		"error":   message,
+		"status":  status,
+		"timestamp": time.Now(),
+	})
+}
+
+// loggingMiddleware logs HTTP requests
+func (s *APIServer) loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		
+		// Create a response writer wrapper to capture status
+		wrapper := &responseWriter{ResponseWriter: w}
+		
+		next.ServeHTTP(wrapper, r)
+		
+		duration := time.Since(start)
+		
+		logrus.WithFields(logrus.Fields{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     wrapper.status,
+			"duration":   duration,
+			"remote_addr": r.RemoteAddr,
+		}).Info("HTTP request")
+		
+		s.auditLogger.LogEvent("HTTP_REQUEST", map[string]interface{}{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     wrapper.status,
+			"duration":   duration.Milliseconds(),
+			"remote_addr": r.RemoteAddr,
+		})
+	})
+}
+
+// corsMiddleware adds CORS headers
+func (s *APIServer) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		
+		next.ServeHTTP(w, r)
+	})
+}
+
+// responseWriter wraps http.ResponseWriter to capture status code
+type responseWriter struct {
+	http.ResponseWriter
+	status int
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
@@ -516,6 +1706,11 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap gives http.ResponseController access to the wrapped writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (rw *responseWriter) Write(b []byte) (int, error) {
 	if rw.status == 0 {
 		rw.status = http.StatusOK
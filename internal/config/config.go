@@ -28,7 +28,9 @@ type Config struct {
 	Monitoring  MonitoringConfig  `yaml:"monitoring"`
 	Logging     LoggingConfig     `yaml:"logging"`
 	Resources   ResourcesConfig   `yaml:"resources"`
+	Quotas      QuotasConfig      `yaml:"quotas"`
 	Networking  NetworkingConfig  `yaml:"networking"`
+	Retention   RetentionConfig   `yaml:"retention"`
 }
 
 // AgentConfig contains agent-specific configuration
@@ -83,6 +85,17 @@ type DockerConfig struct {
 	DefaultStorageLimit string            `yaml:"default_storage_limit"`
 }
 
+// RetentionConfig controls how long old deployments, their revisions and
+// images are kept. Images needed to roll back are always kept.
+type RetentionConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	ReportOnly        bool          `yaml:"report_only"`          // only report what would be removed
+	Interval          time.Duration `yaml:"interval"`
+	KeepRevisions     int           `yaml:"keep_revisions"`       // per app, 0 keeps all
+	MaxAge            time.Duration `yaml:"max_age"`              // 0 keeps revisions regardless of age
+	MaxImageDiskUsage string        `yaml:"max_image_disk_usage"` // e.g. "50GB", empty for no limit
+}
+
 // GitConfig contains Git-specific configuration
 type GitConfig struct {
 	SSHKeyPath     string            `yaml:"ssh_key_path"`
@@ -162,6 +175,9 @@ type LoggingConfig struct {
 	AuditLogMaxSize int    `yaml:"audit_log_max_size"`
 	AuditLogMaxBackups int `yaml:"audit_log_max_backups"`
 	AuditLogMaxAge  int    `yaml:"audit_log_max_age"`
+	ContainerLogDir      string `yaml:"container_log_dir"`       // defaults to container-logs in the data dir
+	ContainerLogMaxSize  int    `yaml:"container_log_max_size"`  // MB per file
+	ContainerLogMaxFiles int    `yaml:"container_log_max_files"` // files kept per deployment
 }
 
 // ResourcesConfig contains resource management configuration
@@ -180,6 +196,41 @@ type ResourcesConfig struct {
 	Monitoring     ResourceMonitoring `yaml:"monitoring"`
 }
 
+// QuotasConfig caps what apps may allocate together, on their own and as
+// part of a team. Apps without a quota are only bound by the host limits.
+type QuotasConfig struct {
+	Apps  map[string]QuotaConfig     `yaml:"apps"`
+	Teams map[string]TeamQuotaConfig `yaml:"teams"`
+}
+
+// QuotaConfig limits the sum of the resource limits of the containers an app
+// or team runs. Amounts are absolute such as "4" cores or "8GB", or a share
+// of the host such as "25%"; unset amounts do not limit.
+type QuotaConfig struct {
+	CPU        string `yaml:"cpu"`
+	Memory     string `yaml:"memory"`
+	Storage    string `yaml:"storage"`
+	Containers int    `yaml:"containers"`
+}
+
+// TeamQuotaConfig is a quota shared by the apps of a team
+type TeamQuotaConfig struct {
+	QuotaConfig `yaml:",inline"`
+	Apps        []string `yaml:"apps"`
+}
+
+// TeamOf returns the team an app belongs to, if any
+func (q QuotasConfig) TeamOf(appID string) (string, bool) {
+	for team, quota := range q.Teams {
+		for _, app := range quota.Apps {
+			if app == appID {
+				return team, true
+			}
+		}
+	}
+	return "", false
+}
+
 // NetworkingConfig contains networking configuration
 type NetworkingConfig struct {
 	AllowedPorts     []int             `yaml:"allowed_ports"`
@@ -338,6 +389,8 @@ func Load(configPath string) (*Config, error) {
 			AuditLogMaxSize: 100,
 			AuditLogMaxBackups: 10,
 			AuditLogMaxAge:  30,
+			ContainerLogMaxSize:  10,
+			ContainerLogMaxFiles: 5,
 		},
 		Resources: ResourcesConfig{
 			CPUQuota:       "80%",
@@ -366,6 +419,15 @@ func Load(configPath string) (*Config, error) {
 			DNSServers:   []string{"8.8.8.8", "8.8.4.4"},
 			FirewallEnabled: true,
 		},
+		// Retention only reports what it would remove until an operator
+		// turns report_only off
+		Retention: RetentionConfig{
+			Enabled:       true,
+			ReportOnly:    true,
+			Interval:      1 * time.Hour,
+			KeepRevisions: 10,
+			MaxAge:        30 * 24 * time.Hour,
+		},
 	}
 
 	// Initialize Viper
@@ -495,6 +557,9 @@ logging:
   max_backups: 10
   max_age: 30
   compress: true
+  container_log_dir: ""      # Defaults to container-logs in the data dir
+  container_log_max_size: 10 # MB per file
+  container_log_max_files: 5 # Files kept per deployment
 
 resources:
   cpu_quota: "80%"
@@ -513,6 +578,14 @@ networking:
   blocked_ports: [22, 23, 135, 139, 445]
   dns_servers: ["8.8.8.8", "8.8.4.4"]
   firewall_enabled: true
+
+retention:
+  enabled: true
+  report_only: true         # Set to false to remove expired deployments and images
+  interval: "1h"
+  keep_revisions: 10
+  max_age: "720h"
+  max_image_disk_usage: ""  # e.g. "50GB"
 `
 
 	// Write default config to file
@@ -547,6 +620,33 @@ func validateConfig(config *Config) error {
 		return errors.New("resources.max_containers must be greater than 0")
 	}
 
+	if config.Logging.ContainerLogMaxSize < 0 || config.Logging.ContainerLogMaxFiles < 0 {
+		return errors.New("logging.container_log_max_size and logging.container_log_max_files must not be negative")
+	}
+
+	if config.Retention.KeepRevisions < 0 || config.Retention.MaxAge < 0 {
+		return errors.New("retention.keep_revisions and retention.max_age must not be negative")
+	}
+
+	// Validate quotas
+	teamOf := make(map[string]string)
+	for team, quota := range config.Quotas.Teams {
+		if quota.Containers < 0 {
+			return fmt.Errorf("quotas.teams.%s.containers must not be negative", team)
+		}
+		for _, app := range quota.Apps {
+			if other, exists := teamOf[app]; exists && other != team {
+				return fmt.Errorf("app %s is in both team %s and team %s", app, other, team)
+			}
+			teamOf[app] = team
+		}
+	}
+	for app, quota := range config.Quotas.Apps {
+		if quota.Containers < 0 {
+			return fmt.Errorf("quotas.apps.%s.containers must not be negative", app)
+		}
+	}
+
 	// Validate monitoring configuration
 	if config.Monitoring.Enabled {
 		if config.Monitoring.MetricsPort <= 0 || config.Monitoring.MetricsPort > 65535 {
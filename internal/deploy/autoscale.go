@@ -0,0 +1,407 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAutoscaleTargetCPU     = 80.0
+	defaultScaleUpStabilization   = 0
+	defaultScaleDownStabilization = 5 * time.Minute
+	defaultScaleUpCooldown        = time.Minute
+	defaultScaleDownCooldown      = 5 * time.Minute
+
+	// autoscaleTolerance is how far the utilization may stray from the
+	// target, as a share of it, before the replica count is changed
+	autoscaleTolerance = 0.1
+)
+
+// AutoscalingPolicy lets the engine scale a running deployment between a
+// minimum and a maximum number of replicas so the average utilization of its
+// replicas stays near the targets. Stabilization windows make the engine act
+// on the most cautious count asked for within them, and cooldowns keep it
+// from scaling again right after it scaled or tried to.
+type AutoscalingPolicy struct {
+	Enabled                bool          `json:"enabled"`
+	MinReplicas            int           `json:"min_replicas"`
+	MaxReplicas            int           `json:"max_replicas"`
+	TargetCPU              float64       `json:"target_cpu,omitempty"`    // percent of the CPU limit, or of a core without one
+	TargetMemory           float64       `json:"target_memory,omitempty"` // percent of the memory limit
+	ScaleUpStabilization   time.Duration `json:"scale_up_stabilization,omitempty"`
+	ScaleDownStabilization time.Duration `json:"scale_down_stabilization,omitempty"`
+	ScaleUpCooldown        time.Duration `json:"scale_up_cooldown,omitempty"`   // wait after a decision before scaling up
+	ScaleDownCooldown      time.Duration `json:"scale_down_cooldown,omitempty"` // wait after a decision before scaling down
+}
+
+// AutoscalingStatus is what the autoscaler last saw of a deployment and did
+// about it
+type AutoscalingStatus struct {
+	CPUUtilization    float64    `json:"cpu_utilization"`    // average over the replicas, percent
+	MemoryUtilization float64    `json:"memory_utilization"` // average over the replicas, percent
+	DesiredReplicas   int        `json:"desired_replicas"`
+	LastScaleTime     *time.Time `json:"last_scale_time,omitempty"`
+	LastDecision      string     `json:"last_decision,omitempty"`
+	LastDecisionTime  *time.Time `json:"last_decision_time,omitempty"` // when it last scaled or tried to
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// autoscaleSample is a replica count the autoscaler asked for at one point
+// in time
+type autoscaleSample struct {
+	replicas int
+	at       time.Time
+}
+
+// autoscale works out how many replicas a deployment should run from the
+// metrics just collected of its replicas, and scales it in the background
+// when that differs from what it runs. The caller must not hold de.mu, as the
+// status of the deployment is updated under it.
+func (de *DeploymentEngine) autoscale(deployment *Deployment, replicas []DeploymentMetrics) {
+	de.mu.Lock()
+	policy := deployment.Config.Autoscaling
+	if !policy.Enabled || len(replicas) == 0 || deployment.Status != StatusRunning {
+		de.mu.Unlock()
+		return
+	}
+
+	current := len(deploymentReplicas(deployment))
+	now := time.Now()
+
+	cpu, memory := replicaUtilization(deployment, replicas)
+	recommended := autoscaleRecommendation(policy, current, cpu, memory)
+
+	de.monitorMu.Lock()
+	samples := de.recordAutoscaleSample(deployment.ID, policy, recommended, now)
+	busy := de.autoscaling[deployment.ID] || de.remediating[deployment.ID] || de.correctingDrift[deployment.ID]
+	de.monitorMu.Unlock()
+
+	desired := stabilizedReplicas(policy, current, samples, now)
+
+	// Readers hold on to the status they got, so it is replaced rather than
+	// changed in place
+	status := AutoscalingStatus{}
+	if deployment.Autoscaling != nil {
+		status = *deployment.Autoscaling
+	}
+	status.CPUUtilization = cpu
+	status.MemoryUtilization = memory
+	status.DesiredReplicas = desired
+	status.UpdatedAt = now
+	deployment.Autoscaling = &status
+	de.mu.Unlock()
+
+	if de.monitor != nil {
+		de.monitor.RecordAutoscaling(deployment.ID, current, desired, cpu, memory)
+	}
+
+	if desired == current || busy {
+		return
+	}
+
+	// Limited and failed attempts wait out the cooldown as well, so they are
+	// not retried and reported on every collection
+	cooldown := autoscaleScaleUpCooldown(policy)
+	if desired < current {
+		cooldown = autoscaleScaleDownCooldown(policy)
+	}
+	if status.LastDecisionTime != nil && now.Sub(*status.LastDecisionTime) < cooldown {
+		return
+	}
+
+	reason := fmt.Sprintf("cpu %.0f%%, memory %.0f%% of limits", cpu, memory)
+
+	de.monitorMu.Lock()
+	if de.autoscaling[deployment.ID] {
+		de.monitorMu.Unlock()
+		return
+	}
+	de.autoscaling[deployment.ID] = true
+	de.monitorMu.Unlock()
+
+	de.wg.Add(1)
+	go de.scaleAutomatically(deployment, current, desired, reason)
+}
+
+// scaleAutomatically carries out a scaling decision of the autoscaler. More
+// replicas are only added as far as the quotas and the host admit them.
+func (de *DeploymentEngine) scaleAutomatically(deployment *Deployment, current, desired int, reason string) {
+	defer de.wg.Done()
+	defer func() {
+		de.monitorMu.Lock()
+		delete(de.autoscaling, deployment.ID)
+		de.monitorMu.Unlock()
+	}()
+
+	direction := "up"
+	if desired < current {
+		direction = "down"
+	}
+
+	target := desired
+	var limitedBy error
+	if desired > current {
+		ctx, cancel := context.WithTimeout(de.ctx, 30*time.Second)
+		target, limitedBy = de.admittedReplicas(ctx, deployment, current, desired)
+		cancel()
+
+		if target == current {
+			de.recordAutoscaleDecision(deployment, current, desired, current, direction, "limited", reason, limitedBy)
+			return
+		}
+	}
+
+	if err := de.Scale(deployment.ID, target); err != nil {
+		de.recordAutoscaleDecision(deployment, current, desired, current, direction, "failed", reason, err)
+		return
+	}
+
+	result := "scaled"
+	if target != desired {
+		result = "limited"
+	}
+	de.recordAutoscaleDecision(deployment, current, desired, target, direction, result, reason, limitedBy)
+}
+
+// admittedReplicas returns how many of the desired replicas the quotas of
+// the app and the host capacity admit, and what held it back
+func (de *DeploymentEngine) admittedReplicas(ctx context.Context, deployment *Deployment, current, desired int) (int, error) {
+	var limitedBy error
+	for target := desired; target > current; target-- {
+		added := target - current
+
+		if errs := de.checkQuotas(ctx, deployment.AppID, deployment.ResourceLimits, added, false); len(errs) > 0 {
+			limitedBy = errs[0]
+			continue
+		}
+		if err := de.checkCapacity(ctx, deployment.AppID, deployment.ResourceLimits, added, false); err != nil {
+			limitedBy = err
+			continue
+		}
+
+		return target, limitedBy
+	}
+
+	return current, limitedBy
+}
+
+// recordAutoscaleDecision audits, logs and exports a scaling decision
+func (de *DeploymentEngine) recordAutoscaleDecision(deployment *Deployment, current, desired, target int, direction, result, reason string, cause error) {
+	decision := fmt.Sprintf("scaled %s from %d to %d replicas (%s)", direction, current, target, reason)
+	switch {
+	case result == "limited" && target == current:
+		decision = fmt.Sprintf("could not scale up from %d to %d replicas: %v", current, desired, cause)
+	case result == "limited":
+		decision = fmt.Sprintf("scaled up from %d to %d of %d replicas: %v", current, target, desired, cause)
+	case result == "failed":
+		decision = fmt.Sprintf("failed to scale %s from %d to %d replicas: %v", direction, current, desired, cause)
+	}
+
+	now := time.Now()
+	de.mu.Lock()
+	status := AutoscalingStatus{}
+	if deployment.Autoscaling != nil {
+		status = *deployment.Autoscaling
+	}
+	status.LastDecision = decision
+	status.LastDecisionTime = &now
+	if target != current {
+		status.LastScaleTime = &now
+	}
+	deployment.Autoscaling = &status
+	de.mu.Unlock()
+
+	level := "info"
+	if result != "scaled" {
+		level = "warn"
+	}
+	de.addDeploymentLog(deployment, level, "Autoscaler "+decision)
+
+	details := map[string]interface{}{
+		"deployment_id":     deployment.ID,
+		"app_id":            deployment.AppID,
+		"direction":         direction,
+		"result":            result,
+		"previous_replicas": current,
+		"desired_replicas":  desired,
+		"replicas":          target,
+		"reason":            reason,
+	}
+	if cause != nil {
+		details["error"] = cause.Error()
+	}
+
+	event := "DEPLOYMENT_AUTOSCALED"
+	if result == "failed" {
+		event = "DEPLOYMENT_AUTOSCALE_FAILED"
+		logrus.Warnf("Autoscaling deployment %s failed: %v", deployment.ID, cause)
+	}
+	de.auditLogger.LogEvent(event, details)
+
+	if de.monitor != nil {
+		de.monitor.RecordScaleEvent(deployment.AppID, direction, result)
+	}
+
+	de.saveDeployment(deployment)
+}
+
+// recordAutoscaleSample keeps the replica counts asked for within the
+// longest stabilization window. The caller holds monitorMu.
+func (de *DeploymentEngine) recordAutoscaleSample(deploymentID string, policy AutoscalingPolicy, replicas int, now time.Time) []autoscaleSample {
+	window := max(autoscaleScaleUpStabilization(policy), autoscaleScaleDownStabilization(policy))
+
+	var kept []autoscaleSample
+	for _, sample := range de.autoscaleSamples[deploymentID] {
+		if now.Sub(sample.at) <= window {
+			kept = append(kept, sample)
+		}
+	}
+	kept = append(kept, autoscaleSample{replicas: replicas, at: now})
+	de.autoscaleSamples[deploymentID] = kept
+
+	return append([]autoscaleSample(nil), kept...)
+}
+
+// replicaUtilization averages the CPU and memory utilization of the replicas
+// of a deployment, in percent of their limits
+func replicaUtilization(deployment *Deployment, replicas []DeploymentMetrics) (float64, float64) {
+	cores := deployment.ResourceLimits.CPULimit
+	if cores <= 0 {
+		cores = 1
+	}
+
+	var cpu, memory float64
+	for _, metrics := range replicas {
+		cpu += metrics.CPUUsage / cores // usage is in percent of a core
+
+		memoryLimit := deployment.ResourceLimits.MemoryLimit
+		if memoryLimit <= 0 {
+			memoryLimit = metrics.MemoryLimit // what the runtime caps the container at
+		}
+		if memoryLimit > 0 {
+			memory += float64(metrics.MemoryUsage) / float64(memoryLimit) * 100
+		}
+	}
+
+	count := float64(len(replicas))
+	return cpu / count, memory / count
+}
+
+// autoscaleRecommendation returns the replica count that brings the average
+// utilization to the targets, the highest one asked for by either
+func autoscaleRecommendation(policy AutoscalingPolicy, current int, cpu, memory float64) int {
+	recommended := 0
+
+	targets := []struct{ utilization, target float64 }{
+		{cpu, autoscaleTargetCPU(policy)},
+		{memory, policy.TargetMemory},
+	}
+	for _, metric := range targets {
+		if metric.target <= 0 {
+			continue
+		}
+
+		ratio := metric.utilization / metric.target
+		replicas := current
+		if math.Abs(ratio-1) > autoscaleTolerance {
+			replicas = int(math.Ceil(float64(current) * ratio))
+		}
+		recommended = max(recommended, replicas)
+	}
+
+	return min(max(recommended, policy.MinReplicas, 1), policy.MaxReplicas)
+}
+
+// stabilizedReplicas settles on the replica count to act on: scaling up goes
+// no further than the lowest count asked for within its window, scaling down
+// no further than the highest
+func stabilizedReplicas(policy AutoscalingPolicy, current int, samples []autoscaleSample, now time.Time) int {
+	upWindow := autoscaleScaleUpStabilization(policy)
+	downWindow := autoscaleScaleDownStabilization(policy)
+
+	lowest, highest := math.MaxInt, 0
+	for _, sample := range samples {
+		age := now.Sub(sample.at)
+		if age <= upWindow {
+			lowest = min(lowest, sample.replicas)
+		}
+		if age <= downWindow {
+			highest = max(highest, sample.replicas)
+		}
+	}
+
+	switch {
+	case lowest != math.MaxInt && lowest > current:
+		return lowest
+	case highest > 0 && highest < current:
+		return highest
+	default:
+		return current
+	}
+}
+
+// validateAutoscaling checks an autoscaling policy against the resource
+// limits it is measured against
+func validateAutoscaling(request *DeploymentRequest) error {
+	policy := request.Config.Autoscaling
+	if !policy.Enabled {
+		return nil
+	}
+
+	if policy.MinReplicas < 1 {
+		return fmt.Errorf("invalid autoscaling policy, min replicas must be at least 1")
+	}
+	if policy.MaxReplicas < policy.MinReplicas {
+		return fmt.Errorf("invalid autoscaling policy, max replicas %d is below min replicas %d", policy.MaxReplicas, policy.MinReplicas)
+	}
+	if policy.TargetCPU < 0 || policy.TargetMemory < 0 {
+		return fmt.Errorf("invalid autoscaling policy, targets must not be negative")
+	}
+	if policy.TargetMemory > 0 && request.ResourceLimits.MemoryLimit <= 0 {
+		return fmt.Errorf("invalid autoscaling policy, a memory target requires a memory limit")
+	}
+	if policy.ScaleUpStabilization < 0 || policy.ScaleDownStabilization < 0 || policy.ScaleUpCooldown < 0 || policy.ScaleDownCooldown < 0 {
+		return fmt.Errorf("invalid autoscaling policy, durations must not be negative")
+	}
+
+	return nil
+}
+
+func autoscaleTargetCPU(policy AutoscalingPolicy) float64 {
+	if policy.TargetCPU > 0 || policy.TargetMemory > 0 {
+		return policy.TargetCPU
+	}
+	return defaultAutoscaleTargetCPU
+}
+
+func autoscaleScaleUpStabilization(policy AutoscalingPolicy) time.Duration {
+	if policy.ScaleUpStabilization > 0 {
+		return policy.ScaleUpStabilization
+	}
+	return defaultScaleUpStabilization
+}
+
+func autoscaleScaleDownStabilization(policy AutoscalingPolicy) time.Duration {
+	if policy.ScaleDownStabilization > 0 {
+		return policy.ScaleDownStabilization
+	}
+	return defaultScaleDownStabilization
+}
+
+func autoscaleScaleUpCooldown(policy AutoscalingPolicy) time.Duration {
+	if policy.ScaleUpCooldown > 0 {
+		return policy.ScaleUpCooldown
+	}
+	return defaultScaleUpCooldown
+}
+
+func autoscaleScaleDownCooldown(policy AutoscalingPolicy) time.Duration {
+	if policy.ScaleDownCooldown > 0 {
+		return policy.ScaleDownCooldown
+	}
+	return defaultScaleDownCooldown
+}
@@ -0,0 +1,134 @@
+package deploy
+
+import (
+	"testing"
+	"time"
+
+	"superagent/internal/deploy/resources"
+)
+
+func TestAutoscaleRecommendation(t *testing.T) {
+	policy := AutoscalingPolicy{Enabled: true, MinReplicas: 1, MaxReplicas: 5}
+
+	tests := []struct {
+		name    string
+		policy  AutoscalingPolicy
+		current int
+		cpu     float64
+		memory  float64
+		want    int
+	}{
+		{"on target", policy, 2, 80, 0, 2},
+		{"within tolerance above", policy, 2, 85, 0, 2},
+		{"within tolerance below", policy, 2, 75, 0, 2},
+		{"scale up", policy, 2, 160, 0, 4},
+		{"scale up rounds up", policy, 3, 100, 0, 4},
+		{"scale down", policy, 4, 40, 0, 2},
+		{"clamped to max", policy, 3, 400, 0, 5},
+		{"clamped to min", AutoscalingPolicy{Enabled: true, MinReplicas: 2, MaxReplicas: 5}, 4, 10, 0, 2},
+		{"never below one", AutoscalingPolicy{Enabled: true, MaxReplicas: 5}, 4, 0, 0, 1},
+		{"custom cpu target", AutoscalingPolicy{Enabled: true, MinReplicas: 1, MaxReplicas: 10, TargetCPU: 50}, 2, 100, 0, 4},
+		{"memory target only", AutoscalingPolicy{Enabled: true, MinReplicas: 1, MaxReplicas: 10, TargetMemory: 50}, 2, 400, 100, 4},
+		{"highest of both targets", AutoscalingPolicy{Enabled: true, MinReplicas: 1, MaxReplicas: 10, TargetCPU: 50, TargetMemory: 50}, 2, 100, 150, 6},
+		{"busy memory keeps idle cpu up", AutoscalingPolicy{Enabled: true, MinReplicas: 1, MaxReplicas: 10, TargetCPU: 50, TargetMemory: 50}, 4, 5, 50, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := autoscaleRecommendation(tt.policy, tt.current, tt.cpu, tt.memory); got != tt.want {
+				t.Errorf("autoscaleRecommendation(%d, cpu %.0f, memory %.0f) = %d, want %d", tt.current, tt.cpu, tt.memory, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStabilizedReplicas(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+
+	policy := AutoscalingPolicy{Enabled: true, MinReplicas: 1, MaxReplicas: 10}
+	upWindow := AutoscalingPolicy{Enabled: true, MinReplicas: 1, MaxReplicas: 10, ScaleUpStabilization: 2 * time.Minute}
+
+	tests := []struct {
+		name    string
+		policy  AutoscalingPolicy
+		current int
+		samples []autoscaleSample
+		want    int
+	}{
+		{"no change asked", policy, 3, []autoscaleSample{{3, now}}, 3},
+		{"scale up at once", policy, 2, []autoscaleSample{{2, ago(time.Minute)}, {4, now}}, 4},
+		{"scale down held by recent higher count", policy, 4, []autoscaleSample{{4, ago(4 * time.Minute)}, {2, now}}, 4},
+		{"scale down to highest in window", policy, 4, []autoscaleSample{{3, ago(4 * time.Minute)}, {2, ago(time.Minute)}, {2, now}}, 3},
+		{"scale down once window passed", policy, 4, []autoscaleSample{{4, ago(6 * time.Minute)}, {2, ago(4 * time.Minute)}, {2, now}}, 2},
+		{"scale up held by lower count in window", upWindow, 2, []autoscaleSample{{2, ago(time.Minute)}, {5, now}}, 2},
+		{"scale up to lowest in window", upWindow, 2, []autoscaleSample{{3, ago(time.Minute)}, {5, now}}, 3},
+		{"scale up once window passed", upWindow, 2, []autoscaleSample{{2, ago(3 * time.Minute)}, {5, ago(time.Minute)}, {5, now}}, 5},
+		{"custom down window", AutoscalingPolicy{Enabled: true, MinReplicas: 1, MaxReplicas: 10, ScaleDownStabilization: time.Minute}, 4, []autoscaleSample{{4, ago(2 * time.Minute)}, {2, now}}, 2},
+		{"no samples", policy, 3, nil, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stabilizedReplicas(tt.policy, tt.current, tt.samples, now); got != tt.want {
+				t.Errorf("stabilizedReplicas(%d) = %d, want %d", tt.current, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplicaUtilization(t *testing.T) {
+	deployment := &Deployment{ResourceLimits: resources.ResourceLimits{CPULimit: 2, MemoryLimit: 1000}}
+	replicas := []DeploymentMetrics{
+		{CPUUsage: 100, MemoryUsage: 500},
+		{CPUUsage: 60, MemoryUsage: 300},
+	}
+
+	cpu, memory := replicaUtilization(deployment, replicas)
+	if cpu != 40 || memory != 40 {
+		t.Errorf("replicaUtilization = cpu %.1f, memory %.1f, want 40, 40", cpu, memory)
+	}
+
+	// Without limits CPU is measured against a core and memory against what
+	// the runtime caps the container at
+	cpu, memory = replicaUtilization(&Deployment{}, []DeploymentMetrics{{CPUUsage: 50, MemoryUsage: 100, MemoryLimit: 400}})
+	if cpu != 50 || memory != 25 {
+		t.Errorf("replicaUtilization without limits = cpu %.1f, memory %.1f, want 50, 25", cpu, memory)
+	}
+}
+
+func TestValidateAutoscaling(t *testing.T) {
+	request := func(policy AutoscalingPolicy, memoryLimit int64) *DeploymentRequest {
+		return &DeploymentRequest{
+			Config:         DeploymentConfig{Autoscaling: policy},
+			ResourceLimits: resources.ResourceLimits{MemoryLimit: memoryLimit},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		request *DeploymentRequest
+		valid   bool
+	}{
+		{"disabled", request(AutoscalingPolicy{MinReplicas: 5, MaxReplicas: 1}, 0), true},
+		{"valid", request(AutoscalingPolicy{Enabled: true, MinReplicas: 1, MaxReplicas: 3}, 0), true},
+		{"memory target with limit", request(AutoscalingPolicy{Enabled: true, MinReplicas: 1, MaxReplicas: 3, TargetMemory: 70}, 1<<30), true},
+		{"min below one", request(AutoscalingPolicy{Enabled: true, MaxReplicas: 3}, 0), false},
+		{"max below min", request(AutoscalingPolicy{Enabled: true, MinReplicas: 3, MaxReplicas: 2}, 0), false},
+		{"negative target", request(AutoscalingPolicy{Enabled: true, MinReplicas: 1, MaxReplicas: 3, TargetCPU: -1}, 0), false},
+		{"memory target without limit", request(AutoscalingPolicy{Enabled: true, MinReplicas: 1, MaxReplicas: 3, TargetMemory: 70}, 0), false},
+		{"negative cooldown", request(AutoscalingPolicy{Enabled: true, MinReplicas: 1, MaxReplicas: 3, ScaleUpCooldown: -time.Second}, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAutoscaling(tt.request)
+			if tt.valid && err != nil {
+				t.Errorf("validateAutoscaling() = %v, want no error", err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("validateAutoscaling() succeeded, want an error")
+			}
+		})
+	}
+}
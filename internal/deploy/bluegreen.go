@@ -0,0 +1,336 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"superagent/internal/deploy/docker"
+	"superagent/internal/deploy/routing"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	colorBlue  = "blue"
+	colorGreen = "green"
+
+	// defaultWarmPeriod is how long the previous set stays available for an
+	// instant rollback after a blue-green switch
+	defaultWarmPeriod = 5 * time.Minute
+)
+
+// blueGreenUpdate starts the complete new set of replicas next to the live
+// deployment, health checks it and then moves all traffic over in a single
+// routing change. The previous set is kept running in standby for the warm
+// period so a rollback only has to switch the route back.
+func (de *DeploymentEngine) blueGreenUpdate(ctx context.Context, live, deployment *Deployment, containerConfig docker.ContainerConfig) error {
+	de.stopDeploymentMonitoring(live.ID)
+	de.updateDeploymentStatus(live, StatusUpdating)
+
+	deployment.Color = oppositeColor(live.Color)
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Starting %s set next to %s deployment %s", deployment.Color, colorOrDefault(live.Color), live.ID))
+
+	replicas, err := de.startReplicas(ctx, deployment, containerConfig, deployment.Ports, 0, desiredReplicas(deployment), nil)
+	if err != nil {
+		de.restoreLiveDeployment(live, deployment, err)
+		return fmt.Errorf("failed to deploy %s set: %w", deployment.Color, err)
+	}
+
+	de.setReplicas(deployment, replicas)
+
+	if deployment.HealthCheck.Enabled {
+		de.updateDeploymentStatus(deployment, StatusHealthCheck)
+		if err := de.checkReplicas(ctx, deployment, replicas, deployment.HealthCheck, deployment.Ports); err != nil {
+			de.removeReplicas(replicas)
+			de.setReplicas(deployment, nil)
+			de.restoreLiveDeployment(live, deployment, err)
+			return fmt.Errorf("health check of %s set failed: %w", deployment.Color, err)
+		}
+	} else {
+		for _, replica := range replicas {
+			replica.Status = ReplicaRunning
+		}
+	}
+
+	if err := de.switchTraffic(deployment); err != nil {
+		de.removeReplicas(replicas)
+		de.setReplicas(deployment, nil)
+		de.restoreLiveDeployment(live, deployment, err)
+		return fmt.Errorf("failed to switch traffic to %s set: %w", deployment.Color, err)
+	}
+
+	standbyUntil := time.Now().Add(warmPeriod(deployment))
+	live.StandbyUntil = &standbyUntil
+	live.LiveColor = deployment.Color
+	deployment.LiveColor = deployment.Color
+
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Traffic switched to %s set", deployment.Color))
+	de.addDeploymentLog(live, "info", fmt.Sprintf("Traffic switched to %s deployment %s, keeping this set warm until %s", deployment.Color, deployment.ID, standbyUntil.Format(time.RFC3339)))
+	de.updateDeploymentStatus(live, StatusStandby)
+	de.scheduleStandbyRetirement(live)
+
+	de.auditLogger.LogEvent("DEPLOYMENT_TRAFFIC_SWITCHED", map[string]interface{}{
+		"deployment_id":          deployment.ID,
+		"previous_deployment_id": live.ID,
+		"live_color":             deployment.Color,
+		"standby_until":          standbyUntil,
+	})
+
+	return nil
+}
+
+// restoreLiveDeployment puts the live deployment back in service after a
+// blue-green update was abandoned
+func (de *DeploymentEngine) restoreLiveDeployment(live, deployment *Deployment, cause error) {
+	de.addDeploymentLog(live, "warn", fmt.Sprintf("Blue-green update to version %s abandoned: %v", deployment.Version, cause))
+	de.updateDeploymentStatus(live, StatusRunning)
+	de.startDeploymentMonitoring(live)
+
+	de.auditLogger.LogEvent("DEPLOYMENT_BLUE_GREEN_ABORTED", map[string]interface{}{
+		"deployment_id":          deployment.ID,
+		"previous_deployment_id": live.ID,
+		"error":                  cause.Error(),
+	})
+}
+
+// instantRollback sends traffic back to the standby set of a blue-green
+// deployment and retires the set that was live
+func (de *DeploymentEngine) instantRollback(deployment, standby *Deployment, previousStatus DeploymentStatus, reason string) (*RollbackInfo, error) {
+	de.stopDeploymentMonitoring(deployment.ID)
+	de.updateDeploymentStatus(deployment, StatusRollingBack)
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Switching traffic back to %s deployment %s: %s", standby.Color, standby.ID, reason))
+
+	if err := de.switchTraffic(standby); err != nil {
+		de.updateDeploymentStatus(standby, StatusStandby)
+
+		de.addDeploymentLog(deployment, "error", fmt.Sprintf("Rollback failed: %v", err))
+		de.updateDeploymentStatus(deployment, previousStatus)
+		if previousStatus == StatusRunning {
+			de.startDeploymentMonitoring(deployment)
+		}
+
+		de.auditLogger.LogEvent("DEPLOYMENT_ROLLBACK_FAILED", map[string]interface{}{
+			"deployment_id":          deployment.ID,
+			"restored_deployment_id": standby.ID,
+			"error":                  err.Error(),
+		})
+
+		return nil, fmt.Errorf("rollback failed: %w", err)
+	}
+
+	standby.StandbyUntil = nil
+	standby.LiveColor = standby.Color
+	de.addDeploymentLog(standby, "info", fmt.Sprintf("Traffic switched back from deployment %s", deployment.ID))
+	de.updateDeploymentStatus(standby, StatusRunning)
+	de.startDeploymentMonitoring(standby)
+
+	rollbackInfo := &RollbackInfo{
+		PreviousVersion:      deployment.Version,
+		RestoredVersion:      standby.Version,
+		RestoredImageID:      standby.ImageID,
+		RestoredDeploymentID: standby.ID,
+		Reason:               reason,
+		Timestamp:            time.Now(),
+		Status:               "completed",
+	}
+
+	deployment.Rollback = rollbackInfo
+	deployment.LiveColor = standby.Color
+
+	de.removeReplicas(deployment.Replicas)
+	de.setReplicas(deployment, nil)
+
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Rolled back to %s deployment %s (version %s)", standby.Color, standby.ID, standby.Version))
+	de.updateDeploymentStatus(deployment, StatusStopped)
+
+	de.auditLogger.LogEvent("DEPLOYMENT_ROLLBACK", map[string]interface{}{
+		"deployment_id":          deployment.ID,
+		"previous_version":       rollbackInfo.PreviousVersion,
+		"restored_version":       rollbackInfo.RestoredVersion,
+		"restored_image_id":      rollbackInfo.RestoredImageID,
+		"restored_deployment_id": rollbackInfo.RestoredDeploymentID,
+		"live_color":             standby.Color,
+		"reason":                 reason,
+	})
+
+	return rollbackInfo, nil
+}
+
+// standbyDeployment returns the warm set a live blue-green deployment can
+// roll back to. Callers must hold de.mu.
+func (de *DeploymentEngine) standbyDeployment(deployment *Deployment) *Deployment {
+	if deployment.Color == "" || deployment.LiveColor != deployment.Color {
+		return nil
+	}
+
+	var standby *Deployment
+	for _, candidate := range de.deployments {
+		if candidate.ID == deployment.ID || candidate.AppID != deployment.AppID || candidate.Status != StatusStandby {
+			continue
+		}
+		if standby == nil || candidate.CreatedAt.After(standby.CreatedAt) {
+			standby = candidate
+		}
+	}
+
+	return standby
+}
+
+// scheduleStandbyRetirement removes a standby set once its warm period is over
+func (de *DeploymentEngine) scheduleStandbyRetirement(deployment *Deployment) {
+	delay := time.Duration(0)
+	if deployment.StandbyUntil != nil {
+		delay = time.Until(*deployment.StandbyUntil)
+	}
+
+	de.wg.Add(1)
+	go func() {
+		defer de.wg.Done()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-de.ctx.Done():
+			return
+		case <-timer.C:
+			de.retireStandby(deployment)
+		}
+	}()
+}
+
+// retireStandby stops and removes the replicas of a standby deployment
+func (de *DeploymentEngine) retireStandby(deployment *Deployment) {
+	de.mu.Lock()
+	if deployment.Status != StatusStandby {
+		// Rolled back to in the meantime
+		de.mu.Unlock()
+		return
+	}
+	deployment.Status = StatusStopping
+	de.mu.Unlock()
+
+	de.updateDeploymentStatus(deployment, StatusStopping)
+
+	de.removeReplicas(deployment.Replicas)
+	de.setReplicas(deployment, nil)
+	deployment.StandbyUntil = nil
+
+	de.addDeploymentLog(deployment, "info", "Warm period over, standby replicas removed")
+	de.updateDeploymentStatus(deployment, StatusStopped)
+
+	de.auditLogger.LogEvent("DEPLOYMENT_STANDBY_RETIRED", map[string]interface{}{
+		"deployment_id": deployment.ID,
+		"color":         deployment.Color,
+	})
+}
+
+// switchTraffic routes all traffic of an app to the replicas of a deployment.
+// Without Traefik routing no traffic can be moved, so that is an error.
+func (de *DeploymentEngine) switchTraffic(deployment *Deployment) error {
+	if !de.routingManager.Enabled() {
+		return fmt.Errorf("cannot route traffic of app %s, Traefik routing is disabled", deployment.AppID)
+	}
+
+	servers := routeServers(deployment)
+	if len(servers) == 0 {
+		return fmt.Errorf("deployment %s has no published port to route traffic to", deployment.ID)
+	}
+
+	return de.routingManager.SetRoute(routing.Route{
+		AppID:   deployment.AppID,
+		Color:   deployment.Color,
+		Servers: servers,
+	})
+}
+
+// removeRoute removes the route of the app of a deployment that is going
+// away, unless another deployment of the app is still active to take its
+// traffic. Callers must hold de.mu.
+func (de *DeploymentEngine) removeRoute(deployment *Deployment) {
+	if deployment.Color == "" || !de.routingManager.Enabled() {
+		return
+	}
+
+	for _, other := range de.deployments {
+		if other.ID != deployment.ID && other.AppID == deployment.AppID && other.Color != "" && !deploymentFinished(other) {
+			return
+		}
+	}
+
+	if err := de.routingManager.RemoveRoute(deployment.AppID); err != nil {
+		logrus.Warnf("Failed to remove route of app %s: %v", deployment.AppID, err)
+	}
+}
+
+// routeServers returns the backend URLs of the running replicas of a deployment
+func routeServers(deployment *Deployment) []string {
+	var servers []string
+	for _, replica := range deployment.Replicas {
+		if replica.Status != ReplicaRunning {
+			continue
+		}
+		for _, port := range replica.Ports {
+			if port.HostPort == 0 || protocolOrTCP(port.Protocol) != "tcp" {
+				continue
+			}
+			host := port.HostIP
+			if host == "" || host == "0.0.0.0" {
+				host = "127.0.0.1"
+			}
+			servers = append(servers, "http://"+net.JoinHostPort(host, strconv.Itoa(port.HostPort)))
+			break
+		}
+	}
+	return servers
+}
+
+// warmPeriod returns how long the previous set of a blue-green deployment stays up
+func warmPeriod(deployment *Deployment) time.Duration {
+	if deployment.Config.WarmPeriod > 0 {
+		return deployment.Config.WarmPeriod
+	}
+	return defaultWarmPeriod
+}
+
+func oppositeColor(color string) string {
+	if color == colorGreen {
+		return colorBlue
+	}
+	return colorGreen
+}
+
+func colorOrDefault(color string) string {
+	if color == "" {
+		return colorBlue
+	}
+	return color
+}
+
+// reconcileStandby resumes the warm period of a standby deployment after an
+// agent restart
+func (de *DeploymentEngine) reconcileStandby(ctx context.Context, deployment *Deployment) {
+	running := 0
+	for _, replica := range deploymentReplicas(deployment) {
+		info, err := de.dockerManager.GetContainerInfo(ctx, replica.ContainerID)
+		if err != nil || info.State != "running" {
+			replica.Status = ReplicaFailed
+			continue
+		}
+		running++
+	}
+
+	if running == 0 {
+		logrus.Warnf("Standby deployment %s has no running containers left", deployment.ID)
+		de.setReplicas(deployment, nil)
+		deployment.StandbyUntil = nil
+		de.addDeploymentLog(deployment, "warn", "Standby replicas no longer exist after agent restart")
+		de.updateDeploymentStatus(deployment, StatusStopped)
+		return
+	}
+
+	de.scheduleStandbyRetirement(deployment)
+}
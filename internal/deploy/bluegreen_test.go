@@ -0,0 +1,56 @@
+package deploy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"superagent/internal/deploy/routing"
+)
+
+func TestRouteServers(t *testing.T) {
+	deployment := &Deployment{Replicas: []*Replica{
+		{Status: ReplicaRunning, Ports: []PortMapping{{ContainerPort: 53, HostPort: 5353, Protocol: "udp"}, {ContainerPort: 80, HostPort: 8080}}},
+		{Status: ReplicaRunning, Ports: []PortMapping{{ContainerPort: 80, HostPort: 8081, HostIP: "10.0.0.5", Protocol: "tcp"}}},
+		{Status: ReplicaUnhealthy, Ports: []PortMapping{{ContainerPort: 80, HostPort: 8082}}},
+		{Status: ReplicaRunning, Ports: []PortMapping{{ContainerPort: 80}}},
+	}}
+
+	got := strings.Join(routeServers(deployment), ",")
+	if want := "http://127.0.0.1:8080,http://10.0.0.5:8081"; got != want {
+		t.Errorf("servers %s, want %s", got, want)
+	}
+}
+
+func TestColors(t *testing.T) {
+	if got := colorOrDefault(""); got != colorBlue {
+		t.Errorf("default color %s, want %s", got, colorBlue)
+	}
+	if got := oppositeColor(colorOrDefault("")); got != colorGreen {
+		t.Errorf("opposite of blue %s, want %s", got, colorGreen)
+	}
+	if got := oppositeColor(colorGreen); got != colorBlue {
+		t.Errorf("opposite of green %s, want %s", got, colorBlue)
+	}
+}
+
+func TestWarmPeriod(t *testing.T) {
+	if got := warmPeriod(&Deployment{}); got != defaultWarmPeriod {
+		t.Errorf("default warm period %s, want %s", got, defaultWarmPeriod)
+	}
+	if got := warmPeriod(&Deployment{Config: DeploymentConfig{WarmPeriod: time.Minute}}); got != time.Minute {
+		t.Errorf("warm period %s, want 1m", got)
+	}
+}
+
+func TestSwitchTrafficWithoutRouting(t *testing.T) {
+	de := &DeploymentEngine{routingManager: &routing.RoutingManager{}}
+	deployment := &Deployment{ID: "d1", AppID: "web", Replicas: []*Replica{
+		{Status: ReplicaRunning, Ports: []PortMapping{{ContainerPort: 80, HostPort: 8080}}},
+	}}
+
+	err := de.switchTraffic(deployment)
+	if err == nil || !strings.Contains(err.Error(), "Traefik routing is disabled") {
+		t.Fatalf("switchTraffic error %v, want routing to be required", err)
+	}
+}
@@ -0,0 +1,439 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"superagent/internal/deploy/docker"
+	"superagent/internal/deploy/routing"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCanaryStepInterval = time.Minute
+	defaultCanaryMaxErrorRate = 0.05
+
+	// canaryProbeInterval is how often the canary replicas are probed while
+	// a step is analysed
+	canaryProbeInterval = 5 * time.Second
+
+	canaryPromote  = "promote"
+	canaryRollback = "rollback"
+)
+
+// defaultCanarySteps is the share of traffic in percent the canary receives
+// at each step before it is promoted
+var defaultCanarySteps = []int{10, 25, 50}
+
+// CanaryConfig controls how a canary release shifts traffic to a new version
+type CanaryConfig struct {
+	Steps        []int         `json:"steps,omitempty"`          // percentage of traffic per step
+	StepInterval time.Duration `json:"step_interval,omitempty"`  // how long each step is analysed
+	MaxErrorRate float64       `json:"max_error_rate,omitempty"` // share of failed health checks tolerated
+	MaxLatency   time.Duration `json:"max_latency,omitempty"`    // 0 disables the latency check
+}
+
+// CanaryStatus records the progress of a canary release
+type CanaryStatus struct {
+	StableDeploymentID string           `json:"stable_deployment_id,omitempty"`
+	Step               int              `json:"step"`
+	Weight             int              `json:"weight"`
+	Result             string           `json:"result,omitempty"` // "promoted" or "rolled_back"
+	Decisions          []CanaryDecision `json:"decisions"`
+	StartedAt          time.Time        `json:"started_at"`
+	CompletedAt        *time.Time       `json:"completed_at,omitempty"`
+}
+
+// CanaryDecision is the outcome of the analysis of one canary step
+type CanaryDecision struct {
+	Step                int           `json:"step"`
+	Weight              int           `json:"weight"`
+	Probes              int           `json:"probes"`
+	ErrorRate           float64       `json:"error_rate"`
+	AverageLatency      time.Duration `json:"average_latency"`
+	HealthCheckFailures int           `json:"health_check_failures"`
+	Decision            string        `json:"decision"` // "promote" or "rollback"
+	Reason              string        `json:"reason"`
+	Timestamp           time.Time     `json:"timestamp"`
+}
+
+// canaryRelease starts the new version next to the stable deployment and
+// shifts traffic to it step by step. After every step the health check
+// results of the canary decide whether it moves on or is rolled back. Once
+// the last step passes, all traffic goes to the canary and the stable
+// replicas are removed.
+func (de *DeploymentEngine) canaryRelease(ctx context.Context, stable, deployment *Deployment, containerConfig docker.ContainerConfig) error {
+	de.stopDeploymentMonitoring(stable.ID)
+	de.updateDeploymentStatus(stable, StatusUpdating)
+
+	stable.Color = colorOrDefault(stable.Color)
+	deployment.Color = oppositeColor(stable.Color)
+	deployment.Canary = &CanaryStatus{
+		StableDeploymentID: stable.ID,
+		Decisions:          []CanaryDecision{},
+		StartedAt:          time.Now(),
+	}
+
+	de.addDeploymentLog(stable, "info", fmt.Sprintf("Canary release of version %s started by deployment %s", deployment.Version, deployment.ID))
+
+	replicas, err := de.startReplicas(ctx, deployment, containerConfig, deployment.Ports, 0, desiredReplicas(deployment), nil)
+	if err != nil {
+		return de.abortCanary(stable, deployment, fmt.Errorf("failed to start canary replicas: %w", err))
+	}
+
+	de.setReplicas(deployment, replicas)
+
+	de.updateDeploymentStatus(deployment, StatusHealthCheck)
+	if err := de.checkReplicas(ctx, deployment, replicas, deployment.HealthCheck, deployment.Ports); err != nil {
+		return de.abortCanary(stable, deployment, fmt.Errorf("health check failed: %w", err))
+	}
+
+	de.updateDeploymentStatus(deployment, StatusCanary)
+
+	steps := canarySteps(deployment.Config.Canary)
+	for i, weight := range steps {
+		step := i + 1
+		deployment.Canary.Step = step
+		deployment.Canary.Weight = weight
+
+		if err := de.routeCanary(stable, deployment, weight); err != nil {
+			return de.abortCanary(stable, deployment, fmt.Errorf("failed to route %d%% of traffic to canary: %w", weight, err))
+		}
+
+		de.addDeploymentLog(deployment, "info", fmt.Sprintf("Canary step %d/%d: %d%% of traffic sent to version %s", step, len(steps), weight, deployment.Version))
+		de.saveDeployment(deployment)
+
+		de.auditLogger.LogEvent("DEPLOYMENT_CANARY_STEP", map[string]interface{}{
+			"deployment_id":        deployment.ID,
+			"stable_deployment_id": stable.ID,
+			"step":                 step,
+			"steps":                len(steps),
+			"weight":               weight,
+		})
+
+		// The progress deadline of a canary deployment includes the
+		// analysis time of every step
+		decision, err := de.analyseCanaryStep(ctx, deployment, step, weight)
+		if err != nil {
+			return de.abortCanary(stable, deployment, fmt.Errorf("canary analysis interrupted: %w", err))
+		}
+
+		deployment.Canary.Decisions = append(deployment.Canary.Decisions, decision)
+
+		level := "info"
+		if decision.Decision == canaryRollback {
+			level = "warn"
+		}
+		de.addDeploymentLog(deployment, level, fmt.Sprintf("Canary step %d decision: %s (%s)", step, decision.Decision, decision.Reason))
+		de.saveDeployment(deployment)
+
+		de.auditLogger.LogEvent("DEPLOYMENT_CANARY_DECISION", map[string]interface{}{
+			"deployment_id":         deployment.ID,
+			"step":                  step,
+			"weight":                weight,
+			"decision":              decision.Decision,
+			"reason":                decision.Reason,
+			"probes":                decision.Probes,
+			"error_rate":            decision.ErrorRate,
+			"average_latency":       decision.AverageLatency.Seconds(),
+			"health_check_failures": decision.HealthCheckFailures,
+		})
+
+		if decision.Decision == canaryRollback {
+			return de.abortCanary(stable, deployment, fmt.Errorf("step %d: %s", step, decision.Reason))
+		}
+	}
+
+	// Every step passed, the canary takes all traffic
+	if err := de.switchTraffic(deployment); err != nil {
+		return de.abortCanary(stable, deployment, fmt.Errorf("failed to switch traffic to canary: %w", err))
+	}
+
+	deployment.LiveColor = deployment.Color
+	stable.LiveColor = deployment.Color
+
+	de.removeReplicas(stable.Replicas)
+	de.setReplicas(stable, nil)
+
+	de.addDeploymentLog(stable, "info", fmt.Sprintf("Replaced by canary deployment %s (version %s)", deployment.ID, deployment.Version))
+	de.updateDeploymentStatus(stable, StatusStopped)
+
+	now := time.Now()
+	deployment.Canary.Result = "promoted"
+	deployment.Canary.CompletedAt = &now
+	deployment.Canary.Weight = 100
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Canary promoted after %d steps", len(steps)))
+
+	de.auditLogger.LogEvent("DEPLOYMENT_CANARY_PROMOTED", map[string]interface{}{
+		"deployment_id":          deployment.ID,
+		"previous_deployment_id": stable.ID,
+		"previous_version":       stable.Version,
+		"version":                deployment.Version,
+		"steps":                  len(steps),
+		"live_color":             deployment.Color,
+	})
+
+	return nil
+}
+
+// analyseCanaryStep probes the canary replicas for the step interval and
+// decides whether the canary may take more traffic
+func (de *DeploymentEngine) analyseCanaryStep(ctx context.Context, deployment *Deployment, step, weight int) (CanaryDecision, error) {
+	interval := canaryStepInterval(deployment.Config.Canary)
+
+	deadline := time.NewTimer(interval)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(min(canaryProbeInterval, interval))
+	defer ticker.Stop()
+
+	var stats probeStats
+
+	for {
+		select {
+		case <-ctx.Done():
+			return CanaryDecision{}, ctx.Err()
+		case <-deadline.C:
+			return de.decideCanary(deployment, step, weight, stats), nil
+		case <-ticker.C:
+			// A canary replica that keeps failing ends the step early
+			if unhealthy := de.probeCanary(ctx, deployment, &stats); unhealthy {
+				return de.decideCanary(deployment, step, weight, stats), nil
+			}
+		}
+	}
+}
+
+// probeCanary runs one round of health probes against the canary replicas
+// and publishes the resulting error rate and latency. It reports whether a
+// canary replica failed too many health checks in a row.
+func (de *DeploymentEngine) probeCanary(ctx context.Context, deployment *Deployment, stats *probeStats) bool {
+	// The replicas are probed without the lock and the results applied
+	// under it
+	de.mu.RLock()
+	var probed []*Replica
+	for _, replica := range deployment.Replicas {
+		if replica.Status == ReplicaRunning {
+			probed = append(probed, replica)
+		}
+	}
+	de.mu.RUnlock()
+
+	latencies := make([]time.Duration, len(probed))
+	errs := make([]error, len(probed))
+	for i, replica := range probed {
+		probeCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout(deployment.HealthCheck))
+		latencies[i], errs[i] = de.probeReplica(probeCtx, deployment, replica)
+		cancel()
+
+		stats.record(latencies[i], errs[i])
+	}
+
+	now := time.Now()
+	unhealthy := false
+
+	de.mu.Lock()
+	for i, replica := range probed {
+		replica.LastHealthCheck = &now
+
+		if errs[i] != nil {
+			replica.HealthCheckFailures++
+			if replica.HealthCheckFailures >= deployment.HealthCheck.FailureThreshold {
+				replica.Status = ReplicaUnhealthy
+			}
+		} else {
+			replica.HealthCheckFailures = 0
+		}
+	}
+	for _, replica := range deployment.Replicas {
+		if replica.Status == ReplicaUnhealthy {
+			unhealthy = true
+		}
+	}
+
+	deployment.LastHealthCheck = &now
+	deployment.Metrics.HealthCheckCount = stats.failures
+	deployment.Metrics.ErrorRate = stats.errorRate()
+	deployment.Metrics.AverageLatency = stats.averageLatency()
+	deployment.Metrics.LastUpdated = now
+	de.recordDeploymentMetrics(deployment)
+	de.mu.Unlock()
+
+	for i, replica := range probed {
+		if errs[i] != nil {
+			logrus.Debugf("Canary health check failed for replica %d of deployment %s: %v", replica.Index, deployment.ID, errs[i])
+		}
+		de.publishHealth(deployment, replica, latencies[i], errs[i])
+	}
+
+	return unhealthy
+}
+
+// decideCanary judges the probes of a canary step against the thresholds of
+// the deployment
+func (de *DeploymentEngine) decideCanary(deployment *Deployment, step, weight int, stats probeStats) CanaryDecision {
+	config := deployment.Config.Canary
+	maxErrorRate := canaryMaxErrorRate(config)
+
+	decision := CanaryDecision{
+		Step:                step,
+		Weight:              weight,
+		Probes:              stats.probes,
+		ErrorRate:           stats.errorRate(),
+		AverageLatency:      stats.averageLatency(),
+		HealthCheckFailures: stats.failures,
+		Decision:            canaryRollback,
+		Timestamp:           time.Now(),
+	}
+
+	de.mu.RLock()
+	for _, replica := range deployment.Replicas {
+		if replica.Status == ReplicaUnhealthy {
+			decision.Reason = fmt.Sprintf("replica %d failed %d consecutive health checks", replica.Index, replica.HealthCheckFailures)
+			break
+		}
+	}
+	de.mu.RUnlock()
+
+	if decision.Reason != "" {
+		return decision
+	}
+
+	switch {
+	case stats.probes == 0:
+		decision.Reason = "no health check results for the canary"
+	case decision.ErrorRate > maxErrorRate:
+		decision.Reason = fmt.Sprintf("error rate %.2f%% above %.2f%%", decision.ErrorRate*100, maxErrorRate*100)
+	case config.MaxLatency > 0 && decision.AverageLatency > config.MaxLatency:
+		decision.Reason = fmt.Sprintf("average latency %s above %s", decision.AverageLatency, config.MaxLatency)
+	default:
+		decision.Decision = canaryPromote
+		decision.Reason = fmt.Sprintf("error rate %.2f%% and average latency %s over %d health checks", decision.ErrorRate*100, decision.AverageLatency, stats.probes)
+	}
+
+	return decision
+}
+
+// abortCanary sends all traffic back to the stable deployment and removes
+// the canary replicas
+func (de *DeploymentEngine) abortCanary(stable, deployment *Deployment, cause error) error {
+	if err := de.switchTraffic(stable); err != nil {
+		logrus.Warnf("Failed to route traffic back to stable deployment %s: %v", stable.ID, err)
+	}
+
+	de.removeReplicas(deployment.Replicas)
+	de.setReplicas(deployment, nil)
+
+	now := time.Now()
+	deployment.Canary.Result = "rolled_back"
+	deployment.Canary.CompletedAt = &now
+	deployment.Canary.Weight = 0
+	deployment.LiveColor = stable.Color
+	stable.LiveColor = stable.Color
+
+	de.addDeploymentLog(stable, "warn", fmt.Sprintf("Canary release of version %s rolled back: %v", deployment.Version, cause))
+	de.updateDeploymentStatus(stable, StatusRunning)
+	de.startDeploymentMonitoring(stable)
+
+	de.auditLogger.LogEvent("DEPLOYMENT_CANARY_ROLLED_BACK", map[string]interface{}{
+		"deployment_id":        deployment.ID,
+		"stable_deployment_id": stable.ID,
+		"version":              deployment.Version,
+		"step":                 deployment.Canary.Step,
+		"error":                cause.Error(),
+	})
+
+	return fmt.Errorf("canary rolled back: %w", cause)
+}
+
+// routeCanary splits the traffic of an app between the stable and canary sets
+func (de *DeploymentEngine) routeCanary(stable, deployment *Deployment, weight int) error {
+	if !de.routingManager.Enabled() {
+		return fmt.Errorf("cannot split traffic of app %s, Traefik routing is disabled", stable.AppID)
+	}
+
+	return de.routingManager.SetWeightedRoute(
+		routing.Route{AppID: stable.AppID, Color: stable.Color, Servers: routeServers(stable)},
+		routing.Route{AppID: deployment.AppID, Color: deployment.Color, Servers: routeServers(deployment)},
+		weight,
+	)
+}
+
+// reconcileCanary gives up on a canary release interrupted by an agent
+// restart. The stable deployment is reattached on its own and gets all
+// traffic back.
+func (de *DeploymentEngine) reconcileCanary(ctx context.Context, deployment *Deployment) {
+	for _, replica := range deploymentReplicas(deployment) {
+		if err := de.dockerManager.RemoveContainer(ctx, replica.ContainerID, true); err != nil {
+			logrus.Debugf("Failed to remove canary container %s: %v", replica.ContainerID, err)
+		}
+		de.releaseContainer(replica.ContainerID)
+	}
+	de.setReplicas(deployment, nil)
+
+	if deployment.Canary != nil {
+		now := time.Now()
+		deployment.Canary.Result = "rolled_back"
+		deployment.Canary.CompletedAt = &now
+
+		de.mu.RLock()
+		stable := de.deployments[deployment.Canary.StableDeploymentID]
+		de.mu.RUnlock()
+
+		if stable != nil {
+			if err := de.switchTraffic(stable); err != nil {
+				logrus.Warnf("Failed to route traffic back to stable deployment %s: %v", stable.ID, err)
+			}
+		}
+	}
+
+	de.abortDeployment(deployment, "canary analysis was interrupted by an agent restart")
+}
+
+// validateCanary checks the canary settings of a deployment request
+func validateCanary(request *DeploymentRequest) error {
+	// Promotion decisions are based on health check results
+	if !request.HealthCheck.Enabled {
+		return fmt.Errorf("canary strategy requires health checks to be enabled")
+	}
+
+	for _, weight := range request.Config.Canary.Steps {
+		if weight <= 0 || weight >= 100 {
+			return fmt.Errorf("invalid canary step %d%%, steps must be between 1 and 99", weight)
+		}
+	}
+
+	if request.Config.Canary.MaxErrorRate < 0 || request.Config.Canary.MaxErrorRate > 1 {
+		return fmt.Errorf("invalid canary max error rate %v, must be between 0 and 1", request.Config.Canary.MaxErrorRate)
+	}
+
+	return nil
+}
+
+// canarySteps returns the traffic shares of a canary release in ascending order
+func canarySteps(config CanaryConfig) []int {
+	if len(config.Steps) == 0 {
+		return defaultCanarySteps
+	}
+
+	steps := append([]int(nil), config.Steps...)
+	sort.Ints(steps)
+	return steps
+}
+
+func canaryStepInterval(config CanaryConfig) time.Duration {
+	if config.StepInterval > 0 {
+		return config.StepInterval
+	}
+	return defaultCanaryStepInterval
+}
+
+func canaryMaxErrorRate(config CanaryConfig) float64 {
+	if config.MaxErrorRate > 0 {
+		return config.MaxErrorRate
+	}
+	return defaultCanaryMaxErrorRate
+}
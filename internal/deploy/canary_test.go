@@ -0,0 +1,127 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"superagent/internal/deploy/routing"
+)
+
+func TestCanarySteps(t *testing.T) {
+	if got := fmt.Sprint(canarySteps(CanaryConfig{})); got != fmt.Sprint(defaultCanarySteps) {
+		t.Errorf("default steps %s, want %v", got, defaultCanarySteps)
+	}
+
+	config := CanaryConfig{Steps: []int{50, 5, 20}}
+	if got := fmt.Sprint(canarySteps(config)); got != "[5 20 50]" {
+		t.Errorf("steps %s, want [5 20 50]", got)
+	}
+	if got := fmt.Sprint(config.Steps); got != "[50 5 20]" {
+		t.Errorf("canarySteps reordered the configured steps to %s", got)
+	}
+}
+
+func TestValidateCanary(t *testing.T) {
+	request := func(enabled bool, canary CanaryConfig) *DeploymentRequest {
+		return &DeploymentRequest{
+			HealthCheck: HealthCheckConfig{Enabled: enabled},
+			Config:      DeploymentConfig{Strategy: "canary", Canary: canary},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		request *DeploymentRequest
+		valid   bool
+	}{
+		{"defaults", request(true, CanaryConfig{}), true},
+		{"custom steps", request(true, CanaryConfig{Steps: []int{1, 99}, MaxErrorRate: 0.1}), true},
+		{"without health checks", request(false, CanaryConfig{}), false},
+		{"step of all traffic", request(true, CanaryConfig{Steps: []int{10, 100}}), false},
+		{"step of no traffic", request(true, CanaryConfig{Steps: []int{0}}), false},
+		{"error rate above one", request(true, CanaryConfig{MaxErrorRate: 1.5}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCanary(tt.request)
+			if tt.valid && err != nil {
+				t.Errorf("validateCanary() = %v, want no error", err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("validateCanary() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestProbeStats(t *testing.T) {
+	var stats probeStats
+	if stats.errorRate() != 0 || stats.averageLatency() != 0 {
+		t.Fatalf("empty stats report errors or latency")
+	}
+
+	probeErr := errors.New("connection refused")
+	stats.record(10*time.Millisecond, nil)
+	stats.record(30*time.Millisecond, nil)
+	stats.record(0, probeErr)
+	stats.record(0, probeErr)
+
+	if got := stats.errorRate(); got != 0.5 {
+		t.Errorf("error rate %v, want 0.5", got)
+	}
+	if got := stats.averageLatency(); got != 10*time.Millisecond {
+		t.Errorf("average latency %s, want 10ms", got)
+	}
+}
+
+func TestDecideCanary(t *testing.T) {
+	stats := func(probes, failures int, latency time.Duration) probeStats {
+		return probeStats{probes: probes, failures: failures, latency: time.Duration(probes) * latency}
+	}
+	healthy := []*Replica{{Index: 0, Status: ReplicaRunning}}
+
+	tests := []struct {
+		name     string
+		canary   CanaryConfig
+		replicas []*Replica
+		stats    probeStats
+		decision string
+		reason   string
+	}{
+		{"healthy", CanaryConfig{}, healthy, stats(20, 0, 5*time.Millisecond), canaryPromote, "error rate 0.00%"},
+		{"errors within default", CanaryConfig{}, healthy, stats(100, 5, time.Millisecond), canaryPromote, "error rate 5.00%"},
+		{"errors above default", CanaryConfig{}, healthy, stats(100, 6, time.Millisecond), canaryRollback, "error rate 6.00% above 5.00%"},
+		{"errors above configured", CanaryConfig{MaxErrorRate: 0.01}, healthy, stats(100, 2, time.Millisecond), canaryRollback, "above 1.00%"},
+		{"too slow", CanaryConfig{MaxLatency: 100 * time.Millisecond}, healthy, stats(10, 0, 200*time.Millisecond), canaryRollback, "average latency 200ms above 100ms"},
+		{"no probes", CanaryConfig{}, healthy, probeStats{}, canaryRollback, "no health check results"},
+		{"unhealthy replica", CanaryConfig{}, []*Replica{{Index: 1, Status: ReplicaUnhealthy, HealthCheckFailures: 3}}, stats(20, 0, time.Millisecond), canaryRollback, "replica 1 failed 3 consecutive health checks"},
+	}
+
+	de := &DeploymentEngine{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := &Deployment{Config: DeploymentConfig{Canary: tt.canary}, Replicas: tt.replicas}
+
+			decision := de.decideCanary(deployment, 1, 25, tt.stats)
+			if decision.Decision != tt.decision || !strings.Contains(decision.Reason, tt.reason) {
+				t.Errorf("decision %s (%s), want %s (%s)", decision.Decision, decision.Reason, tt.decision, tt.reason)
+			}
+			if decision.Step != 1 || decision.Weight != 25 || decision.Probes != tt.stats.probes {
+				t.Errorf("decision of step %d at %d%% over %d probes, want step 1 at 25%% over %d", decision.Step, decision.Weight, decision.Probes, tt.stats.probes)
+			}
+		})
+	}
+}
+
+func TestRouteCanaryWithoutRouting(t *testing.T) {
+	de := &DeploymentEngine{routingManager: &routing.RoutingManager{}}
+
+	err := de.routeCanary(&Deployment{AppID: "web"}, &Deployment{AppID: "web"}, 10)
+	if err == nil || !strings.Contains(err.Error(), "Traefik routing is disabled") {
+		t.Fatalf("routeCanary error %v, want routing to be required", err)
+	}
+}
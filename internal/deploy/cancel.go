@@ -0,0 +1,139 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errDeploymentCancelled is the cause attached to the context of a
+// deployment stopped through Cancel
+var errDeploymentCancelled = errors.New("deployment cancelled")
+
+// Cancel stops a deployment that is still being built, pulled or rolled out.
+// The running clone, build or pull is interrupted, whatever the attempt
+// created is removed and the deployment ends up cancelled. A deployment that
+// is already running, even while its post-deploy hooks still run, can no
+// longer be cancelled and has to be stopped or rolled back instead.
+func (de *DeploymentEngine) Cancel(deploymentID string) error {
+	de.mu.Lock()
+	deployment, exists := de.deployments[deploymentID]
+	if !exists {
+		de.mu.Unlock()
+		return fmt.Errorf("deployment not found: %s", deploymentID)
+	}
+
+	if deployment.Status == StatusRunning {
+		de.mu.Unlock()
+		return fmt.Errorf("deployment %s is already running, stop or roll it back instead", deploymentID)
+	}
+
+	cancel, inFlight := de.deployCancels[deploymentID]
+	if !inFlight {
+		de.mu.Unlock()
+		return fmt.Errorf("deployment %s is not in progress (status: %s)", deploymentID, deployment.Status)
+	}
+	de.mu.Unlock()
+
+	cancel(errDeploymentCancelled)
+
+	de.addDeploymentLog(deployment, "warn", "Cancellation requested")
+
+	de.auditLogger.LogEvent("DEPLOYMENT_CANCEL_REQUESTED", map[string]interface{}{
+		"deployment_id": deploymentID,
+		"status":        string(deployment.Status),
+	})
+
+	return nil
+}
+
+// trackDeployment remembers how to cancel an in-flight deployment
+func (de *DeploymentEngine) trackDeployment(deploymentID string, cancel context.CancelCauseFunc) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	de.deployCancels[deploymentID] = cancel
+}
+
+// untrackDeployment forgets the cancel function of a finished deployment
+func (de *DeploymentEngine) untrackDeployment(deploymentID string) {
+	de.mu.Lock()
+	cancel, ok := de.deployCancels[deploymentID]
+	delete(de.deployCancels, deploymentID)
+	de.mu.Unlock()
+
+	if ok {
+		cancel(nil)
+	}
+}
+
+// abandonDeployment ends a deployment that did not complete, either because
+// it was cancelled or because it failed
+func (de *DeploymentEngine) abandonDeployment(ctx context.Context, deployment *Deployment, err error) {
+	if errors.Is(context.Cause(ctx), errDeploymentCancelled) {
+		de.cleanupCancelledDeployment(deployment)
+		return
+	}
+
+	de.handleDeploymentError(deployment, err)
+	de.concludeRevision(deployment, err)
+}
+
+// cleanupCancelledDeployment removes the containers and image a cancelled
+// deployment created and marks it cancelled
+func (de *DeploymentEngine) cleanupCancelledDeployment(deployment *Deployment) {
+	ctx, cancel := context.WithTimeout(de.ctx, 2*time.Minute)
+	defer cancel()
+
+	removedContainers := len(deployment.Replicas)
+	de.removeReplicas(deployment.Replicas)
+	de.setReplicas(deployment, nil)
+	de.removeLeftoverContainers(ctx, deployment)
+
+	removedImage := ""
+	if deployment.ImageID != "" && !de.imageInUse(deployment.ID, deployment.ImageID) {
+		if err := de.dockerManager.RemoveImage(ctx, deployment.ImageID, false); err != nil {
+			logrus.Warnf("Failed to remove image %s of cancelled deployment %s: %v", deployment.ImageID, deployment.ID, err)
+		} else {
+			removedImage = deployment.ImageID
+		}
+	}
+
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Deployment cancelled while %s", deployment.Status))
+	de.updateDeploymentStatus(deployment, StatusCancelled)
+	de.concludeRevision(deployment, errDeploymentCancelled)
+
+	de.auditLogger.LogEvent("DEPLOYMENT_CANCELLED", map[string]interface{}{
+		"deployment_id":      deployment.ID,
+		"app_id":             deployment.AppID,
+		"version":            deployment.Version,
+		"removed_containers": removedContainers,
+		"removed_image":      removedImage,
+	})
+}
+
+// imageInUse reports whether a deployment other than the given one runs or
+// may return to an image
+func (de *DeploymentEngine) imageInUse(deploymentID, imageID string) bool {
+	de.mu.RLock()
+	defer de.mu.RUnlock()
+
+	for _, deployment := range de.deployments {
+		if deployment.ID != deploymentID && deployment.ImageID == imageID {
+			return true
+		}
+	}
+
+	for _, history := range de.revisions {
+		for _, revision := range history {
+			if revision.DeploymentID != deploymentID && revision.ImageID == imageID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
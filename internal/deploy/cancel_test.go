@@ -0,0 +1,106 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"superagent/internal/logging"
+)
+
+// testAuditLogger creates an audit logger writing to a temporary directory
+func testAuditLogger(t *testing.T) *logging.AuditLogger {
+	t.Helper()
+
+	auditLogger, err := logging.NewAuditLogger(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatalf("NewAuditLogger: %v", err)
+	}
+	t.Cleanup(func() { auditLogger.Close() })
+	return auditLogger
+}
+
+func TestCancel(t *testing.T) {
+	building := &Deployment{ID: "d1", AppID: "web", Status: StatusBuilding}
+	running := &Deployment{ID: "d2", AppID: "web", Status: StatusRunning}
+	failed := &Deployment{ID: "d3", AppID: "web", Status: StatusFailed}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	de := &DeploymentEngine{
+		auditLogger:   testAuditLogger(t),
+		events:        NewEventBus(),
+		deployments:   map[string]*Deployment{"d1": building, "d2": running, "d3": failed},
+		deployCancels: map[string]context.CancelCauseFunc{"d1": cancel, "d2": func(error) {}},
+	}
+
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"d4", "deployment not found: d4"},
+		{"d2", "deployment d2 is already running"},
+		{"d3", "deployment d3 is not in progress (status: failed)"},
+	}
+	for _, tt := range tests {
+		if err := de.Cancel(tt.id); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Cancel(%s) = %v, want %q", tt.id, err, tt.want)
+		}
+	}
+
+	if err := de.Cancel("d1"); err != nil {
+		t.Fatalf("Cancel(d1): %v", err)
+	}
+	if !errors.Is(context.Cause(ctx), errDeploymentCancelled) {
+		t.Errorf("cause %v, want %v", context.Cause(ctx), errDeploymentCancelled)
+	}
+	if len(building.DeploymentLogs) != 1 || building.DeploymentLogs[0].Message != "Cancellation requested" {
+		t.Errorf("logs %+v, want the cancellation logged", building.DeploymentLogs)
+	}
+}
+
+func TestUntrackDeploymentReleasesContext(t *testing.T) {
+	de := &DeploymentEngine{deployCancels: make(map[string]context.CancelCauseFunc)}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	de.trackDeployment("d1", cancel)
+	de.untrackDeployment("d1")
+
+	if ctx.Err() == nil {
+		t.Fatalf("context of a finished deployment still open")
+	}
+	if context.Cause(ctx) == errDeploymentCancelled {
+		t.Errorf("finished deployment reported as cancelled")
+	}
+	if len(de.deployCancels) != 0 {
+		t.Errorf("%d cancel functions left", len(de.deployCancels))
+	}
+}
+
+func TestImageInUse(t *testing.T) {
+	de := &DeploymentEngine{
+		deployments: map[string]*Deployment{
+			"d1": {ID: "d1", ImageID: "sha256:aaa"},
+			"d2": {ID: "d2", ImageID: "sha256:bbb"},
+		},
+		revisions: map[string][]*Revision{
+			"web": {{DeploymentID: "d0", ImageID: "sha256:ccc"}, {DeploymentID: "d1", ImageID: "sha256:aaa"}},
+		},
+	}
+
+	tests := []struct {
+		deploymentID, imageID string
+		want                  bool
+	}{
+		{"d1", "sha256:aaa", false},
+		{"d1", "sha256:bbb", true},
+		{"d1", "sha256:ccc", true},
+		{"d2", "sha256:aaa", true},
+	}
+	for _, tt := range tests {
+		if got := de.imageInUse(tt.deploymentID, tt.imageID); got != tt.want {
+			t.Errorf("imageInUse(%s, %s) = %v, want %v", tt.deploymentID, tt.imageID, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"superagent/internal/deploy/resources"
+
+	"github.com/sirupsen/logrus"
+)
+
+// hostCapacityTTL is how long a reading of the host capacity is used before
+// the host is read again
+const hostCapacityTTL = 30 * time.Second
+
+// HostCapacity reads what the host offers containers once the reservations
+// are taken off. Admission asks for every container, so a reading is used
+// for a short while, and for longer when the host cannot be read again.
+func (de *DeploymentEngine) HostCapacity(ctx context.Context) (*resources.HostCapacity, error) {
+	de.hostCapacityMu.Lock()
+	defer de.hostCapacityMu.Unlock()
+
+	if de.hostCapacity != nil && time.Since(de.capacityReadAt) < hostCapacityTTL {
+		return de.hostCapacity, nil
+	}
+
+	rootDir, err := de.dockerManager.RootDir(ctx)
+	if err != nil {
+		logrus.Warnf("Failed to find the docker root dir, reading storage of /: %v", err)
+		rootDir = "/"
+	}
+
+	capacity, err := resources.ReadHostCapacity(rootDir, de.config.Resources)
+	if err != nil {
+		if de.hostCapacity == nil {
+			return nil, err
+		}
+		logrus.Warnf("Failed to read host capacity, using the reading of %s: %v", de.capacityReadAt.Format(time.RFC3339), err)
+		return de.hostCapacity, nil
+	}
+
+	de.hostCapacity = capacity
+	de.capacityReadAt = time.Now()
+	return capacity, nil
+}
+
+// AdmitContainers checks that the host can take on the requested resources
+// on top of the ledger, containers being started and the unmanaged
+// allocations, such as containers the backend runs directly. Both are
+// expected as resources.Charge charges them.
+func (de *DeploymentEngine) AdmitContainers(ctx context.Context, unmanaged, requested resources.QuotaUsage) error {
+	de.capacityMu.Lock()
+	defer de.capacityMu.Unlock()
+
+	allocated := de.allocatedCapacity()
+	allocated.Merge(unmanaged)
+
+	if err := de.admit(ctx, allocated, requested); err != nil {
+		de.auditLogger.LogEvent("CAPACITY_ADMISSION_REJECTED", map[string]interface{}{
+			"consumer": "unmanaged container",
+			"error":    err.Error(),
+		})
+		return err
+	}
+
+	return nil
+}
+
+// reserveCapacity admits a container about to start and holds its resources
+// until the returned function is called, once the container is in the
+// ledger or failed to start
+func (de *DeploymentEngine) reserveCapacity(ctx context.Context, consumer string, limits resources.ResourceLimits) (func(), error) {
+	de.capacityMu.Lock()
+	defer de.capacityMu.Unlock()
+
+	requested := de.chargeContainer(limits)
+
+	if err := de.admit(ctx, de.allocatedCapacity(), requested); err != nil {
+		de.auditLogger.LogEvent("CAPACITY_ADMISSION_REJECTED", map[string]interface{}{
+			"consumer": consumer,
+			"error":    err.Error(),
+		})
+		return nil, err
+	}
+
+	de.pendingCapacity.Merge(requested)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			de.capacityMu.Lock()
+			defer de.capacityMu.Unlock()
+			de.pendingCapacity.Merge(resources.QuotaUsage{
+				CPU:        -requested.CPU,
+				Memory:     -requested.Memory,
+				Storage:    -requested.Storage,
+				Containers: -requested.Containers,
+			})
+		})
+	}, nil
+}
+
+// checkCapacity tells early whether the host can run the given number of
+// containers of an app. A release that replaces the containers the app runs
+// does not count them.
+func (de *DeploymentEngine) checkCapacity(ctx context.Context, appID string, limits resources.ResourceLimits, containers int, replacing bool) error {
+	de.capacityMu.Lock()
+	allocated := de.allocatedCapacity()
+	de.capacityMu.Unlock()
+
+	if replacing {
+		var current []resources.ResourceAllocation
+		for _, allocation := range de.resourceManager.Allocations() {
+			if allocation.AppID == appID {
+				current = append(current, allocation)
+			}
+		}
+		charged := de.chargedUsage(current)
+		allocated.CPU -= charged.CPU
+		allocated.Memory -= charged.Memory
+		allocated.Storage -= charged.Storage
+		allocated.Containers -= charged.Containers
+	}
+
+	requested := resources.QuotaUsage{}
+	for i := 0; i < containers; i++ {
+		requested.Merge(de.chargeContainer(limits))
+	}
+
+	return de.admit(ctx, allocated, requested)
+}
+
+// allocatedCapacity sums up what admission charges for the ledger and the
+// containers being started. The caller holds capacityMu.
+func (de *DeploymentEngine) allocatedCapacity() resources.QuotaUsage {
+	allocated := de.chargedUsage(de.resourceManager.Allocations())
+	allocated.Merge(de.pendingCapacity)
+	return allocated
+}
+
+// chargeContainer returns what admission charges a container with the given
+// limits
+func (de *DeploymentEngine) chargeContainer(limits resources.ResourceLimits) resources.QuotaUsage {
+	usage := resources.QuotaUsage{}
+	usage.Add(limits, 1)
+	return resources.Charge(usage, resources.DefaultLimits(de.config.Docker))
+}
+
+// chargedUsage sums up what admission charges for allocations in the ledger
+func (de *DeploymentEngine) chargedUsage(allocations []resources.ResourceAllocation) resources.QuotaUsage {
+	defaults := resources.DefaultLimits(de.config.Docker)
+
+	var usage resources.QuotaUsage
+	for _, allocation := range allocations {
+		usage.Merge(resources.Charge(resources.QuotaUsage{
+			CPU:        allocation.CPU,
+			Memory:     allocation.Memory,
+			Storage:    allocation.Storage,
+			Containers: 1,
+		}, defaults))
+	}
+	return usage
+}
+
+// admit compares a request against the capacity of the host. A host whose
+// capacity was never read admits nothing, as nothing can be told.
+func (de *DeploymentEngine) admit(ctx context.Context, allocated, requested resources.QuotaUsage) error {
+	capacity, err := de.HostCapacity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read host capacity: %w", err)
+	}
+
+	return capacity.Admit(allocated, requested)
+}
+
+// IsCapacityError reports whether an error comes from a request the host
+// had no capacity for, and returns it
+func IsCapacityError(err error) (*resources.CapacityError, bool) {
+	var capacityErr *resources.CapacityError
+	if errors.As(err, &capacityErr) {
+		return capacityErr, true
+	}
+	return nil, false
+}
@@ -0,0 +1,101 @@
+package deploy
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"superagent/internal/config"
+	"superagent/internal/deploy/resources"
+)
+
+// capacityEngine is an engine whose host capacity was just read
+func capacityEngine(t *testing.T, capacity *resources.HostCapacity) *DeploymentEngine {
+	t.Helper()
+
+	de := quotaEngine(t, config.QuotasConfig{})
+	de.auditLogger = testAuditLogger(t)
+	de.config.Docker = config.DockerConfig{DefaultCPULimit: "1", DefaultMemoryLimit: "1G"}
+	de.hostCapacity = capacity
+	de.capacityReadAt = time.Now()
+	return de
+}
+
+func TestReserveCapacity(t *testing.T) {
+	de := capacityEngine(t, &resources.HostCapacity{CPU: 2, Memory: 8 << 30, Storage: 1 << 40, Containers: 10})
+	ctx := context.Background()
+	limits := resources.ResourceLimits{CPULimit: 1, MemoryLimit: 1 << 30}
+
+	release, err := de.reserveCapacity(ctx, "replica 0", limits)
+	if err != nil {
+		t.Fatalf("reserveCapacity: %v", err)
+	}
+
+	// The container being started counts until it is in the ledger
+	second, err := de.reserveCapacity(ctx, "replica 1", limits)
+	if err != nil {
+		t.Fatalf("reserveCapacity of the second core: %v", err)
+	}
+	if _, err := de.reserveCapacity(ctx, "replica 2", limits); err == nil {
+		t.Fatalf("third core admitted on a host with two")
+	}
+
+	release()
+	release() // a second call is harmless
+	second()
+	if de.pendingCapacity != (resources.QuotaUsage{}) {
+		t.Fatalf("pending capacity %+v after release, want none", de.pendingCapacity)
+	}
+}
+
+func TestReserveCapacityChargesDefaults(t *testing.T) {
+	de := capacityEngine(t, &resources.HostCapacity{CPU: 1.5, Memory: 8 << 30, Storage: 1 << 40, Containers: 10})
+	ctx := context.Background()
+
+	// A container without limits is charged the default core
+	release, err := de.reserveCapacity(ctx, "replica 0", resources.ResourceLimits{})
+	if err != nil {
+		t.Fatalf("reserveCapacity: %v", err)
+	}
+	defer release()
+
+	if de.pendingCapacity.CPU != 1 || de.pendingCapacity.Memory != 1<<30 {
+		t.Fatalf("pending capacity %+v, want the default limits", de.pendingCapacity)
+	}
+	if _, err := de.reserveCapacity(ctx, "replica 1", resources.ResourceLimits{}); err == nil {
+		t.Fatalf("second unlimited container admitted beyond the host's cores")
+	}
+}
+
+func TestCheckCapacityReplacing(t *testing.T) {
+	de := capacityEngine(t, &resources.HostCapacity{CPU: 4, Memory: 8 << 30, Storage: 1 << 40, Containers: 10})
+	ctx := context.Background()
+
+	// The containers of web run without limits and are charged a core each
+	for _, containerID := range []string{"c1", "c2", "c3"} {
+		de.resourceManager.AllocateResources("web", containerID, resources.ResourceLimits{})
+	}
+
+	limits := resources.ResourceLimits{CPULimit: 1, MemoryLimit: 1 << 30}
+	if err := de.checkCapacity(ctx, "web", limits, 2, false); err == nil || !strings.Contains(err.Error(), "for cpu") {
+		t.Errorf("checkCapacity next to the running containers = %v, want a CPU capacity error", err)
+	}
+	if err := de.checkCapacity(ctx, "web", limits, 3, true); err != nil {
+		t.Errorf("checkCapacity replacing the running containers = %v, want them admitted", err)
+	}
+}
+
+func TestHostCapacityCached(t *testing.T) {
+	cached := &resources.HostCapacity{CPU: 1}
+	de := capacityEngine(t, cached)
+
+	// A fresh reading is used without asking the host again
+	capacity, err := de.HostCapacity(context.Background())
+	if err != nil {
+		t.Fatalf("HostCapacity: %v", err)
+	}
+	if capacity != cached {
+		t.Fatalf("capacity read again within %s", hostCapacityTTL)
+	}
+}
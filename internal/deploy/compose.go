@@ -0,0 +1,1193 @@
+package deploy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"superagent/internal/deploy/resources"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// composeDefaultNetwork is the network services join when they name none
+	composeDefaultNetwork = "default"
+
+	LabelGroup   = "superagent.group"
+	LabelService = "superagent.service"
+)
+
+// composeNamePattern is what compose allows for the names of services,
+// networks and volumes
+var composeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// composeVariablePattern matches $$, $NAME and ${NAME...} in compose values
+var composeVariablePattern = regexp.MustCompile(`\$(\$|[A-Za-z_][A-Za-z0-9_]*|\{[^}]*\})`)
+
+var composeVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+
+// composeIgnoredKeys are service settings that do not change what the
+// service runs and are left out with a warning
+var composeIgnoredKeys = map[string]bool{
+	"container_name":    true,
+	"hostname":          true,
+	"domainname":        true,
+	"logging":           true,
+	"stop_grace_period": true,
+	"stop_signal":       true,
+	"init":              true,
+	"tty":               true,
+	"stdin_open":        true,
+	"platform":          true,
+	"pull_policy":       true,
+	"profiles":          true,
+}
+
+// ComposeProject is a docker-compose file turned into one deployment request
+// per service. Every service is an app of its own, named after the app of
+// the compose file and the service.
+type ComposeProject struct {
+	AppID    string
+	Networks []string          // networks created for the app, external ones excluded
+	Services []*ComposeService // in the order they start
+	Warnings []string          // settings of the file that are not used
+}
+
+// ComposeService is a service of a compose file
+type ComposeService struct {
+	Name      string
+	DependsOn []string
+	Request   DeploymentRequest
+}
+
+// composeParser holds what a compose file shares between its services
+type composeParser struct {
+	request  *DeploymentRequest
+	networks map[string]composeResource // by the name used in the file
+	volumes  map[string]composeResource
+	used     map[string]bool // networks services join
+	warnings []string
+}
+
+// composeResource is a network or named volume declared in a compose file
+type composeResource struct {
+	name     string // name on the host
+	external bool
+}
+
+// ParseCompose turns a deployment request with a compose source into the
+// deployment requests of its services. Services are pulled from their image,
+// or built from the source repository when they have a build section. The
+// config, resource limits, environment and labels of the request apply to
+// every service, settings of a service win. Variables like ${NAME} and
+// ${NAME:-default} are filled in from the environment of the request.
+func ParseCompose(request *DeploymentRequest) (*ComposeProject, error) {
+	if request.AppID == "" {
+		return nil, fmt.Errorf("app_id is required")
+	}
+	if request.Version == "" {
+		return nil, fmt.Errorf("version of app %s is required", request.AppID)
+	}
+	if request.Source.Type != "compose" {
+		return nil, fmt.Errorf("source type of app %s must be compose", request.AppID)
+	}
+	if strings.TrimSpace(request.Source.Compose) == "" {
+		return nil, fmt.Errorf("compose file of app %s is required", request.AppID)
+	}
+	if len(request.Ports) > 0 || len(request.Volumes) > 0 || len(request.Networks) > 0 || len(request.NetworkAliases) > 0 {
+		return nil, fmt.Errorf("ports, volumes and networks of compose app %s are set per service in the compose file", request.AppID)
+	}
+	if len(request.Hooks.PreDeploy) > 0 || len(request.Hooks.PostDeploy) > 0 {
+		return nil, fmt.Errorf("hooks are not supported for compose app %s", request.AppID)
+	}
+
+	document, err := composeDocument([]byte(request.Source.Compose))
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &composeParser{
+		request:  request,
+		networks: make(map[string]composeResource),
+		volumes:  make(map[string]composeResource),
+		used:     make(map[string]bool),
+	}
+
+	interpolated, err := parser.interpolate("", document)
+	if err != nil {
+		return nil, err
+	}
+	top, ok := interpolated.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("a compose file must be a mapping")
+	}
+
+	for key := range top {
+		switch {
+		case key == "version", key == "name", key == "services", key == "networks", key == "volumes", strings.HasPrefix(key, "x-"):
+		default:
+			return nil, fmt.Errorf("top-level %s is not supported", key)
+		}
+	}
+
+	if err := parser.parseResources("networks", top["networks"], parser.networks); err != nil {
+		return nil, err
+	}
+	if err := parser.parseResources("volumes", top["volumes"], parser.volumes); err != nil {
+		return nil, err
+	}
+	if _, declared := parser.networks[composeDefaultNetwork]; !declared {
+		parser.networks[composeDefaultNetwork] = composeResource{name: parser.resourceName(composeDefaultNetwork)}
+	}
+
+	definitions, ok := top["services"].(map[string]interface{})
+	if !ok || len(definitions) == 0 {
+		return nil, fmt.Errorf("a compose file needs services")
+	}
+
+	services := make(map[string]*ComposeService, len(definitions))
+	for name, definition := range definitions {
+		if !composeNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid service name %q", name)
+		}
+
+		service, err := parser.parseService(name, definition)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		services[name] = service
+	}
+
+	ordered, err := composeStartOrder(services)
+	if err != nil {
+		return nil, err
+	}
+
+	project := &ComposeProject{
+		AppID:    request.AppID,
+		Services: ordered,
+		Warnings: parser.warnings,
+	}
+	for name, network := range parser.networks {
+		if parser.used[name] && !network.external {
+			project.Networks = append(project.Networks, network.name)
+		}
+	}
+	sort.Strings(project.Networks)
+	sort.Strings(project.Warnings)
+
+	return project, nil
+}
+
+// composeDocument decodes a compose file, resolving anchors and merge keys
+func composeDocument(data []byte) (interface{}, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+	var node yaml.Node
+	if err := decoder.Decode(&node); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("compose file is empty")
+		}
+		return nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+
+	var extra yaml.Node
+	if err := decoder.Decode(&extra); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("a compose file holds a single document")
+	}
+
+	document, err := yamlValue(&node)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+	return document, nil
+}
+
+// interpolate fills in the variables of every string in a compose file
+func (p *composeParser) interpolate(field string, value interface{}) (interface{}, error) {
+	switch typed := value.(type) {
+	case string:
+		return p.interpolateString(field, typed)
+
+	case map[string]interface{}:
+		for key, child := range typed {
+			interpolated, err := p.interpolate(joinPath(field, key), child)
+			if err != nil {
+				return nil, err
+			}
+			typed[key] = interpolated
+		}
+
+	case []interface{}:
+		for i, child := range typed {
+			interpolated, err := p.interpolate(fmt.Sprintf("%s[%d]", field, i), child)
+			if err != nil {
+				return nil, err
+			}
+			typed[i] = interpolated
+		}
+	}
+
+	return value, nil
+}
+
+// interpolateString fills in $NAME, ${NAME}, ${NAME:-default},
+// ${NAME-default}, ${NAME:?error} and ${NAME?error}. $$ stands for $.
+func (p *composeParser) interpolateString(field, text string) (string, error) {
+	var failure error
+
+	result := composeVariablePattern.ReplaceAllStringFunc(text, func(match string) string {
+		if failure != nil {
+			return match
+		}
+		if match == "$$" {
+			return "$"
+		}
+
+		expression := strings.TrimPrefix(match, "$")
+		if strings.HasPrefix(expression, "{") {
+			expression = expression[1 : len(expression)-1]
+		}
+
+		name := composeVariableName.FindString(expression)
+		operator, argument := "", ""
+		if rest := expression[len(name):]; rest != "" {
+			for _, candidate := range []string{":-", ":?", "-", "?"} {
+				if strings.HasPrefix(rest, candidate) {
+					operator, argument = candidate, rest[len(candidate):]
+					break
+				}
+			}
+			if name == "" || operator == "" {
+				failure = fmt.Errorf("%s: invalid variable %s", field, match)
+				return match
+			}
+		}
+
+		value, set := p.request.Environment[name]
+		switch operator {
+		case ":-":
+			if value == "" {
+				return argument
+			}
+		case "-":
+			if !set {
+				return argument
+			}
+		case ":?", "?":
+			if !set || (operator == ":?" && value == "") {
+				failure = fmt.Errorf("%s: variable %s is required: %s", field, name, argument)
+				return match
+			}
+		default:
+			if !set {
+				p.warn(fmt.Sprintf("%s: variable %s is not set, using an empty string", field, name))
+			}
+		}
+		return value
+	})
+
+	if failure != nil {
+		return "", failure
+	}
+	return result, nil
+}
+
+// parseResources reads the top-level networks or volumes of a compose file.
+// Those not marked external are created for the app and named after it.
+func (p *composeParser) parseResources(kind string, value interface{}, into map[string]composeResource) error {
+	if value == nil {
+		return nil
+	}
+	declared, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("top-level %s must be a mapping", kind)
+	}
+
+	for name, definition := range declared {
+		if !composeNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid %s name %q", strings.TrimSuffix(kind, "s"), name)
+		}
+
+		resource := composeResource{name: p.resourceName(name)}
+		settings, _ := definition.(map[string]interface{})
+		for key, setting := range settings {
+			switch key {
+			case "external":
+				external, err := composeBool(setting)
+				if err != nil {
+					return fmt.Errorf("%s.%s.external: %w", kind, name, err)
+				}
+				resource.external = external
+			case "name":
+				resource.name = composeText(setting)
+			case "driver":
+				if driver := composeText(setting); (kind == "networks" && driver != "bridge") || (kind == "volumes" && driver != "local") {
+					return fmt.Errorf("%s.%s: driver %s is not supported", kind, name, driver)
+				}
+			default:
+				p.warn(fmt.Sprintf("%s.%s.%s is ignored", kind, name, key))
+			}
+		}
+		if resource.external && settings["name"] == nil {
+			resource.name = name
+		}
+
+		into[name] = resource
+	}
+
+	return nil
+}
+
+// resourceName names a network or volume of the app the way compose names
+// those of a project, so data of an app moved over from compose is kept
+func (p *composeParser) resourceName(name string) string {
+	return p.request.AppID + "_" + name
+}
+
+// parseService turns a service of a compose file into a deployment request
+func (p *composeParser) parseService(name string, definition interface{}) (*ComposeService, error) {
+	settings, ok := definition.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("a service must be a mapping")
+	}
+
+	group := p.request
+	request := DeploymentRequest{
+		AppID:          fmt.Sprintf("%s-%s", group.AppID, name),
+		Version:        group.Version,
+		Config:         group.Config,
+		ResourceLimits: group.ResourceLimits,
+		Environment:    make(map[string]string, len(group.Environment)),
+		Labels:         make(map[string]string, len(group.Labels)+2),
+		NetworkAliases: []string{name},
+		TriggeredBy:    group.TriggeredBy,
+	}
+
+	// What the container runs comes from the service alone
+	request.Config.Replicas = 0
+	request.Config.Command = nil
+	request.Config.Args = nil
+	request.Config.User = ""
+	request.Config.WorkingDir = ""
+	request.Config.RestartPolicy = ""
+	request.Config.Privileged = false
+	request.Config.ReadOnlyRootFS = false
+
+	for key, value := range group.Environment {
+		request.Environment[key] = value
+	}
+	for key, value := range group.Labels {
+		request.Labels[key] = value
+	}
+	request.Labels[LabelGroup] = group.AppID
+	request.Labels[LabelService] = name
+
+	service := &ComposeService{Name: name}
+	var networks []string
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := settings[key]
+		var err error
+
+		switch key {
+		case "image", "build":
+			// Read together below
+		case "command":
+			request.Config.Command, err = composeCommand(value)
+		case "environment":
+			err = p.parseEnvironment(value, request.Environment)
+		case "ports":
+			request.Ports, err = parseComposePorts(value)
+		case "expose":
+			// Services reach each other on every port over the app network
+		case "volumes":
+			request.Volumes, err = p.parseVolumes(name, value)
+		case "networks":
+			networks, request.NetworkAliases, err = p.parseServiceNetworks(value, request.NetworkAliases)
+		case "depends_on":
+			service.DependsOn, err = p.parseDependsOn(name, value)
+		case "healthcheck":
+			request.HealthCheck, err = parseComposeHealthCheck(value)
+		case "restart":
+			request.Config.RestartPolicy, err = composeRestartPolicy(composeText(value))
+		case "labels":
+			err = composeMapping(value, request.Labels)
+		case "user":
+			request.Config.User = composeText(value)
+		case "working_dir":
+			request.Config.WorkingDir = composeText(value)
+		case "privileged":
+			request.Config.Privileged, err = composeBool(value)
+		case "read_only":
+			request.Config.ReadOnlyRootFS, err = composeBool(value)
+		case "deploy":
+			err = p.parseDeploy(name, value, &request)
+		case "cpus":
+			request.ResourceLimits.CPULimit, err = composeFloat(value)
+		case "mem_limit":
+			request.ResourceLimits.MemoryLimit, err = composeBytes(value)
+		case "memswap_limit":
+			request.ResourceLimits.MemorySwap, err = composeBytes(value)
+		case "pids_limit":
+			request.ResourceLimits.ProcessLimit, err = composeInt(value)
+		case "cpu_shares":
+			request.ResourceLimits.CPUShares, err = composeInt(value)
+		case "cpuset":
+			request.ResourceLimits.CPUSetCPUs = composeText(value)
+		case "env_file":
+			err = fmt.Errorf("env_file is not supported, set the variables in environment or on the request")
+		default:
+			if composeIgnoredKeys[key] || strings.HasPrefix(key, "x-") {
+				p.warn(fmt.Sprintf("services.%s.%s is ignored", name, key))
+				continue
+			}
+			err = fmt.Errorf("%s is not supported", key)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	source, err := p.parseSource(name, settings["image"], settings["build"])
+	if err != nil {
+		return nil, err
+	}
+	request.Source = source
+
+	if len(networks) == 0 {
+		networks = []string{composeDefaultNetwork}
+	}
+	for _, network := range networks {
+		p.used[network] = true
+		request.Networks = append(request.Networks, p.networks[network].name)
+	}
+
+	service.Request = request
+	return service, nil
+}
+
+// parseSource works out where the image of a service comes from. A service
+// that is built takes the repository, ref and credentials of the request;
+// its build context is a directory of the repository.
+func (p *composeParser) parseSource(name string, image, build interface{}) (DeploymentSource, error) {
+	group := p.request.Source
+
+	if build == nil {
+		reference := composeText(image)
+		if reference == "" {
+			return DeploymentSource{}, fmt.Errorf("image or build is required")
+		}
+
+		source := DeploymentSource{Type: "docker", Repository: reference, Auth: group.Auth}
+		if !strings.Contains(reference, "@") {
+			if colon := strings.LastIndex(reference, ":"); colon > strings.LastIndex(reference, "/") {
+				source.Repository, source.Tag = reference[:colon], reference[colon+1:]
+			}
+		}
+		return source, nil
+	}
+
+	if group.Repository == "" {
+		return DeploymentSource{}, fmt.Errorf("build needs the source repository of the app to build from")
+	}
+	if image != nil {
+		p.warn(fmt.Sprintf("services.%s.image is ignored, the image is built", name))
+	}
+
+	buildContext, dockerfile := ".", ""
+	switch typed := build.(type) {
+	case map[string]interface{}:
+		for key, value := range typed {
+			switch key {
+			case "context":
+				buildContext = composeText(value)
+			case "dockerfile":
+				dockerfile = composeText(value)
+			case "args":
+				p.warn(fmt.Sprintf("services.%s.build.args is ignored, the service environment is passed to the build", name))
+			default:
+				p.warn(fmt.Sprintf("services.%s.build.%s is ignored", name, key))
+			}
+		}
+	default:
+		buildContext = composeText(build)
+	}
+
+	buildContext = path.Clean(buildContext)
+	if path.IsAbs(buildContext) || buildContext == ".." || strings.HasPrefix(buildContext, "../") || strings.Contains(buildContext, "://") {
+		return DeploymentSource{}, fmt.Errorf("build context %s must be a directory of the source repository", buildContext)
+	}
+
+	return DeploymentSource{
+		Type:       "git",
+		Repository: group.Repository,
+		Branch:     group.Branch,
+		Commit:     group.Commit,
+		Tag:        group.Tag,
+		BuildPath:  path.Join(group.BuildPath, buildContext),
+		Dockerfile: dockerfile,
+		Auth:       group.Auth,
+	}, nil
+}
+
+// parseEnvironment reads environment given as a mapping or as a list of
+// NAME=value. A variable without a value is taken from the environment of
+// the request, as compose takes it from the shell.
+func (p *composeParser) parseEnvironment(value interface{}, environment map[string]string) error {
+	set := func(key string, value interface{}, hasValue bool) {
+		if hasValue {
+			environment[key] = composeText(value)
+			return
+		}
+		if _, exists := p.request.Environment[key]; !exists {
+			delete(environment, key)
+		}
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typed {
+			set(key, child, child != nil)
+		}
+	case []interface{}:
+		for _, entry := range typed {
+			key, child, hasValue := strings.Cut(composeText(entry), "=")
+			set(key, child, hasValue)
+		}
+	default:
+		return fmt.Errorf("must be a mapping or a list")
+	}
+
+	return nil
+}
+
+// parseVolumes reads the mounts of a service. Named volumes must be declared
+// at the top level; bind mounts need an absolute host path since the agent
+// has no project directory to resolve relative ones against.
+func (p *composeParser) parseVolumes(service string, value interface{}) ([]VolumeMapping, error) {
+	entries, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be a list")
+	}
+
+	var volumes []VolumeMapping
+	for _, entry := range entries {
+		var volume VolumeMapping
+
+		switch typed := entry.(type) {
+		case map[string]interface{}:
+			volume.Type = composeText(typed["type"])
+			volume.Source = composeText(typed["source"])
+			volume.Target = composeText(typed["target"])
+			if typed["read_only"] != nil {
+				readOnly, err := composeBool(typed["read_only"])
+				if err != nil {
+					return nil, fmt.Errorf("%s: read_only: %w", volume.Target, err)
+				}
+				volume.ReadOnly = readOnly
+			}
+		default:
+			parts := strings.Split(composeText(entry), ":")
+			switch len(parts) {
+			case 1:
+				volume.Target = parts[0]
+			case 2, 3:
+				volume.Source, volume.Target = parts[0], parts[1]
+				if len(parts) == 3 {
+					for _, option := range strings.Split(parts[2], ",") {
+						switch option {
+						case "ro":
+							volume.ReadOnly = true
+						case "rw":
+						default:
+							p.warn(fmt.Sprintf("services.%s.volumes: mount option %s of %s is ignored", service, option, volume.Target))
+						}
+					}
+				}
+			default:
+				return nil, fmt.Errorf("invalid volume %q", composeText(entry))
+			}
+		}
+
+		if volume.Source == "" {
+			if volume.Type == "tmpfs" {
+				return nil, fmt.Errorf("%s: tmpfs mounts are not supported", volume.Target)
+			}
+			p.warn(fmt.Sprintf("services.%s.volumes: anonymous volume %s is not mounted, name it to keep its data", service, volume.Target))
+			continue
+		}
+
+		switch {
+		case volume.Type == "bind" || (volume.Type == "" && (strings.HasPrefix(volume.Source, "/") || strings.HasPrefix(volume.Source, ".") || strings.HasPrefix(volume.Source, "~"))):
+			if !strings.HasPrefix(volume.Source, "/") {
+				return nil, fmt.Errorf("bind mount source %s must be an absolute path", volume.Source)
+			}
+			volume.Type = "bind"
+		case volume.Type == "" || volume.Type == "volume":
+			declared, exists := p.volumes[volume.Source]
+			if !exists {
+				return nil, fmt.Errorf("volume %s is not declared in the top-level volumes", volume.Source)
+			}
+			volume.Type = "volume"
+			volume.Source = declared.name
+		default:
+			return nil, fmt.Errorf("%s: %s mounts are not supported", volume.Target, volume.Type)
+		}
+
+		volumes = append(volumes, volume)
+	}
+
+	return volumes, nil
+}
+
+// parseServiceNetworks reads the networks a service joins, given as a list
+// or as a mapping that may add aliases
+func (p *composeParser) parseServiceNetworks(value interface{}, aliases []string) ([]string, []string, error) {
+	var networks []string
+
+	switch typed := value.(type) {
+	case []interface{}:
+		for _, entry := range typed {
+			networks = append(networks, composeText(entry))
+		}
+	case map[string]interface{}:
+		for network, settings := range typed {
+			networks = append(networks, network)
+
+			options, _ := settings.(map[string]interface{})
+			for key, option := range options {
+				if key != "aliases" {
+					return nil, nil, fmt.Errorf("%s.%s is not supported", network, key)
+				}
+				list, ok := option.([]interface{})
+				if !ok {
+					return nil, nil, fmt.Errorf("%s.aliases must be a list", network)
+				}
+				for _, alias := range list {
+					if text := composeText(alias); !containsString(aliases, text) {
+						aliases = append(aliases, text)
+					}
+				}
+			}
+		}
+	default:
+		return nil, nil, fmt.Errorf("must be a list or a mapping")
+	}
+
+	sort.Strings(networks)
+	for _, network := range networks {
+		if _, declared := p.networks[network]; !declared {
+			return nil, nil, fmt.Errorf("network %s is not declared in the top-level networks", network)
+		}
+	}
+
+	return networks, aliases, nil
+}
+
+// parseDependsOn reads the services a service waits for. A dependency is
+// ready once its deployment runs, which includes passing its health check.
+func (p *composeParser) parseDependsOn(service string, value interface{}) ([]string, error) {
+	var dependencies []string
+
+	switch typed := value.(type) {
+	case []interface{}:
+		for _, entry := range typed {
+			dependencies = append(dependencies, composeText(entry))
+		}
+	case map[string]interface{}:
+		for dependency, settings := range typed {
+			dependencies = append(dependencies, dependency)
+
+			options, _ := settings.(map[string]interface{})
+			switch condition := composeText(options["condition"]); condition {
+			case "", "service_started", "service_healthy":
+			case "service_completed_successfully":
+				return nil, fmt.Errorf("%s: one-off services are not supported", dependency)
+			default:
+				return nil, fmt.Errorf("%s: unknown condition %s", dependency, condition)
+			}
+			for key := range options {
+				if key != "condition" {
+					p.warn(fmt.Sprintf("services.%s.depends_on.%s.%s is ignored", service, dependency, key))
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("must be a list or a mapping")
+	}
+
+	sort.Strings(dependencies)
+	return dependencies, nil
+}
+
+// parseDeploy reads the replicas, resource limits and restart policy of the
+// deploy section
+func (p *composeParser) parseDeploy(service string, value interface{}, request *DeploymentRequest) error {
+	settings, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("must be a mapping")
+	}
+
+	for key, setting := range settings {
+		var err error
+
+		switch key {
+		case "replicas":
+			request.Config.Replicas, err = composeInt(setting)
+		case "resources":
+			resourceSettings, _ := setting.(map[string]interface{})
+			for kind, limits := range resourceSettings {
+				if kind != "limits" {
+					p.warn(fmt.Sprintf("services.%s.deploy.resources.%s is ignored", service, kind))
+					continue
+				}
+				limitSettings, _ := limits.(map[string]interface{})
+				for limit, amount := range limitSettings {
+					switch limit {
+					case "cpus":
+						request.ResourceLimits.CPULimit, err = composeFloat(amount)
+					case "memory":
+						request.ResourceLimits.MemoryLimit, err = composeBytes(amount)
+					case "pids":
+						request.ResourceLimits.ProcessLimit, err = composeInt(amount)
+					default:
+						p.warn(fmt.Sprintf("services.%s.deploy.resources.limits.%s is ignored", service, limit))
+					}
+					if err != nil {
+						return fmt.Errorf("resources.limits.%s: %w", limit, err)
+					}
+				}
+			}
+		case "restart_policy":
+			policy, _ := setting.(map[string]interface{})
+			switch condition := composeText(policy["condition"]); condition {
+			case "none":
+				request.Config.RestartPolicy = "no"
+			case "on-failure":
+				request.Config.RestartPolicy = "on-failure"
+				if attempts := composeText(policy["max_attempts"]); attempts != "" {
+					request.Config.RestartPolicy += ":" + attempts
+				}
+			case "", "any":
+				request.Config.RestartPolicy = "always"
+			default:
+				err = fmt.Errorf("unknown restart condition %s", condition)
+			}
+		default:
+			p.warn(fmt.Sprintf("services.%s.deploy.%s is ignored", service, key))
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *composeParser) warn(message string) {
+	if !containsString(p.warnings, message) {
+		p.warnings = append(p.warnings, message)
+	}
+}
+
+// composeStartOrder sorts services so each starts after the services it
+// depends on. Services that do not depend on each other keep name order.
+func composeStartOrder(services map[string]*ComposeService) ([]*ComposeService, error) {
+	remaining := make(map[string]int, len(services))
+	dependents := make(map[string][]string)
+
+	for name, service := range services {
+		for _, dependency := range service.DependsOn {
+			if _, exists := services[dependency]; !exists {
+				return nil, fmt.Errorf("service %s depends on unknown service %s", name, dependency)
+			}
+			if dependency == name {
+				return nil, fmt.Errorf("service %s depends on itself", name)
+			}
+			dependents[dependency] = append(dependents[dependency], name)
+		}
+		remaining[name] = len(service.DependsOn)
+	}
+
+	var ready []string
+	for name, count := range remaining {
+		if count == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	ordered := make([]*ComposeService, 0, len(services))
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+
+		ordered = append(ordered, services[name])
+		for _, dependent := range dependents[name] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(ordered) < len(services) {
+		var cycle []string
+		for name, count := range remaining {
+			if count > 0 {
+				cycle = append(cycle, name)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("services %s depend on each other in a cycle", strings.Join(cycle, ", "))
+	}
+
+	return ordered, nil
+}
+
+// parseComposePorts reads published ports given as "[host_ip:][host:]container[/protocol]",
+// where host and container may be ranges of the same length, or in the long
+// syntax
+func parseComposePorts(value interface{}) ([]PortMapping, error) {
+	entries, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be a list")
+	}
+
+	var ports []PortMapping
+	for _, entry := range entries {
+		if settings, ok := entry.(map[string]interface{}); ok {
+			port := PortMapping{
+				HostIP:   composeText(settings["host_ip"]),
+				Protocol: composeText(settings["protocol"]),
+			}
+
+			var err error
+			if port.ContainerPort, err = composeInt(settings["target"]); err != nil {
+				return nil, fmt.Errorf("target: %w", err)
+			}
+			if published := composeText(settings["published"]); published != "" {
+				if port.HostPort, err = strconv.Atoi(published); err != nil {
+					return nil, fmt.Errorf("published: invalid port %q", published)
+				}
+			}
+
+			ports = append(ports, port)
+			continue
+		}
+
+		spec := composeText(entry)
+		mappings, err := parseComposePort(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q: %w", spec, err)
+		}
+		ports = append(ports, mappings...)
+	}
+
+	return ports, nil
+}
+
+func parseComposePort(spec string) ([]PortMapping, error) {
+	protocol := ""
+	if slash := strings.LastIndex(spec, "/"); slash >= 0 {
+		spec, protocol = spec[:slash], spec[slash+1:]
+	}
+
+	hostIP := ""
+	if strings.HasPrefix(spec, "[") {
+		end := strings.Index(spec, "]:")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated IPv6 address")
+		}
+		hostIP, spec = spec[1:end], spec[end+2:]
+	}
+
+	parts := strings.Split(spec, ":")
+	var host, container string
+	switch {
+	case len(parts) == 1:
+		container = parts[0]
+	case len(parts) == 2:
+		host, container = parts[0], parts[1]
+	case len(parts) == 3 && hostIP == "":
+		hostIP, host, container = parts[0], parts[1], parts[2]
+	default:
+		return nil, fmt.Errorf("too many colons")
+	}
+
+	containerFirst, containerLast, err := composePortRange(container)
+	if err != nil {
+		return nil, err
+	}
+	hostFirst, hostLast := 0, 0
+	if host != "" {
+		if hostFirst, hostLast, err = composePortRange(host); err != nil {
+			return nil, err
+		}
+		if hostLast-hostFirst != containerLast-containerFirst {
+			return nil, fmt.Errorf("host and container port ranges differ in length")
+		}
+	}
+
+	var ports []PortMapping
+	for offset := 0; containerFirst+offset <= containerLast; offset++ {
+		port := PortMapping{ContainerPort: containerFirst + offset, Protocol: protocol, HostIP: hostIP}
+		if hostFirst > 0 {
+			port.HostPort = hostFirst + offset
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+func composePortRange(text string) (int, int, error) {
+	first, last, isRange := strings.Cut(text, "-")
+
+	start, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", text)
+	}
+	if !isRange {
+		return start, start, nil
+	}
+
+	end, err := strconv.Atoi(last)
+	if err != nil || end < start {
+		return 0, 0, fmt.Errorf("invalid port range %q", text)
+	}
+	return start, end, nil
+}
+
+// parseComposeHealthCheck turns a compose health check into a command check
+// run inside the container
+func parseComposeHealthCheck(value interface{}) (HealthCheckConfig, error) {
+	settings, ok := value.(map[string]interface{})
+	if !ok {
+		return HealthCheckConfig{}, fmt.Errorf("must be a mapping")
+	}
+
+	if settings["disable"] != nil {
+		disabled, err := composeBool(settings["disable"])
+		if err != nil {
+			return HealthCheckConfig{}, fmt.Errorf("disable: %w", err)
+		}
+		if disabled {
+			return HealthCheckConfig{}, nil
+		}
+	}
+
+	var test []string
+	switch typed := settings["test"].(type) {
+	case nil:
+		return HealthCheckConfig{}, fmt.Errorf("test is required")
+	case []interface{}:
+		for _, part := range typed {
+			test = append(test, composeText(part))
+		}
+	default:
+		test = []string{"CMD-SHELL", composeText(typed)}
+	}
+
+	healthCheck := HealthCheckConfig{Enabled: true, Type: "cmd"}
+	switch {
+	case len(test) > 0 && test[0] == "NONE":
+		return HealthCheckConfig{}, nil
+	case len(test) > 1 && test[0] == "CMD":
+		healthCheck.Command = test[1:]
+	case len(test) == 2 && test[0] == "CMD-SHELL":
+		healthCheck.Command = []string{"sh", "-c", test[1]}
+	default:
+		return HealthCheckConfig{}, fmt.Errorf("test must start with CMD, CMD-SHELL or NONE")
+	}
+
+	for key, setting := range settings {
+		var err error
+
+		switch key {
+		case "test", "disable":
+		case "interval":
+			healthCheck.PeriodSeconds, err = composeSeconds(setting)
+		case "timeout":
+			healthCheck.TimeoutSeconds, err = composeSeconds(setting)
+		case "start_period":
+			healthCheck.InitialDelaySeconds, err = composeSeconds(setting)
+		case "retries":
+			healthCheck.FailureThreshold, err = composeInt(setting)
+		default:
+			err = fmt.Errorf("not supported")
+		}
+
+		if err != nil {
+			return HealthCheckConfig{}, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	return healthCheck, nil
+}
+
+// composeRestartPolicy checks a restart policy, which docker takes as is
+func composeRestartPolicy(policy string) (string, error) {
+	name, attempts, hasAttempts := strings.Cut(policy, ":")
+	switch name {
+	case "no", "always", "unless-stopped":
+		if !hasAttempts {
+			return policy, nil
+		}
+	case "on-failure":
+		if _, err := strconv.Atoi(attempts); !hasAttempts || err == nil {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("invalid restart policy %q", policy)
+}
+
+// composeCommand reads a command given as a list or as a string that is
+// split like a shell would, without running one
+func composeCommand(value interface{}) ([]string, error) {
+	if list, ok := value.([]interface{}); ok {
+		command := make([]string, 0, len(list))
+		for _, part := range list {
+			command = append(command, composeText(part))
+		}
+		return command, nil
+	}
+
+	var (
+		command []string
+		current strings.Builder
+		quote   rune
+		inWord  bool
+		escaped bool
+	)
+	for _, r := range composeText(value) {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				command = append(command, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		command = append(command, current.String())
+	}
+
+	return command, nil
+}
+
+// composeMapping reads labels given as a mapping or a list of key=value
+func composeMapping(value interface{}, target map[string]string) error {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typed {
+			target[key] = composeText(child)
+		}
+	case []interface{}:
+		for _, entry := range typed {
+			key, child, _ := strings.Cut(composeText(entry), "=")
+			target[key] = child
+		}
+	default:
+		return fmt.Errorf("must be a mapping or a list")
+	}
+	return nil
+}
+
+// composeText returns the text of a scalar value
+func composeText(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case manifestScalar:
+		return typed.text
+	}
+	return fmt.Sprint(value)
+}
+
+func composeBool(value interface{}) (bool, error) {
+	parsed, err := strconv.ParseBool(composeText(value))
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean %q", composeText(value))
+	}
+	return parsed, nil
+}
+
+func composeInt(value interface{}) (int, error) {
+	parsed, err := strconv.Atoi(composeText(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", composeText(value))
+	}
+	return parsed, nil
+}
+
+func composeFloat(value interface{}) (float64, error) {
+	parsed, err := strconv.ParseFloat(composeText(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", composeText(value))
+	}
+	return parsed, nil
+}
+
+// composeBytes reads a size like 512m or 1gb as docker does, in binary units
+func composeBytes(value interface{}) (int64, error) {
+	text := strings.ToUpper(strings.TrimSpace(composeText(value)))
+	if n := len(text); n > 0 && strings.ContainsRune("KMGT", rune(text[n-1])) {
+		text += "B"
+	}
+
+	size, err := resources.ParseSize(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", composeText(value))
+	}
+	return size, nil
+}
+
+// composeSeconds reads a duration like 30s or 1m30s in whole seconds,
+// rounding up
+func composeSeconds(value interface{}) (int, error) {
+	duration, err := time.ParseDuration(composeText(value))
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid duration %q", composeText(value))
+	}
+	return int(math.Ceil(duration.Seconds())), nil
+}
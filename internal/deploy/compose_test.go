@@ -0,0 +1,187 @@
+package deploy
+
+import (
+	"strings"
+	"testing"
+)
+
+// composeRequest is a request deploying a compose file
+func composeRequest(compose string) *DeploymentRequest {
+	return &DeploymentRequest{
+		AppID:       "shop",
+		Version:     "1",
+		Source:      DeploymentSource{Type: "compose", Repository: "https://example.com/shop.git", Compose: compose},
+		Environment: map[string]string{"TAG": "15"},
+	}
+}
+
+func serviceNames(services []*ComposeService) string {
+	names := make([]string, len(services))
+	for i, service := range services {
+		names[i] = service.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestParseComposeStartOrder(t *testing.T) {
+	project, err := ParseCompose(composeRequest(`
+services:
+  web:
+    image: shop/web
+    depends_on: [api, cache]
+    ports: ["8080:80"]
+  api:
+    image: shop/api
+    depends_on:
+      db:
+        condition: service_healthy
+  db:
+    image: postgres:${TAG}
+  cache:
+    image: redis
+  admin:
+    image: shop/admin
+`))
+	if err != nil {
+		t.Fatalf("ParseCompose: %v", err)
+	}
+
+	if got, want := serviceNames(project.Services), "admin,cache,db,api,web"; got != want {
+		t.Fatalf("start order %s, want %s", got, want)
+	}
+
+	services := make(map[string]*ComposeService)
+	for _, service := range project.Services {
+		services[service.Name] = service
+	}
+
+	web := services["web"].Request
+	if web.AppID != "shop-web" || web.Labels[LabelGroup] != "shop" || web.Labels[LabelService] != "web" {
+		t.Errorf("web app %s labels %v, want shop-web in group shop", web.AppID, web.Labels)
+	}
+	if len(web.Ports) != 1 || web.Ports[0].HostPort != 8080 || web.Ports[0].ContainerPort != 80 {
+		t.Errorf("web ports %+v, want 80 published on 8080", web.Ports)
+	}
+	if db := services["db"].Request.Source; db.Repository != "postgres" || db.Tag != "15" {
+		t.Errorf("db image %s tag %s, want the tag filled in from the environment", db.Repository, db.Tag)
+	}
+	if got := strings.Join(services["web"].DependsOn, ","); got != "api,cache" {
+		t.Errorf("web depends on %s, want api,cache", got)
+	}
+	if got := strings.Join(project.Networks, ","); got != "shop_default" {
+		t.Errorf("networks %s, want shop_default", got)
+	}
+}
+
+func TestComposeStartOrder(t *testing.T) {
+	service := func(name string, dependsOn ...string) *ComposeService {
+		return &ComposeService{Name: name, DependsOn: dependsOn}
+	}
+
+	tests := []struct {
+		name     string
+		services []*ComposeService
+		want     string
+		err      string
+	}{
+		{"independent services by name", []*ComposeService{service("c"), service("a"), service("b")}, "a,b,c", ""},
+		{"chain", []*ComposeService{service("a", "b"), service("b", "c"), service("c")}, "c,b,a", ""},
+		{"diamond", []*ComposeService{service("top", "left", "right"), service("left", "base"), service("right", "base"), service("base")}, "base,left,right,top", ""},
+		{"dependency freed after later name", []*ComposeService{service("a", "z"), service("b"), service("z")}, "b,z,a", ""},
+		{"self dependency", []*ComposeService{service("a", "a")}, "", "service a depends on itself"},
+		{"unknown dependency", []*ComposeService{service("a", "missing")}, "", "service a depends on unknown service missing"},
+		{"two service cycle", []*ComposeService{service("a", "b"), service("b", "a"), service("c")}, "", "services a, b depend on each other in a cycle"},
+		{"cycle behind a dependent", []*ComposeService{service("web", "api"), service("api", "db"), service("db", "api")}, "", "services api, db, web depend on each other in a cycle"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := make(map[string]*ComposeService, len(tt.services))
+			for _, service := range tt.services {
+				services[service.Name] = service
+			}
+
+			ordered, err := composeStartOrder(services)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("composeStartOrder error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("composeStartOrder: %v", err)
+			}
+			if got := serviceNames(ordered); got != tt.want {
+				t.Fatalf("start order %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseComposeInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		compose string
+		want    string
+	}{
+		{"cycle", "services:\n  a: {image: a, depends_on: [b]}\n  b: {image: b, depends_on: [a]}\n", "depend on each other in a cycle"},
+		{"unknown dependency", "services:\n  a: {image: a, depends_on: [b]}\n", "depends on unknown service b"},
+		{"one-off dependency", "services:\n  a:\n    image: a\n    depends_on:\n      b: {condition: service_completed_successfully}\n  b: {image: b}\n", "one-off services are not supported"},
+		{"no services", "version: '3'\n", "a compose file needs services"},
+		{"unsupported top level", "services:\n  a: {image: a}\nconfigs: {}\n", "top-level configs is not supported"},
+		{"unsupported key", "services:\n  a: {image: a, env_file: .env}\n", "env_file is not supported"},
+		{"invalid service name", "services:\n  -a: {image: a}\n", "invalid service name"},
+		{"empty", "", "compose file of app shop is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCompose(composeRequest(tt.compose))
+			if err == nil {
+				t.Fatalf("ParseCompose succeeded, want an error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("ParseCompose error %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseComposePort(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []PortMapping
+		err  bool
+	}{
+		{spec: "80", want: []PortMapping{{ContainerPort: 80}}},
+		{spec: "8080:80", want: []PortMapping{{ContainerPort: 80, HostPort: 8080}}},
+		{spec: "127.0.0.1:8080:80/udp", want: []PortMapping{{ContainerPort: 80, HostPort: 8080, HostIP: "127.0.0.1", Protocol: "udp"}}},
+		{spec: "[::1]:8080:80", want: []PortMapping{{ContainerPort: 80, HostPort: 8080, HostIP: "::1"}}},
+		{spec: "9000-9001:80-81", want: []PortMapping{{ContainerPort: 80, HostPort: 9000}, {ContainerPort: 81, HostPort: 9001}}},
+		{spec: "9000-9002:80-81", err: true},
+		{spec: "1:2:3:4", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			ports, err := parseComposePort(tt.spec)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("parseComposePort succeeded with %+v, want an error", ports)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseComposePort: %v", err)
+			}
+			if len(ports) != len(tt.want) {
+				t.Fatalf("ports %+v, want %+v", ports, tt.want)
+			}
+			for i := range ports {
+				if ports[i] != tt.want[i] {
+					t.Fatalf("ports %+v, want %+v", ports, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package deploy
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"superagent/internal/config"
+	"superagent/internal/deploy/docker"
+	"superagent/internal/logging"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultContainerLogMaxSize is the size in MB a container log file is
+	// rotated at unless configured otherwise
+	defaultContainerLogMaxSize = 10
+
+	// defaultContainerLogMaxFiles is how many container log files are kept
+	// per deployment unless configured otherwise
+	defaultContainerLogMaxFiles = 5
+)
+
+// logCapture copies the output of a container into the logs of its
+// deployment
+type logCapture struct {
+	deploymentID string
+	cancel       context.CancelFunc
+	done         chan struct{}
+}
+
+// newContainerLogStore creates the store the output of deployment containers
+// is captured into
+func newContainerLogStore(cfg *config.Config) (*logging.ContainerLogStore, error) {
+	dir := cfg.Logging.ContainerLogDir
+	if dir == "" {
+		dir = filepath.Join(cfg.Agent.DataDir, "container-logs")
+	}
+
+	maxSize := cfg.Logging.ContainerLogMaxSize
+	if maxSize <= 0 {
+		maxSize = defaultContainerLogMaxSize
+	}
+
+	maxFiles := cfg.Logging.ContainerLogMaxFiles
+	if maxFiles <= 0 {
+		maxFiles = defaultContainerLogMaxFiles
+	}
+
+	return logging.NewContainerLogStore(dir, int64(maxSize)*1024*1024, maxFiles)
+}
+
+// ContainerLogs reads a page of the captured output of the containers of a
+// deployment
+func (de *DeploymentEngine) ContainerLogs(deploymentID string, query logging.LogQuery) (*logging.LogPage, error) {
+	if _, err := de.GetDeployment(deploymentID); err != nil {
+		return nil, err
+	}
+
+	return de.containerLogs.Read(deploymentID, query)
+}
+
+// FollowContainerLogs returns the last tail lines the containers of a
+// deployment wrote and a channel receiving the lines they write from then
+// on. The returned function stops following.
+func (de *DeploymentEngine) FollowContainerLogs(deploymentID string, tail int) ([]logging.LogEntry, <-chan logging.LogEntry, func(), error) {
+	if _, err := de.GetDeployment(deploymentID); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return de.containerLogs.Follow(deploymentID, tail)
+}
+
+// captureContainerLogs starts copying the output of a container into the
+// logs of a deployment in the background, unless it already is. Capturing
+// resumes after the last line stored, so a container that is attached to
+// again, such as after a restart of the agent, is not logged twice.
+func (de *DeploymentEngine) captureContainerLogs(deploymentID, containerID string) {
+	de.monitorMu.Lock()
+	if _, exists := de.logCaptures[containerID]; exists {
+		de.monitorMu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(de.ctx)
+	capture := &logCapture{deploymentID: deploymentID, cancel: cancel, done: make(chan struct{})}
+	de.logCaptures[containerID] = capture
+	de.monitorMu.Unlock()
+
+	de.wg.Add(1)
+	go func() {
+		defer de.wg.Done()
+		defer close(capture.done)
+		defer func() {
+			cancel()
+			de.monitorMu.Lock()
+			if de.logCaptures[containerID] == capture {
+				delete(de.logCaptures, containerID)
+			}
+			de.monitorMu.Unlock()
+		}()
+
+		since := de.containerLogs.LastTimestamp(deploymentID, containerID)
+		if !since.IsZero() {
+			since = since.Add(time.Nanosecond)
+		}
+
+		stdout, stderr, err := de.dockerManager.GetContainerLogs(ctx, containerID, docker.LogOptions{
+			Follow:     true,
+			Since:      since,
+			Timestamps: true,
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				logrus.Warnf("Failed to capture logs of container %s: %v", containerID, err)
+			}
+			return
+		}
+
+		sink := de.containerLogs.Sink(deploymentID)
+		readers := []*logging.ContainerLogReader{
+			logging.NewContainerLogReader(containerID, "stdout", stdout, sink),
+			logging.NewContainerLogReader(containerID, "stderr", stderr, sink),
+		}
+		for _, reader := range readers {
+			reader.Start()
+		}
+
+		// The output ends once the container stops
+		for _, reader := range readers {
+			reader.Wait()
+		}
+	}()
+}
+
+// stopLogCaptures stops capturing the output of the containers of a
+// deployment and waits until the last lines are stored
+func (de *DeploymentEngine) stopLogCaptures(deploymentID string) {
+	var stopped []*logCapture
+
+	de.monitorMu.Lock()
+	for containerID, capture := range de.logCaptures {
+		if capture.deploymentID == deploymentID {
+			capture.cancel()
+			delete(de.logCaptures, containerID)
+			stopped = append(stopped, capture)
+		}
+	}
+	de.monitorMu.Unlock()
+
+	for _, capture := range stopped {
+		<-capture.done
+	}
+}
+
+// removeContainerLogs deletes the captured output of a deployment
+func (de *DeploymentEngine) removeContainerLogs(deploymentID string) {
+	de.stopLogCaptures(deploymentID)
+
+	if err := de.containerLogs.Remove(deploymentID); err != nil {
+		logrus.Warnf("Failed to remove container logs: %v", err)
+	}
+}
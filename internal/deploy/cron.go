@@ -0,0 +1,212 @@
+package deploy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression with the five standard fields:
+// minute, hour, day of month, month and day of week. Fields take lists,
+// ranges, steps and month or weekday names. The macros @hourly, @daily,
+// @weekly, @monthly and @yearly and "@every <duration>" are understood too.
+type CronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64 // bit sets
+	anyDayOfMonth, anyDayOfWeek                bool   // field was "*", so days match on the other one alone
+	every                                      time.Duration
+	location                                   *time.Location
+}
+
+// cronField describes the values a field of a cron expression may take
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	// 7 is Sunday as well
+	{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCronSchedule parses a cron expression. Times are matched in the
+// given IANA time zone, or the agent's local one when it is empty.
+func ParseCronSchedule(expression, timeZone string) (*CronSchedule, error) {
+	location := time.Local
+	if timeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(timeZone); err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", timeZone, err)
+		}
+	}
+
+	expression = strings.TrimSpace(expression)
+	if interval, found := strings.CutPrefix(expression, "@every "); found {
+		every, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval in schedule %q: %w", expression, err)
+		}
+		if every < time.Minute {
+			return nil, fmt.Errorf("schedule %q runs more often than once a minute", expression)
+		}
+		return &CronSchedule{every: every, location: location}, nil
+	}
+
+	if macro, exists := cronMacros[strings.ToLower(expression)]; exists {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("schedule %q must have 5 fields: minute hour day-of-month month day-of-week", expression)
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, text := range fields {
+		var err error
+		if bits[i], err = parseCronField(text, cronFields[i]); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", expression, err)
+		}
+	}
+
+	// Sunday may be given as 0 or 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	schedule := &CronSchedule{
+		minute:        bits[0],
+		hour:          bits[1],
+		dayOfMonth:    bits[2],
+		month:         bits[3],
+		dayOfWeek:     bits[4],
+		anyDayOfMonth: strings.HasPrefix(fields[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(fields[4], "*"),
+		location:      location,
+	}
+
+	if schedule.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never runs", expression)
+	}
+
+	return schedule, nil
+}
+
+// parseCronField turns a field of a cron expression into the set of values
+// it matches
+func parseCronField(text string, field cronField) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(text, ",") {
+		rangeText, stepText, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepText, field.name)
+			}
+		}
+
+		low, high := field.min, field.max
+		if rangeText != "*" {
+			lowText, highText, isRange := strings.Cut(rangeText, "-")
+
+			var err error
+			if low, err = field.value(lowText); err != nil {
+				return 0, err
+			}
+			switch {
+			case isRange:
+				if high, err = field.value(highText); err != nil {
+					return 0, err
+				}
+			case !hasStep:
+				high = low
+			}
+		}
+
+		if low > high {
+			return 0, fmt.Errorf("invalid range %q in %s field", rangeText, field.name)
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+// value parses a single number or name of a field
+func (field cronField) value(text string) (int, error) {
+	if value, exists := field.names[strings.ToLower(text)]; exists {
+		return value, nil
+	}
+
+	value, err := strconv.Atoi(text)
+	if err != nil || value < field.min || value > field.max {
+		return 0, fmt.Errorf("invalid value %q in %s field, expected %d-%d", text, field.name, field.min, field.max)
+	}
+	return value, nil
+}
+
+// Next returns the first time after the given one the schedule runs, or the
+// zero time when it does not run within the next five years
+func (s *CronSchedule) Next(after time.Time) time.Time {
+	if s.every > 0 {
+		return after.Add(s.every).Truncate(time.Second)
+	}
+
+	t := after.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// dayMatches applies the cron rule for days: when both the day of month and
+// the day of week are restricted, a day matching either runs
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dayOfMonth := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
@@ -2,14 +2,18 @@ package deploy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"superagent/internal/config"
 	"superagent/internal/deploy/git"
 	"superagent/internal/deploy/docker"
 	"superagent/internal/deploy/lifecycle"
 	"superagent/internal/deploy/resources"
+	"superagent/internal/deploy/routing"
 	"superagent/internal/storage"
 	"superagent/internal/logging"
 	"superagent/internal/monitoring"
@@ -19,14 +23,40 @@ import (
 
 // DeploymentEngine orchestrates the complete deployment process
 type DeploymentEngine struct {
+	config            *config.Config
 	gitManager        *git.GitManager
 	dockerManager     *docker.DockerManager
 	lifecycleManager  *lifecycle.LifecycleManager
 	resourceManager   *resources.ResourceManager
+	routingManager    *routing.RoutingManager
 	store             *storage.SecureStore
 	auditLogger       *logging.AuditLogger
 	monitor           *monitoring.Monitor
+	events            *EventBus
+	scheduler         *operationScheduler
 	deployments       map[string]*Deployment
+	revisions         map[string][]*Revision
+	groups            map[string]*DeploymentGroup
+	jobs              map[string]*Job
+	jobCancels        map[string]context.CancelCauseFunc
+	monitorCancels    map[string]context.CancelFunc
+	deployCancels     map[string]context.CancelCauseFunc
+	remediating       map[string]bool
+	correctingDrift   map[string]bool
+	restartSamples    map[string][]restartSample
+	autoscaling       map[string]bool
+	autoscaleSamples  map[string][]autoscaleSample
+	usageHistory      *resources.UsageHistory
+	containerLogs     *logging.ContainerLogStore
+	logCaptures       map[string]*logCapture // by container ID
+	reportRemediation RemediationReporter
+	pendingCapacity   resources.QuotaUsage // containers admitted but not yet in the ledger
+	hostCapacity      *resources.HostCapacity // last reading, see hostCapacityTTL
+	capacityReadAt    time.Time
+	monitorMu         sync.Mutex
+	secretsMu         sync.Mutex
+	capacityMu        sync.Mutex
+	hostCapacityMu    sync.Mutex
 	mu                sync.RWMutex
 	ctx               context.Context
 	cancel            context.CancelFunc
@@ -48,10 +78,27 @@ type Deployment struct {
 	UpdatedAt         time.Time             `json:"updated_at"`
 	DeployedAt        *time.Time            `json:"deployed_at,omitempty"`
 	LastHealthCheck   *time.Time            `json:"last_health_check,omitempty"`
+	ImageID           string                `json:"image_id,omitempty"`
 	ContainerID       string                `json:"container_id,omitempty"`
 	ContainerName     string                `json:"container_name,omitempty"`
+	Replicas          []*Replica            `json:"replicas"`
+	Color             string                `json:"color,omitempty"`
+	LiveColor         string                `json:"live_color,omitempty"`
+	StandbyUntil      *time.Time            `json:"standby_until,omitempty"`
+	Canary            *CanaryStatus         `json:"canary,omitempty"`
+	Remediation       *RemediationStatus    `json:"remediation,omitempty"`
+	Drift             *DriftStatus          `json:"drift,omitempty"`
+	Autoscaling       *AutoscalingStatus    `json:"autoscaling,omitempty"`
+	Hooks             DeploymentHooks       `json:"hooks"`
+	HookRuns          []HookRun             `json:"hook_runs,omitempty"`
+	Revision          int                   `json:"revision,omitempty"` // number in the revision history of the app
+	SourceCommit      string                `json:"source_commit,omitempty"`
+	QueuePosition     int                   `json:"queue_position,omitempty"` // place in the queue of the app, 0 once started
+	QueuedAt          *time.Time            `json:"queued_at,omitempty"`
+	QueueWait         time.Duration         `json:"queue_wait,omitempty"`     // time spent waiting for the app queue and a build slot
 	Ports             []PortMapping         `json:"ports"`
 	Networks          []string              `json:"networks"`
+	NetworkAliases    []string              `json:"network_aliases,omitempty"`
 	Volumes           []VolumeMapping       `json:"volumes"`
 	Labels            map[string]string     `json:"labels"`
 	BuildLogs         []LogEntry            `json:"build_logs"`
@@ -74,27 +121,38 @@ const (
 	StatusRollingBack  DeploymentStatus = "rolling_back"
 	StatusHealthCheck  DeploymentStatus = "health_check"
 	StatusUpdating     DeploymentStatus = "updating"
+	StatusAborted      DeploymentStatus = "aborted"
+	StatusStandby      DeploymentStatus = "standby"
+	StatusCanary       DeploymentStatus = "canary"
+	StatusCancelled    DeploymentStatus = "cancelled"
+	StatusSuperseded   DeploymentStatus = "superseded"
 )
 
 // DeploymentSource specifies where the deployment comes from
 type DeploymentSource struct {
-	Type       string            `json:"type"`       // "git" or "docker"
-	Repository string            `json:"repository"` // Git repo URL or Docker image
+	Type       string            `json:"type"`       // "git", "docker" or "compose"
+	Repository string            `json:"repository"` // Git repo URL or Docker image; for compose, the repo services are built from
 	Branch     string            `json:"branch,omitempty"`
 	Commit     string            `json:"commit,omitempty"`
 	Tag        string            `json:"tag,omitempty"`
 	BuildPath  string            `json:"build_path,omitempty"`
 	Dockerfile string            `json:"dockerfile,omitempty"`
 	Auth       map[string]string `json:"auth,omitempty"`
+	Compose    string            `json:"compose,omitempty"` // docker-compose file of a compose source
 }
 
 // DeploymentConfig holds deployment configuration
 type DeploymentConfig struct {
 	Replicas        int               `json:"replicas"`
-	Strategy        string            `json:"strategy"`         // "rolling", "blue-green", "recreate"
+	Strategy        string            `json:"strategy"`         // "rolling", "blue-green", "canary", "recreate"
 	MaxUnavailable  int               `json:"max_unavailable"`
 	MaxSurge        int               `json:"max_surge"`
 	ProgressTimeout time.Duration     `json:"progress_timeout"`
+	WarmPeriod      time.Duration     `json:"warm_period"`      // how long blue-green keeps the old set running
+	Canary          CanaryConfig      `json:"canary"`
+	Remediation     RemediationPolicy `json:"remediation"`
+	Drift           DriftPolicy       `json:"drift"`
+	Autoscaling     AutoscalingPolicy `json:"autoscaling"`
 	RestartPolicy   string            `json:"restart_policy"`
 	Privileged      bool              `json:"privileged"`
 	ReadOnlyRootFS  bool              `json:"read_only_root_fs"`
@@ -170,15 +228,45 @@ type DeploymentMetrics struct {
 	DiskUsage        int64     `json:"disk_usage"`
 	RestartCount     int       `json:"restart_count"`
 	HealthCheckCount int       `json:"health_check_count"`
+	ErrorRate        float64   `json:"error_rate"`
+	AverageLatency   time.Duration `json:"average_latency"`
+	DriftedContainers int      `json:"drifted_containers"`
 	LastUpdated      time.Time `json:"last_updated"`
 }
 
 // RollbackInfo holds rollback information
 type RollbackInfo struct {
-	PreviousVersion string    `json:"previous_version"`
-	Reason          string    `json:"reason"`
-	Timestamp       time.Time `json:"timestamp"`
-	Status          string    `json:"status"`
+	PreviousVersion      string    `json:"previous_version"`
+	RestoredVersion      string    `json:"restored_version,omitempty"`
+	RestoredImageID      string    `json:"restored_image_id,omitempty"`
+	RestoredDeploymentID string    `json:"restored_deployment_id,omitempty"`
+	Reason               string    `json:"reason"`
+	Timestamp            time.Time `json:"timestamp"`
+	Status               string    `json:"status"`
+}
+
+// Revision is an entry in the history of an app. One is recorded for every
+// deployment of the app; those that succeeded capture everything needed to
+// bring the version back.
+type Revision struct {
+	Number          int                    `json:"number"`
+	DeploymentID    string                 `json:"deployment_id"`
+	AppID           string                 `json:"app_id"`
+	Version         string                 `json:"version"`
+	Outcome         string                 `json:"outcome"` // "pending", "succeeded", "failed", "cancelled", "superseded" or "aborted"
+	Error           string                 `json:"error,omitempty"`
+	TriggeredBy     string                 `json:"triggered_by,omitempty"`
+	RedeployOf      int                    `json:"redeploy_of,omitempty"`
+	SourceCommit    string                 `json:"source_commit,omitempty"`
+	ImageID         string                 `json:"image_id"`
+	ImageDigest     string                 `json:"image_digest,omitempty"`
+	Request         DeploymentRequest      `json:"request"`
+	ContainerConfig docker.ContainerConfig `json:"container_config"`
+	Environment     map[string]string      `json:"environment"`
+	HealthCheck     HealthCheckConfig      `json:"health_check"`
+	CreatedAt       time.Time              `json:"created_at"`
+	DeployedAt      time.Time              `json:"deployed_at"`
+	FinishedAt      *time.Time             `json:"finished_at,omitempty"`
 }
 
 // DeploymentRequest represents a deployment request
@@ -192,18 +280,28 @@ type DeploymentRequest struct {
 	Environment    map[string]string        `json:"environment"`
 	Ports          []PortMapping            `json:"ports"`
 	Networks       []string                 `json:"networks"`
+	NetworkAliases []string                 `json:"network_aliases,omitempty"` // names other containers on the networks reach it by
 	Volumes        []VolumeMapping          `json:"volumes"`
 	Labels         map[string]string        `json:"labels"`
+	Hooks          DeploymentHooks          `json:"hooks"`
+	TriggeredBy    string                   `json:"triggered_by,omitempty"`
 }
 
+const (
+	// defaultProgressTimeout bounds a deployment that does not set its own
+	defaultProgressTimeout = 10 * time.Minute
+
+	// maxRetainedRevisions is how many revisions are kept per app
+	maxRetainedRevisions = 50
+)
+
 // NewDeploymentEngine creates a new deployment engine
 func NewDeploymentEngine(
+	cfg *config.Config,
 	store *storage.SecureStore,
 	auditLogger *logging.AuditLogger,
 	monitor *monitoring.Monitor,
 ) (*DeploymentEngine, error) {
-	ctx, cancel := context.WithCancel(context.Background())
-
 	gitManager, err := git.NewGitManager(auditLogger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create git manager: %w", err)
@@ -224,18 +322,48 @@ func NewDeploymentEngine(
 		return nil, fmt.Errorf("failed to create resource manager: %w", err)
 	}
 
+	routingManager, err := routing.NewRoutingManager(cfg.Traefik, auditLogger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create routing manager: %w", err)
+	}
+
+	containerLogs, err := newContainerLogStore(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container log store: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
 	engine := &DeploymentEngine{
+		config:           cfg,
 		gitManager:       gitManager,
 		dockerManager:    dockerManager,
 		lifecycleManager: lifecycleManager,
 		resourceManager:  resourceManager,
+		routingManager:   routingManager,
 		store:            store,
 		auditLogger:      auditLogger,
 		monitor:          monitor,
+		events:           NewEventBus(),
 		deployments:      make(map[string]*Deployment),
+		revisions:        make(map[string][]*Revision),
+		groups:           make(map[string]*DeploymentGroup),
+		jobs:             make(map[string]*Job),
+		jobCancels:       make(map[string]context.CancelCauseFunc),
+		monitorCancels:   make(map[string]context.CancelFunc),
+		deployCancels:    make(map[string]context.CancelCauseFunc),
+		remediating:      make(map[string]bool),
+		correctingDrift:  make(map[string]bool),
+		restartSamples:   make(map[string][]restartSample),
+		autoscaling:      make(map[string]bool),
+		autoscaleSamples: make(map[string][]autoscaleSample),
+		usageHistory:     resources.NewUsageHistory(),
+		containerLogs:    containerLogs,
+		logCaptures:      make(map[string]*logCapture),
 		ctx:              ctx,
 		cancel:           cancel,
 	}
+	engine.scheduler = newOperationScheduler(cfg.Agent.MaxConcurrentOps, &engine.mu)
 
 	return engine, nil
 }
@@ -249,10 +377,44 @@ func (de *DeploymentEngine) Start() error {
 		logrus.Warnf("Failed to load deployments: %v", err)
 	}
 
+	if err := de.loadRevisions(); err != nil {
+		logrus.Warnf("Failed to load revision history: %v", err)
+	}
+
+	if err := de.loadUsageHistory(); err != nil {
+		logrus.Warnf("Failed to load usage history: %v", err)
+	}
+
+	if err := de.loadGroups(); err != nil {
+		logrus.Warnf("Failed to load deployment groups: %v", err)
+	}
+
+	if err := de.loadJobs(); err != nil {
+		logrus.Warnf("Failed to load jobs: %v", err)
+	}
+
+	if err := de.loadAllocations(); err != nil {
+		logrus.Warnf("Failed to load resource allocations: %v", err)
+	}
+	de.reconcileAllocations()
+
+	// Bring loaded deployments in line with the containers that actually exist
+	de.reconcileDeployments()
+
 	// Start monitoring goroutine
 	de.wg.Add(1)
 	go de.monitorDeployments()
 
+	// Start the runs of scheduled jobs
+	de.wg.Add(1)
+	go de.scheduleJobs()
+
+	// Enforce the retention policy
+	if de.config.Retention.Enabled {
+		de.wg.Add(1)
+		go de.collectGarbageLoop()
+	}
+
 	de.auditLogger.LogEvent("DEPLOYMENT_ENGINE_STARTED", map[string]interface{}{
 		"deployment_count": len(de.deployments),
 	})
@@ -267,6 +429,15 @@ func (de *DeploymentEngine) Stop() error {
 	de.cancel()
 	de.wg.Wait()
 
+	de.saveUsageHistory()
+
+	if err := de.containerLogs.Close(); err != nil {
+		logrus.Warnf("Failed to close container logs: %v", err)
+	}
+
+	// Let event subscribers know nothing more is coming
+	de.events.Close()
+
 	de.auditLogger.LogEvent("DEPLOYMENT_ENGINE_STOPPED", map[string]interface{}{})
 
 	return nil
@@ -274,15 +445,64 @@ func (de *DeploymentEngine) Stop() error {
 
 // Deploy creates and starts a new deployment
 func (de *DeploymentEngine) Deploy(request *DeploymentRequest) (*Deployment, error) {
+	return de.createDeployment(request, nil)
+}
+
+// createDeployment creates a deployment and queues it. A deployment
+// redeploying a past revision reuses the revision's image when it still
+// exists.
+func (de *DeploymentEngine) createDeployment(request *DeploymentRequest, redeploy *Revision) (*Deployment, error) {
+	ctx, cancel := context.WithTimeout(de.ctx, 30*time.Second)
+	errs := de.validateRequest(ctx, request)
+	cancel()
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
 	de.mu.Lock()
 	defer de.mu.Unlock()
 
-	// Generate deployment ID
-	deploymentID := fmt.Sprintf("%s-%s-%d", request.AppID, request.Version, time.Now().Unix())
+	deployment := newDeployment(request)
+	deploymentID := deployment.ID
+	if redeploy != nil {
+		deployment.ImageID = redeploy.ImageID
+	}
+
+	// Store deployment and open its entry in the app's history
+	de.deployments[deploymentID] = deployment
+	de.openRevision(deployment, request, redeploy)
+	de.saveDeployment(deployment)
+
+	// Queue behind earlier operations on the app and start the deployment
+	// process asynchronously
+	op := de.queueDeployment(deployment)
+	de.wg.Add(1)
+	go de.deployAsync(deployment, op)
+
+	de.auditLogger.LogEvent("DEPLOYMENT_CREATED", map[string]interface{}{
+		"deployment_id": deploymentID,
+		"app_id":        request.AppID,
+		"version":       request.Version,
+		"source_type":   request.Source.Type,
+		"revision":      deployment.Revision,
+		"triggered_by":  request.TriggeredBy,
+	})
+
+	return deployment, nil
+}
+
+// deploymentSequence tells apart deployments created at the same instant
+var deploymentSequence atomic.Uint64
 
-	// Create deployment
-	deployment := &Deployment{
-		ID:             deploymentID,
+// newDeploymentID returns a unique ID for a deployment of an app version
+func newDeploymentID(appID, version string) string {
+	return fmt.Sprintf("%s-%s-%d-%d", appID, version, time.Now().UnixNano(), deploymentSequence.Add(1))
+}
+
+// newDeployment creates a pending deployment from a request
+func newDeployment(request *DeploymentRequest) *Deployment {
+	return &Deployment{
+		ID:             newDeploymentID(request.AppID, request.Version),
 		AppID:          request.AppID,
 		Version:        request.Version,
 		Status:         StatusPending,
@@ -293,79 +513,80 @@ func (de *DeploymentEngine) Deploy(request *DeploymentRequest) (*Deployment, err
 		Environment:    request.Environment,
 		Ports:          request.Ports,
 		Networks:       request.Networks,
+		NetworkAliases: request.NetworkAliases,
 		Volumes:        request.Volumes,
 		Labels:         request.Labels,
+		Hooks:          request.Hooks,
 		CreatedAt:      time.Now(),
 		UpdatedAt:      time.Now(),
 		BuildLogs:      []LogEntry{},
 		DeploymentLogs: []LogEntry{},
 		Metrics:        DeploymentMetrics{},
 	}
+}
 
-	// Store deployment
-	de.deployments[deploymentID] = deployment
-
-	// Start deployment process asynchronously
-	de.wg.Add(1)
-	go de.deployAsync(deployment)
-
-	de.auditLogger.LogEvent("DEPLOYMENT_CREATED", map[string]interface{}{
-		"deployment_id": deploymentID,
-		"app_id":        request.AppID,
-		"version":       request.Version,
-		"source_type":   request.Source.Type,
-	})
-
-	return deployment, nil
+// runningDeployment finds the newest running deployment of an app. Callers
+// must hold de.mu.
+func (de *DeploymentEngine) runningDeployment(appID string) *Deployment {
+	var running *Deployment
+	for _, deployment := range de.deployments {
+		if deployment.AppID != appID || deployment.Status != StatusRunning {
+			continue
+		}
+		if running == nil || deployment.CreatedAt.After(running.CreatedAt) {
+			running = deployment
+		}
+	}
+	return running
 }
 
 // deployAsync handles the complete deployment process
-func (de *DeploymentEngine) deployAsync(deployment *Deployment) {
+func (de *DeploymentEngine) deployAsync(deployment *Deployment, op *operation) {
 	defer de.wg.Done()
+	defer de.scheduler.finish(op)
+
+	// Keep hold of the cancel function so Cancel can interrupt the deployment
+	ctx, cancelDeployment := context.WithCancelCause(de.ctx)
+	de.trackDeployment(deployment.ID, cancelDeployment)
+	defer de.untrackDeployment(deployment.ID)
+
+	// Wait until earlier operations on the app are done
+	if !de.waitForTurn(ctx, deployment, op) {
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(de.ctx, deployment.Config.ProgressTimeout)
+	ctx, cancel := context.WithTimeout(ctx, progressTimeout(deployment))
 	defer cancel()
 
 	// Update status to building
 	de.updateDeploymentStatus(deployment, StatusBuilding)
 
 	// Step 1: Build or pull image
-	var imageID string
-	var err error
-
-	if deployment.Source.Type == "git" {
-		imageID, err = de.buildFromGit(ctx, deployment)
-	} else if deployment.Source.Type == "docker" {
-		imageID, err = de.pullDockerImage(ctx, deployment)
-	} else {
-		err = fmt.Errorf("unsupported source type: %s", deployment.Source.Type)
-	}
-
+	imageID, err := de.prepareImage(ctx, deployment)
 	if err != nil {
-		de.handleDeploymentError(deployment, fmt.Errorf("failed to prepare image: %w", err))
+		de.abandonDeployment(ctx, deployment, fmt.Errorf("failed to prepare image: %w", err))
 		return
 	}
 
+	deployment.ImageID = imageID
+
 	// Update status to deploying
 	de.updateDeploymentStatus(deployment, StatusDeploying)
 
-	// Step 2: Deploy container
-	containerID, err := de.deployContainer(ctx, deployment, imageID)
-	if err != nil {
-		de.handleDeploymentError(deployment, fmt.Errorf("failed to deploy container: %w", err))
+	// Step 2: Run pre-deploy hooks before anything of the previous version
+	// is touched
+	deployment.ContainerName = fmt.Sprintf("superagent-%s", deployment.ID)
+	if err := de.runHooks(ctx, deployment, HookPreDeploy, deployment.Hooks.PreDeploy); err != nil {
+		de.abandonDeployment(ctx, deployment, err)
 		return
 	}
 
-	deployment.ContainerID = containerID
-	deployment.ContainerName = fmt.Sprintf("superagent-%s", deployment.ID)
+	// Step 3: Deploy replica containers and health check them
+	containerConfig := de.buildContainerConfig(deployment, imageID)
 
-	// Step 3: Health check
-	if deployment.HealthCheck.Enabled {
-		de.updateDeploymentStatus(deployment, StatusHealthCheck)
-		if err := de.performHealthCheck(ctx, deployment); err != nil {
-			de.handleDeploymentError(deployment, fmt.Errorf("health check failed: %w", err))
-			return
-		}
+	if err := de.rolloutReplicas(ctx, deployment, containerConfig); err != nil {
+		de.abandonDeployment(ctx, deployment, err)
+		return
 	}
 
 	// Step 4: Mark as running
@@ -373,32 +594,113 @@ func (de *DeploymentEngine) deployAsync(deployment *Deployment) {
 	deployment.DeployedAt = &now
 	de.updateDeploymentStatus(deployment, StatusRunning)
 
+	// Remember this revision so the app can be rolled back to it later
+	de.recordRevision(deployment, containerConfig)
+
 	// Step 5: Start monitoring
 	de.startDeploymentMonitoring(deployment)
 
+	// Step 6: Run post-deploy hooks. The new version already takes traffic,
+	// so a failing hook is reported but does not undo the deployment.
+	if err := de.runHooks(ctx, deployment, HookPostDeploy, deployment.Hooks.PostDeploy); err != nil {
+		logrus.Warnf("Post-deploy hooks of deployment %s failed: %v", deployment.ID, err)
+	}
+	de.saveDeployment(deployment)
+
 	de.auditLogger.LogEvent("DEPLOYMENT_COMPLETED", map[string]interface{}{
 		"deployment_id": deployment.ID,
-		"container_id":  containerID,
+		"container_id":  deployment.ContainerID,
+		"replicas":      len(deployment.Replicas),
 		"duration":      time.Since(deployment.CreatedAt).Seconds(),
 	})
 }
 
+// rolloutReplicas starts the replicas of a deployment. With the rolling,
+// blue-green and canary strategies they replace those of the app's running
+// deployment.
+func (de *DeploymentEngine) rolloutReplicas(ctx context.Context, deployment *Deployment, containerConfig docker.ContainerConfig) error {
+	switch deployment.Config.Strategy {
+	case "rolling":
+		if previous := de.claimPreviousDeployment(deployment); previous != nil {
+			return de.rollingUpdate(ctx, previous, deployment, containerConfig)
+		}
+	case "blue-green":
+		if previous := de.claimPreviousDeployment(deployment); previous != nil {
+			return de.blueGreenUpdate(ctx, previous, deployment, containerConfig)
+		}
+	case "canary":
+		if stable := de.claimPreviousDeployment(deployment); stable != nil {
+			return de.canaryRelease(ctx, stable, deployment, containerConfig)
+		}
+	}
+
+	replicas, err := de.startReplicas(ctx, deployment, containerConfig, deployment.Ports, 0, desiredReplicas(deployment), nil)
+	if err != nil {
+		return fmt.Errorf("failed to deploy container: %w", err)
+	}
+
+	de.setReplicas(deployment, replicas)
+
+	if deployment.HealthCheck.Enabled {
+		de.updateDeploymentStatus(deployment, StatusHealthCheck)
+		if err := de.checkReplicas(ctx, deployment, replicas, deployment.HealthCheck, deployment.Ports); err != nil {
+			return fmt.Errorf("health check failed: %w", err)
+		}
+	} else {
+		for _, replica := range replicas {
+			replica.Status = ReplicaRunning
+		}
+	}
+
+	// The first blue-green or canary deployment of an app starts out as the
+	// live set
+	if deployment.Config.Strategy == "blue-green" || deployment.Config.Strategy == "canary" {
+		deployment.Color = colorBlue
+		if err := de.switchTraffic(deployment); err != nil {
+			return fmt.Errorf("failed to route traffic: %w", err)
+		}
+		deployment.LiveColor = deployment.Color
+	}
+
+	return nil
+}
+
 // buildFromGit builds a Docker image from a Git repository
 func (de *DeploymentEngine) buildFromGit(ctx context.Context, deployment *Deployment) (string, error) {
-	// Clone repository
-	repoPath, err := de.gitManager.CloneRepository(ctx, deployment.Source.Repository, deployment.Source.Branch, deployment.Source.Auth)
+	// Clone repository, in full when a commit is pinned since it may be
+	// anywhere in the history
+	options := git.CloneOptions{
+		URL:       deployment.Source.Repository,
+		Branch:    deployment.Source.Branch,
+		Tag:       deployment.Source.Tag,
+		Commit:    deployment.Source.Commit,
+		Depth:     1,
+		Recursive: true,
+		Auth:      deployment.Source.Auth,
+	}
+	if options.Commit != "" {
+		options.Depth = 0
+	}
+
+	repoPath, err := de.gitManager.CloneRepositoryWithOptions(ctx, options)
 	if err != nil {
 		return "", fmt.Errorf("failed to clone repository: %w", err)
 	}
 	defer de.gitManager.CleanupRepository(repoPath)
 
+	// Remember exactly what is being built
+	if info, err := de.gitManager.GetRepositoryInfo(repoPath); err == nil {
+		deployment.SourceCommit = info.Commit
+		de.addBuildLog(deployment, "info", fmt.Sprintf("Building commit %s", info.Commit))
+	}
+
 	// Build Docker image
 	buildContext := docker.BuildContext{
 		ContextPath:  repoPath,
 		Dockerfile:   deployment.Source.Dockerfile,
 		BuildPath:    deployment.Source.BuildPath,
 		ImageTag:     fmt.Sprintf("superagent/%s:%s", deployment.AppID, deployment.Version),
-		BuildArgs:    deployment.Environment,
+		BuildArgs:    buildArgs(deployment.Environment),
 		Labels:       deployment.Labels,
 		NoCache:      false,
 		Pull:         true,
@@ -435,15 +737,16 @@ func (de *DeploymentEngine) pullDockerImage(ctx context.Context, deployment *Dep
 	return imageID, nil
 }
 
-// deployContainer creates and starts a container
-func (de *DeploymentEngine) deployContainer(ctx context.Context, deployment *Deployment, imageID string) (string, error) {
-	containerConfig := docker.ContainerConfig{
+// buildContainerConfig derives the container configuration for a deployment
+func (de *DeploymentEngine) buildContainerConfig(deployment *Deployment, imageID string) docker.ContainerConfig {
+	return docker.ContainerConfig{
 		Image:        imageID,
 		Name:         deployment.ContainerName,
 		Environment:  deployment.Environment,
 		Ports:        convertPortMappings(deployment.Ports),
 		Volumes:      convertVolumeMappings(deployment.Volumes),
 		Networks:     deployment.Networks,
+		NetworkAliases: deployment.NetworkAliases,
 		Labels:       deployment.Labels,
 		Command:      deployment.Config.Command,
 		Args:         deployment.Config.Args,
@@ -461,8 +764,11 @@ func (de *DeploymentEngine) deployContainer(ctx context.Context, deployment *Dep
 		},
 		SecurityOpts: buildSecurityOpts(deployment.Config.Security),
 	}
+}
 
-	containerID, err := de.dockerManager.CreateContainer(ctx, containerConfig)
+// deployContainer creates and starts a container
+func (de *DeploymentEngine) deployContainer(ctx context.Context, containerConfig docker.ContainerConfig) (string, error) {
+	containerID, err := de.createContainer(ctx, containerConfig, "container "+containerConfig.Name)
 	if err != nil {
 		return "", fmt.Errorf("failed to create container: %w", err)
 	}
@@ -476,20 +782,9 @@ func (de *DeploymentEngine) deployContainer(ctx context.Context, deployment *Dep
 	return containerID, nil
 }
 
-// performHealthCheck performs health checks on the deployment
-func (de *DeploymentEngine) performHealthCheck(ctx context.Context, deployment *Deployment) error {
-	return de.lifecycleManager.PerformHealthCheck(ctx, deployment.ContainerID, lifecycle.HealthCheckConfig{
-		Type:                deployment.HealthCheck.Type,
-		Path:                deployment.HealthCheck.Path,
-		Port:                deployment.HealthCheck.Port,
-		Command:             deployment.HealthCheck.Command,
-		InitialDelaySeconds: deployment.HealthCheck.InitialDelaySeconds,
-		PeriodSeconds:       deployment.HealthCheck.PeriodSeconds,
-		TimeoutSeconds:      deployment.HealthCheck.TimeoutSeconds,
-		FailureThreshold:    deployment.HealthCheck.FailureThreshold,
-		SuccessThreshold:    deployment.HealthCheck.SuccessThreshold,
-		Headers:             deployment.HealthCheck.Headers,
-	})
+// performContainerHealthCheck runs a health check against a single container
+func (de *DeploymentEngine) performContainerHealthCheck(ctx context.Context, containerID string, healthCheck HealthCheckConfig) error {
+	return de.lifecycleManager.PerformHealthCheck(ctx, containerID, convertHealthCheckConfig(healthCheck))
 }
 
 // StopDeployment stops a deployment
@@ -506,23 +801,28 @@ func (de *DeploymentEngine) StopDeployment(deploymentID string) error {
 		return nil // Already stopped
 	}
 
+	de.stopDeploymentMonitoring(deploymentID)
 	de.updateDeploymentStatus(deployment, StatusStopping)
 
-	// Stop container
-	if deployment.ContainerID != "" {
+	// Stop replica containers
+	for _, replica := range deploymentReplicas(deployment) {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		if err := de.dockerManager.StopContainer(ctx, deployment.ContainerID, 10); err != nil {
-			logrus.Warnf("Failed to stop container %s: %v", deployment.ContainerID, err)
+		if err := de.dockerManager.StopContainer(ctx, replica.ContainerID, 10); err != nil {
+			logrus.Warnf("Failed to stop container %s: %v", replica.ContainerID, err)
 		}
+		cancel()
+		de.releaseContainer(replica.ContainerID)
+
+		replica.Status = ReplicaStopped
 	}
 
 	de.updateDeploymentStatus(deployment, StatusStopped)
+	de.removeRoute(deployment)
 
 	de.auditLogger.LogEvent("DEPLOYMENT_STOPPED", map[string]interface{}{
 		"deployment_id": deploymentID,
 		"container_id":  deployment.ContainerID,
+		"replicas":      len(deployment.Replicas),
 	})
 
 	return nil
@@ -530,10 +830,10 @@ func (de *DeploymentEngine) StopDeployment(deploymentID string) error {
 
 // Remove removes a deployment completely
 func (de *DeploymentEngine) Remove(deploymentID string) error {
-	de.mu.Lock()
-	defer de.mu.Unlock()
-
+	de.mu.RLock()
 	deployment, exists := de.deployments[deploymentID]
+	de.mu.RUnlock()
+
 	if !exists {
 		return fmt.Errorf("deployment not found: %s", deploymentID)
 	}
@@ -545,14 +845,16 @@ func (de *DeploymentEngine) Remove(deploymentID string) error {
 		}
 	}
 
-	// Remove container
-	if deployment.ContainerID != "" {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+	de.stopDeploymentMonitoring(deploymentID)
 
-		if err := de.dockerManager.RemoveContainer(ctx, deployment.ContainerID, true); err != nil {
-			logrus.Warnf("Failed to remove container %s: %v", deployment.ContainerID, err)
+	// Remove replica containers
+	for _, replica := range deploymentReplicas(deployment) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := de.dockerManager.RemoveContainer(ctx, replica.ContainerID, true); err != nil {
+			logrus.Warnf("Failed to remove container %s: %v", replica.ContainerID, err)
 		}
+		cancel()
+		de.releaseContainer(replica.ContainerID)
 	}
 
 	// Remove from storage
@@ -561,7 +863,16 @@ func (de *DeploymentEngine) Remove(deploymentID string) error {
 	}
 
 	// Remove from memory
+	de.mu.Lock()
 	delete(de.deployments, deploymentID)
+	de.removeRoute(deployment)
+	de.mu.Unlock()
+	de.usageHistory.Remove(deploymentID)
+	de.removeContainerLogs(deploymentID)
+
+	de.monitorMu.Lock()
+	delete(de.autoscaleSamples, deploymentID)
+	de.monitorMu.Unlock()
 
 	de.auditLogger.LogEvent("DEPLOYMENT_REMOVED", map[string]interface{}{
 		"deployment_id": deploymentID,
@@ -596,42 +907,204 @@ func (de *DeploymentEngine) ListDeployments() []*Deployment {
 	return deployments
 }
 
-// Rollback rolls back a deployment to a previous version
-func (de *DeploymentEngine) Rollback(deploymentID string, reason string) error {
-	de.mu.Lock()
-	defer de.mu.Unlock()
+// Rollback restores the revision of the deployment's app that was running
+// before the current one. The prior image is started with its original
+// container configuration and environment, health-checked, and only then are
+// the failed replicas stopped and removed.
+func (de *DeploymentEngine) Rollback(deploymentID string, reason string) (*RollbackInfo, error) {
+	// Wait for earlier operations on the app to finish
+	release, err := de.lockDeploymentApp(deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
+	de.mu.Lock()
 	deployment, exists := de.deployments[deploymentID]
 	if !exists {
-		return fmt.Errorf("deployment not found: %s", deploymentID)
+		de.mu.Unlock()
+		return nil, fmt.Errorf("deployment not found: %s", deploymentID)
 	}
 
-	if deployment.Rollback == nil {
-		return fmt.Errorf("no rollback information available for deployment: %s", deploymentID)
+	switch deployment.Status {
+	case StatusPending, StatusBuilding, StatusDeploying, StatusHealthCheck, StatusRollingBack, StatusUpdating, StatusCanary, StatusCancelled, StatusSuperseded:
+		de.mu.Unlock()
+		return nil, fmt.Errorf("deployment %s cannot be rolled back while %s", deploymentID, deployment.Status)
 	}
 
+	// A blue-green deployment with a warm standby set only needs its
+	// route switched back
+	if standby := de.standbyDeployment(deployment); standby != nil {
+		previousStatus := deployment.Status
+		deployment.Status = StatusRollingBack
+		standby.Status = StatusUpdating
+		de.mu.Unlock()
+
+		return de.instantRollback(deployment, standby, previousStatus, reason)
+	}
+
+	target := de.previousRevision(deployment)
+	if target == nil {
+		de.mu.Unlock()
+		return nil, fmt.Errorf("no previous revision available for app %s", deployment.AppID)
+	}
+
+	// Claim the deployment before releasing the lock so concurrent
+	// rollbacks are rejected
+	previousStatus := deployment.Status
+	deployment.Status = StatusRollingBack
+	de.mu.Unlock()
+
+	de.stopDeploymentMonitoring(deploymentID)
 	de.updateDeploymentStatus(deployment, StatusRollingBack)
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Rolling back from version %s to version %s: %s", deployment.Version, target.Version, reason))
+
+	ctx, cancel := context.WithTimeout(de.ctx, progressTimeout(deployment))
+	defer cancel()
+
+	failedReplicas := deploymentReplicas(deployment)
+	targetPorts := portMappingsFromDocker(target.ContainerConfig.Ports)
+
+	// A host port can only be bound once, so the failed replicas have to
+	// release it before the restored revision can start
+	var stoppedReplicas []*Replica
+	released := make(map[string]bool)
+	if sharesHostPorts(target.ContainerConfig.Ports, convertPortMappings(deployment.Ports)) {
+		for _, replica := range failedReplicas {
+			if err := de.dockerManager.StopContainer(ctx, replica.ContainerID, 10); err != nil {
+				logrus.Warnf("Failed to stop container %s before rollback: %v", replica.ContainerID, err)
+				continue
+			}
+			de.releaseContainer(replica.ContainerID)
+			stoppedReplicas = append(stoppedReplicas, replica)
+			released[replica.ContainerID] = true
+		}
+	}
+
+	containerConfig := target.ContainerConfig
+	containerConfig.Name = fmt.Sprintf("superagent-%s-%d", deployment.ID, time.Now().Unix())
+
+	replicas, err := de.startReplicas(ctx, deployment, containerConfig, targetPorts, 0, desiredReplicas(deployment), released)
+	if err != nil {
+		return nil, de.abortRollback(deployment, target, previousStatus, stoppedReplicas, reason, fmt.Errorf("failed to start version %s: %w", target.Version, err))
+	}
+
+	if target.HealthCheck.Enabled {
+		if err := de.checkReplicas(ctx, deployment, replicas, target.HealthCheck, targetPorts); err != nil {
+			de.removeReplicas(replicas)
+			return nil, de.abortRollback(deployment, target, previousStatus, stoppedReplicas, reason, fmt.Errorf("version %s failed health check: %w", target.Version, err))
+		}
+	} else {
+		for _, replica := range replicas {
+			replica.Status = ReplicaRunning
+		}
+	}
 
-	// Implement rollback logic here
-	// This is a simplified version - in production you'd want more sophisticated rollback
+	// The restored revision is healthy, retire the failed replicas
+	de.removeReplicas(failedReplicas)
 
 	rollbackInfo := &RollbackInfo{
-		PreviousVersion: deployment.Version,
-		Reason:          reason,
-		Timestamp:       time.Now(),
-		Status:          "completed",
+		PreviousVersion:      deployment.Version,
+		RestoredVersion:      target.Version,
+		RestoredImageID:      target.ImageID,
+		RestoredDeploymentID: target.DeploymentID,
+		Reason:               reason,
+		Timestamp:            time.Now(),
+		Status:               "completed",
 	}
 
+	now := time.Now()
+	deployment.Version = target.Version
+	deployment.ImageID = target.ImageID
+	deployment.Environment = target.Environment
+	deployment.HealthCheck = target.HealthCheck
+	deployment.Ports = targetPorts
+	deployment.DeployedAt = &now
+	deployment.Metrics.HealthCheckCount = 0
 	deployment.Rollback = rollbackInfo
-	deployment.UpdatedAt = time.Now()
+	de.setReplicas(deployment, replicas)
+
+	if deployment.LiveColor != "" {
+		if err := de.switchTraffic(deployment); err != nil {
+			logrus.Warnf("Failed to route traffic to rolled back deployment %s: %v", deploymentID, err)
+		}
+	}
+
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Rolled back to version %s (image %s)", target.Version, target.ImageID))
+	de.updateDeploymentStatus(deployment, StatusRunning)
+	de.startDeploymentMonitoring(deployment)
 
 	de.auditLogger.LogEvent("DEPLOYMENT_ROLLBACK", map[string]interface{}{
-		"deployment_id":     deploymentID,
-		"previous_version":  rollbackInfo.PreviousVersion,
-		"reason":            reason,
+		"deployment_id":          deploymentID,
+		"previous_version":       rollbackInfo.PreviousVersion,
+		"restored_version":       rollbackInfo.RestoredVersion,
+		"restored_image_id":      rollbackInfo.RestoredImageID,
+		"restored_deployment_id": rollbackInfo.RestoredDeploymentID,
+		"container_id":           deployment.ContainerID,
+		"replicas":               len(replicas),
+		"reason":                 reason,
 	})
 
-	return nil
+	return rollbackInfo, nil
+}
+
+// abortRollback puts a deployment back the way it was after a failed rollback
+func (de *DeploymentEngine) abortRollback(deployment *Deployment, target *Revision, previousStatus DeploymentStatus, stoppedReplicas []*Replica, reason string, rollbackErr error) error {
+	for _, replica := range stoppedReplicas {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := de.dockerManager.StartContainer(ctx, replica.ContainerID); err != nil {
+			logrus.Warnf("Failed to restart container %s after aborted rollback: %v", replica.ContainerID, err)
+		} else {
+			de.allocateContainer(deployment.AppID, replica.ContainerID, deployment.ResourceLimits)
+		}
+		cancel()
+	}
+
+	deployment.Rollback = &RollbackInfo{
+		PreviousVersion:      deployment.Version,
+		RestoredVersion:      target.Version,
+		RestoredImageID:      target.ImageID,
+		RestoredDeploymentID: target.DeploymentID,
+		Reason:               reason,
+		Timestamp:            time.Now(),
+		Status:               "failed",
+	}
+
+	de.addDeploymentLog(deployment, "error", fmt.Sprintf("Rollback failed: %v", rollbackErr))
+	de.updateDeploymentStatus(deployment, previousStatus)
+	if previousStatus == StatusRunning {
+		de.startDeploymentMonitoring(deployment)
+	}
+
+	de.auditLogger.LogEvent("DEPLOYMENT_ROLLBACK_FAILED", map[string]interface{}{
+		"deployment_id":    deployment.ID,
+		"restored_version": target.Version,
+		"error":            rollbackErr.Error(),
+	})
+
+	return fmt.Errorf("rollback failed: %w", rollbackErr)
+}
+
+// previousRevision finds the revision that preceded the one a deployment is
+// running. A deployment whose image was never recorded (for example one that
+// failed its initial health check) goes back to the latest recorded revision.
+// Callers must hold de.mu.
+func (de *DeploymentEngine) previousRevision(deployment *Deployment) *Revision {
+	history := deployedRevisions(de.revisions[deployment.AppID])
+
+	current := len(history)
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].ImageID == deployment.ImageID {
+			current = i
+			break
+		}
+	}
+
+	if current == 0 {
+		return nil
+	}
+
+	return history[current-1]
 }
 
 // Helper functions
@@ -641,19 +1114,14 @@ func (de *DeploymentEngine) updateDeploymentStatus(deployment *Deployment, statu
 	deployment.UpdatedAt = time.Now()
 
 	// Save to storage
-	stateData := map[string]interface{}{
-		"status":     string(status),
-		"updated_at": deployment.UpdatedAt,
-	}
-
-	if err := de.store.StoreDeploymentState(deployment.ID, stateData); err != nil {
-		logrus.Warnf("Failed to store deployment state: %v", err)
-	}
+	de.saveDeployment(deployment)
 
 	// Send metrics
 	if de.monitor != nil {
 		de.monitor.RecordDeploymentStatus(deployment.ID, string(status))
 	}
+
+	de.publishEvent(deployment, Event{Type: EventStatus})
 }
 
 func (de *DeploymentEngine) handleDeploymentError(deployment *Deployment, err error) {
@@ -676,6 +1144,7 @@ func (de *DeploymentEngine) addBuildLog(deployment *Deployment, level, message s
 	}
 
 	deployment.BuildLogs = append(deployment.BuildLogs, logEntry)
+	de.publishEvent(deployment, Event{Type: EventLog, Log: &logEntry})
 }
 
 func (de *DeploymentEngine) addDeploymentLog(deployment *Deployment, level, message string) {
@@ -687,6 +1156,7 @@ func (de *DeploymentEngine) addDeploymentLog(deployment *Deployment, level, mess
 	}
 
 	deployment.DeploymentLogs = append(deployment.DeploymentLogs, logEntry)
+	de.publishEvent(deployment, Event{Type: EventLog, Log: &logEntry})
 }
 
 func (de *DeploymentEngine) loadDeployments() error {
@@ -702,13 +1172,16 @@ func (de *DeploymentEngine) loadDeployments() error {
 			continue
 		}
 
-		// Reconstruct deployment from state
-		// This is a simplified version - in production you'd want more complete state restoration
-		deployment := &Deployment{
-			ID:        deploymentID,
-			Status:    DeploymentStatus(state["status"].(string)),
-			UpdatedAt: state["updated_at"].(time.Time),
+		if state == nil {
+			continue
+		}
+
+		deployment, err := decodeDeploymentState(state)
+		if err != nil {
+			logrus.Warnf("Failed to restore deployment %s: %v", deploymentID, err)
+			continue
 		}
+		deployment.ID = deploymentID
 
 		de.deployments[deploymentID] = deployment
 	}
@@ -722,102 +1195,284 @@ func (de *DeploymentEngine) monitorDeployments() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
+	driftTicker := time.NewTicker(driftCheckInterval)
+	defer driftTicker.Stop()
+
+	historyTicker := time.NewTicker(usageHistorySaveInterval)
+	defer historyTicker.Stop()
+
 	for {
 		select {
 		case <-de.ctx.Done():
 			return
 		case <-ticker.C:
 			de.updateDeploymentMetrics()
+		case <-driftTicker.C:
+			de.detectDrift()
+		case <-historyTicker.C:
+			de.saveUsageHistory()
 		}
 	}
 }
 
 func (de *DeploymentEngine) updateDeploymentMetrics() {
-	de.mu.RLock()
-	defer de.mu.RUnlock()
+	// The replicas are picked under the lock but their stats are collected
+	// without it, so a slow container runtime holds up no other operation
+	type collection struct {
+		deployment *Deployment
+		replicas   []*Replica
+		containers []string
+		stats      []*docker.ContainerStats
+	}
+	var collections []*collection
 
+	de.mu.RLock()
 	for _, deployment := range de.deployments {
-		if deployment.Status == StatusRunning && deployment.ContainerID != "" {
+		if deployment.Status != StatusRunning {
+			continue
+		}
+
+		c := &collection{deployment: deployment}
+		for _, replica := range deployment.Replicas {
+			if replica.Status == ReplicaStopped || replica.Status == ReplicaFailed {
+				continue
+			}
+			c.replicas = append(c.replicas, replica)
+			c.containers = append(c.containers, replica.ContainerID)
+		}
+		if len(c.replicas) > 0 {
+			collections = append(collections, c)
+		}
+	}
+	de.mu.RUnlock()
+
+	for _, c := range collections {
+		c.stats = make([]*docker.ContainerStats, len(c.containers))
+		for i, containerID := range c.containers {
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			stats, err := de.dockerManager.GetContainerStats(ctx, deployment.ContainerID)
+			stats, err := de.dockerManager.GetContainerStats(ctx, containerID)
 			cancel()
 
 			if err != nil {
-				logrus.Warnf("Failed to get stats for container %s: %v", deployment.ContainerID, err)
+				logrus.Warnf("Failed to get stats for container %s: %v", containerID, err)
 				continue
 			}
+			c.stats[i] = stats
+		}
+	}
+
+	// Remediation and autoscaling start operations of their own, so they
+	// wait until the metrics are applied
+	type result struct {
+		deployment *Deployment
+		replicas   []DeploymentMetrics
+		containers []string
+		restarts   int
+		crashLoop  bool
+	}
+	var results []result
 
-			deployment.Metrics = DeploymentMetrics{
-				CPUUsage:      stats.CPUUsage,
-				MemoryUsage:   stats.MemoryUsage,
-				MemoryLimit:   stats.MemoryLimit,
-				NetworkRx:     stats.NetworkRx,
-				NetworkTx:     stats.NetworkTx,
-				DiskUsage:     stats.DiskUsage,
-				RestartCount:  stats.RestartCount,
-				LastUpdated:   time.Now(),
+	de.mu.Lock()
+	for _, c := range collections {
+		deployment := c.deployment
+
+		// The deployment may have been stopped or updated in the meantime
+		if deployment.Status != StatusRunning {
+			continue
+		}
+
+		aggregate := DeploymentMetrics{
+			HealthCheckCount: deployment.Metrics.HealthCheckCount,
+			ErrorRate:        deployment.Metrics.ErrorRate,
+			AverageLatency:   deployment.Metrics.AverageLatency,
+			DriftedContainers: deployment.Metrics.DriftedContainers,
+			LastUpdated:      time.Now(),
+		}
+		var collected []DeploymentMetrics
+		var containers []string
+
+		for i, replica := range c.replicas {
+			stats := c.stats[i]
+			if stats == nil || replica.ContainerID != c.containers[i] {
+				continue
 			}
 
-			// Send metrics to monitoring system
-			if de.monitor != nil {
-				monitoringMetrics := monitoring.DeploymentMetrics{
-					CPUUsage:         deployment.Metrics.CPUUsage,
-					MemoryUsage:      deployment.Metrics.MemoryUsage,
-					MemoryLimit:      deployment.Metrics.MemoryLimit,
-					NetworkRx:        deployment.Metrics.NetworkRx,
-					NetworkTx:        deployment.Metrics.NetworkTx,
-					DiskUsage:        deployment.Metrics.DiskUsage,
-					RestartCount:     deployment.Metrics.RestartCount,
-					HealthCheckCount: deployment.Metrics.HealthCheckCount,
-					LastUpdated:      deployment.Metrics.LastUpdated,
-				}
-				de.monitor.RecordDeploymentMetrics(deployment.ID, monitoringMetrics)
+			replica.Metrics = DeploymentMetrics{
+				CPUUsage:         stats.CPUUsage,
+				MemoryUsage:      stats.MemoryUsage,
+				MemoryLimit:      stats.MemoryLimit,
+				NetworkRx:        stats.NetworkRx,
+				NetworkTx:        stats.NetworkTx,
+				DiskUsage:        stats.DiskUsage,
+				RestartCount:     stats.RestartCount,
+				HealthCheckCount: replica.HealthCheckFailures,
+				LastUpdated:      time.Now(),
 			}
+
+			// The deployment reports the sum over its replicas
+			aggregate.CPUUsage += stats.CPUUsage
+			aggregate.MemoryUsage += stats.MemoryUsage
+			aggregate.MemoryLimit += stats.MemoryLimit
+			aggregate.NetworkRx += stats.NetworkRx
+			aggregate.NetworkTx += stats.NetworkTx
+			aggregate.DiskUsage += stats.DiskUsage
+			aggregate.RestartCount += stats.RestartCount
+			collected = append(collected, replica.Metrics)
+			containers = append(containers, replica.ContainerID)
+		}
+
+		if len(collected) == 0 {
+			continue
+		}
+
+		deployment.Metrics = aggregate
+		de.recordUsage(deployment, collected)
+
+		// Send metrics to monitoring system
+		de.recordDeploymentMetrics(deployment)
+
+		// Containers the runtime keeps restarting are crash-looping
+		restarts, crashLoop := de.detectCrashLoop(deployment)
+		results = append(results, result{deployment, collected, containers, restarts, crashLoop})
+	}
+	de.mu.Unlock()
+
+	for _, r := range results {
+		// Attach again to containers the runtime restarted
+		for _, containerID := range r.containers {
+			de.captureContainerLogs(r.deployment.ID, containerID)
 		}
+
+		if r.crashLoop {
+			de.startRemediation(r.deployment, fmt.Sprintf("containers restarted %d times within %s", r.restarts, crashLoopWindow(r.deployment.Config.Remediation)), true)
+		}
+
+		de.autoscale(r.deployment, r.replicas)
 	}
 }
 
 func (de *DeploymentEngine) startDeploymentMonitoring(deployment *Deployment) {
 	// Start continuous health checking if enabled
 	if deployment.HealthCheck.Enabled {
+		de.monitorMu.Lock()
+		if cancel, exists := de.monitorCancels[deployment.ID]; exists {
+			cancel()
+		}
+		ctx, cancel := context.WithCancel(de.ctx)
+		de.monitorCancels[deployment.ID] = cancel
+		de.monitorMu.Unlock()
+
 		de.wg.Add(1)
-		go de.continuousHealthCheck(deployment)
+		go de.continuousHealthCheck(ctx, deployment)
+	}
+}
+
+// stopDeploymentMonitoring stops the continuous health check of a deployment
+func (de *DeploymentEngine) stopDeploymentMonitoring(deploymentID string) {
+	de.monitorMu.Lock()
+	defer de.monitorMu.Unlock()
+
+	if cancel, exists := de.monitorCancels[deploymentID]; exists {
+		cancel()
+		delete(de.monitorCancels, deploymentID)
 	}
 }
 
-func (de *DeploymentEngine) continuousHealthCheck(deployment *Deployment) {
+func (de *DeploymentEngine) continuousHealthCheck(monitorCtx context.Context, deployment *Deployment) {
 	defer de.wg.Done()
 
-	ticker := time.NewTicker(time.Duration(deployment.HealthCheck.PeriodSeconds) * time.Second)
+	ticker := time.NewTicker(healthCheckPeriod(deployment.HealthCheck))
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-de.ctx.Done():
+		case <-monitorCtx.Done():
 			return
 		case <-ticker.C:
-			if deployment.Status != StatusRunning {
+			// The replicas are probed without the lock and the results
+			// applied under it
+			de.mu.RLock()
+			status := deployment.Status
+			var probed []*Replica
+			for _, replica := range deployment.Replicas {
+				if replica.Status == ReplicaRunning || replica.Status == ReplicaUnhealthy {
+					probed = append(probed, replica)
+				}
+			}
+			de.mu.RUnlock()
+
+			if status != StatusRunning {
 				return
 			}
 
-			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(deployment.HealthCheck.TimeoutSeconds)*time.Second)
-			err := de.performHealthCheck(ctx, deployment)
-			cancel()
+			latencies := make([]time.Duration, len(probed))
+			errs := make([]error, len(probed))
+			for i, replica := range probed {
+				ctx, cancel := context.WithTimeout(monitorCtx, healthCheckTimeout(deployment.HealthCheck))
+				latencies[i], errs[i] = de.probeReplica(ctx, deployment, replica)
+				cancel()
+			}
 
+			healthy := 0
+			failures := 0
+			var stats probeStats
 			now := time.Now()
+
+			de.mu.Lock()
+			if deployment.Status != StatusRunning {
+				de.mu.Unlock()
+				return
+			}
+
+			for i, replica := range probed {
+				// Replaced or stopped while it was probed
+				if replica.Status != ReplicaRunning && replica.Status != ReplicaUnhealthy {
+					continue
+				}
+
+				stats.record(latencies[i], errs[i])
+				replica.LastHealthCheck = &now
+
+				if errs[i] != nil {
+					replica.HealthCheckFailures++
+
+					// A replica that fails too many times is taken out of rotation
+					if replica.HealthCheckFailures >= deployment.HealthCheck.FailureThreshold {
+						replica.Status = ReplicaUnhealthy
+					}
+				} else {
+					replica.HealthCheckFailures = 0 // Reset failure count on success
+					replica.Status = ReplicaRunning
+				}
+
+				if replica.Status == ReplicaRunning {
+					healthy++
+				}
+				failures += replica.HealthCheckFailures
+			}
+
 			deployment.LastHealthCheck = &now
+			deployment.Metrics.HealthCheckCount = failures
+			deployment.Metrics.ErrorRate = stats.errorRate()
+			deployment.Metrics.AverageLatency = stats.averageLatency()
+			replicas := len(deployment.Replicas)
+			de.mu.Unlock()
+
+			for i, replica := range probed {
+				if errs[i] != nil {
+					logrus.Warnf("Health check failed for replica %d of deployment %s: %v", replica.Index, deployment.ID, errs[i])
+				}
+				de.publishHealth(deployment, replica, latencies[i], errs[i])
+			}
 
-			if err != nil {
-				logrus.Warnf("Health check failed for deployment %s: %v", deployment.ID, err)
-				deployment.Metrics.HealthCheckCount++
-				
-				// If health check fails too many times, mark as failed
-				if deployment.Metrics.HealthCheckCount >= deployment.HealthCheck.FailureThreshold {
-					de.handleDeploymentError(deployment, fmt.Errorf("health check failed %d times", deployment.Metrics.HealthCheckCount))
-					return
+			// If no replica is healthy any more, remediate the deployment or
+			// mark it as failed
+			if healthy == 0 {
+				reason := fmt.Sprintf("health check failed on all %d replicas", replicas)
+				if !de.startRemediation(deployment, reason, false) {
+					de.handleDeploymentError(deployment, errors.New(reason))
 				}
-			} else {
-				deployment.Metrics.HealthCheckCount = 0 // Reset failure count on success
+				return
 			}
 		}
 	}
@@ -825,6 +1480,21 @@ func (de *DeploymentEngine) continuousHealthCheck(deployment *Deployment) {
 
 // Helper functions for type conversion
 
+func convertHealthCheckConfig(healthCheck HealthCheckConfig) lifecycle.HealthCheckConfig {
+	return lifecycle.HealthCheckConfig{
+		Type:                healthCheck.Type,
+		Path:                healthCheck.Path,
+		Port:                healthCheck.Port,
+		Command:             healthCheck.Command,
+		InitialDelaySeconds: healthCheck.InitialDelaySeconds,
+		PeriodSeconds:       healthCheck.PeriodSeconds,
+		TimeoutSeconds:      healthCheck.TimeoutSeconds,
+		FailureThreshold:    healthCheck.FailureThreshold,
+		SuccessThreshold:    healthCheck.SuccessThreshold,
+		Headers:             healthCheck.Headers,
+	}
+}
+
 func convertPortMappings(ports []PortMapping) []docker.PortMapping {
 	dockerPorts := make([]docker.PortMapping, len(ports))
 	for i, port := range ports {
@@ -869,4 +1539,85 @@ func buildSecurityOpts(security SecurityConfig) []string {
 	}
 
 	return opts
-}
\ No newline at end of file
+}
+
+// progressTimeout returns how long a deployment operation may take
+func progressTimeout(deployment *Deployment) time.Duration {
+	timeout := defaultProgressTimeout
+	if deployment.Config.ProgressTimeout > 0 {
+		timeout = deployment.Config.ProgressTimeout
+	}
+
+	// Canary analysis runs for a fixed time on top of the rollout itself
+	if deployment.Config.Strategy == "canary" {
+		timeout += time.Duration(len(canarySteps(deployment.Config.Canary))) * canaryStepInterval(deployment.Config.Canary)
+	}
+
+	// Hooks have their own timeouts
+	timeout += hooksTimeout(deployment.Hooks)
+
+	return timeout
+}
+
+// healthCheckPeriod returns the interval between continuous health checks
+func healthCheckPeriod(healthCheck HealthCheckConfig) time.Duration {
+	if healthCheck.PeriodSeconds > 0 {
+		return time.Duration(healthCheck.PeriodSeconds) * time.Second
+	}
+	return 30 * time.Second
+}
+
+// healthCheckTimeout returns how long a single health check may take
+func healthCheckTimeout(healthCheck HealthCheckConfig) time.Duration {
+	timeout := 10 * time.Second
+	if healthCheck.TimeoutSeconds > 0 {
+		timeout = time.Duration(healthCheck.TimeoutSeconds) * time.Second
+	}
+	// The initial delay is applied on every check, so it has to fit too
+	return timeout + time.Duration(healthCheck.InitialDelaySeconds)*time.Second
+}
+
+// sharesHostPorts reports whether two sets of port mappings bind a common host port
+func sharesHostPorts(a, b []docker.PortMapping) bool {
+	for _, pa := range a {
+		if pa.HostPort == 0 {
+			continue
+		}
+		for _, pb := range b {
+			if pa.HostPort == pb.HostPort && protocolOrTCP(pa.Protocol) == protocolOrTCP(pb.Protocol) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func protocolOrTCP(protocol string) string {
+	if protocol == "" {
+		return "tcp"
+	}
+	return protocol
+}
+
+// recordDeploymentMetrics sends the current metrics of a deployment to the
+// monitoring system
+func (de *DeploymentEngine) recordDeploymentMetrics(deployment *Deployment) {
+	if de.monitor == nil {
+		return
+	}
+
+	de.monitor.RecordDeploymentMetrics(deployment.ID, monitoring.DeploymentMetrics{
+		CPUUsage:         deployment.Metrics.CPUUsage,
+		MemoryUsage:      deployment.Metrics.MemoryUsage,
+		MemoryLimit:      deployment.Metrics.MemoryLimit,
+		NetworkRx:        deployment.Metrics.NetworkRx,
+		NetworkTx:        deployment.Metrics.NetworkTx,
+		DiskUsage:        deployment.Metrics.DiskUsage,
+		RestartCount:     deployment.Metrics.RestartCount,
+		HealthCheckCount: deployment.Metrics.HealthCheckCount,
+		ErrorRate:        deployment.Metrics.ErrorRate,
+		AverageLatency:   deployment.Metrics.AverageLatency,
+		DriftedContainers: deployment.Metrics.DriftedContainers,
+		LastUpdated:      deployment.Metrics.LastUpdated,
+	})
+}
@@ -0,0 +1,330 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StartDeployment brings a stopped or failed deployment back. The stored
+// replica containers are started again; replicas whose container is gone are
+// recreated from the persisted deployment spec.
+func (de *DeploymentEngine) StartDeployment(deploymentID string) error {
+	// Wait for earlier operations on the app to finish
+	release, err := de.lockDeploymentApp(deploymentID)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return de.startDeployment(deploymentID)
+}
+
+// startDeployment starts a deployment while its app is locked
+func (de *DeploymentEngine) startDeployment(deploymentID string) error {
+	de.mu.Lock()
+	deployment, exists := de.deployments[deploymentID]
+	if !exists {
+		de.mu.Unlock()
+		return fmt.Errorf("deployment not found: %s", deploymentID)
+	}
+
+	switch deployment.Status {
+	case StatusStopped, StatusFailed:
+	case StatusRunning:
+		de.mu.Unlock()
+		return nil // Already running
+	default:
+		de.mu.Unlock()
+		return fmt.Errorf("deployment %s cannot be started while %s", deploymentID, deployment.Status)
+	}
+
+	if deployment.ImageID == "" {
+		de.mu.Unlock()
+		return fmt.Errorf("deployment %s has no image to start from", deploymentID)
+	}
+
+	// Claim the deployment so no other operation changes its replicas
+	previousStatus := deployment.Status
+	deployment.Status = StatusDeploying
+	existing := deploymentReplicas(deployment)
+	stored := make([]*Replica, len(existing))
+	copy(stored, existing)
+	de.mu.Unlock()
+
+	de.updateDeploymentStatus(deployment, StatusDeploying)
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Starting deployment (was %s)", previousStatus))
+
+	ctx, cancel := context.WithTimeout(de.ctx, progressTimeout(deployment))
+	defer cancel()
+
+	replicas, recreated, err := de.startStoredReplicas(ctx, deployment, stored)
+	if err != nil {
+		de.addDeploymentLog(deployment, "error", fmt.Sprintf("Failed to start deployment: %v", err))
+		de.updateDeploymentStatus(deployment, previousStatus)
+
+		de.auditLogger.LogEvent("DEPLOYMENT_START_FAILED", map[string]interface{}{
+			"deployment_id": deploymentID,
+			"error":         err.Error(),
+		})
+
+		return fmt.Errorf("failed to start deployment: %w", err)
+	}
+
+	de.setReplicas(deployment, replicas)
+
+	if deployment.HealthCheck.Enabled {
+		de.updateDeploymentStatus(deployment, StatusHealthCheck)
+		if err := de.checkReplicas(ctx, deployment, replicas, deployment.HealthCheck, deployment.Ports); err != nil {
+			de.handleDeploymentError(deployment, fmt.Errorf("health check failed after start: %w", err))
+			return fmt.Errorf("health check failed: %w", err)
+		}
+	} else {
+		for _, replica := range replicas {
+			replica.Status = ReplicaRunning
+		}
+	}
+
+	de.refreshRoute(deployment)
+
+	deployment.Metrics.HealthCheckCount = 0
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Deployment started with %d replicas (%d recreated)", len(replicas), recreated))
+	de.updateDeploymentStatus(deployment, StatusRunning)
+	de.startDeploymentMonitoring(deployment)
+
+	de.auditLogger.LogEvent("DEPLOYMENT_STARTED", map[string]interface{}{
+		"deployment_id":   deploymentID,
+		"previous_status": string(previousStatus),
+		"container_id":    deployment.ContainerID,
+		"replicas":        len(replicas),
+		"recreated":       recreated,
+	})
+
+	return nil
+}
+
+// RestartDeployment restarts the replicas of a running deployment one at a
+// time, waiting for each to pass its health check before moving on. Stopped
+// deployments, and failed ones without replicas, are started instead.
+func (de *DeploymentEngine) RestartDeployment(deploymentID string) error {
+	// Wait for earlier operations on the app to finish
+	release, err := de.lockDeploymentApp(deploymentID)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	de.mu.Lock()
+	deployment, exists := de.deployments[deploymentID]
+	if !exists {
+		de.mu.Unlock()
+		return fmt.Errorf("deployment not found: %s", deploymentID)
+	}
+
+	switch {
+	case deployment.Status == StatusRunning:
+	case deployment.Status == StatusFailed && len(deployment.Replicas) > 0:
+		// The replicas of a failed deployment may still be running, starting
+		// them again would leave them as they are
+	case deployment.Status == StatusStopped, deployment.Status == StatusFailed:
+		de.mu.Unlock()
+		return de.startDeployment(deploymentID)
+	default:
+		de.mu.Unlock()
+		return fmt.Errorf("deployment %s cannot be restarted while %s", deploymentID, deployment.Status)
+	}
+
+	// Claim the deployment so no other operation changes its replicas
+	deployment.Status = StatusUpdating
+	existing := deploymentReplicas(deployment)
+	current := make([]*Replica, len(existing))
+	copy(current, existing)
+	de.mu.Unlock()
+
+	de.stopDeploymentMonitoring(deploymentID)
+	de.updateDeploymentStatus(deployment, StatusUpdating)
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Restarting %d replicas", len(current)))
+
+	ctx, cancel := context.WithTimeout(de.ctx, progressTimeout(deployment))
+	defer cancel()
+
+	replicas := make([]*Replica, 0, len(current))
+	healthy := 0
+	recreated := 0
+
+	for _, replica := range current {
+		restarted, fresh, err := de.restartReplica(ctx, deployment, replica)
+		if fresh {
+			recreated++
+		}
+
+		if err != nil {
+			logrus.Warnf("Failed to restart replica %d of deployment %s: %v", replica.Index, deploymentID, err)
+			de.addDeploymentLog(deployment, "error", fmt.Sprintf("Failed to restart replica %d: %v", replica.Index, err))
+		} else {
+			healthy++
+		}
+
+		replicas = append(replicas, restarted)
+	}
+
+	de.setReplicas(deployment, replicas)
+
+	if healthy == 0 {
+		de.handleDeploymentError(deployment, fmt.Errorf("none of the %d replicas came back after restart", len(replicas)))
+
+		de.auditLogger.LogEvent("DEPLOYMENT_RESTART_FAILED", map[string]interface{}{
+			"deployment_id": deploymentID,
+			"replicas":      len(replicas),
+		})
+
+		return fmt.Errorf("failed to restart deployment %s", deploymentID)
+	}
+
+	de.refreshRoute(deployment)
+
+	deployment.Metrics.HealthCheckCount = 0
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Restarted %d/%d replicas (%d recreated)", healthy, len(replicas), recreated))
+	de.updateDeploymentStatus(deployment, StatusRunning)
+	de.startDeploymentMonitoring(deployment)
+
+	de.auditLogger.LogEvent("DEPLOYMENT_RESTARTED", map[string]interface{}{
+		"deployment_id": deploymentID,
+		"container_id":  deployment.ContainerID,
+		"replicas":      len(replicas),
+		"healthy":       healthy,
+		"recreated":     recreated,
+	})
+
+	return nil
+}
+
+// startStoredReplicas starts the recorded replica containers of a deployment,
+// recreating those that no longer start. A deployment without recorded
+// replicas gets a fresh set. It returns the replicas and how many of them
+// were recreated.
+func (de *DeploymentEngine) startStoredReplicas(ctx context.Context, deployment *Deployment, stored []*Replica) ([]*Replica, int, error) {
+	if len(stored) == 0 {
+		replicas, err := de.startReplicas(ctx, deployment, de.replicaBaseConfig(deployment), deployment.Ports, 0, desiredReplicas(deployment), nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		return replicas, len(replicas), nil
+	}
+
+	replicas := make([]*Replica, 0, len(stored))
+	recreated := 0
+
+	for _, replica := range stored {
+		err := de.dockerManager.StartContainer(ctx, replica.ContainerID)
+		if err == nil {
+			de.allocateContainer(deployment.AppID, replica.ContainerID, deployment.ResourceLimits)
+			replica.Status = ReplicaStarting
+			replica.HealthCheckFailures = 0
+			replicas = append(replicas, replica)
+			continue
+		}
+
+		// The stored container is gone or its ports are taken
+		logrus.Infof("Recreating replica %d of deployment %s: %v", replica.Index, deployment.ID, err)
+
+		fresh, err := de.recreateReplica(ctx, deployment, replica)
+		if err != nil {
+			for _, started := range replicas {
+				if stopErr := de.stopReplica(started); stopErr != nil {
+					logrus.Warnf("Failed to stop container %s: %v", started.ContainerID, stopErr)
+				}
+			}
+			return nil, 0, err
+		}
+
+		replicas = append(replicas, fresh)
+		recreated++
+	}
+
+	return replicas, recreated, nil
+}
+
+// restartReplica restarts the container of a replica and waits for it to be
+// ready. A replica whose container is gone is recreated. It returns the
+// replica now in its place and whether it was recreated.
+func (de *DeploymentEngine) restartReplica(ctx context.Context, deployment *Deployment, replica *Replica) (*Replica, bool, error) {
+	err := de.restartContainer(ctx, deployment, replica)
+	if err == nil {
+		now := time.Now()
+		replica.LastHealthCheck = &now
+		replica.Status = ReplicaRunning
+		replica.HealthCheckFailures = 0
+		return replica, false, nil
+	}
+
+	if _, infoErr := de.dockerManager.GetContainerInfo(ctx, replica.ContainerID); infoErr == nil {
+		// The container exists but does not come up healthy
+		replica.Status = ReplicaUnhealthy
+		return replica, false, err
+	}
+
+	fresh, err := de.recreateReplica(ctx, deployment, replica)
+	if err != nil {
+		replica.Status = ReplicaFailed
+		return replica, false, err
+	}
+
+	if deployment.HealthCheck.Enabled {
+		if err := de.checkReplicas(ctx, deployment, []*Replica{fresh}, deployment.HealthCheck, deployment.Ports); err != nil {
+			return fresh, true, err
+		}
+	} else {
+		fresh.Status = ReplicaRunning
+	}
+
+	return fresh, true, nil
+}
+
+// restartContainer restarts the container of a replica, through the
+// lifecycle manager's readiness check when the deployment has one
+func (de *DeploymentEngine) restartContainer(ctx context.Context, deployment *Deployment, replica *Replica) error {
+	if !deployment.HealthCheck.Enabled {
+		if err := de.dockerManager.StopContainer(ctx, replica.ContainerID, 10); err != nil {
+			return err
+		}
+		return de.dockerManager.StartContainer(ctx, replica.ContainerID)
+	}
+
+	healthCheck := replicaHealthCheck(deployment.HealthCheck, deployment.Ports, replica.Ports)
+	err := de.lifecycleManager.RestartContainer(ctx, replica.ContainerID, convertHealthCheckConfig(healthCheck))
+	de.publishHealth(deployment, replica, 0, err)
+
+	return err
+}
+
+// recreateReplica replaces the container of a replica with a new one built
+// from the persisted deployment spec
+func (de *DeploymentEngine) recreateReplica(ctx context.Context, deployment *Deployment, replica *Replica) (*Replica, error) {
+	if err := de.dockerManager.RemoveContainer(ctx, replica.ContainerID, true); err != nil {
+		logrus.Debugf("Failed to remove container %s before recreating it: %v", replica.ContainerID, err)
+	}
+	de.releaseContainer(replica.ContainerID)
+
+	released := map[string]bool{replica.ContainerID: true}
+	fresh, err := de.startReplicas(ctx, deployment, de.replicaBaseConfig(deployment), deployment.Ports, replica.Index, 1, released)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recreate replica %d: %w", replica.Index, err)
+	}
+
+	return fresh[0], nil
+}
+
+// refreshRoute points the route of a live blue-green or canary deployment
+// at its current replicas
+func (de *DeploymentEngine) refreshRoute(deployment *Deployment) {
+	if deployment.Color == "" || deployment.LiveColor != deployment.Color {
+		return
+	}
+
+	if err := de.switchTraffic(deployment); err != nil {
+		logrus.Warnf("Failed to update route of deployment %s: %v", deployment.ID, err)
+	}
+}
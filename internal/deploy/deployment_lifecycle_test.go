@@ -0,0 +1,79 @@
+package deploy
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// lifecycleEngine is an engine knowing the given deployments
+func lifecycleEngine(deployments ...*Deployment) *DeploymentEngine {
+	de := &DeploymentEngine{
+		ctx:         context.Background(),
+		deployments: make(map[string]*Deployment),
+	}
+	de.scheduler = newOperationScheduler(1, &de.mu)
+	for _, deployment := range deployments {
+		de.deployments[deployment.ID] = deployment
+	}
+	return de
+}
+
+func TestStartDeploymentRejected(t *testing.T) {
+	de := lifecycleEngine(
+		&Deployment{ID: "building", AppID: "web", Status: StatusBuilding},
+		&Deployment{ID: "no-image", AppID: "web", Status: StatusStopped},
+	)
+
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"missing", "deployment not found: missing"},
+		{"building", "deployment building cannot be started while building"},
+		{"no-image", "deployment no-image has no image to start from"},
+	}
+	for _, tt := range tests {
+		if err := de.StartDeployment(tt.id); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("StartDeployment(%s) = %v, want %q", tt.id, err, tt.want)
+		}
+	}
+
+	if status := de.deployments["no-image"].Status; status != StatusStopped {
+		t.Errorf("rejected deployment left %s, want stopped", status)
+	}
+}
+
+func TestStartRunningDeployment(t *testing.T) {
+	running := &Deployment{ID: "d1", AppID: "web", Status: StatusRunning, ImageID: "sha256:aaa"}
+	de := lifecycleEngine(running)
+
+	if err := de.StartDeployment("d1"); err != nil {
+		t.Fatalf("StartDeployment of a running deployment: %v", err)
+	}
+	if running.Status != StatusRunning || len(running.DeploymentLogs) != 0 {
+		t.Errorf("running deployment touched: %s with %d logs", running.Status, len(running.DeploymentLogs))
+	}
+}
+
+func TestRestartDeploymentRejected(t *testing.T) {
+	de := lifecycleEngine(
+		&Deployment{ID: "deploying", AppID: "web", Status: StatusDeploying},
+		&Deployment{ID: "stopped", AppID: "api", Status: StatusStopped},
+	)
+
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"missing", "deployment not found: missing"},
+		{"deploying", "deployment deploying cannot be restarted while deploying"},
+		// A stopped deployment is started instead, which needs an image
+		{"stopped", "deployment stopped has no image to start from"},
+	}
+	for _, tt := range tests {
+		if err := de.RestartDeployment(tt.id); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("RestartDeployment(%s) = %v, want %q", tt.id, err, tt.want)
+		}
+	}
+}
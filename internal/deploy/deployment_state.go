@@ -0,0 +1,279 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// saveDeployment persists the complete deployment record
+func (de *DeploymentEngine) saveDeployment(deployment *Deployment) {
+	state, err := encodeState(deployment)
+	if err != nil {
+		logrus.Warnf("Failed to encode deployment state for %s: %v", deployment.ID, err)
+		return
+	}
+
+	if err := de.store.StoreDeploymentState(deployment.ID, state); err != nil {
+		logrus.Warnf("Failed to store deployment state: %v", err)
+	}
+}
+
+// saveRevisions persists the revision history of an app
+func (de *DeploymentEngine) saveRevisions(appID string, history []*Revision) {
+	entries := make([]map[string]interface{}, 0, len(history))
+	for _, revision := range history {
+		entry, err := encodeState(revision)
+		if err != nil {
+			logrus.Warnf("Failed to encode revision %s of app %s: %v", revision.Version, appID, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := de.store.StoreRevisionHistory(appID, entries); err != nil {
+		logrus.Warnf("Failed to store revision history for app %s: %v", appID, err)
+	}
+}
+
+// loadRevisions restores the revision history of every app from storage
+func (de *DeploymentEngine) loadRevisions() error {
+	stored, err := de.store.LoadRevisionHistory()
+	if err != nil {
+		return fmt.Errorf("failed to load revision history: %w", err)
+	}
+
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	for appID, entries := range stored {
+		history := make([]*Revision, 0, len(entries))
+		for _, entry := range entries {
+			var revision Revision
+			if err := decodeState(entry, &revision); err != nil {
+				logrus.Warnf("Failed to restore revision of app %s: %v", appID, err)
+				continue
+			}
+
+			// Revisions recorded before every deployment was tracked were
+			// all successful ones and had no number
+			if revision.Outcome == "" {
+				revision.Outcome = RevisionSucceeded
+			}
+			if revision.Number == 0 {
+				revision.Number = len(history) + 1
+			}
+			history = append(history, &revision)
+		}
+		de.revisions[appID] = history
+	}
+
+	return nil
+}
+
+// reconcileDeployments compares the restored deployments with the containers
+// that actually exist on the host. Running deployments get their health
+// checks back, deployments whose container is gone are marked failed, and
+// deployments interrupted mid-flight are resumed or aborted.
+func (de *DeploymentEngine) reconcileDeployments() {
+	de.mu.RLock()
+	deployments := make([]*Deployment, 0, len(de.deployments))
+	for _, deployment := range de.deployments {
+		deployments = append(deployments, deployment)
+	}
+	de.mu.RUnlock()
+
+	// Interrupted deployments are queued again in the order they were made
+	sort.Slice(deployments, func(i, j int) bool {
+		return deployments[i].CreatedAt.Before(deployments[j].CreatedAt)
+	})
+
+	for _, deployment := range deployments {
+		de.reconcileDeployment(deployment)
+	}
+}
+
+func (de *DeploymentEngine) reconcileDeployment(deployment *Deployment) {
+	ctx, cancel := context.WithTimeout(de.ctx, 30*time.Second)
+	defer cancel()
+
+	switch deployment.Status {
+	case StatusPending, StatusBuilding, StatusDeploying, StatusHealthCheck:
+		// Records written before the full deployment was persisted cannot
+		// be replayed
+		if deployment.Source.Type == "" {
+			de.abortDeployment(deployment, "deployment was interrupted by an agent restart and cannot be resumed")
+			return
+		}
+
+		// Discard whatever the interrupted attempt left behind so the
+		// container names are free again
+		for _, replica := range deploymentReplicas(deployment) {
+			if err := de.dockerManager.RemoveContainer(ctx, replica.ContainerID, true); err != nil {
+				logrus.Debugf("Failed to remove container %s of interrupted deployment: %v", replica.ContainerID, err)
+			}
+			de.releaseContainer(replica.ContainerID)
+		}
+		de.setReplicas(deployment, nil)
+		de.removeLeftoverContainers(ctx, deployment)
+
+		de.addDeploymentLog(deployment, "info", fmt.Sprintf("Resuming deployment interrupted while %s", deployment.Status))
+		de.updateDeploymentStatus(deployment, StatusPending)
+
+		de.auditLogger.LogEvent("DEPLOYMENT_RESUMED", map[string]interface{}{
+			"deployment_id": deployment.ID,
+			"app_id":        deployment.AppID,
+			"version":       deployment.Version,
+		})
+
+		de.mu.Lock()
+		op := de.queueDeployment(deployment)
+		de.mu.Unlock()
+		de.wg.Add(1)
+		go de.deployAsync(deployment, op)
+
+	case StatusRunning, StatusUpdating, StatusRollingBack:
+		replicas := deploymentReplicas(deployment)
+		if len(replicas) == 0 {
+			de.handleDeploymentError(deployment, fmt.Errorf("no container recorded for deployment after agent restart"))
+			return
+		}
+
+		running := 0
+		for _, replica := range replicas {
+			info, err := de.dockerManager.GetContainerInfo(ctx, replica.ContainerID)
+			if err != nil {
+				replica.Status = ReplicaFailed
+				de.addDeploymentLog(deployment, "error", fmt.Sprintf("Container %s of replica %d no longer exists", replica.ContainerID, replica.Index))
+				continue
+			}
+
+			if info.State != "running" {
+				replica.Status = ReplicaFailed
+				de.addDeploymentLog(deployment, "error", fmt.Sprintf("Container %s of replica %d is %s (exit code %d)", replica.ContainerID, replica.Index, info.Status, info.ExitCode))
+				continue
+			}
+
+			if replica.Status != ReplicaUnhealthy {
+				replica.Status = ReplicaRunning
+			}
+			running++
+		}
+
+		if running == 0 {
+			de.handleDeploymentError(deployment, fmt.Errorf("none of the %d replica containers is running after agent restart", len(replicas)))
+			return
+		}
+
+		if deployment.Status != StatusRunning {
+			de.addDeploymentLog(deployment, "warn", fmt.Sprintf("Operation interrupted while %s, keeping %d running replicas", deployment.Status, running))
+		}
+		de.updateDeploymentStatus(deployment, StatusRunning)
+
+		de.startDeploymentMonitoring(deployment)
+
+		de.auditLogger.LogEvent("DEPLOYMENT_REATTACHED", map[string]interface{}{
+			"deployment_id": deployment.ID,
+			"container_id":  deployment.ContainerID,
+			"replicas":      running,
+		})
+
+	case StatusStandby:
+		de.reconcileStandby(ctx, deployment)
+
+	case StatusCanary:
+		de.reconcileCanary(ctx, deployment)
+
+	case StatusStopping:
+		for _, replica := range deploymentReplicas(deployment) {
+			if err := de.dockerManager.StopContainer(ctx, replica.ContainerID, 10); err != nil {
+				logrus.Warnf("Failed to stop container %s: %v", replica.ContainerID, err)
+			}
+			de.releaseContainer(replica.ContainerID)
+			replica.Status = ReplicaStopped
+		}
+		de.updateDeploymentStatus(deployment, StatusStopped)
+	}
+}
+
+// removeLeftoverContainers removes containers an interrupted attempt may have
+// created under the replica names of a deployment before recording them
+func (de *DeploymentEngine) removeLeftoverContainers(ctx context.Context, deployment *Deployment) {
+	base := fmt.Sprintf("superagent-%s", deployment.ID)
+	for index := 0; index < desiredReplicas(deployment); index++ {
+		name := base
+		if index > 0 {
+			name = fmt.Sprintf("%s-%d", base, index)
+		}
+		if err := de.dockerManager.RemoveContainer(ctx, name, true); err != nil {
+			logrus.Debugf("No leftover container %s for interrupted deployment: %v", name, err)
+		}
+	}
+
+	for phase, hooks := range map[string][]HookConfig{HookPreDeploy: deployment.Hooks.PreDeploy, HookPostDeploy: deployment.Hooks.PostDeploy} {
+		for index := range hooks {
+			name := hookContainerName(deployment, phase, index)
+			if err := de.dockerManager.RemoveContainer(ctx, name, true); err != nil {
+				logrus.Debugf("No leftover hook container %s for interrupted deployment: %v", name, err)
+			}
+		}
+	}
+}
+
+// abortDeployment gives up on a deployment that can not be completed
+func (de *DeploymentEngine) abortDeployment(deployment *Deployment, reason string) {
+	de.addDeploymentLog(deployment, "error", reason)
+	de.updateDeploymentStatus(deployment, StatusAborted)
+	de.concludeRevision(deployment, errors.New(reason))
+
+	de.auditLogger.LogEvent("DEPLOYMENT_ABORTED", map[string]interface{}{
+		"deployment_id": deployment.ID,
+		"reason":        reason,
+	})
+}
+
+// encodeState converts a value into the generic form kept by the secure store
+func encodeState(value interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var state map[string]interface{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// decodeState converts a value read from the secure store back into its type
+func decodeState(state map[string]interface{}, value interface{}) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
+
+func decodeDeploymentState(state map[string]interface{}) (*Deployment, error) {
+	var deployment Deployment
+	if err := decodeState(state, &deployment); err != nil {
+		return nil, err
+	}
+
+	if deployment.BuildLogs == nil {
+		deployment.BuildLogs = []LogEntry{}
+	}
+	if deployment.DeploymentLogs == nil {
+		deployment.DeploymentLogs = []LogEntry{}
+	}
+
+	return &deployment, nil
+}
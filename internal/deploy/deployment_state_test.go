@@ -0,0 +1,84 @@
+package deploy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeploymentStateRoundTrip(t *testing.T) {
+	deployedAt := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	deployment := &Deployment{
+		ID:          "d1",
+		AppID:       "web",
+		Version:     "1.2",
+		Status:      StatusRunning,
+		Source:      DeploymentSource{Type: "docker", Repository: "nginx", Tag: "1.25"},
+		Config:      DeploymentConfig{Replicas: 3, Strategy: "rolling", ProgressTimeout: 5 * time.Minute},
+		HealthCheck: HealthCheckConfig{Path: "/health", PeriodSeconds: 15},
+		Environment: map[string]string{"LOG_LEVEL": "debug"},
+		CreatedAt:   deployedAt.Add(-time.Minute),
+		DeployedAt:  &deployedAt,
+		ImageID:     "sha256:aaa",
+		ContainerID: "c1",
+		Ports:       []PortMapping{{ContainerPort: 80, HostPort: 8080, Protocol: "tcp"}},
+		Rollback:    &RollbackInfo{PreviousVersion: "1.3", RestoredVersion: "1.2", Reason: "health check failed"},
+	}
+
+	state, err := encodeState(deployment)
+	if err != nil {
+		t.Fatalf("encodeState: %v", err)
+	}
+
+	restored, err := decodeDeploymentState(state)
+	if err != nil {
+		t.Fatalf("decodeDeploymentState: %v", err)
+	}
+
+	if restored.ID != "d1" || restored.AppID != "web" || restored.Version != "1.2" || restored.Status != StatusRunning {
+		t.Errorf("restored %s of %s version %s %s, want d1 of web version 1.2 running", restored.ID, restored.AppID, restored.Version, restored.Status)
+	}
+	if restored.Source.Repository != "nginx" || restored.Source.Tag != "1.25" {
+		t.Errorf("source %+v, want nginx:1.25", restored.Source)
+	}
+	if restored.Config.Replicas != 3 || restored.Config.ProgressTimeout != 5*time.Minute {
+		t.Errorf("config %d replicas, timeout %s, want 3 and 5m", restored.Config.Replicas, restored.Config.ProgressTimeout)
+	}
+	if restored.HealthCheck.Path != "/health" || restored.HealthCheck.PeriodSeconds != 15 {
+		t.Errorf("health check %+v, want /health every 15s", restored.HealthCheck)
+	}
+	if restored.Environment["LOG_LEVEL"] != "debug" {
+		t.Errorf("environment %v, want LOG_LEVEL=debug", restored.Environment)
+	}
+	if restored.DeployedAt == nil || !restored.DeployedAt.Equal(deployedAt) {
+		t.Errorf("deployed at %v, want %v", restored.DeployedAt, deployedAt)
+	}
+	if restored.ImageID != "sha256:aaa" || restored.ContainerID != "c1" {
+		t.Errorf("image %s container %s, want sha256:aaa and c1", restored.ImageID, restored.ContainerID)
+	}
+	if len(restored.Ports) != 1 || restored.Ports[0] != deployment.Ports[0] {
+		t.Errorf("ports %+v, want %+v", restored.Ports, deployment.Ports)
+	}
+	if restored.Rollback == nil || restored.Rollback.PreviousVersion != "1.3" || restored.Rollback.Reason != "health check failed" {
+		t.Errorf("rollback %+v, want the recorded rollback", restored.Rollback)
+	}
+}
+
+func TestDecodeDeploymentStateFillsLogs(t *testing.T) {
+	// Records written before logs were persisted have none
+	restored, err := decodeDeploymentState(map[string]interface{}{"id": "d1", "status": "failed"})
+	if err != nil {
+		t.Fatalf("decodeDeploymentState: %v", err)
+	}
+	if restored.BuildLogs == nil || restored.DeploymentLogs == nil {
+		t.Fatalf("logs left nil, want empty logs to append to")
+	}
+	if restored.Status != StatusFailed {
+		t.Errorf("status %s, want failed", restored.Status)
+	}
+}
+
+func TestDecodeDeploymentStateInvalid(t *testing.T) {
+	if _, err := decodeDeploymentState(map[string]interface{}{"config": "three replicas"}); err == nil {
+		t.Fatalf("decodeDeploymentState of a malformed record succeeded")
+	}
+}
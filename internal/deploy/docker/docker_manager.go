@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -56,6 +58,7 @@ type ContainerConfig struct {
 	Ports           []PortMapping     `json:"ports"`
 	Volumes         []VolumeMapping   `json:"volumes"`
 	Networks        []string          `json:"networks"`
+	NetworkAliases  []string          `json:"network_aliases,omitempty"`
 	Labels          map[string]string `json:"labels"`
 	WorkingDir      string            `json:"working_dir"`
 	User            string            `json:"user"`
@@ -181,8 +184,10 @@ type ContainerInfo struct {
 	State       string                 `json:"state"`
 	Ports       []PortMapping          `json:"ports"`
 	Labels      map[string]string      `json:"labels"`
+	Environment map[string]string      `json:"environment"`
 	Mounts      []VolumeMapping        `json:"mounts"`
 	Networks    map[string]interface{} `json:"networks"`
+	ResourceLimits ResourceLimits      `json:"resource_limits"`
 	CreatedAt   time.Time              `json:"created_at"`
 	StartedAt   time.Time              `json:"started_at"`
 	FinishedAt  time.Time              `json:"finished_at"`
@@ -214,6 +219,14 @@ type ImageInfo struct {
 	Layers      []string          `json:"layers"`
 }
 
+// LogOptions selects the output GetContainerLogs returns
+type LogOptions struct {
+	Follow     bool
+	Tail       int       // lines from the end, 0 for all
+	Since      time.Time // only output written after this time
+	Timestamps bool      // prefix every line with the time it was written
+}
+
 // LogCallback is a callback function for build/pull logs
 type LogCallback func(string)
 
@@ -243,9 +256,23 @@ func (dm *DockerManager) BuildImage(ctx context.Context, buildContext BuildConte
 	// Prepare build command
 	cmd := exec.CommandContext(ctx, "docker", "build")
 
+	// The build runs in BuildPath inside the context, which is also where a
+	// relative Dockerfile is looked up
+	contextPath := buildContext.ContextPath
+	if contextPath == "" {
+		contextPath = "."
+	}
+	if buildContext.BuildPath != "" {
+		contextPath = filepath.Join(contextPath, buildContext.BuildPath)
+	}
+
 	// Add build arguments
 	if buildContext.Dockerfile != "" {
-		cmd.Args = append(cmd.Args, "-f", buildContext.Dockerfile)
+		dockerfile := buildContext.Dockerfile
+		if !filepath.IsAbs(dockerfile) {
+			dockerfile = filepath.Join(contextPath, dockerfile)
+		}
+		cmd.Args = append(cmd.Args, "-f", dockerfile)
 	}
 
 	// Add image tag
@@ -321,10 +348,6 @@ func (dm *DockerManager) BuildImage(ctx context.Context, buildContext BuildConte
 	}
 
 	// Add context path
-	contextPath := buildContext.ContextPath
-	if contextPath == "" {
-		contextPath = "."
-	}
 	cmd.Args = append(cmd.Args, contextPath)
 
 	// Set up stdout/stderr capture
@@ -471,9 +494,19 @@ func (dm *DockerManager) CreateContainer(ctx context.Context, config ContainerCo
 		cmd.Args = append(cmd.Args, "-v", volumeMapping)
 	}
 
-	// Add networks
-	for _, network := range config.Networks {
-		cmd.Args = append(cmd.Args, "--network", network)
+	// Add networks. Aliases can only be given for the network the container
+	// is created on, it joins the others once it exists.
+	var connectNetworks []string
+	if len(config.NetworkAliases) > 0 && len(config.Networks) > 0 {
+		cmd.Args = append(cmd.Args, "--network", config.Networks[0])
+		for _, alias := range config.NetworkAliases {
+			cmd.Args = append(cmd.Args, "--network-alias", alias)
+		}
+		connectNetworks = config.Networks[1:]
+	} else {
+		for _, network := range config.Networks {
+			cmd.Args = append(cmd.Args, "--network", network)
+		}
 	}
 
 	// Add labels
@@ -609,6 +642,26 @@ func (dm *DockerManager) CreateContainer(ctx context.Context, config ContainerCo
 
 	containerID := strings.TrimSpace(string(output))
 
+	for _, network := range connectNetworks {
+		connect := exec.CommandContext(ctx, "docker", "network", "connect")
+		for _, alias := range config.NetworkAliases {
+			connect.Args = append(connect.Args, "--alias", alias)
+		}
+		connect.Args = append(connect.Args, network, containerID)
+
+		if output, err := connect.CombinedOutput(); err != nil {
+			exec.CommandContext(ctx, "docker", "rm", "-f", containerID).Run()
+			dm.auditLogger.LogEvent("DOCKER_CREATE_FAILED", map[string]interface{}{
+				"container_name": config.Name,
+				"image":          config.Image,
+				"network":        network,
+				"error":          err.Error(),
+				"output":         string(output),
+			})
+			return "", fmt.Errorf("failed to connect container to network %s: %w, output: %s", network, err, output)
+		}
+	}
+
 	dm.auditLogger.LogEvent("DOCKER_CREATE_SUCCESS", map[string]interface{}{
 		"container_name": config.Name,
 		"container_id":   containerID,
@@ -703,6 +756,62 @@ func (dm *DockerManager) RemoveContainer(ctx context.Context, containerID string
 	return nil
 }
 
+// WaitContainer blocks until a container exits and returns its exit code.
+// It does not hold the manager lock since a container may run for a long time.
+func (dm *DockerManager) WaitContainer(ctx context.Context, containerID string) (int, error) {
+	cmd := exec.CommandContext(ctx, "docker", "wait", containerID)
+	output, err := cmd.Output()
+	if err != nil {
+		return -1, fmt.Errorf("failed to wait for container: %w", err)
+	}
+
+	exitCode, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return -1, fmt.Errorf("failed to parse exit code %q: %w", strings.TrimSpace(string(output)), err)
+	}
+
+	return exitCode, nil
+}
+
+// FollowContainerLogs passes every line a container writes to stdout or
+// stderr to the callback until the container exits
+func (dm *DockerManager) FollowContainerLogs(ctx context.Context, containerID string, logCallback LogCallback) error {
+	cmd := exec.CommandContext(ctx, "docker", "logs", "-f", containerID)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start logs command: %w", err)
+	}
+
+	// Both pipes have to be drained before waiting for the command
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		dm.readLogs(stdout, logCallback)
+	}()
+	go func() {
+		defer readers.Done()
+		dm.readLogs(stderr, logCallback)
+	}()
+	readers.Wait()
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to follow container logs: %w", err)
+	}
+
+	return nil
+}
+
 // GetContainerStats gets container statistics
 func (dm *DockerManager) GetContainerStats(ctx context.Context, containerID string) (*ContainerStats, error) {
 	dm.mu.RLock()
@@ -757,6 +866,21 @@ func (dm *DockerManager) GetContainerStats(ctx context.Context, containerID stri
 		}
 	}
 
+	// docker stats does not report restarts, the container state does
+	cmd = exec.CommandContext(ctx, "docker", "inspect", containerID, "--format", "{{.RestartCount}}|{{.State.Status}}|{{.State.ExitCode}}")
+	if output, err := cmd.Output(); err == nil {
+		parts := strings.Split(strings.TrimSpace(string(output)), "|")
+		if len(parts) == 3 {
+			if restarts, err := strconv.Atoi(parts[0]); err == nil {
+				stats.RestartCount = restarts
+			}
+			stats.State = parts[1]
+			if exitCode, err := strconv.Atoi(parts[2]); err == nil {
+				stats.ExitCode = exitCode
+			}
+		}
+	}
+
 	return stats, nil
 }
 
@@ -807,6 +931,105 @@ func (dm *DockerManager) GetContainerInfo(ctx context.Context, containerID strin
 				}
 			}
 		}
+		if env, ok := config["Env"].([]interface{}); ok {
+			info.Environment = make(map[string]string)
+			for _, entry := range env {
+				if str, ok := entry.(string); ok {
+					key, value, _ := strings.Cut(str, "=")
+					info.Environment[key] = value
+				}
+			}
+		}
+	}
+
+	if imageID, ok := containerData["Image"].(string); ok {
+		info.ImageID = imageID
+	}
+
+	// Configured port bindings, these also exist while the container is stopped
+	if hostConfig, ok := containerData["HostConfig"].(map[string]interface{}); ok {
+		if bindings, ok := hostConfig["PortBindings"].(map[string]interface{}); ok {
+			for containerPort, hostBindings := range bindings {
+				port, protocol, _ := strings.Cut(containerPort, "/")
+				portNum, err := strconv.Atoi(port)
+				if err != nil {
+					continue
+				}
+
+				entries, _ := hostBindings.([]interface{})
+				for _, entry := range entries {
+					binding, ok := entry.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					mapping := PortMapping{ContainerPort: portNum, Protocol: protocol}
+					if hostIP, ok := binding["HostIp"].(string); ok {
+						mapping.HostIP = hostIP
+					}
+					if hostPort, ok := binding["HostPort"].(string); ok {
+						mapping.HostPort, _ = strconv.Atoi(hostPort)
+					}
+					info.Ports = append(info.Ports, mapping)
+				}
+			}
+			sort.Slice(info.Ports, func(i, j int) bool {
+				return info.Ports[i].ContainerPort < info.Ports[j].ContainerPort
+			})
+		}
+
+		if nanoCPUs, ok := hostConfig["NanoCpus"].(float64); ok {
+			info.ResourceLimits.CPULimit = nanoCPUs / 1e9
+		}
+		if memory, ok := hostConfig["Memory"].(float64); ok {
+			info.ResourceLimits.MemoryLimit = int64(memory)
+		}
+		if memorySwap, ok := hostConfig["MemorySwap"].(float64); ok {
+			info.ResourceLimits.SwapLimit = int64(memorySwap)
+		}
+		if cpuShares, ok := hostConfig["CpuShares"].(float64); ok {
+			info.ResourceLimits.CPUShares = int(cpuShares)
+		}
+		if cpusetCPUs, ok := hostConfig["CpusetCpus"].(string); ok {
+			info.ResourceLimits.CPUSetCPUs = cpusetCPUs
+		}
+		if cpusetMems, ok := hostConfig["CpusetMems"].(string); ok {
+			info.ResourceLimits.CPUSetMems = cpusetMems
+		}
+		// Docker reports an unlimited process count as 0, -1 or null
+		if pidsLimit, ok := hostConfig["PidsLimit"].(float64); ok && pidsLimit > 0 {
+			info.ResourceLimits.ProcessLimit = int(pidsLimit)
+		}
+	}
+
+	if mounts, ok := containerData["Mounts"].([]interface{}); ok {
+		for _, entry := range mounts {
+			mount, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			volume := VolumeMapping{}
+			volume.Type, _ = mount["Type"].(string)
+			volume.Target, _ = mount["Destination"].(string)
+			volume.Source, _ = mount["Source"].(string)
+			// Named volumes are referred to by name rather than their path
+			if name, ok := mount["Name"].(string); ok && name != "" {
+				volume.Source = name
+			}
+			if rw, ok := mount["RW"].(bool); ok {
+				volume.ReadOnly = !rw
+			}
+			info.Mounts = append(info.Mounts, volume)
+		}
+	}
+
+	if settings, ok := containerData["NetworkSettings"].(map[string]interface{}); ok {
+		if networks, ok := settings["Networks"].(map[string]interface{}); ok {
+			info.Networks = networks
+		}
+	}
+
+	if restartCount, ok := containerData["RestartCount"].(float64); ok {
+		info.RestartCount = int(restartCount)
 	}
 
 	if state, ok := containerData["State"].(map[string]interface{}); ok {
@@ -953,6 +1176,22 @@ func (dm *DockerManager) getImageID(ctx context.Context, imageName string) (stri
 	return imageID, nil
 }
 
+// RootDir returns the directory Docker keeps images and containers in
+func (dm *DockerManager) RootDir(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, "docker", "info", "--format", "{{.DockerRootDir}}")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get docker root dir: %w", err)
+	}
+
+	rootDir := strings.TrimSpace(string(output))
+	if rootDir == "" {
+		return "", fmt.Errorf("docker did not report its root dir")
+	}
+
+	return rootDir, nil
+}
+
 // parseMemorySize parses memory size string (e.g., "1.5GB", "512MB")
 func parseMemorySize(sizeStr string) (int64, error) {
 	sizeStr = strings.TrimSpace(sizeStr)
@@ -1042,6 +1281,9 @@ func (dm *DockerManager) GetImageInfo(ctx context.Context, imageID string) (*Ima
 	info := &ImageInfo{
 		ID: imageID,
 	}
+	if id, ok := imageData["Id"].(string); ok && id != "" {
+		info.ID = id
+	}
 
 	// Parse basic info
 	if repoTags, ok := imageData["RepoTags"].([]interface{}); ok && len(repoTags) > 0 {
@@ -1057,6 +1299,14 @@ func (dm *DockerManager) GetImageInfo(ctx context.Context, imageID string) (*Ima
 		}
 	}
 
+	if repoDigests, ok := imageData["RepoDigests"].([]interface{}); ok && len(repoDigests) > 0 {
+		if repoDigest, ok := repoDigests[0].(string); ok {
+			if at := strings.LastIndex(repoDigest, "@"); at >= 0 {
+				info.Digest = repoDigest[at+1:]
+			}
+		}
+	}
+
 	if created, ok := imageData["Created"].(string); ok {
 		if t, err := time.Parse(time.RFC3339Nano, created); err == nil {
 			info.CreatedAt = t
@@ -1090,8 +1340,9 @@ func (dm *DockerManager) GetImageInfo(ctx context.Context, imageID string) (*Ima
 	return info, nil
 }
 
-// PruneImages removes unused images
-func (dm *DockerManager) PruneImages(ctx context.Context, dangling bool) error {
+// PruneImages removes unused images. Filters are passed on as docker prune
+// filters such as "label=key" or "until=24h".
+func (dm *DockerManager) PruneImages(ctx context.Context, dangling bool, filters ...string) error {
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
 
@@ -1101,6 +1352,9 @@ func (dm *DockerManager) PruneImages(ctx context.Context, dangling bool) error {
 	if !dangling {
 		cmd.Args = append(cmd.Args, "-a")
 	}
+	for _, filter := range filters {
+		cmd.Args = append(cmd.Args, "--filter", filter)
+	}
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -1118,14 +1372,18 @@ func (dm *DockerManager) PruneImages(ctx context.Context, dangling bool) error {
 	return nil
 }
 
-// PruneContainers removes stopped containers
-func (dm *DockerManager) PruneContainers(ctx context.Context) error {
+// PruneContainers removes stopped containers, optionally only those matching
+// the given docker prune filters
+func (dm *DockerManager) PruneContainers(ctx context.Context, filters ...string) error {
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
 
 	logrus.Info("Pruning stopped containers")
 
 	cmd := exec.CommandContext(ctx, "docker", "container", "prune", "-f")
+	for _, filter := range filters {
+		cmd.Args = append(cmd.Args, "--filter", filter)
+	}
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		dm.auditLogger.LogEvent("DOCKER_CONTAINER_PRUNE_FAILED", map[string]interface{}{
@@ -1142,6 +1400,69 @@ func (dm *DockerManager) PruneContainers(ctx context.Context) error {
 	return nil
 }
 
+// EnsureNetwork creates a bridge network unless it already exists
+func (dm *DockerManager) EnsureNetwork(ctx context.Context, name string, labels map[string]string) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if err := exec.CommandContext(ctx, "docker", "network", "inspect", name).Run(); err == nil {
+		return nil
+	}
+
+	logrus.Infof("Creating network: %s", name)
+
+	cmd := exec.CommandContext(ctx, "docker", "network", "create", "--driver", "bridge")
+	for key, value := range labels {
+		cmd.Args = append(cmd.Args, "--label", fmt.Sprintf("%s=%s", key, value))
+	}
+	cmd.Args = append(cmd.Args, name)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		dm.auditLogger.LogEvent("DOCKER_NETWORK_CREATE_FAILED", map[string]interface{}{
+			"network": name,
+			"error":   err.Error(),
+			"output":  string(output),
+		})
+		return fmt.Errorf("failed to create network: %w, output: %s", err, output)
+	}
+
+	dm.auditLogger.LogEvent("DOCKER_NETWORK_CREATE_SUCCESS", map[string]interface{}{
+		"network": name,
+	})
+
+	return nil
+}
+
+// RemoveNetwork removes a network. A network that no longer exists is not an
+// error.
+func (dm *DockerManager) RemoveNetwork(ctx context.Context, name string) error {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if err := exec.CommandContext(ctx, "docker", "network", "inspect", name).Run(); err != nil {
+		return nil
+	}
+
+	logrus.Infof("Removing network: %s", name)
+
+	output, err := exec.CommandContext(ctx, "docker", "network", "rm", name).CombinedOutput()
+	if err != nil {
+		dm.auditLogger.LogEvent("DOCKER_NETWORK_REMOVE_FAILED", map[string]interface{}{
+			"network": name,
+			"error":   err.Error(),
+			"output":  string(output),
+		})
+		return fmt.Errorf("failed to remove network: %w, output: %s", err, output)
+	}
+
+	dm.auditLogger.LogEvent("DOCKER_NETWORK_REMOVE_SUCCESS", map[string]interface{}{
+		"network": name,
+	})
+
+	return nil
+}
+
 // ExecuteInContainer executes a command in a running container
 func (dm *DockerManager) ExecuteInContainer(ctx context.Context, containerID string, command []string) (string, error) {
 	dm.mu.RLock()
@@ -1158,27 +1479,46 @@ func (dm *DockerManager) ExecuteInContainer(ctx context.Context, containerID str
 	return string(output), nil
 }
 
-// GetContainerLogs gets logs from a container
-func (dm *DockerManager) GetContainerLogs(ctx context.Context, containerID string, follow bool, tail int) (io.ReadCloser, error) {
+// GetContainerLogs gets the stdout and stderr of a container. Both readers
+// have to be drained or closed; with Follow they end once the container
+// stops or the context is cancelled.
+func (dm *DockerManager) GetContainerLogs(ctx context.Context, containerID string, options LogOptions) (io.ReadCloser, io.ReadCloser, error) {
 	dm.mu.RLock()
 	defer dm.mu.RUnlock()
 
 	cmd := exec.CommandContext(ctx, "docker", "logs", containerID)
-	if follow {
+	if options.Follow {
 		cmd.Args = append(cmd.Args, "-f")
 	}
-	if tail > 0 {
-		cmd.Args = append(cmd.Args, "--tail", fmt.Sprintf("%d", tail))
+	if options.Tail > 0 {
+		cmd.Args = append(cmd.Args, "--tail", fmt.Sprintf("%d", options.Tail))
 	}
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	if !options.Since.IsZero() {
+		cmd.Args = append(cmd.Args, "--since", fmt.Sprintf("%d.%09d", options.Since.Unix(), options.Since.Nanosecond()))
 	}
+	if options.Timestamps {
+		cmd.Args = append(cmd.Args, "--timestamps")
+	}
+
+	// The pipes are closed once the command exits, so readers see the end
+	// of the output without waiting on the command themselves
+	stdoutReader, stdoutWriter := io.Pipe()
+	stderrReader, stderrWriter := io.Pipe()
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
 
 	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start logs command: %w", err)
+		return nil, nil, fmt.Errorf("failed to start logs command: %w", err)
 	}
 
-	return stdout, nil
+	go func() {
+		err := cmd.Wait()
+		if err != nil && ctx.Err() == nil {
+			logrus.Debugf("Logs command of container %s exited: %v", containerID, err)
+		}
+		stdoutWriter.Close()
+		stderrWriter.Close()
+	}()
+
+	return stdoutReader, stderrReader, nil
 }
\ No newline at end of file
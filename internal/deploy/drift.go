@@ -0,0 +1,536 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"superagent/internal/deploy/docker"
+
+	"github.com/sirupsen/logrus"
+)
+
+// driftCheckInterval is how often the containers of running deployments are
+// compared with their spec
+const driftCheckInterval = 2 * time.Minute
+
+// DriftPolicy controls what the engine does when the containers of a running
+// deployment no longer match its spec, for example after someone ran docker
+// update or docker network disconnect by hand
+type DriftPolicy struct {
+	AutoRecreate bool `json:"auto_recreate"` // replace drifted containers with ones built from the spec
+}
+
+// DriftStatus records how the containers of a deployment differ from its spec
+type DriftStatus struct {
+	Drifted    bool           `json:"drifted"`
+	Replicas   []ReplicaDrift `json:"replicas,omitempty"`
+	DetectedAt *time.Time     `json:"detected_at,omitempty"` // when the current drift was first seen
+	CheckedAt  time.Time      `json:"checked_at"`
+	Recreated  int            `json:"recreated,omitempty"` // containers recreated because they drifted
+}
+
+// ReplicaDrift lists how the container of one replica differs from its spec
+type ReplicaDrift struct {
+	Index       int               `json:"index"`
+	ContainerID string            `json:"container_id"`
+	Differences []DriftDifference `json:"differences"`
+}
+
+// DriftDifference is a single setting of a container that differs from the
+// spec. Environment values are never shown.
+type DriftDifference struct {
+	Field    string `json:"field"` // e.g. "image", "environment.KEY", "ports.80/tcp", "networks.backend"
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// detectDrift compares the containers of all running deployments with their
+// spec
+func (de *DeploymentEngine) detectDrift() {
+	de.mu.RLock()
+	running := make([]*Deployment, 0, len(de.deployments))
+	for _, deployment := range de.deployments {
+		if deployment.Status == StatusRunning {
+			running = append(running, deployment)
+		}
+	}
+	de.mu.RUnlock()
+
+	for _, deployment := range running {
+		de.checkDrift(deployment)
+	}
+}
+
+// checkDrift compares the containers of a deployment with its spec, records
+// the result on the deployment and starts recreating drifted containers when
+// the policy asks for it
+func (de *DeploymentEngine) checkDrift(deployment *Deployment) {
+	ctx, cancel := context.WithTimeout(de.ctx, 30*time.Second)
+	defer cancel()
+
+	// Image defaults are not drift, the container inherits them
+	var image *docker.ImageInfo
+	if info, err := de.dockerManager.GetImageInfo(ctx, deployment.ImageID); err == nil {
+		image = info
+	} else {
+		logrus.Debugf("Failed to inspect image %s of deployment %s: %v", deployment.ImageID, deployment.ID, err)
+	}
+
+	base := de.replicaBaseConfig(deployment)
+	var drifted []ReplicaDrift
+
+	for _, replica := range deploymentReplicas(deployment) {
+		if replica.Status != ReplicaRunning && replica.Status != ReplicaUnhealthy {
+			continue
+		}
+
+		// Containers that are gone are left to the health checks
+		info, err := de.dockerManager.GetContainerInfo(ctx, replica.ContainerID)
+		if err != nil {
+			logrus.Debugf("Failed to inspect container %s for drift: %v", replica.ContainerID, err)
+			continue
+		}
+
+		expected := replicaContainerConfig(base, replica.Index, replica.Ports)
+		if differences := containerDrift(expected, info, image); len(differences) > 0 {
+			drifted = append(drifted, ReplicaDrift{
+				Index:       replica.Index,
+				ContainerID: replica.ContainerID,
+				Differences: differences,
+			})
+		}
+	}
+
+	de.recordDrift(deployment, drifted)
+
+	if len(drifted) > 0 && deployment.Config.Drift.AutoRecreate {
+		de.startDriftCorrection(deployment)
+	}
+}
+
+// recordDrift stores the drift found on a deployment and reports changes to
+// it in the deployment log, the audit log and the metrics
+func (de *DeploymentEngine) recordDrift(deployment *Deployment, drifted []ReplicaDrift) {
+	now := time.Now()
+	previous := deployment.Drift
+
+	status := &DriftStatus{
+		Drifted:   len(drifted) > 0,
+		Replicas:  drifted,
+		CheckedAt: now,
+	}
+	if previous != nil {
+		status.Recreated = previous.Recreated
+		if previous.Drifted && status.Drifted {
+			status.DetectedAt = previous.DetectedAt
+		}
+	}
+	if status.Drifted && status.DetectedAt == nil {
+		status.DetectedAt = &now
+	}
+
+	de.mu.Lock()
+	deployment.Drift = status
+	deployment.Metrics.DriftedContainers = len(drifted)
+	de.mu.Unlock()
+
+	de.recordDeploymentMetrics(deployment)
+
+	changed := previous == nil || previous.Drifted != status.Drifted || driftSummary(previous.Replicas) != driftSummary(drifted)
+	if !changed {
+		return
+	}
+
+	switch {
+	case status.Drifted:
+		fields := driftFields(drifted)
+		logrus.Warnf("Deployment %s drifted from its spec: %s", deployment.ID, strings.Join(fields, ", "))
+		de.addDeploymentLog(deployment, "warn", fmt.Sprintf("%d containers drifted from the spec: %s", len(drifted), strings.Join(fields, ", ")))
+
+		de.auditLogger.LogEvent("DEPLOYMENT_DRIFT_DETECTED", map[string]interface{}{
+			"deployment_id": deployment.ID,
+			"app_id":        deployment.AppID,
+			"version":       deployment.Version,
+			"containers":    len(drifted),
+			"fields":        fields,
+		})
+	case previous != nil && previous.Drifted:
+		de.addDeploymentLog(deployment, "info", "Containers match the spec again")
+
+		de.auditLogger.LogEvent("DEPLOYMENT_DRIFT_RESOLVED", map[string]interface{}{
+			"deployment_id": deployment.ID,
+			"app_id":        deployment.AppID,
+			"version":       deployment.Version,
+		})
+	}
+
+	de.saveDeployment(deployment)
+}
+
+// startDriftCorrection recreates the drifted containers of a deployment in
+// the background
+func (de *DeploymentEngine) startDriftCorrection(deployment *Deployment) {
+	de.monitorMu.Lock()
+	defer de.monitorMu.Unlock()
+
+	// Remediation replaces containers itself
+	if de.correctingDrift[deployment.ID] || de.remediating[deployment.ID] {
+		return
+	}
+	de.correctingDrift[deployment.ID] = true
+
+	de.wg.Add(1)
+	go de.correctDrift(deployment)
+}
+
+// correctDrift replaces the drifted containers of a deployment with ones
+// built from its spec
+func (de *DeploymentEngine) correctDrift(deployment *Deployment) {
+	defer de.wg.Done()
+	defer func() {
+		de.monitorMu.Lock()
+		delete(de.correctingDrift, deployment.ID)
+		de.monitorMu.Unlock()
+	}()
+
+	unlock, err := de.lockApp(deployment.AppID)
+	if err != nil {
+		logrus.Warnf("Failed to correct drift of deployment %s: %v", deployment.ID, err)
+		return
+	}
+	defer unlock()
+
+	// Another operation may have replaced the deployment in the meantime
+	if deployment.Status != StatusRunning || deployment.Drift == nil || !deployment.Drift.Drifted {
+		return
+	}
+
+	drifted := make(map[string]bool, len(deployment.Drift.Replicas))
+	for _, replica := range deployment.Drift.Replicas {
+		drifted[replica.ContainerID] = true
+	}
+
+	ctx, cancel := context.WithTimeout(de.ctx, progressTimeout(deployment))
+	defer cancel()
+
+	current := deploymentReplicas(deployment)
+	replicas := make([]*Replica, 0, len(current))
+	recreated := 0
+	var failed []string
+
+	for _, replica := range current {
+		if !drifted[replica.ContainerID] {
+			replicas = append(replicas, replica)
+			continue
+		}
+
+		fresh, err := de.recreateReplica(ctx, deployment, replica)
+		if err != nil {
+			replica.Status = ReplicaFailed
+			replicas = append(replicas, replica)
+			failed = append(failed, err.Error())
+			continue
+		}
+
+		if deployment.HealthCheck.Enabled {
+			if err := de.checkReplicas(ctx, deployment, []*Replica{fresh}, deployment.HealthCheck, deployment.Ports); err != nil {
+				failed = append(failed, err.Error())
+			}
+		} else {
+			fresh.Status = ReplicaRunning
+		}
+
+		replicas = append(replicas, fresh)
+		recreated++
+	}
+
+	de.setReplicas(deployment, replicas)
+	de.refreshRoute(deployment)
+
+	de.mu.Lock()
+	deployment.Drift.Recreated += recreated
+	de.mu.Unlock()
+
+	details := map[string]interface{}{
+		"deployment_id": deployment.ID,
+		"app_id":        deployment.AppID,
+		"version":       deployment.Version,
+		"recreated":     recreated,
+	}
+	if len(failed) > 0 {
+		details["errors"] = failed
+		de.addDeploymentLog(deployment, "error", fmt.Sprintf("Failed to recreate drifted containers: %s", strings.Join(failed, "; ")))
+	} else {
+		de.addDeploymentLog(deployment, "info", fmt.Sprintf("Recreated %d drifted containers from the spec", recreated))
+	}
+	de.auditLogger.LogEvent("DEPLOYMENT_DRIFT_CORRECTED", details)
+
+	// Record the state of the new containers right away
+	de.checkDrift(deployment)
+}
+
+// containerDrift lists how a container differs from the configuration it was
+// created from. Settings the container inherits from its image are not
+// counted; without the image only the configured settings are compared.
+func containerDrift(expected docker.ContainerConfig, info *docker.ContainerInfo, image *docker.ImageInfo) []DriftDifference {
+	var differences []DriftDifference
+
+	expectedImage := expected.Image
+	var imageEnv, imageLabels map[string]string
+	if image != nil {
+		expectedImage = image.ID
+		imageEnv = imageEnvironment(image)
+		imageLabels = image.Labels
+		if imageLabels == nil {
+			imageLabels = map[string]string{}
+		}
+	}
+	if !sameImage(expectedImage, info.ImageID) {
+		differences = append(differences, DriftDifference{Field: "image", Expected: expectedImage, Actual: info.ImageID})
+	}
+
+	differences = append(differences, mapDrift("environment", expected.Environment, info.Environment, imageEnv, true)...)
+	differences = append(differences, mapDrift("labels", expected.Labels, info.Labels, imageLabels, false)...)
+	differences = append(differences, portDrift(expected.Ports, info.Ports)...)
+	differences = append(differences, mountDrift(expected.Volumes, info.Mounts)...)
+	differences = append(differences, limitDrift(expected.ResourceLimits, info.ResourceLimits)...)
+	differences = append(differences, networkDrift(expected.Networks, info.Networks)...)
+
+	sort.SliceStable(differences, func(i, j int) bool {
+		return differences[i].Field < differences[j].Field
+	})
+
+	return differences
+}
+
+// mapDrift compares environment variables or labels. Keys the container has
+// beyond the expected ones only count when they do not come from the image.
+// Values referencing a secret are only checked for presence, as the secret
+// may have been rotated since the container was created.
+func mapDrift(field string, expected, actual, inherited map[string]string, hideValues bool) []DriftDifference {
+	var differences []DriftDifference
+	show := func(value string) string {
+		if hideValues {
+			return ""
+		}
+		return value
+	}
+
+	for key, value := range expected {
+		other, exists := actual[key]
+		switch {
+		case !exists:
+			differences = append(differences, DriftDifference{Field: field + "." + key, Expected: show(value), Actual: "(unset)"})
+		case strings.HasPrefix(value, SecretRefPrefix):
+		case other != value:
+			differences = append(differences, DriftDifference{Field: field + "." + key, Expected: show(value), Actual: show(other)})
+		}
+	}
+
+	if inherited == nil {
+		return differences
+	}
+	for key, value := range actual {
+		if _, exists := expected[key]; exists {
+			continue
+		}
+		if imageValue, exists := inherited[key]; exists && imageValue == value {
+			continue
+		}
+		differences = append(differences, DriftDifference{Field: field + "." + key, Expected: "(unset)", Actual: show(value)})
+	}
+
+	return differences
+}
+
+// portDrift compares the host bindings of each container port
+func portDrift(expected, actual []docker.PortMapping) []DriftDifference {
+	expectedBindings := portBindings(expected)
+	actualBindings := portBindings(actual)
+
+	var differences []DriftDifference
+	for port, binding := range expectedBindings {
+		if other := actualBindings[port]; other != binding {
+			differences = append(differences, DriftDifference{Field: "ports." + port, Expected: binding, Actual: other})
+		}
+	}
+	for port, binding := range actualBindings {
+		if _, exists := expectedBindings[port]; !exists {
+			differences = append(differences, DriftDifference{Field: "ports." + port, Actual: binding})
+		}
+	}
+
+	return differences
+}
+
+// portBindings maps "port/protocol" to the sorted host bindings of the port
+func portBindings(ports []docker.PortMapping) map[string]string {
+	bindings := make(map[string][]string)
+	for _, port := range ports {
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		key := fmt.Sprintf("%d/%s", port.ContainerPort, protocol)
+		binding := strconv.Itoa(port.HostPort)
+		if port.HostIP != "" {
+			binding = port.HostIP + ":" + binding
+		}
+		bindings[key] = append(bindings[key], binding)
+	}
+
+	result := make(map[string]string, len(bindings))
+	for key, hosts := range bindings {
+		sort.Strings(hosts)
+		result[key] = strings.Join(hosts, ",")
+	}
+	return result
+}
+
+// mountDrift compares the mounts of a container by their target. Volumes the
+// container has beyond the expected ones come from the image and are not
+// counted, extra bind mounts are.
+func mountDrift(expected, actual []docker.VolumeMapping) []DriftDifference {
+	mounted := make(map[string]docker.VolumeMapping, len(actual))
+	for _, mount := range actual {
+		mounted[mount.Target] = mount
+	}
+
+	var differences []DriftDifference
+	wanted := make(map[string]bool, len(expected))
+	for _, volume := range expected {
+		if volume.Type == "tmpfs" {
+			continue
+		}
+		wanted[volume.Target] = true
+
+		mount, exists := mounted[volume.Target]
+		switch {
+		case !exists:
+			differences = append(differences, DriftDifference{Field: "mounts." + volume.Target, Expected: mountString(volume)})
+		case mount.Source != volume.Source || mount.ReadOnly != volume.ReadOnly:
+			differences = append(differences, DriftDifference{Field: "mounts." + volume.Target, Expected: mountString(volume), Actual: mountString(mount)})
+		}
+	}
+
+	for _, mount := range actual {
+		if !wanted[mount.Target] && mount.Type == "bind" {
+			differences = append(differences, DriftDifference{Field: "mounts." + mount.Target, Actual: mountString(mount)})
+		}
+	}
+
+	return differences
+}
+
+func mountString(volume docker.VolumeMapping) string {
+	if volume.ReadOnly {
+		return volume.Source + ":ro"
+	}
+	return volume.Source
+}
+
+// limitDrift compares the resource limits docker update can change
+func limitDrift(expected, actual docker.ResourceLimits) []DriftDifference {
+	var differences []DriftDifference
+	add := func(field, want, got string) {
+		if want != got {
+			differences = append(differences, DriftDifference{Field: "resource_limits." + field, Expected: want, Actual: got})
+		}
+	}
+
+	// Docker keeps CPU limits with two decimals
+	if math.Abs(expected.CPULimit-actual.CPULimit) >= 0.005 {
+		add("cpu_limit", strconv.FormatFloat(expected.CPULimit, 'f', 2, 64), strconv.FormatFloat(actual.CPULimit, 'f', 2, 64))
+	}
+	add("memory_limit", strconv.FormatInt(expected.MemoryLimit, 10), strconv.FormatInt(actual.MemoryLimit, 10))
+	add("process_limit", strconv.Itoa(expected.ProcessLimit), strconv.Itoa(actual.ProcessLimit))
+	add("cpu_shares", strconv.Itoa(expected.CPUShares), strconv.Itoa(actual.CPUShares))
+	add("cpu_set_cpus", expected.CPUSetCPUs, actual.CPUSetCPUs)
+	add("cpu_set_mems", expected.CPUSetMems, actual.CPUSetMems)
+
+	return differences
+}
+
+// networkDrift compares the networks a container is connected to. A
+// container created without networks is on the default bridge.
+func networkDrift(expected []string, actual map[string]interface{}) []DriftDifference {
+	if len(expected) == 0 {
+		expected = []string{"bridge"}
+	}
+
+	wanted := make(map[string]bool, len(expected))
+	var differences []DriftDifference
+	for _, network := range expected {
+		wanted[network] = true
+		if _, connected := actual[network]; !connected {
+			differences = append(differences, DriftDifference{Field: "networks." + network, Expected: "connected", Actual: "disconnected"})
+		}
+	}
+	for network := range actual {
+		if !wanted[network] {
+			differences = append(differences, DriftDifference{Field: "networks." + network, Expected: "disconnected", Actual: "connected"})
+		}
+	}
+
+	return differences
+}
+
+// sameImage reports whether two image IDs name the same image. Either may be
+// the short ID docker prints.
+func sameImage(expected, actual string) bool {
+	expected = strings.TrimPrefix(expected, "sha256:")
+	actual = strings.TrimPrefix(actual, "sha256:")
+	if expected == "" || actual == "" {
+		return expected == actual
+	}
+	return strings.HasPrefix(actual, expected) || strings.HasPrefix(expected, actual)
+}
+
+// imageEnvironment returns the environment variables an image sets
+func imageEnvironment(image *docker.ImageInfo) map[string]string {
+	env := make(map[string]string)
+	entries, _ := image.Config["Env"].([]interface{})
+	for _, entry := range entries {
+		if str, ok := entry.(string); ok {
+			key, value, _ := strings.Cut(str, "=")
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// driftFields returns the distinct settings that drifted, without the keys
+// of individual entries
+func driftFields(drifted []ReplicaDrift) []string {
+	seen := make(map[string]bool)
+	var fields []string
+	for _, replica := range drifted {
+		for _, difference := range replica.Differences {
+			field, _, _ := strings.Cut(difference.Field, ".")
+			if !seen[field] {
+				seen[field] = true
+				fields = append(fields, field)
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// driftSummary identifies a set of differences so repeated checks only report
+// new drift
+func driftSummary(drifted []ReplicaDrift) string {
+	var parts []string
+	for _, replica := range drifted {
+		for _, difference := range replica.Differences {
+			parts = append(parts, fmt.Sprintf("%s/%s=%s", replica.ContainerID, difference.Field, difference.Actual))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ";")
+}
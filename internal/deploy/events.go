@@ -0,0 +1,176 @@
+package deploy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// eventBufferSize is how many events a subscriber may fall behind before
+// further events are dropped for it
+const eventBufferSize = 256
+
+// EventType identifies what an event reports
+type EventType string
+
+const (
+	EventStatus EventType = "status"
+	EventLog    EventType = "log"
+	EventHealth EventType = "health"
+)
+
+// Event is a single change of a deployment published on the event bus
+type Event struct {
+	Sequence     uint64           `json:"sequence"`
+	Type         EventType        `json:"type"`
+	DeploymentID string           `json:"deployment_id"`
+	AppID        string           `json:"app_id"`
+	Status       DeploymentStatus `json:"status"`
+	Terminal     bool             `json:"terminal"`
+	Log          *LogEntry        `json:"log,omitempty"`
+	Health       *HealthEvent     `json:"health,omitempty"`
+	Timestamp    time.Time        `json:"timestamp"`
+}
+
+// HealthEvent is the result of a single health check of a replica
+type HealthEvent struct {
+	Replica int           `json:"replica"`
+	Healthy bool          `json:"healthy"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// IsTerminal reports whether no operation is in progress on a deployment in
+// this status
+func (s DeploymentStatus) IsTerminal() bool {
+	switch s {
+	case StatusRunning, StatusStopped, StatusFailed, StatusAborted, StatusStandby, StatusCancelled, StatusSuperseded:
+		return true
+	}
+	return false
+}
+
+// EventBus fans deployment events out to in-process subscribers. Publishing
+// never blocks; a subscriber that does not keep up misses events.
+type EventBus struct {
+	subscribers map[uint64]*subscription
+	nextID      uint64
+	sequence    uint64
+	closed      bool
+	mu          sync.Mutex
+}
+
+type subscription struct {
+	deploymentID string
+	events       chan Event
+}
+
+// NewEventBus creates a new event bus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[uint64]*subscription),
+	}
+}
+
+// Subscribe returns a channel receiving the events of one deployment, or of
+// all deployments when deploymentID is empty. The returned function ends the
+// subscription and closes the channel.
+func (eb *EventBus) Subscribe(deploymentID string) (<-chan Event, func()) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	sub := &subscription{
+		deploymentID: deploymentID,
+		events:       make(chan Event, eventBufferSize),
+	}
+
+	if eb.closed {
+		close(sub.events)
+		return sub.events, func() {}
+	}
+
+	id := eb.nextID
+	eb.nextID++
+	eb.subscribers[id] = sub
+
+	return sub.events, func() {
+		eb.mu.Lock()
+		defer eb.mu.Unlock()
+
+		if _, ok := eb.subscribers[id]; ok {
+			delete(eb.subscribers, id)
+			close(sub.events)
+		}
+	}
+}
+
+// Publish delivers an event to every matching subscriber
+func (eb *EventBus) Publish(event Event) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	eb.sequence++
+	event.Sequence = eb.sequence
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	for _, sub := range eb.subscribers {
+		if sub.deploymentID != "" && sub.deploymentID != event.DeploymentID {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			logrus.Debugf("Dropping %s event of deployment %s for slow subscriber", event.Type, event.DeploymentID)
+		}
+	}
+}
+
+// Close ends all subscriptions
+func (eb *EventBus) Close() {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	for id, sub := range eb.subscribers {
+		delete(eb.subscribers, id)
+		close(sub.events)
+	}
+	eb.closed = true
+}
+
+// SubscribeEvents streams the events of a deployment, or of all deployments
+// when deploymentID is empty
+func (de *DeploymentEngine) SubscribeEvents(deploymentID string) (<-chan Event, func()) {
+	return de.events.Subscribe(deploymentID)
+}
+
+// publishEvent stamps an event with the identity and status of a deployment
+// and publishes it
+func (de *DeploymentEngine) publishEvent(deployment *Deployment, event Event) {
+	event.DeploymentID = deployment.ID
+	event.AppID = deployment.AppID
+	event.Status = deployment.Status
+	event.Terminal = deployment.Status.IsTerminal()
+
+	de.events.Publish(event)
+}
+
+// publishHealth publishes the result of a health check of a replica
+func (de *DeploymentEngine) publishHealth(deployment *Deployment, replica *Replica, latency time.Duration, err error) {
+	health := &HealthEvent{
+		Replica: replica.Index,
+		Healthy: err == nil,
+		Latency: latency,
+	}
+	if err != nil {
+		health.Error = err.Error()
+	}
+
+	de.publishEvent(deployment, Event{
+		Type:   EventHealth,
+		Health: health,
+	})
+}
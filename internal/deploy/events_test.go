@@ -0,0 +1,122 @@
+package deploy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// nextEvent receives an event or fails when none arrives
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatalf("event channel closed")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+	}
+	return Event{}
+}
+
+// noEvent fails when an event is waiting
+func noEvent(t *testing.T, events <-chan Event) {
+	t.Helper()
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected %s event of deployment %s", event.Type, event.DeploymentID)
+	default:
+	}
+}
+
+func TestEventBusFiltersByDeployment(t *testing.T) {
+	eb := NewEventBus()
+
+	one, stopOne := eb.Subscribe("d1")
+	defer stopOne()
+	all, stopAll := eb.Subscribe("")
+	defer stopAll()
+
+	eb.Publish(Event{Type: EventStatus, DeploymentID: "d1", Status: StatusBuilding})
+	eb.Publish(Event{Type: EventStatus, DeploymentID: "d2", Status: StatusBuilding})
+
+	if event := nextEvent(t, one); event.DeploymentID != "d1" || event.Sequence != 1 || event.Timestamp.IsZero() {
+		t.Errorf("event %+v, want the first event of d1 stamped", event)
+	}
+	noEvent(t, one)
+
+	first, second := nextEvent(t, all), nextEvent(t, all)
+	if first.DeploymentID != "d1" || second.DeploymentID != "d2" || second.Sequence != 2 {
+		t.Errorf("events of %s and %s numbered %d, want d1 and d2 in order", first.DeploymentID, second.DeploymentID, second.Sequence)
+	}
+}
+
+func TestEventBusUnsubscribeAndClose(t *testing.T) {
+	eb := NewEventBus()
+
+	events, stop := eb.Subscribe("")
+	stop()
+	stop() // a second call is harmless
+	if _, ok := <-events; ok {
+		t.Fatalf("channel still open after unsubscribing")
+	}
+
+	events, _ = eb.Subscribe("d1")
+	eb.Close()
+	if _, ok := <-events; ok {
+		t.Fatalf("channel still open after the bus closed")
+	}
+
+	// Subscribing to a closed bus ends at once
+	events, _ = eb.Subscribe("d1")
+	if _, ok := <-events; ok {
+		t.Fatalf("subscription to a closed bus is open")
+	}
+	eb.Publish(Event{DeploymentID: "d1"})
+}
+
+func TestEventBusDropsForSlowSubscriber(t *testing.T) {
+	eb := NewEventBus()
+	events, stop := eb.Subscribe("d1")
+	defer stop()
+
+	// Publishing never blocks, events beyond the buffer are dropped
+	for i := 0; i < eventBufferSize+10; i++ {
+		eb.Publish(Event{DeploymentID: "d1"})
+	}
+	if len(events) != eventBufferSize {
+		t.Fatalf("%d events buffered, want %d", len(events), eventBufferSize)
+	}
+}
+
+func TestPublishHealth(t *testing.T) {
+	de := &DeploymentEngine{events: NewEventBus()}
+	events, stop := de.SubscribeEvents("d1")
+	defer stop()
+
+	deployment := &Deployment{ID: "d1", AppID: "web", Status: StatusRunning}
+	de.publishHealth(deployment, &Replica{Index: 2}, 0, errors.New("connection refused"))
+
+	event := nextEvent(t, events)
+	if event.Type != EventHealth || event.AppID != "web" || event.Status != StatusRunning || !event.Terminal {
+		t.Errorf("event %+v, want a health event of running web", event)
+	}
+	if event.Health == nil || event.Health.Replica != 2 || event.Health.Healthy || event.Health.Error != "connection refused" {
+		t.Errorf("health %+v, want replica 2 failing", event.Health)
+	}
+}
+
+func TestStatusIsTerminal(t *testing.T) {
+	for _, status := range []DeploymentStatus{StatusRunning, StatusStopped, StatusFailed} {
+		if !status.IsTerminal() {
+			t.Errorf("%s is not terminal", status)
+		}
+	}
+	for _, status := range []DeploymentStatus{StatusPending, StatusBuilding, StatusDeploying, StatusUpdating} {
+		if status.IsTerminal() {
+			t.Errorf("%s is terminal", status)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"superagent/internal/config"
+	"superagent/internal/deploy/resources"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultGCInterval = 1 * time.Hour
+
+	GCReasonCount = "count"
+	GCReasonAge   = "age"
+	GCReasonDisk  = "disk"
+)
+
+// GCReport describes what a garbage collection run removed, or would have
+// removed in report-only mode
+type GCReport struct {
+	ReportOnly     bool         `json:"report_only"`
+	StartedAt      time.Time    `json:"started_at"`
+	FinishedAt     time.Time    `json:"finished_at"`
+	Revisions      []GCRevision `json:"revisions"`
+	Images         []GCImage    `json:"images"`
+	KeptRevisions  int          `json:"kept_revisions"`
+	KeptImages     int          `json:"kept_images"`
+	ImageDiskUsage int64        `json:"image_disk_usage"` // size of the kept images
+	ReclaimedBytes int64        `json:"reclaimed_bytes"`
+	PrunedDangling bool         `json:"pruned_dangling_images"`
+	Errors         []string     `json:"errors,omitempty"`
+}
+
+// GCRevision is a revision removed together with its deployment record
+type GCRevision struct {
+	AppID        string `json:"app_id"`
+	Number       int    `json:"number,omitempty"` // 0 for deployments recorded before revisions
+	Version      string `json:"version"`
+	DeploymentID string `json:"deployment_id,omitempty"`
+	Reason       string `json:"reason"` // "count", "age" or "disk"
+}
+
+// GCImage is an image removed because nothing that is kept uses it
+type GCImage struct {
+	ID   string `json:"id"`
+	Tag  string `json:"tag,omitempty"`
+	Size int64  `json:"size"`
+}
+
+// gcCandidate is a revision or bare deployment record that may be collected
+type gcCandidate struct {
+	GCRevision
+	imageID string
+	at      time.Time
+}
+
+// gcPlan is what a garbage collection run is going to remove
+type gcPlan struct {
+	expired      []gcCandidate
+	images       []GCImage
+	kept         int
+	keptSize     int64
+	keptCount    int
+	keptUntagged bool // a kept image has lost its tag, so untagged images are not pruned
+}
+
+// collectGarbageLoop enforces the retention policy in the background
+func (de *DeploymentEngine) collectGarbageLoop() {
+	defer de.wg.Done()
+
+	interval := de.config.Retention.Interval
+	if interval <= 0 {
+		interval = defaultGCInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-de.ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := de.CollectGarbage(de.config.Retention.ReportOnly); err != nil {
+				logrus.Warnf("Garbage collection failed: %v", err)
+			}
+		}
+	}
+}
+
+// CollectGarbage removes the deployment records, revisions and images the
+// retention policy no longer keeps. Revisions that are deploying or running,
+// the revisions running deployments would roll back to, the last successful
+// and the latest revision of every app are always kept. In report-only mode
+// nothing is removed.
+func (de *DeploymentEngine) CollectGarbage(reportOnly bool) (*GCReport, error) {
+	policy := de.config.Retention
+
+	var diskLimit int64
+	if policy.MaxImageDiskUsage != "" {
+		limit, err := resources.ParseSize(policy.MaxImageDiskUsage)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention.max_image_disk_usage: %w", err)
+		}
+		diskLimit = limit
+	}
+
+	report := &GCReport{
+		ReportOnly: reportOnly,
+		StartedAt:  time.Now(),
+		Revisions:  []GCRevision{},
+		Images:     []GCImage{},
+	}
+
+	ctx, cancel := context.WithTimeout(de.ctx, 10*time.Minute)
+	defer cancel()
+
+	plan := de.planGarbage(ctx, policy, diskLimit)
+	report.KeptRevisions = plan.kept
+	report.KeptImages = plan.keptCount
+	report.ImageDiskUsage = plan.keptSize
+
+	if reportOnly {
+		for _, candidate := range plan.expired {
+			report.Revisions = append(report.Revisions, candidate.GCRevision)
+		}
+		report.Images = append(report.Images, plan.images...)
+		for _, image := range plan.images {
+			report.ReclaimedBytes += image.Size
+		}
+	} else {
+		de.removeGarbage(ctx, plan, report)
+	}
+
+	report.FinishedAt = time.Now()
+
+	de.auditLogger.LogEvent("GARBAGE_COLLECTED", map[string]interface{}{
+		"report_only":     reportOnly,
+		"revisions":       len(report.Revisions),
+		"images":          len(report.Images),
+		"reclaimed_bytes": report.ReclaimedBytes,
+		"errors":          len(report.Errors),
+	})
+
+	if len(report.Revisions) > 0 || len(report.Images) > 0 {
+		verb := "Removed"
+		if reportOnly {
+			verb = "Would remove"
+		}
+		logrus.Infof("%s %d revisions and %d images (%d bytes)", verb, len(report.Revisions), len(report.Images), report.ReclaimedBytes)
+	}
+
+	return report, nil
+}
+
+// planGarbage works out which revisions and images the retention policy no
+// longer keeps
+func (de *DeploymentEngine) planGarbage(ctx context.Context, policy config.RetentionConfig, diskLimit int64) *gcPlan {
+	now := time.Now()
+	plan := &gcPlan{}
+
+	// refs counts the kept revisions and deployment records using each image
+	refs := make(map[string]int)
+	var spare []gcCandidate
+
+	de.mu.RLock()
+	for appID, history := range de.revisions {
+		protected := de.protectedRevisions(appID)
+
+		for i, revision := range history {
+			candidate := gcCandidate{
+				GCRevision: GCRevision{
+					AppID:        appID,
+					Number:       revision.Number,
+					Version:      revision.Version,
+					DeploymentID: revision.DeploymentID,
+				},
+				imageID: revision.ImageID,
+				at:      revisionTime(revision),
+			}
+
+			switch {
+			case protected[revision.Number]:
+			case policy.KeepRevisions > 0 && i < len(history)-policy.KeepRevisions:
+				candidate.Reason = GCReasonCount
+			case policy.MaxAge > 0 && now.Sub(candidate.at) > policy.MaxAge:
+				candidate.Reason = GCReasonAge
+			}
+
+			if candidate.Reason != "" {
+				plan.expired = append(plan.expired, candidate)
+				continue
+			}
+
+			plan.kept++
+			if revision.ImageID != "" {
+				refs[revision.ImageID]++
+			}
+			if !protected[revision.Number] {
+				spare = append(spare, candidate)
+			}
+		}
+	}
+
+	expiredDeployments := make(map[string]bool, len(plan.expired))
+	for _, candidate := range plan.expired {
+		expiredDeployments[candidate.DeploymentID] = true
+	}
+
+	for _, deployment := range de.deployments {
+		if expiredDeployments[deployment.ID] {
+			continue
+		}
+
+		// Deployments recorded before revisions only expire with age
+		if de.deploymentRevision(deployment) == nil && deploymentFinished(deployment) && policy.MaxAge > 0 && now.Sub(deployment.UpdatedAt) > policy.MaxAge {
+			plan.expired = append(plan.expired, gcCandidate{
+				GCRevision: GCRevision{
+					AppID:        deployment.AppID,
+					Version:      deployment.Version,
+					DeploymentID: deployment.ID,
+					Reason:       GCReasonAge,
+				},
+				imageID: deployment.ImageID,
+				at:      deployment.UpdatedAt,
+			})
+			continue
+		}
+
+		if deployment.ImageID != "" {
+			refs[deployment.ImageID]++
+		}
+	}
+	de.mu.RUnlock()
+
+	// Look up the size of every image involved
+	sizes := make(map[string]int64)
+	tags := make(map[string]string)
+	untagged := make(map[string]bool)
+	lookup := func(imageID string) {
+		if _, known := sizes[imageID]; known || imageID == "" {
+			return
+		}
+		info, err := de.dockerManager.GetImageInfo(ctx, imageID)
+		if err != nil {
+			sizes[imageID] = 0 // Already gone
+			return
+		}
+		sizes[imageID] = info.Size
+		if info.Repository != "" {
+			tags[imageID] = info.Repository + ":" + info.Tag
+		} else {
+			untagged[imageID] = true
+		}
+	}
+	for imageID := range refs {
+		lookup(imageID)
+		plan.keptSize += sizes[imageID]
+	}
+
+	// Evict the oldest revisions that are not protected until the kept
+	// images fit the disk limit
+	if diskLimit > 0 && plan.keptSize > diskLimit {
+		sort.Slice(spare, func(i, j int) bool {
+			return spare[i].at.Before(spare[j].at)
+		})
+
+		for _, candidate := range spare {
+			if plan.keptSize <= diskLimit {
+				break
+			}
+
+			candidate.Reason = GCReasonDisk
+			plan.expired = append(plan.expired, candidate)
+			plan.kept--
+
+			de.mu.RLock()
+			deployment := de.deployments[candidate.DeploymentID]
+			de.mu.RUnlock()
+
+			released := []string{candidate.imageID}
+			if deployment != nil {
+				released = append(released, deployment.ImageID)
+			}
+			for _, imageID := range released {
+				if imageID == "" {
+					continue
+				}
+				refs[imageID]--
+				if refs[imageID] == 0 {
+					plan.keptSize -= sizes[imageID]
+				}
+			}
+		}
+	}
+
+	// Images of expired revisions go once nothing kept uses them
+	seen := make(map[string]bool)
+	for _, candidate := range plan.expired {
+		imageID := candidate.imageID
+		if imageID == "" || seen[imageID] || refs[imageID] > 0 {
+			continue
+		}
+		seen[imageID] = true
+		lookup(imageID)
+		plan.images = append(plan.images, GCImage{ID: imageID, Tag: tags[imageID], Size: sizes[imageID]})
+	}
+
+	for imageID, count := range refs {
+		if count > 0 {
+			plan.keptCount++
+			plan.keptUntagged = plan.keptUntagged || untagged[imageID]
+		}
+	}
+
+	return plan
+}
+
+// protectedRevisions returns the revisions of an app that are never
+// collected: those still deploying or running, the ones running deployments
+// would roll back to, the last successful and the latest revision. Callers
+// must hold de.mu.
+func (de *DeploymentEngine) protectedRevisions(appID string) map[int]bool {
+	history := de.revisions[appID]
+	protected := make(map[int]bool)
+
+	if len(history) > 0 {
+		protected[history[len(history)-1].Number] = true
+	}
+
+	deployed := deployedRevisions(history)
+	if len(deployed) > 0 {
+		protected[deployed[len(deployed)-1].Number] = true
+	}
+
+	for _, revision := range history {
+		if revision.Outcome == RevisionPending {
+			protected[revision.Number] = true
+		}
+	}
+
+	for _, deployment := range de.deployments {
+		if deployment.AppID != appID || deploymentFinished(deployment) {
+			continue
+		}
+
+		protected[deployment.Revision] = true
+
+		// The revision whose image it runs, which differs after a rollback
+		for _, revision := range deployed {
+			if revision.ImageID == deployment.ImageID {
+				protected[revision.Number] = true
+			}
+		}
+
+		if target := de.previousRevision(deployment); target != nil {
+			protected[target.Number] = true
+		}
+	}
+
+	return protected
+}
+
+// removeGarbage removes what a plan lists, one app at a time so no
+// operation on the app runs meanwhile
+func (de *DeploymentEngine) removeGarbage(ctx context.Context, plan *gcPlan, report *GCReport) {
+	byApp := make(map[string][]gcCandidate)
+	for _, candidate := range plan.expired {
+		byApp[candidate.AppID] = append(byApp[candidate.AppID], candidate)
+	}
+
+	for appID, candidates := range byApp {
+		unlock, err := de.lockApp(appID)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+
+		removed := de.removeExpired(ctx, appID, candidates)
+		report.Revisions = append(report.Revisions, removed...)
+		unlock()
+	}
+
+	for _, image := range plan.images {
+		// Something may have started using the image since the plan was made
+		if de.imageInUse("", image.ID) {
+			continue
+		}
+
+		if err := de.dockerManager.RemoveImage(ctx, image.ID, false); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to remove image %s: %v", image.ID, err))
+			continue
+		}
+		report.Images = append(report.Images, image)
+		report.ReclaimedBytes += image.Size
+	}
+
+	// Hook and job containers left behind by interrupted runs
+	for _, filter := range []string{"label=superagent.hook", "label=superagent.job"} {
+		if err := de.dockerManager.PruneContainers(ctx, filter); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+
+	// Untagged images are only pruned when no kept image is one of them,
+	// since a rebuilt version moves the tag off the image a rollback needs
+	if !plan.keptUntagged {
+		if err := de.dockerManager.PruneImages(ctx, true); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		} else {
+			report.PrunedDangling = true
+		}
+	}
+}
+
+// removeExpired removes expired revisions of an app with their deployment
+// records and containers. Candidates that became protected since the plan
+// was made are skipped. The caller holds the app lock.
+func (de *DeploymentEngine) removeExpired(ctx context.Context, appID string, candidates []gcCandidate) []GCRevision {
+	de.mu.Lock()
+	protected := de.protectedRevisions(appID)
+
+	expired := make(map[int]bool)
+	var removed []GCRevision
+	var deployments []*Deployment
+
+	for _, candidate := range candidates {
+		if candidate.Number > 0 && protected[candidate.Number] {
+			continue
+		}
+
+		if deployment, exists := de.deployments[candidate.DeploymentID]; exists {
+			if !deploymentFinished(deployment) {
+				continue
+			}
+			deployments = append(deployments, deployment)
+			delete(de.deployments, deployment.ID)
+			de.removeRoute(deployment)
+			de.usageHistory.Remove(deployment.ID)
+		}
+
+		if candidate.Number > 0 {
+			expired[candidate.Number] = true
+		}
+		removed = append(removed, candidate.GCRevision)
+	}
+
+	if len(expired) > 0 {
+		history := de.revisions[appID]
+		kept := make([]*Revision, 0, len(history))
+		for _, revision := range history {
+			if !expired[revision.Number] {
+				kept = append(kept, revision)
+			}
+		}
+		de.revisions[appID] = kept
+		de.saveRevisions(appID, kept)
+	}
+	de.mu.Unlock()
+
+	for _, deployment := range deployments {
+		de.stopDeploymentMonitoring(deployment.ID)
+
+		for _, replica := range deploymentReplicas(deployment) {
+			if err := de.dockerManager.RemoveContainer(ctx, replica.ContainerID, true); err != nil {
+				logrus.Debugf("Failed to remove container %s of collected deployment: %v", replica.ContainerID, err)
+			}
+			de.releaseContainer(replica.ContainerID)
+		}
+		de.removeLeftoverContainers(ctx, deployment)
+		de.removeContainerLogs(deployment.ID)
+
+		if err := de.store.DeleteDeploymentState(deployment.ID); err != nil {
+			logrus.Warnf("Failed to delete deployment state: %v", err)
+		}
+		if de.monitor != nil {
+			de.monitor.RemoveDeploymentMetrics(deployment.ID)
+		}
+	}
+
+	return removed
+}
+
+// deploymentFinished reports whether a deployment is done and neither runs
+// nor will run again by itself
+func deploymentFinished(deployment *Deployment) bool {
+	switch deployment.Status {
+	case StatusStopped, StatusFailed, StatusCancelled, StatusSuperseded, StatusAborted:
+		return true
+	}
+	return false
+}
+
+// revisionTime is when a revision was last active
+func revisionTime(revision *Revision) time.Time {
+	switch {
+	case revision.FinishedAt != nil:
+		return *revision.FinishedAt
+	case !revision.DeployedAt.IsZero():
+		return revision.DeployedAt
+	}
+	return revision.CreatedAt
+}
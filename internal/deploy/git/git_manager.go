@@ -214,6 +214,44 @@ func (gm *GitManager) CloneRepositoryWithOptions(ctx context.Context, options Cl
 	return clonePath, nil
 }
 
+// ResolveRef looks up the commit a branch or tag of a remote repository
+// points to without cloning it. An empty ref resolves the default branch.
+func (gm *GitManager) ResolveRef(ctx context.Context, url, ref string, auth map[string]string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	// Annotated tags are listed twice, the peeled entry names the commit
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", url, ref, ref+"^{}")
+	if err := gm.setupAuth(cmd, auth); err != nil {
+		return "", fmt.Errorf("failed to setup authentication: %w", err)
+	}
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to list remote refs: %w", err)
+	}
+
+	commit := ""
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if strings.HasSuffix(fields[1], "^{}") {
+			return fields[0], nil
+		}
+		if commit == "" {
+			commit = fields[0]
+		}
+	}
+
+	if commit == "" {
+		return "", fmt.Errorf("ref %s not found in %s", ref, url)
+	}
+	return commit, nil
+}
+
 // GetRepositoryInfo returns information about a cloned repository
 func (gm *GitManager) GetRepositoryInfo(repoPath string) (*RepositoryInfo, error) {
 	gm.mu.RLock()
@@ -538,7 +576,7 @@ func (gm *GitManager) setupAuth(cmd *exec.Cmd, auth map[string]string) error {
 
 // setupAuthWithOptions sets up authentication with detailed options
 func (gm *GitManager) setupAuthWithOptions(cmd *exec.Cmd, options CloneOptions) error {
-	env := os.Environ()
+	var env []string
 
 	// Handle SSH key authentication
 	if options.SSHKeyPath != "" {
@@ -560,7 +598,11 @@ func (gm *GitManager) setupAuthWithOptions(cmd *exec.Cmd, options CloneOptions)
 		}
 	}
 
-	cmd.Env = env
+	// Keep what the auth map set up
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, env...)
 	return nil
 }
 
@@ -0,0 +1,633 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	GroupDeploying   = "deploying"
+	GroupRunning     = "running"
+	GroupFailed      = "failed"
+	GroupStopping    = "stopping"
+	GroupStopped     = "stopped"
+	GroupRollingBack = "rolling_back"
+	GroupRemoving    = "removing"
+
+	// groupPollInterval is how often a group deployment checks on the
+	// service it waits for
+	groupPollInterval = 2 * time.Second
+)
+
+// DeploymentGroup is an application made of several services deployed from
+// a compose file. Every service is an app of its own; the group starts them
+// in dependency order on networks of their own and stops, removes and rolls
+// them back as one.
+type DeploymentGroup struct {
+	AppID       string          `json:"app_id"`
+	Version     string          `json:"version"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	Networks    []string        `json:"networks"`
+	Services    []*GroupService `json:"services"` // in the order they start
+	Warnings    []string        `json:"warnings,omitempty"`
+	TriggeredBy string          `json:"triggered_by,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// GroupService is a service of a group
+type GroupService struct {
+	Name         string           `json:"name"`
+	AppID        string           `json:"app_id"`
+	DependsOn    []string         `json:"depends_on,omitempty"`
+	DeploymentID string           `json:"deployment_id,omitempty"`
+	Changed      bool             `json:"changed"`          // deployed by the last release, which a rollback of the group undoes
+	Status       DeploymentStatus `json:"status,omitempty"` // of the deployment, filled in when the group is read
+}
+
+// DeployGroup deploys the services of a compose source. Services whose
+// spec did not change keep running; the others are deployed one after the
+// other in dependency order, each once the services it depends on run.
+// Should a service fail, the services the release already changed are
+// rolled back. Services no longer in the compose file are removed once the
+// release succeeded.
+func (de *DeploymentEngine) DeployGroup(request *DeploymentRequest) (*DeploymentGroup, error) {
+	project, err := ParseCompose(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every service has to be valid before any of them is touched
+	ctx, cancel := context.WithTimeout(de.ctx, 30*time.Second)
+	var errs []error
+	for _, service := range project.Services {
+		for _, err := range de.validateRequest(ctx, &service.Request) {
+			errs = append(errs, fmt.Errorf("service %s: %w", service.Name, err))
+		}
+	}
+	cancel()
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	de.mu.Lock()
+
+	for _, service := range project.Services {
+		for _, deployment := range de.deployments {
+			if deployment.AppID == service.Request.AppID && deployment.Labels[LabelGroup] != project.AppID {
+				de.mu.Unlock()
+				return nil, fmt.Errorf("app %s of service %s already exists outside group %s", deployment.AppID, service.Name, project.AppID)
+			}
+		}
+	}
+
+	now := time.Now()
+	group, exists := de.groups[project.AppID]
+	if exists && groupBusy(group.Status) {
+		de.mu.Unlock()
+		return nil, fmt.Errorf("group %s is %s", project.AppID, group.Status)
+	}
+	if !exists {
+		group = &DeploymentGroup{AppID: project.AppID, CreatedAt: now}
+		de.groups[project.AppID] = group
+	}
+
+	previous := make(map[string]*GroupService, len(group.Services))
+	for _, service := range group.Services {
+		previous[service.Name] = service
+	}
+
+	services := make([]*GroupService, 0, len(project.Services))
+	for _, service := range project.Services {
+		entry := &GroupService{
+			Name:      service.Name,
+			AppID:     service.Request.AppID,
+			DependsOn: service.DependsOn,
+		}
+		if earlier, exists := previous[service.Name]; exists {
+			entry.DeploymentID = earlier.DeploymentID
+			delete(previous, service.Name)
+		}
+		services = append(services, entry)
+	}
+
+	var orphans []*GroupService
+	for _, service := range group.Services {
+		if _, dropped := previous[service.Name]; dropped {
+			orphans = append(orphans, service)
+		}
+	}
+
+	// Networks the file no longer uses stay until the group is removed
+	networks := append([]string{}, group.Networks...)
+	for _, network := range project.Networks {
+		if !containsString(networks, network) {
+			networks = append(networks, network)
+		}
+	}
+	sort.Strings(networks)
+
+	group.Version = request.Version
+	group.Status = GroupDeploying
+	group.Error = ""
+	group.Networks = networks
+	group.Services = services
+	group.Warnings = project.Warnings
+	group.TriggeredBy = request.TriggeredBy
+	group.UpdatedAt = now
+
+	de.mu.Unlock()
+
+	de.saveGroup(group)
+
+	de.wg.Add(1)
+	go de.deployGroupAsync(group, project, orphans)
+
+	de.auditLogger.LogEvent("GROUP_DEPLOYMENT_STARTED", map[string]interface{}{
+		"app_id":       group.AppID,
+		"version":      group.Version,
+		"services":     len(services),
+		"networks":     project.Networks,
+		"triggered_by": request.TriggeredBy,
+	})
+
+	return de.groupSnapshot(group), nil
+}
+
+// deployGroupAsync deploys the services of a group in their start order
+func (de *DeploymentEngine) deployGroupAsync(group *DeploymentGroup, project *ComposeProject, orphans []*GroupService) {
+	defer de.wg.Done()
+
+	for _, network := range project.Networks {
+		ctx, cancel := context.WithTimeout(de.ctx, 30*time.Second)
+		err := de.dockerManager.EnsureNetwork(ctx, network, map[string]string{LabelGroup: group.AppID})
+		cancel()
+		if err != nil {
+			de.failGroup(group, fmt.Errorf("failed to create network %s: %w", network, err), nil)
+			return
+		}
+	}
+
+	var released []*GroupService
+	for i, service := range project.Services {
+		entry := group.Services[i]
+
+		request := service.Request
+		result, err := de.Apply(&request, false)
+		if err != nil {
+			de.failGroup(group, fmt.Errorf("service %s: %w", service.Name, err), released)
+			return
+		}
+
+		deploymentID := ""
+		if result.Changed {
+			deploymentID = result.Deployment.ID
+		} else {
+			de.mu.RLock()
+			if revision, err := de.findRevision(entry.AppID, result.Revision); err == nil {
+				deploymentID = revision.DeploymentID
+			}
+			de.mu.RUnlock()
+		}
+
+		de.mu.Lock()
+		entry.DeploymentID = deploymentID
+		entry.Changed = result.Changed
+		group.UpdatedAt = time.Now()
+		de.mu.Unlock()
+		de.saveGroup(group)
+
+		if err := de.waitForService(deploymentID); err != nil {
+			// The group is picked up as interrupted on the next start
+			if de.ctx.Err() != nil {
+				return
+			}
+			de.failGroup(group, fmt.Errorf("service %s: %w", service.Name, err), released)
+			return
+		}
+
+		if result.Changed {
+			released = append(released, entry)
+		}
+	}
+
+	var errs []string
+	for _, orphan := range orphans {
+		if err := de.removeServiceApp(orphan.AppID); err != nil {
+			errs = append(errs, fmt.Sprintf("service %s: %v", orphan.Name, err))
+		}
+	}
+
+	de.setGroupStatus(group, GroupRunning, strings.Join(errs, "; "))
+
+	de.auditLogger.LogEvent("GROUP_DEPLOYED", map[string]interface{}{
+		"app_id":   group.AppID,
+		"version":  group.Version,
+		"changed":  len(released),
+		"removed":  len(orphans),
+		"services": len(group.Services),
+	})
+}
+
+// failGroup marks a group deployment failed after rolling back the services
+// it already changed
+func (de *DeploymentEngine) failGroup(group *DeploymentGroup, cause error, released []*GroupService) {
+	logrus.Errorf("Deployment of group %s failed: %v", group.AppID, cause)
+
+	message := cause.Error()
+	if len(released) > 0 {
+		de.setGroupStatus(group, GroupRollingBack, message)
+
+		errs := de.rollbackServices(released, fmt.Sprintf("Group %s failed to deploy: %v", group.AppID, cause))
+		for _, err := range errs {
+			message += "; rollback of " + err.Error()
+		}
+	}
+
+	de.setGroupStatus(group, GroupFailed, message)
+
+	de.auditLogger.LogEvent("GROUP_DEPLOYMENT_FAILED", map[string]interface{}{
+		"app_id":      group.AppID,
+		"version":     group.Version,
+		"error":       cause.Error(),
+		"rolled_back": len(released),
+	})
+}
+
+// waitForService waits until the deployment of a service runs
+func (de *DeploymentEngine) waitForService(deploymentID string) error {
+	ticker := time.NewTicker(groupPollInterval)
+	defer ticker.Stop()
+
+	for {
+		de.mu.RLock()
+		deployment, exists := de.deployments[deploymentID]
+		var status DeploymentStatus
+		if exists {
+			status = deployment.Status
+		}
+		de.mu.RUnlock()
+
+		switch {
+		case !exists:
+			return fmt.Errorf("deployment %s was removed", deploymentID)
+		case status == StatusRunning:
+			return nil
+		case status.IsTerminal():
+			return fmt.Errorf("deployment %s is %s", deploymentID, status)
+		}
+
+		select {
+		case <-de.ctx.Done():
+			return de.ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// StopGroup stops the services of a group, dependents first
+func (de *DeploymentEngine) StopGroup(appID string) error {
+	group, _, err := de.claimGroup(appID, GroupStopping)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for i := len(group.Services) - 1; i >= 0; i-- {
+		service := group.Services[i]
+		if service.DeploymentID == "" {
+			continue
+		}
+		if err := de.StopDeployment(service.DeploymentID); err != nil {
+			errs = append(errs, fmt.Sprintf("service %s: %v", service.Name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		de.setGroupStatus(group, GroupFailed, strings.Join(errs, "; "))
+		return fmt.Errorf("failed to stop group %s: %s", appID, strings.Join(errs, "; "))
+	}
+
+	de.setGroupStatus(group, GroupStopped, "")
+
+	de.auditLogger.LogEvent("GROUP_STOPPED", map[string]interface{}{
+		"app_id":   appID,
+		"services": len(group.Services),
+	})
+
+	return nil
+}
+
+// RemoveGroup removes the services of a group, dependents first, and then
+// the networks created for it. Named volumes are kept.
+func (de *DeploymentEngine) RemoveGroup(appID string) error {
+	group, _, err := de.claimGroup(appID, GroupRemoving)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for i := len(group.Services) - 1; i >= 0; i-- {
+		service := group.Services[i]
+		if err := de.removeServiceApp(service.AppID); err != nil {
+			errs = append(errs, fmt.Sprintf("service %s: %v", service.Name, err))
+		}
+	}
+
+	if len(errs) == 0 {
+		for _, network := range group.Networks {
+			ctx, cancel := context.WithTimeout(de.ctx, 30*time.Second)
+			if err := de.dockerManager.RemoveNetwork(ctx, network); err != nil {
+				errs = append(errs, fmt.Sprintf("network %s: %v", network, err))
+			}
+			cancel()
+		}
+	}
+
+	if len(errs) > 0 {
+		de.setGroupStatus(group, GroupFailed, strings.Join(errs, "; "))
+		return fmt.Errorf("failed to remove group %s: %s", appID, strings.Join(errs, "; "))
+	}
+
+	de.mu.Lock()
+	delete(de.groups, appID)
+	de.mu.Unlock()
+
+	if err := de.store.DeleteGroupState(appID); err != nil {
+		logrus.Warnf("Failed to delete group state: %v", err)
+	}
+
+	de.auditLogger.LogEvent("GROUP_REMOVED", map[string]interface{}{
+		"app_id":   appID,
+		"services": len(group.Services),
+		"networks": group.Networks,
+	})
+
+	return nil
+}
+
+// RollbackGroup undoes the last release of a group: every service it
+// changed goes back to its previous revision, dependents first. A service
+// the release added is removed.
+func (de *DeploymentEngine) RollbackGroup(appID string, reason string) (*DeploymentGroup, error) {
+	group, previousStatus, err := de.claimGroup(appID, GroupRollingBack)
+	if err != nil {
+		return nil, err
+	}
+
+	de.mu.RLock()
+	var changed []*GroupService
+	for _, service := range group.Services {
+		if service.Changed && service.DeploymentID != "" {
+			changed = append(changed, service)
+		}
+	}
+	de.mu.RUnlock()
+
+	if len(changed) == 0 {
+		de.setGroupStatus(group, previousStatus, group.Error)
+		return nil, fmt.Errorf("the last release of group %s changed no running service", appID)
+	}
+
+	if errs := de.rollbackServices(changed, reason); len(errs) > 0 {
+		message := errors.Join(errs...).Error()
+		de.setGroupStatus(group, GroupFailed, message)
+
+		de.auditLogger.LogEvent("GROUP_ROLLBACK_FAILED", map[string]interface{}{
+			"app_id": appID,
+			"error":  message,
+		})
+		return nil, fmt.Errorf("failed to roll back group %s: %s", appID, message)
+	}
+
+	de.setGroupStatus(group, GroupRunning, "")
+
+	de.auditLogger.LogEvent("GROUP_ROLLBACK", map[string]interface{}{
+		"app_id":   appID,
+		"services": len(changed),
+		"reason":   reason,
+	})
+
+	return de.groupSnapshot(group), nil
+}
+
+// rollbackServices rolls back services in the reverse of their start order
+func (de *DeploymentEngine) rollbackServices(services []*GroupService, reason string) []error {
+	var errs []error
+	for i := len(services) - 1; i >= 0; i-- {
+		if err := de.rollbackService(services[i], reason); err != nil {
+			errs = append(errs, fmt.Errorf("service %s: %w", services[i].Name, err))
+		}
+	}
+	return errs
+}
+
+// rollbackService brings a service back to the revision it ran before its
+// current deployment, or removes it when there was none
+func (de *DeploymentEngine) rollbackService(service *GroupService, reason string) error {
+	de.mu.RLock()
+	deployment, exists := de.deployments[service.DeploymentID]
+	added := exists && de.standbyDeployment(deployment) == nil && de.previousRevision(deployment) == nil
+	de.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("deployment not found: %s", service.DeploymentID)
+	}
+
+	if added {
+		if err := de.Remove(deployment.ID); err != nil {
+			return err
+		}
+
+		de.mu.Lock()
+		service.DeploymentID = ""
+		service.Changed = false
+		de.mu.Unlock()
+		return nil
+	}
+
+	rollbackInfo, err := de.Rollback(deployment.ID, reason)
+	if err != nil {
+		return err
+	}
+
+	// A blue-green rollback moves traffic back to the standby deployment
+	de.mu.Lock()
+	if restored, exists := de.deployments[rollbackInfo.RestoredDeploymentID]; exists && deployment.Status != StatusRunning && restored.Status == StatusRunning {
+		service.DeploymentID = restored.ID
+	}
+	service.Changed = false
+	de.mu.Unlock()
+
+	return nil
+}
+
+// removeServiceApp removes every deployment of the app of a service
+func (de *DeploymentEngine) removeServiceApp(appID string) error {
+	de.mu.RLock()
+	var deploymentIDs []string
+	for _, deployment := range de.deployments {
+		if deployment.AppID != appID {
+			continue
+		}
+		if !deployment.Status.IsTerminal() {
+			de.mu.RUnlock()
+			return fmt.Errorf("deployment %s is %s", deployment.ID, deployment.Status)
+		}
+		deploymentIDs = append(deploymentIDs, deployment.ID)
+	}
+	de.mu.RUnlock()
+
+	for _, deploymentID := range deploymentIDs {
+		if err := de.Remove(deploymentID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetGroup returns a group with the current status of its services
+func (de *DeploymentEngine) GetGroup(appID string) (*DeploymentGroup, error) {
+	de.mu.RLock()
+	group, exists := de.groups[appID]
+	de.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("group not found: %s", appID)
+	}
+
+	return de.groupSnapshot(group), nil
+}
+
+// ListGroups returns every group, ordered by app
+func (de *DeploymentEngine) ListGroups() []*DeploymentGroup {
+	de.mu.RLock()
+	groups := make([]*DeploymentGroup, 0, len(de.groups))
+	for _, group := range de.groups {
+		groups = append(groups, group)
+	}
+	de.mu.RUnlock()
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].AppID < groups[j].AppID
+	})
+
+	snapshots := make([]*DeploymentGroup, 0, len(groups))
+	for _, group := range groups {
+		snapshots = append(snapshots, de.groupSnapshot(group))
+	}
+	return snapshots
+}
+
+// groupSnapshot copies a group and fills in the status of its services
+func (de *DeploymentEngine) groupSnapshot(group *DeploymentGroup) *DeploymentGroup {
+	de.mu.RLock()
+	defer de.mu.RUnlock()
+
+	snapshot := *group
+	snapshot.Services = make([]*GroupService, 0, len(group.Services))
+	for _, service := range group.Services {
+		entry := *service
+		if deployment, exists := de.deployments[service.DeploymentID]; exists {
+			entry.Status = deployment.Status
+		}
+		snapshot.Services = append(snapshot.Services, &entry)
+	}
+
+	return &snapshot
+}
+
+// claimGroup marks a group busy with an operation so no other starts on it.
+// It returns the status the group had.
+func (de *DeploymentEngine) claimGroup(appID, status string) (*DeploymentGroup, string, error) {
+	de.mu.Lock()
+	group, exists := de.groups[appID]
+	if !exists {
+		de.mu.Unlock()
+		return nil, "", fmt.Errorf("group not found: %s", appID)
+	}
+	if groupBusy(group.Status) {
+		de.mu.Unlock()
+		return nil, "", fmt.Errorf("group %s is %s", appID, group.Status)
+	}
+
+	previousStatus := group.Status
+	group.Status = status
+	group.UpdatedAt = time.Now()
+	de.mu.Unlock()
+
+	de.saveGroup(group)
+	return group, previousStatus, nil
+}
+
+// setGroupStatus records the status of a group and the error that led to it
+func (de *DeploymentEngine) setGroupStatus(group *DeploymentGroup, status, message string) {
+	de.mu.Lock()
+	group.Status = status
+	group.Error = message
+	group.UpdatedAt = time.Now()
+	de.mu.Unlock()
+
+	de.saveGroup(group)
+}
+
+// saveGroup persists a group
+func (de *DeploymentEngine) saveGroup(group *DeploymentGroup) {
+	de.mu.RLock()
+	state, err := encodeState(group)
+	de.mu.RUnlock()
+	if err != nil {
+		logrus.Warnf("Failed to encode group state for %s: %v", group.AppID, err)
+		return
+	}
+
+	if err := de.store.StoreGroupState(group.AppID, state); err != nil {
+		logrus.Warnf("Failed to store group state: %v", err)
+	}
+}
+
+// loadGroups restores the groups from storage. Operations on a group that
+// the agent was stopped in the middle of are marked failed; the deployments
+// of its services are resumed on their own.
+func (de *DeploymentEngine) loadGroups() error {
+	states, err := de.store.LoadGroupStates()
+	if err != nil {
+		return fmt.Errorf("failed to load groups: %w", err)
+	}
+
+	for appID, state := range states {
+		var group DeploymentGroup
+		if err := decodeState(state, &group); err != nil {
+			logrus.Warnf("Failed to restore group %s: %v", appID, err)
+			continue
+		}
+
+		de.mu.Lock()
+		de.groups[appID] = &group
+		de.mu.Unlock()
+
+		if groupBusy(group.Status) {
+			de.setGroupStatus(&group, GroupFailed, fmt.Sprintf("interrupted while %s by an agent restart", group.Status))
+		}
+	}
+
+	return nil
+}
+
+// groupBusy reports whether an operation is in progress on a group with
+// this status
+func groupBusy(status string) bool {
+	switch status {
+	case GroupDeploying, GroupStopping, GroupRollingBack, GroupRemoving:
+		return true
+	}
+	return false
+}
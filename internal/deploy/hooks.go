@@ -0,0 +1,267 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"superagent/internal/deploy/docker"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHookTimeout = 5 * time.Minute
+
+	// maxHookLogLines is how many output lines of a hook are kept in the
+	// deployment logs
+	maxHookLogLines = 500
+
+	HookPreDeploy  = "pre-deploy"
+	HookPostDeploy = "post-deploy"
+)
+
+// DeploymentHooks are one-off jobs run from the deployment's image around
+// the rollout. Pre-deploy hooks run before the previous version is touched,
+// post-deploy hooks once the new version takes traffic.
+type DeploymentHooks struct {
+	PreDeploy  []HookConfig `json:"pre_deploy,omitempty"`
+	PostDeploy []HookConfig `json:"post_deploy,omitempty"`
+}
+
+// HookConfig defines a single hook job
+type HookConfig struct {
+	Name        string            `json:"name"`
+	Command     []string          `json:"command"`
+	Timeout     time.Duration     `json:"timeout,omitempty"`
+	Environment map[string]string `json:"environment,omitempty"` // added to the deployment's environment
+}
+
+// HookRun records one run of a hook
+type HookRun struct {
+	Name        string     `json:"name"`
+	Phase       string     `json:"phase"` // "pre-deploy" or "post-deploy"
+	ContainerID string     `json:"container_id,omitempty"`
+	ExitCode    int        `json:"exit_code"`
+	Status      string     `json:"status"` // "running", "succeeded", "failed" or "timed_out"
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// runHooks runs the hooks of a phase one after the other and stops at the
+// first that fails
+func (de *DeploymentEngine) runHooks(ctx context.Context, deployment *Deployment, phase string, hooks []HookConfig) error {
+	for index, hook := range hooks {
+		if err := de.runHook(ctx, deployment, phase, index, hook); err != nil {
+			return fmt.Errorf("%s hook %s failed: %w", phase, hookName(hook, index), err)
+		}
+	}
+	return nil
+}
+
+// runHook runs a hook in a container of its own, streams its output into the
+// deployment logs and removes the container once it exits
+func (de *DeploymentEngine) runHook(ctx context.Context, deployment *Deployment, phase string, index int, hook HookConfig) error {
+	name := hookName(hook, index)
+	run := HookRun{
+		Name:      name,
+		Phase:     phase,
+		ExitCode:  -1,
+		Status:    "running",
+		StartedAt: time.Now(),
+	}
+
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Running %s hook %s: %v", phase, name, hook.Command))
+
+	hookCtx, cancel := context.WithTimeout(ctx, hookTimeout(hook))
+	defer cancel()
+
+	containerID, err := de.createContainer(hookCtx, de.hookContainerConfig(deployment, phase, index, hook), fmt.Sprintf("%s hook %s of deployment %s", phase, name, deployment.ID))
+	if err == nil {
+		run.ContainerID = containerID
+		defer de.removeHookContainer(containerID)
+
+		err = de.dockerManager.StartContainer(hookCtx, containerID)
+	}
+
+	if err == nil {
+		// Follow the output while waiting for the hook to exit
+		var logsDone sync.WaitGroup
+		logsDone.Add(1)
+		go func() {
+			defer logsDone.Done()
+			de.captureHookLogs(hookCtx, deployment, name, containerID)
+		}()
+
+		run.ExitCode, err = de.dockerManager.WaitContainer(hookCtx, containerID)
+		logsDone.Wait()
+
+		if err == nil && run.ExitCode != 0 {
+			err = fmt.Errorf("exited with code %d", run.ExitCode)
+		}
+	}
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+
+	switch {
+	case err == nil:
+		run.Status = "succeeded"
+	case errors.Is(hookCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+		run.Status = "timed_out"
+		err = fmt.Errorf("timed out after %s", hookTimeout(hook))
+	default:
+		run.Status = "failed"
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	deployment.HookRuns = append(deployment.HookRuns, run)
+
+	details := map[string]interface{}{
+		"deployment_id": deployment.ID,
+		"hook":          name,
+		"phase":         phase,
+		"container_id":  run.ContainerID,
+		"exit_code":     run.ExitCode,
+		"status":        run.Status,
+		"duration":      finished.Sub(run.StartedAt).Seconds(),
+	}
+
+	if err != nil {
+		de.addDeploymentLog(deployment, "error", fmt.Sprintf("%s hook %s %s: %v", phase, name, run.Status, err))
+		details["error"] = err.Error()
+		de.auditLogger.LogEvent("DEPLOYMENT_HOOK_FAILED", details)
+		return err
+	}
+
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("%s hook %s succeeded in %s", phase, name, finished.Sub(run.StartedAt).Round(time.Millisecond)))
+	de.auditLogger.LogEvent("DEPLOYMENT_HOOK_COMPLETED", details)
+
+	return nil
+}
+
+// captureHookLogs copies the output of a hook container into the deployment
+// logs
+func (de *DeploymentEngine) captureHookLogs(ctx context.Context, deployment *Deployment, name, containerID string) {
+	var mu sync.Mutex
+	lines := 0
+
+	err := de.dockerManager.FollowContainerLogs(ctx, containerID, func(line string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		lines++
+		switch {
+		case lines <= maxHookLogLines:
+			de.addHookLog(deployment, name, line)
+		case lines == maxHookLogLines+1:
+			de.addHookLog(deployment, name, fmt.Sprintf("output truncated after %d lines", maxHookLogLines))
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		logrus.Warnf("Failed to capture logs of hook %s of deployment %s: %v", name, deployment.ID, err)
+	}
+}
+
+// addHookLog adds a line of hook output to the deployment logs
+func (de *DeploymentEngine) addHookLog(deployment *Deployment, name, line string) {
+	logEntry := LogEntry{
+		Timestamp: time.Now(),
+		Level:     "info",
+		Message:   line,
+		Source:    "hook",
+		Metadata:  map[string]interface{}{"hook": name},
+	}
+
+	deployment.DeploymentLogs = append(deployment.DeploymentLogs, logEntry)
+	de.publishEvent(deployment, Event{Type: EventLog, Log: &logEntry})
+}
+
+// hookContainerConfig derives the container of a hook from the deployment's.
+// Hooks publish no ports and are never restarted.
+func (de *DeploymentEngine) hookContainerConfig(deployment *Deployment, phase string, index int, hook HookConfig) docker.ContainerConfig {
+	config := de.buildContainerConfig(deployment, deployment.ImageID)
+	config.Name = hookContainerName(deployment, phase, index)
+	config.Ports = nil
+	config.Command = hook.Command
+	config.Args = nil
+	config.RestartPolicy = "no"
+
+	config.Environment = make(map[string]string, len(deployment.Environment)+len(hook.Environment))
+	for key, value := range deployment.Environment {
+		config.Environment[key] = value
+	}
+	for key, value := range hook.Environment {
+		config.Environment[key] = value
+	}
+
+	config.Labels = make(map[string]string, len(deployment.Labels)+2)
+	for key, value := range deployment.Labels {
+		config.Labels[key] = value
+	}
+	config.Labels["superagent.deployment"] = deployment.ID
+	config.Labels["superagent.hook"] = phase
+
+	return config
+}
+
+// removeHookContainer removes a finished hook container. It uses its own
+// context since the hook's may already be done.
+func (de *DeploymentEngine) removeHookContainer(containerID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := de.dockerManager.RemoveContainer(ctx, containerID, true); err != nil {
+		logrus.Warnf("Failed to remove hook container %s: %v", containerID, err)
+	}
+}
+
+// validateHooks rejects hooks without a command
+func validateHooks(hooks DeploymentHooks) error {
+	for phase, list := range map[string][]HookConfig{HookPreDeploy: hooks.PreDeploy, HookPostDeploy: hooks.PostDeploy} {
+		for index, hook := range list {
+			if len(hook.Command) == 0 {
+				return fmt.Errorf("%s hook %s has no command", phase, hookName(hook, index))
+			}
+			if hook.Timeout < 0 {
+				return fmt.Errorf("%s hook %s has a negative timeout", phase, hookName(hook, index))
+			}
+		}
+	}
+	return nil
+}
+
+// hooksTimeout is the longest the hooks of a deployment may take together
+func hooksTimeout(hooks DeploymentHooks) time.Duration {
+	var total time.Duration
+	for _, hook := range hooks.PreDeploy {
+		total += hookTimeout(hook)
+	}
+	for _, hook := range hooks.PostDeploy {
+		total += hookTimeout(hook)
+	}
+	return total
+}
+
+func hookTimeout(hook HookConfig) time.Duration {
+	if hook.Timeout > 0 {
+		return hook.Timeout
+	}
+	return defaultHookTimeout
+}
+
+func hookName(hook HookConfig, index int) string {
+	if hook.Name != "" {
+		return hook.Name
+	}
+	return fmt.Sprintf("#%d", index+1)
+}
+
+func hookContainerName(deployment *Deployment, phase string, index int) string {
+	return fmt.Sprintf("superagent-%s-%s-%d", deployment.ID, phase, index)
+}
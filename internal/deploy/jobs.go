@@ -0,0 +1,1005 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"superagent/internal/deploy/docker"
+	"superagent/internal/deploy/git"
+	"superagent/internal/deploy/resources"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	JobPolicyForbid  = "forbid"
+	JobPolicyReplace = "replace"
+	JobPolicyAllow   = "allow"
+
+	JobTriggerCreate   = "create"
+	JobTriggerManual   = "manual"
+	JobTriggerSchedule = "schedule"
+
+	JobRunPending   = "pending"
+	JobRunRunning   = "running"
+	JobRunBackoff   = "backoff" // waiting to retry a failed attempt
+	JobRunSucceeded = "succeeded"
+	JobRunFailed    = "failed"
+	JobRunCancelled = "cancelled"
+
+	defaultJobTimeout      = time.Hour
+	defaultJobBackoffDelay = 10 * time.Second
+	maxJobBackoffDelay     = 10 * time.Minute
+	defaultJobHistoryLimit = 10
+
+	// maxJobLogLines is how many of the last log lines of a run are kept
+	maxJobLogLines = 1000
+
+	// jobScheduleInterval is how often the scheduler looks for jobs due to run
+	jobScheduleInterval = 10 * time.Second
+)
+
+var (
+	errJobRunCancelled = errors.New("job run cancelled")
+	errJobRunReplaced  = errors.New("replaced by a newer run")
+
+	jobNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+)
+
+// JobSpec describes a job: a container that runs to completion, either once
+// or on a cron schedule. A failed attempt is retried up to BackoffLimit
+// times, waiting BackoffDelay before the first retry and twice as long
+// before each further one.
+type JobSpec struct {
+	Name              string                   `json:"name"`
+	Source            DeploymentSource         `json:"source"`            // git or docker
+	Command           []string                 `json:"command,omitempty"` // the image's by default
+	Environment       map[string]string        `json:"environment,omitempty"`
+	Labels            map[string]string        `json:"labels,omitempty"`
+	Volumes           []VolumeMapping          `json:"volumes,omitempty"`
+	Networks          []string                 `json:"networks,omitempty"`
+	ResourceLimits    resources.ResourceLimits `json:"resource_limits"`
+	User              string                   `json:"user,omitempty"`
+	WorkingDir        string                   `json:"working_dir,omitempty"`
+	Security          SecurityConfig           `json:"security"`
+	Schedule          string                   `json:"schedule,omitempty"`           // cron expression; without one the job runs once when created
+	TimeZone          string                   `json:"time_zone,omitempty"`          // of the schedule, the agent's by default
+	ConcurrencyPolicy string                   `json:"concurrency_policy,omitempty"` // "forbid" (default), "replace" or "allow"
+	Suspended         bool                     `json:"suspended,omitempty"`          // the schedule does not start runs
+	Timeout           time.Duration            `json:"timeout,omitempty"`            // of each attempt
+	BackoffLimit      int                      `json:"backoff_limit"`
+	BackoffDelay      time.Duration            `json:"backoff_delay,omitempty"`
+	HistoryLimit      int                      `json:"history_limit,omitempty"` // finished runs kept
+	TriggeredBy       string                   `json:"triggered_by,omitempty"`
+}
+
+// Job is a job with its schedule and run history
+type Job struct {
+	Spec            JobSpec    `json:"spec"`
+	EnvironmentKeys []string   `json:"environment_keys,omitempty"` // filled in when the job is read, values are not shown
+	NextRunAt       *time.Time `json:"next_run_at,omitempty"`
+	LastScheduledAt *time.Time `json:"last_scheduled_at,omitempty"`
+	RunCount        int        `json:"run_count"`
+	Runs            []*JobRun  `json:"runs"` // newest first
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+
+	schedule *CronSchedule
+}
+
+// JobRun records one run of a job
+type JobRun struct {
+	ID              string        `json:"id"`
+	Job             string        `json:"job"`
+	Trigger         string        `json:"trigger"` // "create", "manual" or "schedule"
+	TriggeredBy     string        `json:"triggered_by,omitempty"`
+	Status          string        `json:"status"`
+	ImageID         string        `json:"image_id,omitempty"`
+	SourceCommit    string        `json:"source_commit,omitempty"`
+	ExitCode        int           `json:"exit_code"` // of the last attempt, -1 until one exits
+	Error           string        `json:"error,omitempty"`
+	Attempts        []JobAttempt  `json:"attempts"`
+	Logs            []LogEntry    `json:"logs,omitempty"`
+	DroppedLogLines int           `json:"dropped_log_lines,omitempty"` // older lines beyond the kept ones
+	CreatedAt       time.Time     `json:"created_at"`
+	StartedAt       *time.Time    `json:"started_at,omitempty"`
+	FinishedAt      *time.Time    `json:"finished_at,omitempty"`
+	Duration        time.Duration `json:"duration"`
+}
+
+// JobAttempt records one container a run started
+type JobAttempt struct {
+	Number      int        `json:"number"`
+	ContainerID string     `json:"container_id,omitempty"`
+	ExitCode    int        `json:"exit_code"`
+	Status      string     `json:"status"` // "running", "succeeded", "failed" or "timed_out"
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether a run is over
+func (run *JobRun) Finished() bool {
+	switch run.Status {
+	case JobRunSucceeded, JobRunFailed, JobRunCancelled:
+		return true
+	}
+	return false
+}
+
+// ParseJobSpecs reads job specs from a YAML or JSON file the way
+// ParseManifests reads app manifests
+func ParseJobSpecs(data []byte) ([]JobSpec, error) {
+	documents, err := manifestDocuments(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(documents) == 0 {
+		return nil, fmt.Errorf("no jobs found")
+	}
+
+	specs := make([]JobSpec, 0, len(documents))
+	seen := make(map[string]bool)
+
+	for index, document := range documents {
+		var spec JobSpec
+		if err := decodeManifestInto(document, &spec); err != nil {
+			return nil, fmt.Errorf("job %d: %w", index+1, err)
+		}
+
+		if spec.Name == "" {
+			return nil, fmt.Errorf("job %d: name is required", index+1)
+		}
+		if seen[spec.Name] {
+			return nil, fmt.Errorf("job %d: job %s is defined more than once", index+1, spec.Name)
+		}
+		seen[spec.Name] = true
+
+		specs = append(specs, spec)
+	}
+
+	return specs, nil
+}
+
+// CreateJob creates a job or replaces the spec of an existing one, keeping
+// its run history. A job without a schedule runs right away and its run is
+// returned.
+func (de *DeploymentEngine) CreateJob(spec *JobSpec) (*Job, *JobRun, error) {
+	schedule, err := de.validateJob(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+
+	de.mu.Lock()
+	job, exists := de.jobs[spec.Name]
+	if !exists {
+		job = &Job{CreatedAt: now}
+		de.jobs[spec.Name] = job
+	}
+	job.Spec = *spec
+	job.schedule = schedule
+	job.UpdatedAt = now
+	job.NextRunAt = nil
+	if schedule != nil && !spec.Suspended {
+		next := schedule.Next(now)
+		job.NextRunAt = &next
+	}
+	de.mu.Unlock()
+
+	de.saveJob(job)
+
+	event := "JOB_CREATED"
+	if exists {
+		event = "JOB_UPDATED"
+	}
+	de.auditLogger.LogEvent(event, map[string]interface{}{
+		"job":          spec.Name,
+		"source_type":  spec.Source.Type,
+		"schedule":     spec.Schedule,
+		"suspended":    spec.Suspended,
+		"triggered_by": spec.TriggeredBy,
+	})
+
+	if schedule != nil {
+		return de.jobSnapshot(job), nil, nil
+	}
+
+	run, err := de.startJobRun(job, JobTriggerCreate, spec.TriggeredBy)
+	if err != nil {
+		return de.jobSnapshot(job), nil, err
+	}
+
+	return de.jobSnapshot(job), run, nil
+}
+
+// RunJob starts a run of a job right away. The concurrency policy of the
+// job applies as it does to scheduled runs.
+func (de *DeploymentEngine) RunJob(name, triggeredBy string) (*JobRun, error) {
+	de.mu.RLock()
+	job, exists := de.jobs[name]
+	de.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("job not found: %s", name)
+	}
+
+	return de.startJobRun(job, JobTriggerManual, triggeredBy)
+}
+
+// CancelJobRun stops a run; its container is removed
+func (de *DeploymentEngine) CancelJobRun(name, runID string) error {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	if _, exists := de.jobs[name]; !exists {
+		return fmt.Errorf("job not found: %s", name)
+	}
+
+	cancel, active := de.jobCancels[runID]
+	if !active {
+		return fmt.Errorf("run %s of job %s is not running", runID, name)
+	}
+	cancel(errJobRunCancelled)
+
+	return nil
+}
+
+// DeleteJob removes a job with its history and cancels its active runs
+func (de *DeploymentEngine) DeleteJob(name string) error {
+	de.mu.Lock()
+	job, exists := de.jobs[name]
+	if !exists {
+		de.mu.Unlock()
+		return fmt.Errorf("job not found: %s", name)
+	}
+
+	cancelled := 0
+	for _, run := range job.Runs {
+		if cancel, active := de.jobCancels[run.ID]; active {
+			cancel(errJobRunCancelled)
+			cancelled++
+		}
+	}
+	delete(de.jobs, name)
+	de.mu.Unlock()
+
+	if err := de.store.DeleteJobState(name); err != nil {
+		logrus.Warnf("Failed to delete job state: %v", err)
+	}
+
+	de.auditLogger.LogEvent("JOB_DELETED", map[string]interface{}{
+		"job":            name,
+		"cancelled_runs": cancelled,
+	})
+
+	return nil
+}
+
+// GetJob returns a job with its run history, without the logs of its runs
+func (de *DeploymentEngine) GetJob(name string) (*Job, error) {
+	de.mu.RLock()
+	job, exists := de.jobs[name]
+	de.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("job not found: %s", name)
+	}
+
+	return de.jobSnapshot(job), nil
+}
+
+// ListJobs returns every job, ordered by name
+func (de *DeploymentEngine) ListJobs() []*Job {
+	de.mu.RLock()
+	jobs := make([]*Job, 0, len(de.jobs))
+	for _, job := range de.jobs {
+		jobs = append(jobs, job)
+	}
+	de.mu.RUnlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Spec.Name < jobs[j].Spec.Name
+	})
+
+	snapshots := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		snapshots = append(snapshots, de.jobSnapshot(job))
+	}
+	return snapshots
+}
+
+// GetJobRun returns a run of a job with its logs
+func (de *DeploymentEngine) GetJobRun(name, runID string) (*JobRun, error) {
+	de.mu.RLock()
+	defer de.mu.RUnlock()
+
+	job, exists := de.jobs[name]
+	if !exists {
+		return nil, fmt.Errorf("job not found: %s", name)
+	}
+
+	for _, run := range job.Runs {
+		if run.ID == runID {
+			snapshot := copyJobRun(run)
+			snapshot.Logs = append([]LogEntry(nil), run.Logs...)
+			return snapshot, nil
+		}
+	}
+
+	return nil, fmt.Errorf("run %s of job %s not found", runID, name)
+}
+
+// startJobRun starts a run of a job in the background unless its
+// concurrency policy forbids it
+func (de *DeploymentEngine) startJobRun(job *Job, trigger, triggeredBy string) (*JobRun, error) {
+	de.mu.Lock()
+
+	name := job.Spec.Name
+	var active []*JobRun
+	for _, run := range job.Runs {
+		if !run.Finished() {
+			active = append(active, run)
+		}
+	}
+
+	if len(active) > 0 {
+		switch job.Spec.ConcurrencyPolicy {
+		case JobPolicyAllow:
+		case JobPolicyReplace:
+			for _, run := range active {
+				if cancel, exists := de.jobCancels[run.ID]; exists {
+					cancel(errJobRunReplaced)
+				}
+			}
+		default:
+			de.mu.Unlock()
+			return nil, fmt.Errorf("job %s is still running as %s", name, active[0].ID)
+		}
+	}
+
+	job.RunCount++
+	run := &JobRun{
+		ID:          fmt.Sprintf("%s-%d", name, job.RunCount),
+		Job:         name,
+		Trigger:     trigger,
+		TriggeredBy: triggeredBy,
+		Status:      JobRunPending,
+		ExitCode:    -1,
+		CreatedAt:   time.Now(),
+	}
+	job.Runs = append([]*JobRun{run}, job.Runs...)
+	pruneJobRuns(job)
+
+	ctx, cancel := context.WithCancelCause(de.ctx)
+	de.jobCancels[run.ID] = cancel
+
+	spec := job.Spec
+	snapshot := copyJobRun(run)
+	de.mu.Unlock()
+
+	de.saveJob(job)
+
+	de.wg.Add(1)
+	go de.runJob(ctx, job, run, spec)
+
+	de.auditLogger.LogEvent("JOB_RUN_STARTED", map[string]interface{}{
+		"job":          name,
+		"run_id":       run.ID,
+		"trigger":      trigger,
+		"triggered_by": triggeredBy,
+		"replaced":     len(active) > 0 && spec.ConcurrencyPolicy == JobPolicyReplace,
+	})
+
+	return snapshot, nil
+}
+
+// runJob prepares the image of a run and runs its attempts
+func (de *DeploymentEngine) runJob(ctx context.Context, job *Job, run *JobRun, spec JobSpec) {
+	defer de.wg.Done()
+
+	started := time.Now()
+	de.mu.Lock()
+	run.StartedAt = &started
+	de.mu.Unlock()
+
+	imageID, err := de.prepareJobImage(ctx, spec, run)
+	if err == nil {
+		err = de.runJobAttempts(ctx, spec, run, imageID)
+	}
+
+	de.finishJobRun(ctx, job, run, err)
+}
+
+// prepareJobImage builds or pulls the image of a run once one of the shared
+// build slots is free. Builds are tagged per job so the image of the
+// previous run is left dangling for garbage collection.
+func (de *DeploymentEngine) prepareJobImage(ctx context.Context, spec JobSpec, run *JobRun) (string, error) {
+	if err := de.scheduler.acquireBuildSlot(ctx); err != nil {
+		return "", err
+	}
+	defer de.scheduler.releaseBuildSlot()
+
+	logBuild := func(line string) {
+		de.addJobLog(run, "build", "info", line)
+	}
+
+	var (
+		imageID string
+		err     error
+	)
+	switch spec.Source.Type {
+	case "docker":
+		imageName := spec.Source.Repository
+		if spec.Source.Tag != "" {
+			imageName = fmt.Sprintf("%s:%s", imageName, spec.Source.Tag)
+		}
+		if imageID, err = de.dockerManager.PullImage(ctx, imageName, spec.Source.Auth, logBuild); err != nil {
+			return "", fmt.Errorf("failed to pull image: %w", err)
+		}
+
+	case "git":
+		options := git.CloneOptions{
+			URL:       spec.Source.Repository,
+			Branch:    spec.Source.Branch,
+			Tag:       spec.Source.Tag,
+			Commit:    spec.Source.Commit,
+			Depth:     1,
+			Recursive: true,
+			Auth:      spec.Source.Auth,
+		}
+		if options.Commit != "" {
+			options.Depth = 0
+		}
+
+		repoPath, err := de.gitManager.CloneRepositoryWithOptions(ctx, options)
+		if err != nil {
+			return "", fmt.Errorf("failed to clone repository: %w", err)
+		}
+		defer de.gitManager.CleanupRepository(repoPath)
+
+		if info, err := de.gitManager.GetRepositoryInfo(repoPath); err == nil {
+			de.mu.Lock()
+			run.SourceCommit = info.Commit
+			de.mu.Unlock()
+			de.addJobLog(run, "build", "info", fmt.Sprintf("Building commit %s", info.Commit))
+		}
+
+		buildContext := docker.BuildContext{
+			ContextPath: repoPath,
+			Dockerfile:  spec.Source.Dockerfile,
+			BuildPath:   spec.Source.BuildPath,
+			ImageTag:    fmt.Sprintf("superagent/job-%s:latest", spec.Name),
+			BuildArgs:   buildArgs(spec.Environment),
+			Labels:      spec.Labels,
+			Pull:        true,
+			Platform:    "linux/amd64",
+		}
+		if imageID, err = de.dockerManager.BuildImage(ctx, buildContext, logBuild); err != nil {
+			return "", fmt.Errorf("failed to build image: %w", err)
+		}
+
+	default:
+		return "", fmt.Errorf("unsupported source type: %s", spec.Source.Type)
+	}
+
+	de.mu.Lock()
+	run.ImageID = imageID
+	de.mu.Unlock()
+
+	return imageID, nil
+}
+
+// runJobAttempts runs the container of a job until an attempt succeeds or
+// the backoff limit is reached
+func (de *DeploymentEngine) runJobAttempts(ctx context.Context, spec JobSpec, run *JobRun, imageID string) error {
+	for number := 1; ; number++ {
+		err := de.runJobAttempt(ctx, spec, run, imageID, number)
+		if err == nil || ctx.Err() != nil || number > spec.BackoffLimit {
+			return err
+		}
+
+		delay := jobBackoff(spec, number)
+		de.setJobRunStatus(run, JobRunBackoff)
+		de.addJobLog(run, "job", "warn", fmt.Sprintf("Attempt %d failed: %v; retrying in %s", number, err, delay))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// runJobAttempt runs the container of a job once, captures its output and
+// removes it once it exits
+func (de *DeploymentEngine) runJobAttempt(ctx context.Context, spec JobSpec, run *JobRun, imageID string, number int) error {
+	attempt := JobAttempt{
+		Number:    number,
+		ExitCode:  -1,
+		Status:    "running",
+		StartedAt: time.Now(),
+	}
+
+	de.mu.Lock()
+	run.Status = JobRunRunning
+	run.Attempts = append(run.Attempts, attempt)
+	index := len(run.Attempts) - 1
+	de.mu.Unlock()
+
+	de.addJobLog(run, "job", "info", fmt.Sprintf("Starting attempt %d", number))
+
+	attemptCtx, cancel := context.WithTimeout(ctx, jobTimeout(spec))
+	defer cancel()
+
+	var containerID string
+	release, err := de.reserveCapacity(attemptCtx, fmt.Sprintf("job run %s", run.ID), spec.ResourceLimits)
+	if err == nil {
+		// Jobs are not in the ledger, they hold their resources until they exit
+		defer release()
+		containerID, err = de.createContainer(attemptCtx, de.jobContainerConfig(spec, run, imageID, number), fmt.Sprintf("job run %s", run.ID))
+	}
+	if err == nil {
+		de.mu.Lock()
+		run.Attempts[index].ContainerID = containerID
+		de.mu.Unlock()
+		defer de.removeJobContainer(containerID)
+
+		err = de.dockerManager.StartContainer(attemptCtx, containerID)
+	}
+
+	exitCode := -1
+	if err == nil {
+		var logsDone sync.WaitGroup
+		logsDone.Add(1)
+		go func() {
+			defer logsDone.Done()
+			de.captureJobLogs(attemptCtx, run, number, containerID)
+		}()
+
+		exitCode, err = de.dockerManager.WaitContainer(attemptCtx, containerID)
+		logsDone.Wait()
+
+		if err == nil && exitCode != 0 {
+			err = fmt.Errorf("exited with code %d", exitCode)
+		}
+	}
+
+	finished := time.Now()
+	status := "succeeded"
+	switch {
+	case err == nil:
+	case errors.Is(attemptCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil:
+		status = "timed_out"
+		err = fmt.Errorf("timed out after %s", jobTimeout(spec))
+	default:
+		status = "failed"
+	}
+
+	de.mu.Lock()
+	run.Attempts[index].ExitCode = exitCode
+	run.Attempts[index].Status = status
+	run.Attempts[index].FinishedAt = &finished
+	if err != nil {
+		run.Attempts[index].Error = err.Error()
+	}
+	run.ExitCode = exitCode
+	de.mu.Unlock()
+
+	level, message := "info", fmt.Sprintf("Attempt %d succeeded in %s", number, finished.Sub(attempt.StartedAt).Round(time.Millisecond))
+	if err != nil {
+		level, message = "error", fmt.Sprintf("Attempt %d %s: %v", number, status, err)
+	}
+	de.addJobLog(run, "job", level, message)
+
+	return err
+}
+
+// finishJobRun records the outcome of a run and drops the oldest finished
+// runs beyond the history limit
+func (de *DeploymentEngine) finishJobRun(ctx context.Context, job *Job, run *JobRun, err error) {
+	finished := time.Now()
+	cause := context.Cause(ctx)
+
+	de.mu.Lock()
+	delete(de.jobCancels, run.ID)
+
+	run.FinishedAt = &finished
+	if run.StartedAt != nil {
+		run.Duration = finished.Sub(*run.StartedAt)
+	}
+	switch {
+	case err == nil:
+		run.Status = JobRunSucceeded
+	case errors.Is(cause, errJobRunCancelled), errors.Is(cause, errJobRunReplaced):
+		run.Status = JobRunCancelled
+		run.Error = cause.Error()
+	case de.ctx.Err() != nil:
+		run.Status = JobRunFailed
+		run.Error = "interrupted by an agent shutdown"
+	default:
+		run.Status = JobRunFailed
+		run.Error = err.Error()
+	}
+
+	pruneJobRuns(job)
+	current := de.jobs[job.Spec.Name] == job
+	details := map[string]interface{}{
+		"job":       run.Job,
+		"run_id":    run.ID,
+		"trigger":   run.Trigger,
+		"status":    run.Status,
+		"attempts":  len(run.Attempts),
+		"exit_code": run.ExitCode,
+		"duration":  run.Duration.Seconds(),
+	}
+	if run.Error != "" {
+		details["error"] = run.Error
+	}
+	de.mu.Unlock()
+
+	// A deleted job is not stored again
+	if current {
+		de.saveJob(job)
+	}
+
+	switch run.Status {
+	case JobRunSucceeded:
+		logrus.Infof("Job run %s succeeded in %s", run.ID, run.Duration.Round(time.Millisecond))
+		de.auditLogger.LogEvent("JOB_RUN_SUCCEEDED", details)
+	case JobRunCancelled:
+		logrus.Infof("Job run %s cancelled: %s", run.ID, run.Error)
+		de.auditLogger.LogEvent("JOB_RUN_CANCELLED", details)
+	default:
+		logrus.Warnf("Job run %s failed: %s", run.ID, run.Error)
+		de.auditLogger.LogEvent("JOB_RUN_FAILED", details)
+	}
+}
+
+// captureJobLogs copies the output of a job container into the run's logs
+func (de *DeploymentEngine) captureJobLogs(ctx context.Context, run *JobRun, number int, containerID string) {
+	err := de.dockerManager.FollowContainerLogs(ctx, containerID, func(line string) {
+		de.addJobLogEntry(run, LogEntry{
+			Timestamp: time.Now(),
+			Level:     "info",
+			Message:   line,
+			Source:    "container",
+			Metadata:  map[string]interface{}{"attempt": number},
+		})
+	})
+	if err != nil && ctx.Err() == nil {
+		logrus.Warnf("Failed to capture logs of job run %s: %v", run.ID, err)
+	}
+}
+
+func (de *DeploymentEngine) addJobLog(run *JobRun, source, level, message string) {
+	de.addJobLogEntry(run, LogEntry{
+		Timestamp: time.Now(),
+		Level:     level,
+		Message:   message,
+		Source:    source,
+	})
+}
+
+// addJobLogEntry adds a line to the logs of a run, dropping the oldest once
+// there are more than maxJobLogLines
+func (de *DeploymentEngine) addJobLogEntry(run *JobRun, entry LogEntry) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	run.Logs = append(run.Logs, entry)
+	if excess := len(run.Logs) - maxJobLogLines; excess > 0 {
+		run.Logs = append(run.Logs[:0], run.Logs[excess:]...)
+		run.DroppedLogLines += excess
+	}
+}
+
+func (de *DeploymentEngine) setJobRunStatus(run *JobRun, status string) {
+	de.mu.Lock()
+	run.Status = status
+	de.mu.Unlock()
+}
+
+// jobContainerConfig derives the container of a job attempt the way a
+// deployment's is derived. Job containers publish no ports and are never
+// restarted by docker; retries are up to the run.
+func (de *DeploymentEngine) jobContainerConfig(spec JobSpec, run *JobRun, imageID string, number int) docker.ContainerConfig {
+	labels := make(map[string]string, len(spec.Labels)+2)
+	for key, value := range spec.Labels {
+		labels[key] = value
+	}
+	labels["superagent.job"] = spec.Name
+	labels["superagent.job-run"] = run.ID
+
+	config := de.buildContainerConfig(&Deployment{
+		ContainerName:  fmt.Sprintf("superagent-job-%s-%d", run.ID, number),
+		Environment:    spec.Environment,
+		Volumes:        spec.Volumes,
+		Networks:       spec.Networks,
+		Labels:         labels,
+		ResourceLimits: spec.ResourceLimits,
+		Config: DeploymentConfig{
+			Command:       spec.Command,
+			User:          spec.User,
+			WorkingDir:    spec.WorkingDir,
+			RestartPolicy: "no",
+			Security:      spec.Security,
+		},
+	}, imageID)
+
+	return config
+}
+
+// removeJobContainer removes the container of a finished attempt. It uses
+// its own context since the attempt's may already be done.
+func (de *DeploymentEngine) removeJobContainer(containerID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := de.dockerManager.RemoveContainer(ctx, containerID, true); err != nil {
+		logrus.Warnf("Failed to remove job container %s: %v", containerID, err)
+	}
+}
+
+// scheduleJobs starts the runs of scheduled jobs when they are due
+func (de *DeploymentEngine) scheduleJobs() {
+	defer de.wg.Done()
+
+	ticker := time.NewTicker(jobScheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-de.ctx.Done():
+			return
+		case <-ticker.C:
+			de.runDueJobs(time.Now())
+		}
+	}
+}
+
+// runDueJobs starts a run of every job whose next run is due. A job that
+// missed several runs, for example while the agent was down, runs once.
+func (de *DeploymentEngine) runDueJobs(now time.Time) {
+	de.mu.Lock()
+	var due []*Job
+	for _, job := range de.jobs {
+		if job.schedule == nil || job.Spec.Suspended || job.NextRunAt == nil || job.NextRunAt.After(now) {
+			continue
+		}
+
+		scheduled := *job.NextRunAt
+		job.LastScheduledAt = &scheduled
+		job.NextRunAt = nil
+		if next := job.schedule.Next(now); !next.IsZero() {
+			job.NextRunAt = &next
+		}
+		due = append(due, job)
+	}
+	de.mu.Unlock()
+
+	for _, job := range due {
+		if _, err := de.startJobRun(job, JobTriggerSchedule, "scheduler"); err != nil {
+			logrus.Infof("Skipped scheduled run of job %s: %v", job.Spec.Name, err)
+			de.saveJob(job)
+
+			de.auditLogger.LogEvent("JOB_RUN_SKIPPED", map[string]interface{}{
+				"job":    job.Spec.Name,
+				"reason": err.Error(),
+			})
+		}
+	}
+}
+
+// validateJob checks a job spec, fills in its defaults and parses its
+// schedule
+func (de *DeploymentEngine) validateJob(spec *JobSpec) (*CronSchedule, error) {
+	var errs []error
+
+	if !jobNamePattern.MatchString(spec.Name) {
+		errs = append(errs, fmt.Errorf("job name %q must start with a letter or digit and contain only letters, digits, '_', '.' and '-'", spec.Name))
+	}
+
+	switch spec.Source.Type {
+	case "git":
+	case "docker":
+		if err := de.validateRegistry(spec.Source.Repository); err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		errs = append(errs, fmt.Errorf("source type of job %s must be git or docker", spec.Name))
+	}
+	if spec.Source.Repository == "" {
+		errs = append(errs, fmt.Errorf("source repository of job %s is required", spec.Name))
+	}
+
+	switch spec.ConcurrencyPolicy {
+	case "":
+		spec.ConcurrencyPolicy = JobPolicyForbid
+	case JobPolicyForbid, JobPolicyReplace, JobPolicyAllow:
+	default:
+		errs = append(errs, fmt.Errorf("concurrency policy must be forbid, replace or allow, not %q", spec.ConcurrencyPolicy))
+	}
+
+	if spec.Timeout < 0 || spec.BackoffDelay < 0 {
+		errs = append(errs, fmt.Errorf("timeout and backoff delay must not be negative"))
+	}
+	if spec.BackoffLimit < 0 || spec.HistoryLimit < 0 {
+		errs = append(errs, fmt.Errorf("backoff and history limits must not be negative"))
+	}
+
+	var schedule *CronSchedule
+	if spec.Schedule != "" {
+		var err error
+		if schedule, err = ParseCronSchedule(spec.Schedule, spec.TimeZone); err != nil {
+			errs = append(errs, err)
+		}
+	} else if spec.TimeZone != "" || spec.Suspended {
+		errs = append(errs, fmt.Errorf("time_zone and suspended only apply to jobs with a schedule"))
+	}
+
+	ctx, cancel := context.WithTimeout(de.ctx, 30*time.Second)
+	quota := de.resourceManager.HostQuota(ctx, "job-"+spec.Name, de.config.Resources)
+	cancel()
+	if err := de.resourceManager.ValidateResourceLimits("job-"+spec.Name, spec.ResourceLimits, quota); err != nil {
+		errs = append(errs, fmt.Errorf("invalid resource limits: %w", err))
+	}
+
+	errs = append(errs, validateVolumes(spec.Volumes, spec.Security.AllowPrivileged)...)
+	errs = append(errs, validateSecurity(DeploymentConfig{User: spec.User, Security: spec.Security})...)
+	errs = append(errs, de.validateSecretRefs(spec.Environment)...)
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return schedule, nil
+}
+
+// jobSnapshot copies a job for readers. Environment values, registry
+// credentials and the logs of runs are left out.
+func (de *DeploymentEngine) jobSnapshot(job *Job) *Job {
+	de.mu.RLock()
+	defer de.mu.RUnlock()
+
+	snapshot := *job
+	snapshot.Spec.Environment = nil
+	snapshot.Spec.Source.Auth = nil
+	snapshot.EnvironmentKeys = make([]string, 0, len(job.Spec.Environment))
+	for key := range job.Spec.Environment {
+		snapshot.EnvironmentKeys = append(snapshot.EnvironmentKeys, key)
+	}
+	sort.Strings(snapshot.EnvironmentKeys)
+
+	snapshot.Runs = make([]*JobRun, 0, len(job.Runs))
+	for _, run := range job.Runs {
+		snapshot.Runs = append(snapshot.Runs, copyJobRun(run))
+	}
+
+	return &snapshot
+}
+
+// copyJobRun copies a run without its logs. The caller must hold mu.
+func copyJobRun(run *JobRun) *JobRun {
+	snapshot := *run
+	snapshot.Attempts = append([]JobAttempt(nil), run.Attempts...)
+	snapshot.Logs = nil
+	return &snapshot
+}
+
+// pruneJobRuns drops the oldest finished runs beyond the history limit of a
+// job. The caller must hold mu.
+func pruneJobRuns(job *Job) {
+	limit := job.Spec.HistoryLimit
+	if limit == 0 {
+		limit = defaultJobHistoryLimit
+	}
+
+	kept := job.Runs[:0]
+	finished := 0
+	for _, run := range job.Runs {
+		if run.Finished() {
+			finished++
+			if finished > limit {
+				continue
+			}
+		}
+		kept = append(kept, run)
+	}
+	job.Runs = kept
+}
+
+// saveJob persists a job with its runs
+func (de *DeploymentEngine) saveJob(job *Job) {
+	de.mu.RLock()
+	state, err := encodeState(job)
+	de.mu.RUnlock()
+	if err != nil {
+		logrus.Warnf("Failed to encode job state for %s: %v", job.Spec.Name, err)
+		return
+	}
+
+	if err := de.store.StoreJobState(job.Spec.Name, state); err != nil {
+		logrus.Warnf("Failed to store job state: %v", err)
+	}
+}
+
+// loadJobs restores the jobs from storage. Runs the agent was stopped in the
+// middle of are marked failed and their containers removed.
+func (de *DeploymentEngine) loadJobs() error {
+	states, err := de.store.LoadJobStates()
+	if err != nil {
+		return fmt.Errorf("failed to load jobs: %w", err)
+	}
+
+	for name, state := range states {
+		var job Job
+		if err := decodeState(state, &job); err != nil {
+			logrus.Warnf("Failed to restore job %s: %v", name, err)
+			continue
+		}
+
+		if job.Spec.Schedule != "" {
+			if job.schedule, err = ParseCronSchedule(job.Spec.Schedule, job.Spec.TimeZone); err != nil {
+				logrus.Warnf("Job %s is not scheduled: %v", name, err)
+			}
+		}
+
+		interrupted := false
+		for _, run := range job.Runs {
+			if run.Finished() {
+				continue
+			}
+			for _, attempt := range run.Attempts {
+				if attempt.ContainerID != "" && attempt.FinishedAt == nil {
+					de.removeJobContainer(attempt.ContainerID)
+				}
+			}
+
+			now := time.Now()
+			run.Status = JobRunFailed
+			run.Error = "interrupted by an agent restart"
+			run.FinishedAt = &now
+			interrupted = true
+		}
+
+		de.mu.Lock()
+		de.jobs[name] = &job
+		de.mu.Unlock()
+
+		if interrupted {
+			de.saveJob(&job)
+		}
+	}
+
+	return nil
+}
+
+// jobBackoff is how long to wait before retrying after the given attempt
+func jobBackoff(spec JobSpec, attempt int) time.Duration {
+	delay := spec.BackoffDelay
+	if delay == 0 {
+		delay = defaultJobBackoffDelay
+	}
+	for i := 1; i < attempt && delay < maxJobBackoffDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxJobBackoffDelay {
+		delay = maxJobBackoffDelay
+	}
+	return delay
+}
+
+func jobTimeout(spec JobSpec) time.Duration {
+	if spec.Timeout > 0 {
+		return spec.Timeout
+	}
+	return defaultJobTimeout
+}
@@ -0,0 +1,472 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ApplyResult tells what applying the manifest of an app did
+type ApplyResult struct {
+	AppID      string
+	Changed    bool
+	Revision   int              // revision the manifest was compared with, 0 when the app runs none
+	Changes    []RevisionChange // what differs from that revision
+	Deployment *Deployment      // the deployment started for the changes
+	Plan       *DeploymentPlan  // what deploying the changes would do, for dry runs
+}
+
+// ParseManifests reads the application manifests in a YAML or JSON file.
+// Every manifest maps onto a DeploymentRequest with the same field names as
+// the API. A file may hold several manifests, separated by "---" in YAML or
+// given as a list in JSON. Durations may be written as "30s" or "5m".
+func ParseManifests(data []byte) ([]DeploymentRequest, error) {
+	documents, err := manifestDocuments(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(documents) == 0 {
+		return nil, fmt.Errorf("no manifests found")
+	}
+
+	requests := make([]DeploymentRequest, 0, len(documents))
+	seen := make(map[string]bool)
+
+	for index, document := range documents {
+		request, err := decodeManifest(document)
+		if err != nil {
+			return nil, fmt.Errorf("manifest %d: %w", index+1, err)
+		}
+
+		if err := validateManifest(request); err != nil {
+			return nil, fmt.Errorf("manifest %d: %w", index+1, err)
+		}
+
+		if seen[request.AppID] {
+			return nil, fmt.Errorf("manifest %d: app %s is defined more than once", index+1, request.AppID)
+		}
+		seen[request.AppID] = true
+
+		requests = append(requests, *request)
+	}
+
+	return requests, nil
+}
+
+// Apply deploys the manifest of an app unless the app already runs exactly
+// what it describes. The manifest is compared with the revision the app
+// currently runs, or the one about to replace it. A dry run plans the
+// deployment instead of starting it.
+func (de *DeploymentEngine) Apply(request *DeploymentRequest, dryRun bool) (*ApplyResult, error) {
+	if request.AppID == "" {
+		return nil, fmt.Errorf("app_id is required")
+	}
+
+	result := &ApplyResult{
+		AppID:   request.AppID,
+		Changes: []RevisionChange{},
+	}
+
+	if baseline := de.applyBaseline(request.AppID); baseline != nil {
+		current, err := flattenSpec(requestSpec(&baseline.Request))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read revision %d: %w", baseline.Number, err)
+		}
+		desired, err := flattenSpec(requestSpec(request))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest: %w", err)
+		}
+
+		result.Revision = baseline.Number
+		result.Changes = diffSpecs(current, desired,
+			environmentFields(baseline.Request.Hooks, baseline.Request.Environment),
+			environmentFields(request.Hooks, request.Environment))
+
+		if len(result.Changes) == 0 {
+			if dryRun {
+				return result, nil
+			}
+
+			de.auditLogger.LogEvent("MANIFEST_APPLIED", map[string]interface{}{
+				"app_id":       request.AppID,
+				"revision":     baseline.Number,
+				"changed":      false,
+				"triggered_by": request.TriggeredBy,
+			})
+			return result, nil
+		}
+	}
+
+	if dryRun {
+		plan, err := de.Plan(request)
+		if err != nil {
+			return nil, err
+		}
+		result.Changed = true
+		result.Plan = plan
+		return result, nil
+	}
+
+	deployment, err := de.Deploy(request)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Changed = true
+	result.Deployment = deployment
+
+	de.auditLogger.LogEvent("MANIFEST_APPLIED", map[string]interface{}{
+		"app_id":        request.AppID,
+		"revision":      result.Revision,
+		"changed":       true,
+		"changes":       len(result.Changes),
+		"deployment_id": deployment.ID,
+		"new_revision":  deployment.Revision,
+		"triggered_by":  request.TriggeredBy,
+	})
+
+	return result, nil
+}
+
+// applyBaseline finds the revision a manifest is compared with: the newest
+// revision still being deployed, or else the one the app runs. Revisions
+// recorded before requests were kept cannot be compared.
+func (de *DeploymentEngine) applyBaseline(appID string) *Revision {
+	de.mu.RLock()
+	defer de.mu.RUnlock()
+
+	var baseline *Revision
+	if running := de.runningDeployment(appID); running != nil {
+		baseline = de.deploymentRevision(running)
+	}
+
+	history := de.revisions[appID]
+	for i := len(history) - 1; i >= 0; i-- {
+		if baseline != nil && history[i].Number <= baseline.Number {
+			break
+		}
+		if history[i].Outcome == RevisionPending {
+			baseline = history[i]
+			break
+		}
+	}
+
+	if baseline == nil || baseline.Request.AppID == "" {
+		return nil
+	}
+	return baseline
+}
+
+// manifestDocuments splits a manifest file into its decoded documents
+func manifestDocuments(data []byte) ([]interface{}, error) {
+	trimmed := bytes.TrimSpace(data)
+	documents := []interface{}{}
+
+	// JSON is read on its own since YAML does not allow tabs for indentation
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.UseNumber()
+		for {
+			var document interface{}
+			err := decoder.Decode(&document)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSON manifest: %w", err)
+			}
+
+			if list, ok := document.([]interface{}); ok {
+				documents = append(documents, list...)
+			} else {
+				documents = append(documents, document)
+			}
+		}
+		return documents, nil
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML manifest: %w", err)
+		}
+
+		document, err := yamlValue(&node)
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML manifest: %w", err)
+		}
+		if document != nil {
+			documents = append(documents, document)
+		}
+	}
+	return documents, nil
+}
+
+// manifestScalar is a YAML number or boolean that keeps its text, so that
+// version: 1.10 stays "1.10" where a string is expected
+type manifestScalar struct {
+	text  string
+	value interface{}
+}
+
+func (s manifestScalar) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.value)
+}
+
+// yamlValue converts a YAML node into the values JSON decoding produces
+func yamlValue(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlValue(node.Content[0])
+
+	case yaml.AliasNode:
+		return yamlValue(node.Alias)
+
+	case yaml.MappingNode:
+		object := make(map[string]interface{}, len(node.Content)/2)
+		var merged []interface{}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value, err := yamlValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+
+			if node.Content[i].Tag == "!!merge" {
+				merged = append(merged, value)
+				continue
+			}
+			object[node.Content[i].Value] = value
+		}
+
+		// Keys given explicitly win over merged ones
+		for _, value := range merged {
+			sources := []interface{}{value}
+			if list, ok := value.([]interface{}); ok {
+				sources = list
+			}
+			for _, source := range sources {
+				mapping, ok := source.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("line %d: only mappings can be merged", node.Line)
+				}
+				for key, child := range mapping {
+					if _, exists := object[key]; !exists {
+						object[key] = child
+					}
+				}
+			}
+		}
+		return object, nil
+
+	case yaml.SequenceNode:
+		list := make([]interface{}, 0, len(node.Content))
+		for _, child := range node.Content {
+			value, err := yamlValue(child)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+
+	case yaml.ScalarNode:
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		switch value.(type) {
+		case nil, string:
+			return value, nil
+		}
+		return manifestScalar{text: node.Value, value: value}, nil
+	}
+
+	return nil, fmt.Errorf("line %d: unsupported YAML node", node.Line)
+}
+
+// decodeManifest turns a decoded document into a deployment request
+func decodeManifest(document interface{}) (*DeploymentRequest, error) {
+	var request DeploymentRequest
+	if err := decodeManifestInto(document, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// decodeManifestInto decodes a document into the struct target points to.
+// Unknown fields are rejected so typos do not go unnoticed, except top-level
+// fields starting with "x-" which may hold YAML anchors to share.
+func decodeManifestInto(document interface{}, target interface{}) error {
+	object, ok := document.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("a manifest must be an object")
+	}
+
+	for key := range object {
+		if strings.HasPrefix(key, "x-") {
+			delete(object, key)
+		}
+	}
+
+	conformed, err := conformManifestValue("", object, reflect.TypeOf(target).Elem())
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(conformed)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(target)
+}
+
+// validateManifest checks the fields every manifest needs
+func validateManifest(request *DeploymentRequest) error {
+	if request.AppID == "" {
+		return fmt.Errorf("app_id is required")
+	}
+	if request.Version == "" {
+		return fmt.Errorf("version of app %s is required", request.AppID)
+	}
+
+	switch request.Source.Type {
+	case "git", "docker":
+	default:
+		return fmt.Errorf("source type of app %s must be git or docker", request.AppID)
+	}
+	if request.Source.Repository == "" {
+		return fmt.Errorf("source repository of app %s is required", request.AppID)
+	}
+
+	return nil
+}
+
+// conformManifestValue adjusts a decoded value to the field it is meant for:
+// duration strings become nanoseconds and numbers or booleans given for
+// string fields, like an environment variable PORT: 8080, become strings
+func conformManifestValue(path string, value interface{}, target reflect.Type) (interface{}, error) {
+	if target == durationType {
+		if scalar, ok := value.(manifestScalar); ok {
+			return scalar.value, nil
+		}
+		if text, ok := value.(string); ok {
+			duration, err := time.ParseDuration(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid duration %q", path, text)
+			}
+			return int64(duration), nil
+		}
+		return value, nil
+	}
+
+	switch target.Kind() {
+	case reflect.Ptr:
+		return conformManifestValue(path, value, target.Elem())
+
+	case reflect.String:
+		switch typed := value.(type) {
+		case manifestScalar:
+			return typed.text, nil
+		case json.Number:
+			return typed.String(), nil
+		case bool:
+			return fmt.Sprint(typed), nil
+		}
+
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			break
+		}
+		fields := jsonFields(target)
+		for key, child := range object {
+			field, known := fields[key]
+			if !known {
+				continue // reported when decoding
+			}
+			conformed, err := conformManifestValue(joinPath(path, key), child, field)
+			if err != nil {
+				return nil, err
+			}
+			object[key] = conformed
+		}
+
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for key, child := range object {
+			conformed, err := conformManifestValue(joinPath(path, key), child, target.Elem())
+			if err != nil {
+				return nil, err
+			}
+			object[key] = conformed
+		}
+
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			break
+		}
+		for i, child := range list {
+			conformed, err := conformManifestValue(fmt.Sprintf("%s[%d]", path, i), child, target.Elem())
+			if err != nil {
+				return nil, err
+			}
+			list[i] = conformed
+		}
+	}
+
+	return value, nil
+}
+
+// jsonFields maps the JSON names of the fields of a struct to their types
+func jsonFields(target reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, target.NumField())
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
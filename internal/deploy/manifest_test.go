@@ -0,0 +1,127 @@
+package deploy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseManifestsYAML(t *testing.T) {
+	data := []byte(`
+x-defaults: &defaults
+  source:
+    type: docker
+    repository: nginx
+  environment:
+    LOG_LEVEL: info
+<<: *defaults
+app_id: web
+version: 1.10
+config:
+  replicas: 3
+  strategy: rolling
+  progress_timeout: 5m
+environment:
+  PORT: 8080
+  DEBUG: true
+ports:
+  - container_port: 80
+    host_port: 8080
+    protocol: tcp
+---
+app_id: worker
+version: "2"
+source:
+  type: git
+  repository: https://example.com/worker.git
+  branch: main
+`)
+
+	requests, err := ParseManifests(data)
+	if err != nil {
+		t.Fatalf("ParseManifests: %v", err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("got %d manifests, want 2", len(requests))
+	}
+
+	web := requests[0]
+	if web.AppID != "web" || web.Version != "1.10" {
+		t.Errorf("app %q version %q, want web 1.10", web.AppID, web.Version)
+	}
+	if web.Source.Type != "docker" || web.Source.Repository != "nginx" {
+		t.Errorf("source %+v, want the merged docker source", web.Source)
+	}
+	if web.Config.Replicas != 3 || web.Config.ProgressTimeout != 5*time.Minute {
+		t.Errorf("config %d replicas, timeout %s, want 3 and 5m", web.Config.Replicas, web.Config.ProgressTimeout)
+	}
+
+	// Keys given explicitly replace merged ones as a whole
+	if got := web.Environment; got["PORT"] != "8080" || got["DEBUG"] != "true" || got["LOG_LEVEL"] != "" {
+		t.Errorf("environment %v, want PORT and DEBUG as strings only", got)
+	}
+	if len(web.Ports) != 1 || web.Ports[0].HostPort != 8080 {
+		t.Errorf("ports %+v, want 80 published on 8080", web.Ports)
+	}
+
+	worker := requests[1]
+	if worker.AppID != "worker" || worker.Source.Branch != "main" {
+		t.Errorf("worker %+v, want branch main", worker)
+	}
+}
+
+func TestParseManifestsJSON(t *testing.T) {
+	data := []byte(`[
+	{"app_id": "api", "version": "1", "source": {"type": "docker", "repository": "api"}, "config": {"warm_period": "90s"}},
+	{"app_id": "db", "version": "2", "source": {"type": "docker", "repository": "postgres"}, "config": {"progress_timeout": 60000000000}}
+]`)
+
+	requests, err := ParseManifests(data)
+	if err != nil {
+		t.Fatalf("ParseManifests: %v", err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("got %d manifests, want 2", len(requests))
+	}
+	if got := requests[0].Config.WarmPeriod; got != 90*time.Second {
+		t.Errorf("warm period %s, want 90s", got)
+	}
+	if got := requests[1].Config.ProgressTimeout; got != time.Minute {
+		t.Errorf("progress timeout %s, want 1m", got)
+	}
+}
+
+func TestParseManifestsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"empty", "", "no manifests found"},
+		{"only comments", "# nothing here\n", "no manifests found"},
+		{"invalid YAML", "app_id: [web\n", "invalid YAML manifest"},
+		{"invalid JSON", `{"app_id": "web",}`, "invalid JSON manifest"},
+		{"not an object", "- web\n- api\n", "must be an object"},
+		{"missing app ID", "version: 1\nsource: {type: docker, repository: nginx}\n", "app_id is required"},
+		{"missing version", "app_id: web\nsource: {type: docker, repository: nginx}\n", "version of app web is required"},
+		{"unknown source type", "app_id: web\nversion: 1\nsource: {type: svn, repository: nginx}\n", "must be git or docker"},
+		{"missing repository", "app_id: web\nversion: 1\nsource: {type: docker}\n", "source repository of app web is required"},
+		{"unknown field", "app_id: web\nversion: 1\nreplicas: 3\nsource: {type: docker, repository: nginx}\n", `unknown field "replicas"`},
+		{"invalid duration", "app_id: web\nversion: 1\nsource: {type: docker, repository: nginx}\nconfig: {progress_timeout: soon}\n", `config.progress_timeout: invalid duration "soon"`},
+		{"wrong type", "app_id: web\nversion: 1\nsource: {type: docker, repository: nginx}\nconfig: {replicas: many}\n", "cannot unmarshal"},
+		{"merge of a scalar", "base: &base 1\napp_id: web\n<<: *base\n", "only mappings can be merged"},
+		{"duplicate app", "app_id: web\nversion: 1\nsource: {type: docker, repository: nginx}\n---\napp_id: web\nversion: 2\nsource: {type: docker, repository: nginx}\n", "manifest 2: app web is defined more than once"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseManifests([]byte(tt.data))
+			if err == nil {
+				t.Fatalf("ParseManifests succeeded, want an error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("ParseManifests error %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
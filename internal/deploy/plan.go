@@ -0,0 +1,406 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"superagent/internal/deploy/docker"
+)
+
+const (
+	// planTimeout bounds resolving the image and commit of a dry run
+	planTimeout = time.Minute
+
+	ImageActionBuild = "build"
+	ImageActionPull  = "pull"
+	ImageActionReuse = "reuse"
+)
+
+// sensitiveHostPaths may only be mounted by deployments that allow
+// privileged containers
+var sensitiveHostPaths = []string{"/", "/boot", "/dev", "/etc", "/proc", "/sys", "/var/run/docker.sock", "/run/docker.sock"}
+
+// DeploymentPlan describes what a deployment request would do without doing
+// any of it
+type DeploymentPlan struct {
+	AppID          string                   `json:"app_id"`
+	Version        string                   `json:"version"`
+	Valid          bool                     `json:"valid"`
+	Errors         []string                 `json:"errors"`
+	Warnings       []string                 `json:"warnings"`
+	Strategy       string                   `json:"strategy"`
+	Replaces       string                   `json:"replaces,omitempty"` // running deployment the new one takes over from
+	Image          string                   `json:"image"`              // image that is built, pulled or reused
+	ImageAction    string                   `json:"image_action"`       // "build", "pull" or "reuse"
+	ImageID        string                   `json:"image_id,omitempty"` // set when the image is already on the host
+	ImageDigest    string                   `json:"image_digest,omitempty"`
+	SourceCommit   string                   `json:"source_commit,omitempty"`
+	Containers     []docker.ContainerConfig `json:"containers"`
+	HookContainers []docker.ContainerConfig `json:"hook_containers,omitempty"`
+}
+
+// Plan runs every check a deployment request goes through and works out the
+// containers it would create, without creating anything. The image and
+// commit are resolved by looking at the host and the remote repository only.
+func (de *DeploymentEngine) Plan(request *DeploymentRequest) (*DeploymentPlan, error) {
+	if request.AppID == "" {
+		return nil, fmt.Errorf("app_id is required")
+	}
+
+	ctx, cancel := context.WithTimeout(de.ctx, planTimeout)
+	defer cancel()
+
+	plan := &DeploymentPlan{
+		AppID:      request.AppID,
+		Version:    request.Version,
+		Strategy:   request.Config.Strategy,
+		Errors:     []string{},
+		Warnings:   []string{},
+		Containers: []docker.ContainerConfig{},
+	}
+
+	for _, err := range de.validateRequest(ctx, request) {
+		plan.Errors = append(plan.Errors, err.Error())
+	}
+
+	deployment := newDeployment(request)
+	deployment.ContainerName = fmt.Sprintf("superagent-%s", deployment.ID)
+
+	de.planImage(ctx, plan, deployment)
+	deployment.ImageID = plan.ImageID
+	if deployment.ImageID == "" {
+		deployment.ImageID = plan.Image
+	}
+
+	// The replicas of the replaced deployment hand their ports on once the
+	// update is done
+	var released map[string]bool
+	switch deployment.Config.Strategy {
+	case "rolling", "blue-green", "canary":
+		de.mu.RLock()
+		if running := de.runningDeployment(deployment.AppID); running != nil {
+			plan.Replaces = running.ID
+			released = make(map[string]bool)
+			for _, replica := range running.Replicas {
+				released[replica.ContainerID] = true
+			}
+		}
+		de.mu.RUnlock()
+	}
+
+	// The same port allocation the rollout goes through, which reports host
+	// ports other deployments hold
+	base := de.replicaBaseConfig(deployment)
+	assigned := make(map[int]bool)
+	for index := 0; index < desiredReplicas(deployment); index++ {
+		ports, err := de.allocateReplicaPorts(deployment.AppID, deployment.Ports, index, released, assigned)
+		if err != nil {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("failed to allocate ports for replica %d: %v", index, err))
+			continue
+		}
+		plan.Containers = append(plan.Containers, replicaContainerConfig(base, index, ports))
+	}
+
+	for index, hook := range deployment.Hooks.PreDeploy {
+		plan.HookContainers = append(plan.HookContainers, de.hookContainerConfig(deployment, HookPreDeploy, index, hook))
+	}
+	for index, hook := range deployment.Hooks.PostDeploy {
+		plan.HookContainers = append(plan.HookContainers, de.hookContainerConfig(deployment, HookPostDeploy, index, hook))
+	}
+
+	for _, volume := range deployment.Volumes {
+		if volumeType(volume) != "bind" || !filepath.IsAbs(volume.Source) {
+			continue
+		}
+		if _, err := os.Stat(volume.Source); err != nil {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("host path %s does not exist and will be created as an empty directory", volume.Source))
+		}
+	}
+
+	plan.Valid = len(plan.Errors) == 0
+
+	de.auditLogger.LogEvent("DEPLOYMENT_PLANNED", map[string]interface{}{
+		"app_id":       request.AppID,
+		"version":      request.Version,
+		"valid":        plan.Valid,
+		"errors":       len(plan.Errors),
+		"image":        plan.Image,
+		"image_action": plan.ImageAction,
+		"triggered_by": request.TriggeredBy,
+	})
+
+	return plan, nil
+}
+
+// planImage works out which image a deployment would run and how it gets it
+func (de *DeploymentEngine) planImage(ctx context.Context, plan *DeploymentPlan, deployment *Deployment) {
+	source := deployment.Source
+
+	switch source.Type {
+	case "git":
+		plan.Image = fmt.Sprintf("superagent/%s:%s", deployment.AppID, deployment.Version)
+		plan.ImageAction = ImageActionBuild
+
+		if source.Commit != "" {
+			plan.SourceCommit = source.Commit
+			return
+		}
+
+		ref := source.Branch
+		if ref == "" {
+			ref = source.Tag
+		}
+		commit, err := de.gitManager.ResolveRef(ctx, source.Repository, ref, source.Auth)
+		if err != nil {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("failed to resolve source commit: %v", err))
+			return
+		}
+		plan.SourceCommit = commit
+
+	case "docker":
+		plan.Image = source.Repository
+		if source.Tag != "" {
+			plan.Image = fmt.Sprintf("%s:%s", source.Repository, source.Tag)
+		}
+		plan.ImageAction = ImageActionPull
+
+		if info, err := de.dockerManager.GetImageInfo(ctx, plan.Image); err == nil {
+			plan.ImageID = info.ID
+			plan.ImageDigest = info.Digest
+		} else {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("image %s is not on the host yet", plan.Image))
+		}
+
+	default:
+		plan.Errors = append(plan.Errors, fmt.Sprintf("unsupported source type: %s", source.Type))
+	}
+}
+
+// validateRequest runs the checks a deployment request has to pass before it
+// is queued and returns every problem found
+func (de *DeploymentEngine) validateRequest(ctx context.Context, request *DeploymentRequest) []error {
+	var errs []error
+
+	if request.Config.Strategy == "canary" {
+		if err := validateCanary(request); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Blue-green and canary releases only move traffic through Traefik
+	if (request.Config.Strategy == "blue-green" || request.Config.Strategy == "canary") && !de.routingManager.Enabled() {
+		errs = append(errs, fmt.Errorf("%s strategy requires Traefik routing, which is disabled", request.Config.Strategy))
+	}
+
+	if err := validateRemediation(request.Config.Remediation); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := validateAutoscaling(request); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := validateHooks(request.Hooks); err != nil {
+		errs = append(errs, err)
+	}
+
+	quota := de.resourceManager.HostQuota(ctx, request.AppID, de.config.Resources)
+	if err := de.resourceManager.ValidateResourceLimits(request.AppID, request.ResourceLimits, quota); err != nil {
+		errs = append(errs, fmt.Errorf("invalid resource limits: %w", err))
+	}
+
+	replicas := desiredReplicas(&Deployment{Config: request.Config})
+	errs = append(errs, de.checkQuotas(ctx, request.AppID, request.ResourceLimits, replicas, true)...)
+
+	// Blue-green and canary releases run next to the current containers
+	replacing := request.Config.Strategy != "blue-green" && request.Config.Strategy != "canary"
+	if err := de.checkCapacity(ctx, request.AppID, request.ResourceLimits, replicas, replacing); err != nil {
+		errs = append(errs, err)
+	}
+
+	if request.Source.Type == "compose" {
+		errs = append(errs, fmt.Errorf("compose source of app %s must be deployed as a group", request.AppID))
+	}
+
+	if request.Source.Type == "docker" {
+		if err := de.validateRegistry(request.Source.Repository); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	errs = append(errs, validatePorts(request.Ports)...)
+	errs = append(errs, validateVolumes(request.Volumes, request.Config.Security.AllowPrivileged)...)
+	errs = append(errs, validateSecurity(request.Config)...)
+
+	errs = append(errs, de.validateSecretRefs(request.Environment)...)
+	for _, hooks := range [][]HookConfig{request.Hooks.PreDeploy, request.Hooks.PostDeploy} {
+		for _, hook := range hooks {
+			errs = append(errs, de.validateSecretRefs(hook.Environment)...)
+		}
+	}
+
+	return errs
+}
+
+// validateRegistry applies the agent's registry allow and block lists to an
+// image
+func (de *DeploymentEngine) validateRegistry(image string) error {
+	registry := imageRegistry(image)
+	security := de.config.Security
+
+	if len(security.AllowedRegistries) > 0 && !containsString(security.AllowedRegistries, registry) {
+		return fmt.Errorf("registry not allowed: %s", registry)
+	}
+
+	if containsString(security.BlockedRegistries, registry) {
+		return fmt.Errorf("registry blocked: %s", registry)
+	}
+
+	return nil
+}
+
+// validatePorts checks port numbers and protocols and that no host port is
+// mapped twice
+func validatePorts(ports []PortMapping) []error {
+	var errs []error
+	mapped := make(map[string]bool)
+
+	for _, port := range ports {
+		if port.ContainerPort <= 0 || port.ContainerPort > 65535 {
+			errs = append(errs, fmt.Errorf("invalid container port: %d", port.ContainerPort))
+		}
+		if port.HostPort < 0 || port.HostPort > 65535 {
+			errs = append(errs, fmt.Errorf("invalid host port: %d", port.HostPort))
+		}
+
+		protocol := protocolOrTCP(port.Protocol)
+		if protocol != "tcp" && protocol != "udp" {
+			errs = append(errs, fmt.Errorf("invalid protocol for port %d: %s", port.ContainerPort, port.Protocol))
+		}
+
+		if port.HostPort == 0 {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d/%s", port.HostIP, port.HostPort, protocol)
+		if mapped[key] {
+			errs = append(errs, fmt.Errorf("host port %d/%s is mapped more than once", port.HostPort, protocol))
+		}
+		mapped[key] = true
+	}
+
+	return errs
+}
+
+// validateVolumes checks mount paths. Sensitive host paths may only be
+// mounted when privileged containers are allowed.
+func validateVolumes(volumes []VolumeMapping, allowPrivileged bool) []error {
+	var errs []error
+	targets := make(map[string]bool)
+
+	for _, volume := range volumes {
+		if !filepath.IsAbs(volume.Target) {
+			errs = append(errs, fmt.Errorf("volume target %q must be an absolute path", volume.Target))
+		} else if targets[filepath.Clean(volume.Target)] {
+			errs = append(errs, fmt.Errorf("volume target %s is mounted more than once", volume.Target))
+		}
+		targets[filepath.Clean(volume.Target)] = true
+
+		switch volumeType(volume) {
+		case "bind":
+			if !filepath.IsAbs(volume.Source) {
+				errs = append(errs, fmt.Errorf("bind mount source %q must be an absolute path", volume.Source))
+				continue
+			}
+			if !allowPrivileged && sensitiveHostPath(volume.Source) {
+				errs = append(errs, fmt.Errorf("mounting host path %s requires security.allow_privileged", volume.Source))
+			}
+		case "volume":
+			if volume.Source == "" || strings.ContainsAny(volume.Source, "/:") {
+				errs = append(errs, fmt.Errorf("invalid volume name %q", volume.Source))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unsupported volume type for %s: %s", volume.Target, volume.Type))
+		}
+	}
+
+	return errs
+}
+
+// validateSecurity checks the security settings of a deployment
+func validateSecurity(config DeploymentConfig) []error {
+	var errs []error
+	security := config.Security
+
+	if config.Privileged && !security.AllowPrivileged {
+		errs = append(errs, fmt.Errorf("privileged containers require security.allow_privileged"))
+	}
+
+	if security.RunAsNonRoot {
+		user := strings.SplitN(config.User, ":", 2)[0]
+		if user == "" || user == "root" || user == "0" {
+			errs = append(errs, fmt.Errorf("run_as_non_root requires config.user to name a non-root user"))
+		}
+	}
+
+	if profile := security.SeccompProfile; profile != "" && profile != "unconfined" && strings.Contains(profile, "/") {
+		if _, err := os.Stat(profile); err != nil {
+			errs = append(errs, fmt.Errorf("seccomp profile %s not found", profile))
+		}
+	}
+
+	for key := range security.SELinuxOptions {
+		switch key {
+		case "user", "role", "type", "level", "disable":
+		default:
+			errs = append(errs, fmt.Errorf("unknown SELinux option: %s", key))
+		}
+	}
+
+	return errs
+}
+
+// volumeType returns the type of a volume, bind mounts being the default
+// for absolute sources and named volumes for the rest
+func volumeType(volume VolumeMapping) string {
+	if volume.Type != "" {
+		return volume.Type
+	}
+	if filepath.IsAbs(volume.Source) {
+		return "bind"
+	}
+	return "volume"
+}
+
+func sensitiveHostPath(path string) bool {
+	path = filepath.Clean(path)
+	for _, sensitive := range sensitiveHostPaths {
+		if path == sensitive || (sensitive != "/" && strings.HasPrefix(path, sensitive+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageRegistry returns the registry an image is pulled from
+func imageRegistry(image string) string {
+	if slash := strings.Index(image, "/"); slash >= 0 {
+		first := image[:slash]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			return first
+		}
+	}
+	return "docker.io"
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
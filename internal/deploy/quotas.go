@@ -0,0 +1,260 @@
+package deploy
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"superagent/internal/deploy/docker"
+	"superagent/internal/deploy/resources"
+
+	"github.com/sirupsen/logrus"
+)
+
+// QuotaStatus is a configured app or team quota with what its apps hold
+type QuotaStatus struct {
+	Scope    string               `json:"scope"` // "app" or "team"
+	Name     string               `json:"name"`
+	Apps     []string             `json:"apps"`
+	Limit    resources.QuotaUsage `json:"limit"` // amounts that are not limited are 0
+	Used     resources.QuotaUsage `json:"used"`
+	Exceeded []string             `json:"exceeded,omitempty"` // e.g. after the quota was lowered
+}
+
+// AppQuotaUsage is what an app holds, with the quotas that apply to it
+type AppQuotaUsage struct {
+	AppID       string                         `json:"app_id"`
+	Team        string                         `json:"team,omitempty"`
+	Used        resources.QuotaUsage           `json:"used"`
+	Allocations []resources.ResourceAllocation `json:"allocations"`
+	Quotas      []*QuotaStatus                 `json:"quotas"`
+}
+
+// Quotas returns every configured quota with its usage, apps first
+func (de *DeploymentEngine) Quotas(ctx context.Context) []*QuotaStatus {
+	quotas := de.config.Quotas
+	statuses := make([]*QuotaStatus, 0, len(quotas.Apps)+len(quotas.Teams))
+
+	for appID := range quotas.Apps {
+		statuses = append(statuses, de.appQuotaStatus(ctx, appID))
+	}
+	for team := range quotas.Teams {
+		statuses = append(statuses, de.teamQuotaStatus(ctx, team))
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Scope != statuses[j].Scope {
+			return statuses[i].Scope == "app"
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+// AppQuota returns the allocations of an app and the quotas it is bound by
+func (de *DeploymentEngine) AppQuota(ctx context.Context, appID string) *AppQuotaUsage {
+	usage := &AppQuotaUsage{
+		AppID:       appID,
+		Used:        de.resourceManager.Usage(appID),
+		Allocations: []resources.ResourceAllocation{},
+		Quotas:      []*QuotaStatus{},
+	}
+
+	for _, allocation := range de.resourceManager.Allocations() {
+		if allocation.AppID == appID {
+			usage.Allocations = append(usage.Allocations, allocation)
+		}
+	}
+
+	if _, exists := de.config.Quotas.Apps[appID]; exists {
+		usage.Quotas = append(usage.Quotas, de.appQuotaStatus(ctx, appID))
+	}
+	if team, exists := de.config.Quotas.TeamOf(appID); exists {
+		usage.Team = team
+		usage.Quotas = append(usage.Quotas, de.teamQuotaStatus(ctx, team))
+	}
+
+	return usage
+}
+
+// appQuotaStatus reports the quota of an app
+func (de *DeploymentEngine) appQuotaStatus(ctx context.Context, appID string) *QuotaStatus {
+	quota := de.resourceManager.ConfiguredQuota(ctx, "app "+appID, de.config.Quotas.Apps[appID])
+	return newQuotaStatus("app", appID, []string{appID}, quota, de.resourceManager.Usage(appID))
+}
+
+// teamQuotaStatus reports the quota of a team
+func (de *DeploymentEngine) teamQuotaStatus(ctx context.Context, team string) *QuotaStatus {
+	cfg := de.config.Quotas.Teams[team]
+	quota := de.resourceManager.ConfiguredQuota(ctx, "team "+team, cfg.QuotaConfig)
+	return newQuotaStatus("team", team, cfg.Apps, quota, de.resourceManager.Usage(cfg.Apps...))
+}
+
+// newQuotaStatus describes a quota and its usage
+func newQuotaStatus(scope, name string, apps []string, quota resources.ResourceQuota, used resources.QuotaUsage) *QuotaStatus {
+	status := &QuotaStatus{
+		Scope: scope,
+		Name:  name,
+		Apps:  apps,
+		Used:  used,
+	}
+
+	if quota.MaxCPU < math.MaxFloat64 {
+		status.Limit.CPU = quota.MaxCPU
+	}
+	if quota.MaxMemory < math.MaxInt64 {
+		status.Limit.Memory = quota.MaxMemory
+	}
+	if quota.MaxStorage < math.MaxInt64 {
+		status.Limit.Storage = quota.MaxStorage
+	}
+	if quota.MaxContainers < math.MaxInt {
+		status.Limit.Containers = quota.MaxContainers
+	}
+
+	// The limits of running containers were admitted, only the sums count
+	limits := resources.ResourceLimits{CPULimit: 1, MemoryLimit: 1, DiskLimit: 1}
+	for _, err := range resources.CheckQuota(quota, used, limits) {
+		status.Exceeded = append(status.Exceeded, err.Error())
+	}
+
+	return status
+}
+
+// checkQuotas checks that an app and its team stay within their quotas with
+// the given number of containers added. A release replaces the containers
+// the app runs, so they are not counted then.
+func (de *DeploymentEngine) checkQuotas(ctx context.Context, appID string, limits resources.ResourceLimits, containers int, replacing bool) []error {
+	quotas := de.config.Quotas
+	var errs []error
+
+	if cfg, exists := quotas.Apps[appID]; exists {
+		var usage resources.QuotaUsage
+		if !replacing {
+			usage = de.resourceManager.Usage(appID)
+		}
+		usage.Add(limits, containers)
+
+		quota := de.resourceManager.ConfiguredQuota(ctx, "app "+appID, cfg)
+		errs = append(errs, resources.CheckQuota(quota, usage, limits)...)
+	}
+
+	if team, exists := quotas.TeamOf(appID); exists {
+		cfg := quotas.Teams[team]
+
+		apps := make([]string, 0, len(cfg.Apps))
+		for _, app := range cfg.Apps {
+			if app != appID || !replacing {
+				apps = append(apps, app)
+			}
+		}
+		usage := de.resourceManager.Usage(apps...)
+		usage.Add(limits, containers)
+
+		quota := de.resourceManager.ConfiguredQuota(ctx, "team "+team, cfg.QuotaConfig)
+		errs = append(errs, resources.CheckQuota(quota, usage, limits)...)
+	}
+
+	return errs
+}
+
+// allocateContainer records the resources of a container that started in
+// the ledger
+func (de *DeploymentEngine) allocateContainer(appID, containerID string, limits resources.ResourceLimits) {
+	allocation, err := de.resourceManager.AllocateResources(appID, containerID, limits)
+	if err != nil {
+		logrus.Warnf("Failed to allocate resources for container %s: %v", containerID, err)
+		return
+	}
+
+	state, err := encodeState(allocation)
+	if err != nil {
+		logrus.Warnf("Failed to encode allocation of container %s: %v", containerID, err)
+		return
+	}
+
+	if err := de.store.StoreAllocation(containerID, state); err != nil {
+		logrus.Warnf("Failed to store allocation: %v", err)
+	}
+}
+
+// releaseContainer frees the resources of a container that was stopped or
+// removed
+func (de *DeploymentEngine) releaseContainer(containerID string) {
+	// Containers that were never allocated have nothing to free
+	if err := de.resourceManager.DeallocateResources(containerID); err != nil {
+		return
+	}
+
+	if err := de.store.DeleteAllocation(containerID); err != nil {
+		logrus.Warnf("Failed to delete allocation: %v", err)
+	}
+}
+
+// loadAllocations restores the allocation ledger from storage
+func (de *DeploymentEngine) loadAllocations() error {
+	states, err := de.store.LoadAllocations()
+	if err != nil {
+		return err
+	}
+
+	allocations := make([]*resources.ResourceAllocation, 0, len(states))
+	for containerID, state := range states {
+		var allocation resources.ResourceAllocation
+		if err := decodeState(state, &allocation); err != nil {
+			logrus.Warnf("Failed to restore allocation of container %s: %v", containerID, err)
+			continue
+		}
+		allocations = append(allocations, &allocation)
+	}
+
+	de.resourceManager.RestoreAllocations(allocations)
+	return nil
+}
+
+// reconcileAllocations brings the ledger in line with the loaded
+// deployments: containers no deployment runs are freed, and running
+// replicas recorded before the ledger existed are allocated.
+func (de *DeploymentEngine) reconcileAllocations() {
+	de.mu.RLock()
+	running := make(map[string]*Deployment)
+	for _, deployment := range de.deployments {
+		switch deployment.Status {
+		case StatusPending, StatusStopped, StatusAborted, StatusCancelled, StatusSuperseded:
+			continue
+		}
+		for _, replica := range deploymentReplicas(deployment) {
+			if replica.Status != ReplicaStopped {
+				running[replica.ContainerID] = deployment
+			}
+		}
+	}
+	de.mu.RUnlock()
+
+	allocated := make(map[string]bool)
+	for _, allocation := range de.resourceManager.Allocations() {
+		if _, exists := running[allocation.ContainerID]; !exists {
+			de.releaseContainer(allocation.ContainerID)
+			continue
+		}
+		allocated[allocation.ContainerID] = true
+	}
+
+	for containerID, deployment := range running {
+		if !allocated[containerID] {
+			de.allocateContainer(deployment.AppID, containerID, deployment.ResourceLimits)
+		}
+	}
+}
+
+// allocationLimits returns the limits of a container configuration the
+// ledger records
+func allocationLimits(limits docker.ResourceLimits) resources.ResourceLimits {
+	return resources.ResourceLimits{
+		CPULimit:     limits.CPULimit,
+		MemoryLimit:  limits.MemoryLimit,
+		DiskLimit:    limits.DiskLimit,
+		NetworkLimit: limits.NetworkLimit,
+	}
+}
@@ -0,0 +1,114 @@
+package deploy
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"superagent/internal/config"
+	"superagent/internal/deploy/resources"
+)
+
+// quotaEngine is an engine with the given quotas and an empty ledger
+func quotaEngine(t *testing.T, quotas config.QuotasConfig) *DeploymentEngine {
+	t.Helper()
+
+	resourceManager, err := resources.NewResourceManager(testAuditLogger(t))
+	if err != nil {
+		t.Fatalf("NewResourceManager: %v", err)
+	}
+
+	return &DeploymentEngine{
+		config:          &config.Config{Quotas: quotas},
+		resourceManager: resourceManager,
+	}
+}
+
+// errorList joins the messages of errors
+func errorList(errs []error) string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func TestCheckQuotas(t *testing.T) {
+	de := quotaEngine(t, config.QuotasConfig{
+		Apps: map[string]config.QuotaConfig{"web": {CPU: "2", Containers: 3}},
+		Teams: map[string]config.TeamQuotaConfig{
+			"shop": {QuotaConfig: config.QuotaConfig{Memory: "1GB"}, Apps: []string{"web", "api"}},
+		},
+	})
+
+	half := resources.ResourceLimits{CPULimit: 0.5, MemoryLimit: 256 << 20}
+	de.resourceManager.AllocateResources("web", "c1", half)
+	de.resourceManager.AllocateResources("web", "c2", half)
+	de.resourceManager.AllocateResources("api", "c3", resources.ResourceLimits{MemoryLimit: 256 << 20})
+
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		appID      string
+		limits     resources.ResourceLimits
+		containers int
+		replacing  bool
+		want       []string
+	}{
+		{"within quotas", "web", half, 1, false, nil},
+		{"too many containers", "web", resources.ResourceLimits{CPULimit: 0.1, MemoryLimit: 1 << 20}, 2, false, []string{"4 containers exceed quota 3 of app web"}},
+		{"too much cpu", "web", resources.ResourceLimits{CPULimit: 1.5, MemoryLimit: 1 << 20}, 1, false, []string{"CPU of 2.50 cores exceeds quota 2.00 of app web"}},
+		{"team memory", "api", resources.ResourceLimits{MemoryLimit: 512 << 20}, 1, false, []string{"exceeds quota 1073741824 of team shop"}},
+		{"release replaces the app's containers", "web", resources.ResourceLimits{CPULimit: 1, MemoryLimit: 256 << 20}, 2, true, nil},
+		{"limit required by quota", "web", resources.ResourceLimits{}, 1, false, []string{"so a cpu limit is required", "so a memory limit is required"}},
+		{"app without quotas", "worker", resources.ResourceLimits{}, 10, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := de.checkQuotas(ctx, tt.appID, tt.limits, tt.containers, tt.replacing)
+			got := errorList(errs)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("errors:\n%s\nwant %d: %v", got, len(tt.want), tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("errors:\n%s\nwant one containing %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestQuotasReportUsage(t *testing.T) {
+	de := quotaEngine(t, config.QuotasConfig{
+		Apps: map[string]config.QuotaConfig{"web": {Containers: 1}},
+		Teams: map[string]config.TeamQuotaConfig{
+			"shop": {QuotaConfig: config.QuotaConfig{Containers: 5}, Apps: []string{"web", "api"}},
+		},
+	})
+	de.resourceManager.AllocateResources("web", "c1", resources.ResourceLimits{CPULimit: 1})
+	de.resourceManager.AllocateResources("web", "c2", resources.ResourceLimits{CPULimit: 1})
+
+	statuses := de.Quotas(context.Background())
+	if len(statuses) != 2 || statuses[0].Scope != "app" || statuses[1].Scope != "team" {
+		t.Fatalf("quotas %+v, want the app quota before the team quota", statuses)
+	}
+
+	// The quota was lowered below what the app already runs
+	app := statuses[0]
+	if app.Limit.Containers != 1 || app.Limit.CPU != 0 || app.Used.Containers != 2 || app.Used.CPU != 2 {
+		t.Errorf("app quota limit %+v used %+v, want 1 container limited, 2 used", app.Limit, app.Used)
+	}
+	if len(app.Exceeded) != 1 {
+		t.Errorf("exceeded %v, want the container count", app.Exceeded)
+	}
+	if team := statuses[1]; len(team.Exceeded) != 0 || team.Used.Containers != 2 {
+		t.Errorf("team quota used %+v exceeded %v, want 2 containers within the quota", team.Used, team.Exceeded)
+	}
+
+	usage := de.AppQuota(context.Background(), "web")
+	if usage.Team != "shop" || len(usage.Allocations) != 2 || len(usage.Quotas) != 2 {
+		t.Errorf("app quota of web %+v, want team shop with 2 allocations and 2 quotas", usage)
+	}
+}
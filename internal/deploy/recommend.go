@@ -0,0 +1,201 @@
+package deploy
+
+import (
+	"fmt"
+	"time"
+
+	"superagent/internal/deploy/resources"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultRecommendWindow is how much usage history a recommendation
+	// looks at unless told otherwise
+	DefaultRecommendWindow = 7 * 24 * time.Hour
+
+	// DefaultRecommendPercentile is the usage percentile limits are sized to
+	// unless told otherwise
+	DefaultRecommendPercentile = 95.0
+
+	// minRecommendSamples is how many samples a recommendation needs to
+	// say anything about a deployment
+	minRecommendSamples = 10
+
+	// usageHistorySaveInterval is how often the usage history is persisted
+	usageHistorySaveInterval = 15 * time.Minute
+)
+
+// ResourceRecommendation suggests resource limits for the containers of a
+// deployment from the usage of its busiest replica
+type ResourceRecommendation struct {
+	DeploymentID          string                   `json:"deployment_id"`
+	AppID                 string                   `json:"app_id"`
+	Window                time.Duration            `json:"window"`
+	Percentile            float64                  `json:"percentile"`
+	Samples               int                      `json:"samples"`
+	Usage                 resources.ResourceUsage  `json:"usage"` // usage at the percentile
+	Current               resources.ResourceLimits `json:"current"`
+	Recommended           resources.ResourceLimits `json:"recommended"`
+	Efficiency            map[string]float64       `json:"efficiency"`             // usage over the current limits
+	RecommendedEfficiency map[string]float64       `json:"recommended_efficiency"` // usage over the recommended limits
+	AppliedDeploymentID   string                   `json:"applied_deployment_id,omitempty"`
+}
+
+// RecommendResources sizes the limits of a deployment to a percentile of
+// its usage within the window. Zero values take the defaults.
+func (de *DeploymentEngine) RecommendResources(deploymentID string, window time.Duration, percentile float64) (*ResourceRecommendation, error) {
+	if window == 0 {
+		window = DefaultRecommendWindow
+	}
+	if percentile == 0 {
+		percentile = DefaultRecommendPercentile
+	}
+	if window < 0 {
+		return nil, fmt.Errorf("window must be positive")
+	}
+	if percentile < 0 || percentile > 100 {
+		return nil, fmt.Errorf("percentile must be between 0 and 100")
+	}
+
+	deployment, err := de.GetDeployment(deploymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := de.usageHistory.Samples(deploymentID, window)
+	if len(samples) < minRecommendSamples {
+		return nil, fmt.Errorf("deployment %s has %d usage samples within %s, at least %d are needed", deploymentID, len(samples), window, minRecommendSamples)
+	}
+
+	cpu := make([]float64, 0, len(samples))
+	memory := make([]float64, 0, len(samples))
+	for _, sample := range samples {
+		cpu = append(cpu, sample.CPUUsage)
+		memory = append(memory, float64(sample.MemoryUsage))
+	}
+
+	usage := resources.ResourceUsage{
+		CPUUsage:    resources.Percentile(cpu, percentile),
+		MemoryUsage: int64(resources.Percentile(memory, percentile)),
+		Timestamp:   time.Now(),
+	}
+	recommended := de.resourceManager.OptimizeResourceLimits(samples, deployment.ResourceLimits, percentile)
+
+	return &ResourceRecommendation{
+		DeploymentID:          deploymentID,
+		AppID:                 deployment.AppID,
+		Window:                window,
+		Percentile:            percentile,
+		Samples:               len(samples),
+		Usage:                 usage,
+		Current:               deployment.ResourceLimits,
+		Recommended:           recommended,
+		Efficiency:            de.resourceManager.CalculateResourceEfficiency(&usage, deployment.ResourceLimits),
+		RecommendedEfficiency: de.resourceManager.CalculateResourceEfficiency(&usage, recommended),
+	}, nil
+}
+
+// ApplyRecommendation deploys the revision a deployment runs again with the
+// recommended limits, as a new revision of the app
+func (de *DeploymentEngine) ApplyRecommendation(deploymentID string, window time.Duration, percentile float64, triggeredBy string) (*ResourceRecommendation, error) {
+	recommendation, err := de.RecommendResources(deploymentID, window, percentile)
+	if err != nil {
+		return nil, err
+	}
+
+	de.mu.RLock()
+	deployment, exists := de.deployments[deploymentID]
+	if !exists {
+		de.mu.RUnlock()
+		return nil, fmt.Errorf("deployment not found: %s", deploymentID)
+	}
+	status := deployment.Status
+	revision, err := de.findRevision(deployment.AppID, deployment.Revision)
+	de.mu.RUnlock()
+
+	if status != StatusRunning {
+		return nil, fmt.Errorf("deployment %s is %s, only running deployments can be resized", deploymentID, status)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := redeployRequest(revision, triggeredBy)
+	if err != nil {
+		return nil, err
+	}
+	request.ResourceLimits = recommendation.Recommended
+
+	resized, err := de.createDeployment(request, revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deploy recommended limits: %w", err)
+	}
+	recommendation.AppliedDeploymentID = resized.ID
+
+	de.auditLogger.LogEvent("RESOURCE_RECOMMENDATION_APPLIED", map[string]interface{}{
+		"deployment_id":     deploymentID,
+		"app_id":            deployment.AppID,
+		"new_deployment_id": resized.ID,
+		"new_revision":      resized.Revision,
+		"percentile":        recommendation.Percentile,
+		"window":            recommendation.Window.String(),
+		"cpu_limit":         recommendation.Recommended.CPULimit,
+		"memory_limit":      recommendation.Recommended.MemoryLimit,
+		"triggered_by":      triggeredBy,
+	})
+
+	return recommendation, nil
+}
+
+// recordUsage adds a sample of the busiest replica of a deployment to its
+// usage history. Limits apply per container, so that is what they are
+// sized to.
+func (de *DeploymentEngine) recordUsage(deployment *Deployment, replicas []DeploymentMetrics) {
+	peak := resources.ResourceUsage{Timestamp: time.Now()}
+	for _, metrics := range replicas {
+		peak.CPUUsage = max(peak.CPUUsage, metrics.CPUUsage)
+		peak.MemoryUsage = max(peak.MemoryUsage, metrics.MemoryUsage)
+		peak.MemoryLimit = max(peak.MemoryLimit, metrics.MemoryLimit)
+		peak.NetworkRx += metrics.NetworkRx
+		peak.NetworkTx += metrics.NetworkTx
+	}
+
+	de.usageHistory.Record(deployment.ID, peak)
+}
+
+// loadUsageHistory restores the usage history from storage
+func (de *DeploymentEngine) loadUsageHistory() error {
+	state, err := de.store.LoadUsageHistory()
+	if err != nil {
+		return err
+	}
+
+	if err := decodeState(state, de.usageHistory); err != nil {
+		return err
+	}
+
+	// Deployments removed while the agent was down keep no history
+	de.mu.RLock()
+	defer de.mu.RUnlock()
+	for _, deploymentID := range de.usageHistory.Keys() {
+		if _, exists := de.deployments[deploymentID]; !exists {
+			de.usageHistory.Remove(deploymentID)
+		}
+	}
+
+	return nil
+}
+
+// saveUsageHistory persists the usage history
+func (de *DeploymentEngine) saveUsageHistory() {
+	state, err := encodeState(de.usageHistory)
+	if err != nil {
+		logrus.Warnf("Failed to encode usage history: %v", err)
+		return
+	}
+
+	if err := de.store.StoreUsageHistory(state); err != nil {
+		logrus.Warnf("Failed to store usage history: %v", err)
+	}
+}
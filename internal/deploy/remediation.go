@@ -0,0 +1,355 @@
+package deploy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRemediationMaxRestarts = 3
+	defaultRemediationBackoff     = 10 * time.Second
+	defaultRemediationMaxBackoff  = 5 * time.Minute
+	defaultCrashLoopRestarts      = 5
+	defaultCrashLoopWindow        = 10 * time.Minute
+
+	// remediationResetAfter is how long a deployment has to stay healthy
+	// before earlier restart attempts no longer count against it
+	remediationResetAfter = 30 * time.Minute
+
+	// maxRemediationActions is how many remediation actions are kept on a
+	// deployment
+	maxRemediationActions = 20
+
+	RemediationRestart   = "restart"
+	RemediationRollback  = "rollback"
+	RemediationCrashLoop = "crash_loop"
+	RemediationGaveUp    = "gave_up"
+)
+
+// RemediationPolicy controls what the engine does about a running deployment
+// that stops passing its health checks or keeps crashing. The deployment is
+// restarted with a growing backoff first; once the restarts are used up it is
+// rolled back to the previous revision.
+type RemediationPolicy struct {
+	Enabled           bool          `json:"enabled"`
+	MaxRestarts       int           `json:"max_restarts,omitempty"`
+	RestartBackoff    time.Duration `json:"restart_backoff,omitempty"` // wait before the first restart, doubled for each further one
+	MaxBackoff        time.Duration `json:"max_backoff,omitempty"`
+	AutoRollback      bool          `json:"auto_rollback"`
+	CrashLoopRestarts int           `json:"crash_loop_restarts,omitempty"` // container restarts within the window that make a crash loop
+	CrashLoopWindow   time.Duration `json:"crash_loop_window,omitempty"`
+}
+
+// RemediationStatus records what the engine did about a failing deployment
+type RemediationStatus struct {
+	Restarts    int                 `json:"restarts"` // restart attempts since the deployment was last healthy for a while
+	LastFailure *time.Time          `json:"last_failure,omitempty"`
+	Actions     []RemediationAction `json:"actions"`
+}
+
+// RemediationAction is a single step taken to bring a deployment back
+type RemediationAction struct {
+	DeploymentID string    `json:"deployment_id"`
+	AppID        string    `json:"app_id"`
+	Version      string    `json:"version"`
+	Action       string    `json:"action"` // "restart", "rollback", "crash_loop" or "gave_up"
+	Attempt      int       `json:"attempt,omitempty"`
+	Reason       string    `json:"reason"`
+	Succeeded    bool      `json:"succeeded"`
+	Error        string    `json:"error,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// RemediationReporter is told about every remediation action
+type RemediationReporter func(deployment *Deployment, action RemediationAction)
+
+// restartSample is the total restart count of a deployment's containers at
+// one point in time
+type restartSample struct {
+	restarts int
+	at       time.Time
+}
+
+// SetRemediationReporter registers the function remediation actions are
+// reported to, in addition to the audit log
+func (de *DeploymentEngine) SetRemediationReporter(reporter RemediationReporter) {
+	de.monitorMu.Lock()
+	defer de.monitorMu.Unlock()
+
+	de.reportRemediation = reporter
+}
+
+// startRemediation starts remediating a deployment in the background. It
+// reports false when the deployment has no remediation policy.
+func (de *DeploymentEngine) startRemediation(deployment *Deployment, reason string, crashLoop bool) bool {
+	if !deployment.Config.Remediation.Enabled {
+		return false
+	}
+
+	de.monitorMu.Lock()
+	defer de.monitorMu.Unlock()
+
+	if de.remediating[deployment.ID] {
+		return true // Already being remediated
+	}
+	de.remediating[deployment.ID] = true
+
+	de.wg.Add(1)
+	go de.remediate(deployment, reason, crashLoop)
+
+	return true
+}
+
+// remediate restarts a failing deployment until the policy's restarts are
+// used up and then rolls it back. A crash-looping deployment is rolled back
+// right away since the container runtime already keeps restarting it.
+func (de *DeploymentEngine) remediate(deployment *Deployment, reason string, crashLoop bool) {
+	defer de.wg.Done()
+	defer func() {
+		de.monitorMu.Lock()
+		delete(de.remediating, deployment.ID)
+		de.monitorMu.Unlock()
+	}()
+
+	policy := deployment.Config.Remediation
+
+	// The API reads the remediation status under the lock, so it is only
+	// changed under it
+	de.mu.Lock()
+	if deployment.Remediation == nil {
+		deployment.Remediation = &RemediationStatus{Actions: []RemediationAction{}}
+	}
+	status := deployment.Remediation
+
+	now := time.Now()
+	if status.LastFailure != nil && now.Sub(*status.LastFailure) > remediationResetAfter {
+		status.Restarts = 0
+	}
+	status.LastFailure = &now
+	restarts := status.Restarts
+	de.mu.Unlock()
+
+	if crashLoop {
+		logrus.Warnf("Deployment %s is crash-looping: %s", deployment.ID, reason)
+		de.recordRemediation(deployment, RemediationCrashLoop, 0, reason, nil)
+	} else {
+		for restarts < remediationMaxRestarts(policy) {
+			de.mu.Lock()
+			status.Restarts++
+			restarts = status.Restarts
+			de.mu.Unlock()
+
+			backoff := remediationBackoff(policy, restarts)
+
+			de.addDeploymentLog(deployment, "warn", fmt.Sprintf("Restarting in %s (attempt %d/%d): %s", backoff, restarts, remediationMaxRestarts(policy), reason))
+
+			select {
+			case <-de.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			// Another operation may have taken over in the meantime
+			if current := de.deploymentStatus(deployment); current != StatusRunning && current != StatusFailed {
+				logrus.Infof("Stopping remediation of deployment %s, it is now %s", deployment.ID, current)
+				return
+			}
+
+			err := de.RestartDeployment(deployment.ID)
+			de.recordRemediation(deployment, RemediationRestart, restarts, reason, err)
+			if err == nil {
+				return
+			}
+		}
+	}
+
+	if !policy.AutoRollback {
+		de.recordRemediation(deployment, RemediationGaveUp, restarts, reason, nil)
+		if de.deploymentStatus(deployment) != StatusFailed {
+			de.handleDeploymentError(deployment, fmt.Errorf("remediation gave up: %s", reason))
+		}
+		return
+	}
+
+	_, err := de.Rollback(deployment.ID, fmt.Sprintf("automatic rollback: %s", reason))
+	de.recordRemediation(deployment, RemediationRollback, 0, reason, err)
+	if err != nil {
+		if de.deploymentStatus(deployment) != StatusFailed {
+			de.handleDeploymentError(deployment, fmt.Errorf("automatic rollback failed: %w", err))
+		}
+		return
+	}
+
+	// The restored revision starts with a clean slate
+	de.mu.Lock()
+	status.Restarts = 0
+	de.mu.Unlock()
+
+	de.monitorMu.Lock()
+	delete(de.restartSamples, deployment.ID)
+	de.monitorMu.Unlock()
+}
+
+// deploymentStatus reads the status of a deployment under the lock
+func (de *DeploymentEngine) deploymentStatus(deployment *Deployment) DeploymentStatus {
+	de.mu.RLock()
+	defer de.mu.RUnlock()
+
+	return deployment.Status
+}
+
+// recordRemediation logs a remediation action on the deployment, in the
+// audit log and with the reporter
+func (de *DeploymentEngine) recordRemediation(deployment *Deployment, kind string, attempt int, reason string, err error) {
+	action := RemediationAction{
+		DeploymentID: deployment.ID,
+		AppID:        deployment.AppID,
+		Version:      deployment.Version,
+		Action:       kind,
+		Attempt:      attempt,
+		Reason:       reason,
+		Succeeded:    err == nil && kind != RemediationGaveUp && kind != RemediationCrashLoop,
+		Timestamp:    time.Now(),
+	}
+	if err != nil {
+		action.Error = err.Error()
+	}
+
+	de.mu.Lock()
+	if status := deployment.Remediation; status != nil {
+		status.Actions = append(status.Actions, action)
+		if len(status.Actions) > maxRemediationActions {
+			status.Actions = status.Actions[len(status.Actions)-maxRemediationActions:]
+		}
+	}
+	de.mu.Unlock()
+
+	switch {
+	case err != nil:
+		de.addDeploymentLog(deployment, "error", fmt.Sprintf("Remediation %s failed: %v", kind, err))
+	case action.Succeeded:
+		de.addDeploymentLog(deployment, "info", fmt.Sprintf("Remediation %s succeeded", kind))
+	default:
+		de.addDeploymentLog(deployment, "warn", fmt.Sprintf("Remediation %s: %s", kind, reason))
+	}
+	de.saveDeployment(deployment)
+
+	details := map[string]interface{}{
+		"deployment_id": deployment.ID,
+		"app_id":        deployment.AppID,
+		"version":       deployment.Version,
+		"action":        kind,
+		"attempt":       attempt,
+		"reason":        reason,
+		"succeeded":     action.Succeeded,
+	}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+	de.auditLogger.LogEvent("DEPLOYMENT_REMEDIATION", details)
+
+	de.monitorMu.Lock()
+	reporter := de.reportRemediation
+	de.monitorMu.Unlock()
+
+	if reporter != nil {
+		reporter(deployment, action)
+	}
+}
+
+// detectCrashLoop samples the restart count of a deployment's containers and
+// reports whether it grew past the crash loop threshold within the window
+func (de *DeploymentEngine) detectCrashLoop(deployment *Deployment) (int, bool) {
+	policy := deployment.Config.Remediation
+	if !policy.Enabled {
+		return 0, false
+	}
+
+	de.monitorMu.Lock()
+	defer de.monitorMu.Unlock()
+
+	now := time.Now()
+	samples := de.restartSamples[deployment.ID]
+
+	// A recreated container starts counting from zero again
+	if len(samples) > 0 && deployment.Metrics.RestartCount < samples[len(samples)-1].restarts {
+		samples = nil
+	}
+
+	window := crashLoopWindow(policy)
+	kept := samples[:0]
+	for _, sample := range samples {
+		if now.Sub(sample.at) <= window {
+			kept = append(kept, sample)
+		}
+	}
+	samples = append(kept, restartSample{restarts: deployment.Metrics.RestartCount, at: now})
+
+	restarts := samples[len(samples)-1].restarts - samples[0].restarts
+	if restarts >= crashLoopRestarts(policy) {
+		// Start over so the same restarts are not reported twice
+		delete(de.restartSamples, deployment.ID)
+		return restarts, true
+	}
+
+	de.restartSamples[deployment.ID] = samples
+	return restarts, false
+}
+
+// validateRemediation rejects remediation policies with negative limits
+func validateRemediation(policy RemediationPolicy) error {
+	if policy.MaxRestarts < 0 || policy.CrashLoopRestarts < 0 {
+		return fmt.Errorf("invalid remediation policy, restart limits must not be negative")
+	}
+
+	if policy.RestartBackoff < 0 || policy.MaxBackoff < 0 || policy.CrashLoopWindow < 0 {
+		return fmt.Errorf("invalid remediation policy, durations must not be negative")
+	}
+
+	return nil
+}
+
+func remediationMaxRestarts(policy RemediationPolicy) int {
+	if policy.MaxRestarts > 0 {
+		return policy.MaxRestarts
+	}
+	return defaultRemediationMaxRestarts
+}
+
+// remediationBackoff doubles the wait before each further restart attempt
+func remediationBackoff(policy RemediationPolicy, attempt int) time.Duration {
+	backoff := policy.RestartBackoff
+	if backoff <= 0 {
+		backoff = defaultRemediationBackoff
+	}
+
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRemediationMaxBackoff
+	}
+
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	return backoff
+}
+
+func crashLoopRestarts(policy RemediationPolicy) int {
+	if policy.CrashLoopRestarts > 0 {
+		return policy.CrashLoopRestarts
+	}
+	return defaultCrashLoopRestarts
+}
+
+func crashLoopWindow(policy RemediationPolicy) time.Duration {
+	if policy.CrashLoopWindow > 0 {
+		return policy.CrashLoopWindow
+	}
+	return defaultCrashLoopWindow
+}
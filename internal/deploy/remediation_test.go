@@ -0,0 +1,122 @@
+package deploy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRemediationBackoff(t *testing.T) {
+	policy := RemediationPolicy{RestartBackoff: 10 * time.Second, MaxBackoff: time.Minute}
+
+	tests := []struct {
+		policy  RemediationPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{policy, 1, 10 * time.Second},
+		{policy, 2, 20 * time.Second},
+		{policy, 3, 40 * time.Second},
+		{policy, 4, time.Minute},
+		{policy, 10, time.Minute},
+		{RemediationPolicy{}, 1, defaultRemediationBackoff},
+		{RemediationPolicy{}, 20, defaultRemediationMaxBackoff},
+		{RemediationPolicy{RestartBackoff: 2 * time.Minute, MaxBackoff: time.Minute}, 1, time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := remediationBackoff(tt.policy, tt.attempt); got != tt.want {
+			t.Errorf("remediationBackoff(%+v, %d) = %s, want %s", tt.policy, tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestRemediationDefaults(t *testing.T) {
+	if got := remediationMaxRestarts(RemediationPolicy{}); got != defaultRemediationMaxRestarts {
+		t.Errorf("default max restarts %d, want %d", got, defaultRemediationMaxRestarts)
+	}
+	if got := crashLoopRestarts(RemediationPolicy{CrashLoopRestarts: 2}); got != 2 {
+		t.Errorf("crash loop restarts %d, want 2", got)
+	}
+	if got := crashLoopWindow(RemediationPolicy{}); got != defaultCrashLoopWindow {
+		t.Errorf("default crash loop window %s, want %s", got, defaultCrashLoopWindow)
+	}
+}
+
+func TestValidateRemediation(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RemediationPolicy
+		valid  bool
+	}{
+		{"defaults", RemediationPolicy{Enabled: true}, true},
+		{"custom", RemediationPolicy{Enabled: true, MaxRestarts: 5, RestartBackoff: time.Second, CrashLoopWindow: time.Minute}, true},
+		{"negative restarts", RemediationPolicy{MaxRestarts: -1}, false},
+		{"negative crash loop restarts", RemediationPolicy{CrashLoopRestarts: -1}, false},
+		{"negative backoff", RemediationPolicy{RestartBackoff: -time.Second}, false},
+		{"negative window", RemediationPolicy{CrashLoopWindow: -time.Second}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRemediation(tt.policy)
+			if tt.valid && err != nil {
+				t.Errorf("validateRemediation() = %v, want no error", err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("validateRemediation() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestDetectCrashLoop(t *testing.T) {
+	de := &DeploymentEngine{restartSamples: make(map[string][]restartSample)}
+	deployment := &Deployment{ID: "d1", Config: DeploymentConfig{Remediation: RemediationPolicy{
+		Enabled:           true,
+		CrashLoopRestarts: 3,
+		CrashLoopWindow:   time.Minute,
+	}}}
+
+	observe := func(restartCount int) (int, bool) {
+		deployment.Metrics.RestartCount = restartCount
+		return de.detectCrashLoop(deployment)
+	}
+
+	if restarts, loop := observe(1); restarts != 0 || loop {
+		t.Fatalf("first sample = %d, %v, want 0, false", restarts, loop)
+	}
+	if restarts, loop := observe(3); restarts != 2 || loop {
+		t.Fatalf("second sample = %d, %v, want 2, false", restarts, loop)
+	}
+	if restarts, loop := observe(4); restarts != 3 || !loop {
+		t.Fatalf("third sample = %d, %v, want 3, true", restarts, loop)
+	}
+
+	// The samples start over once a crash loop was reported
+	if restarts, loop := observe(5); restarts != 0 || loop {
+		t.Fatalf("sample after the crash loop = %d, %v, want 0, false", restarts, loop)
+	}
+
+	// Restarts older than the window no longer count
+	de.restartSamples["d1"] = []restartSample{{restarts: 5, at: time.Now().Add(-2 * time.Minute)}}
+	if restarts, loop := observe(8); restarts != 0 || loop {
+		t.Fatalf("sample after the window passed = %d, %v, want 0, false", restarts, loop)
+	}
+
+	// A recreated container counts from zero again
+	if restarts, loop := observe(0); restarts != 0 || loop {
+		t.Fatalf("sample of a recreated container = %d, %v, want 0, false", restarts, loop)
+	}
+}
+
+func TestDetectCrashLoopDisabled(t *testing.T) {
+	de := &DeploymentEngine{restartSamples: make(map[string][]restartSample)}
+	deployment := &Deployment{ID: "d1", Metrics: DeploymentMetrics{RestartCount: 100}}
+
+	if _, loop := de.detectCrashLoop(deployment); loop {
+		t.Fatalf("crash loop reported without remediation")
+	}
+	if len(de.restartSamples) != 0 {
+		t.Fatalf("restarts sampled without remediation")
+	}
+}
@@ -0,0 +1,529 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"superagent/internal/deploy/docker"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Replica is one of the containers that make up a deployment
+type Replica struct {
+	Index               int               `json:"index"`
+	ContainerID         string            `json:"container_id"`
+	ContainerName       string            `json:"container_name"`
+	Status              ReplicaStatus     `json:"status"`
+	Ports               []PortMapping     `json:"ports"`
+	HealthCheckFailures int               `json:"health_check_failures"`
+	LastHealthCheck     *time.Time        `json:"last_health_check,omitempty"`
+	Metrics             DeploymentMetrics `json:"metrics"`
+	CreatedAt           time.Time         `json:"created_at"`
+}
+
+// ReplicaStatus represents the current state of a replica
+type ReplicaStatus string
+
+const (
+	ReplicaStarting  ReplicaStatus = "starting"
+	ReplicaRunning   ReplicaStatus = "running"
+	ReplicaUnhealthy ReplicaStatus = "unhealthy"
+	ReplicaStopped   ReplicaStatus = "stopped"
+	ReplicaFailed    ReplicaStatus = "failed"
+)
+
+// Scale changes the number of replica containers of a running deployment
+func (de *DeploymentEngine) Scale(deploymentID string, replicas int) error {
+	if replicas < 1 {
+		return fmt.Errorf("replica count must be at least 1, got %d", replicas)
+	}
+
+	// Wait for earlier operations on the app to finish
+	release, err := de.lockDeploymentApp(deploymentID)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	de.mu.Lock()
+	deployment, exists := de.deployments[deploymentID]
+	if !exists {
+		de.mu.Unlock()
+		return fmt.Errorf("deployment not found: %s", deploymentID)
+	}
+
+	if deployment.Status != StatusRunning {
+		de.mu.Unlock()
+		return fmt.Errorf("deployment %s cannot be scaled while %s", deploymentID, deployment.Status)
+	}
+
+	if policy := deployment.Config.Autoscaling; policy.Enabled && (replicas < policy.MinReplicas || replicas > policy.MaxReplicas) {
+		de.mu.Unlock()
+		return fmt.Errorf("deployment %s is autoscaled between %d and %d replicas, got %d", deploymentID, policy.MinReplicas, policy.MaxReplicas, replicas)
+	}
+
+	// Claim the deployment so no other operation changes its replicas
+	deployment.Status = StatusUpdating
+	existing := deploymentReplicas(deployment)
+	current := make([]*Replica, len(existing))
+	copy(current, existing)
+	de.mu.Unlock()
+
+	de.updateDeploymentStatus(deployment, StatusUpdating)
+
+	previousCount := len(current)
+
+	ctx, cancel := context.WithTimeout(de.ctx, progressTimeout(deployment))
+	defer cancel()
+
+	if replicas > previousCount {
+		errs := de.checkQuotas(ctx, deployment.AppID, deployment.ResourceLimits, replicas-previousCount, false)
+		if err := de.checkCapacity(ctx, deployment.AppID, deployment.ResourceLimits, replicas-previousCount, false); err != nil {
+			errs = append(errs, err)
+		}
+
+		if len(errs) > 0 {
+			de.updateDeploymentStatus(deployment, StatusRunning)
+
+			de.auditLogger.LogEvent("DEPLOYMENT_SCALE_REJECTED", map[string]interface{}{
+				"deployment_id": deploymentID,
+				"replicas":      replicas,
+				"error":         errors.Join(errs...).Error(),
+			})
+
+			return fmt.Errorf("scaling to %d replicas was rejected: %w", replicas, errors.Join(errs...))
+		}
+	}
+
+	switch {
+	case replicas > previousCount:
+		err = de.scaleUp(ctx, deployment, current, replicas-previousCount)
+	case replicas < previousCount:
+		de.scaleDown(deployment, current, previousCount-replicas)
+	}
+
+	if err != nil {
+		de.addDeploymentLog(deployment, "error", fmt.Sprintf("Failed to scale to %d replicas: %v", replicas, err))
+		de.updateDeploymentStatus(deployment, StatusRunning)
+
+		de.auditLogger.LogEvent("DEPLOYMENT_SCALE_FAILED", map[string]interface{}{
+			"deployment_id": deploymentID,
+			"replicas":      replicas,
+			"error":         err.Error(),
+		})
+
+		return fmt.Errorf("failed to scale deployment: %w", err)
+	}
+
+	deployment.Config.Replicas = replicas
+
+	// Keep the route of a live blue-green set in line with its replicas
+	de.refreshRoute(deployment)
+
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Scaled from %d to %d replicas", previousCount, replicas))
+	de.updateDeploymentStatus(deployment, StatusRunning)
+
+	de.auditLogger.LogEvent("DEPLOYMENT_SCALED", map[string]interface{}{
+		"deployment_id":     deploymentID,
+		"previous_replicas": previousCount,
+		"replicas":          replicas,
+	})
+
+	return nil
+}
+
+// scaleUp adds replicas to a deployment, keeping it unchanged on failure
+func (de *DeploymentEngine) scaleUp(ctx context.Context, deployment *Deployment, current []*Replica, count int) error {
+	nextIndex := 0
+	for _, replica := range current {
+		if replica.Index >= nextIndex {
+			nextIndex = replica.Index + 1
+		}
+	}
+
+	added, err := de.startReplicas(ctx, deployment, de.replicaBaseConfig(deployment), deployment.Ports, nextIndex, count, nil)
+	if err != nil {
+		return err
+	}
+
+	if deployment.HealthCheck.Enabled {
+		if err := de.checkReplicas(ctx, deployment, added, deployment.HealthCheck, deployment.Ports); err != nil {
+			de.removeReplicas(added)
+			return err
+		}
+	}
+
+	de.setReplicas(deployment, append(current, added...))
+	return nil
+}
+
+// scaleDown removes the newest replicas of a deployment
+func (de *DeploymentEngine) scaleDown(deployment *Deployment, current []*Replica, count int) {
+	keep := current[:len(current)-count]
+	removed := current[len(current)-count:]
+
+	de.setReplicas(deployment, keep)
+	de.removeReplicas(removed)
+}
+
+// replicaBaseConfig rebuilds the container configuration the replicas of a
+// deployment are created from
+func (de *DeploymentEngine) replicaBaseConfig(deployment *Deployment) docker.ContainerConfig {
+	base := de.buildContainerConfig(deployment, deployment.ImageID)
+	base.Name = fmt.Sprintf("superagent-%s", deployment.ID)
+	return base
+}
+
+// startReplicas creates and starts count replicas of a container
+// configuration, numbered from firstIndex. Replicas that were started are
+// removed again if a later one fails. The output of each replica is captured
+// into the logs of the deployment.
+func (de *DeploymentEngine) startReplicas(ctx context.Context, deployment *Deployment, base docker.ContainerConfig, basePorts []PortMapping, firstIndex, count int, released map[string]bool) ([]*Replica, error) {
+	appID := deployment.AppID
+	replicas := make([]*Replica, 0, count)
+	assigned := make(map[int]bool)
+
+	limits := allocationLimits(base.ResourceLimits)
+
+	for index := firstIndex; index < firstIndex+count; index++ {
+		release, err := de.reserveCapacity(ctx, fmt.Sprintf("app %s replica %d", appID, index), limits)
+		if err != nil {
+			de.removeReplicas(replicas)
+			return nil, fmt.Errorf("cannot start replica %d: %w", index, err)
+		}
+
+		ports, err := de.allocateReplicaPorts(appID, basePorts, index, released, assigned)
+		if err != nil {
+			release()
+			de.removeReplicas(replicas)
+			return nil, fmt.Errorf("failed to allocate ports for replica %d: %w", index, err)
+		}
+
+		containerConfig := replicaContainerConfig(base, index, ports)
+
+		containerID, err := de.deployContainer(ctx, containerConfig)
+		if err != nil {
+			release()
+			de.removeReplicas(replicas)
+			return nil, fmt.Errorf("failed to start replica %d: %w", index, err)
+		}
+		de.allocateContainer(appID, containerID, limits)
+		release()
+		de.captureContainerLogs(deployment.ID, containerID)
+
+		replicas = append(replicas, &Replica{
+			Index:         index,
+			ContainerID:   containerID,
+			ContainerName: containerConfig.Name,
+			Status:        ReplicaStarting,
+			Ports:         ports,
+			CreatedAt:     time.Now(),
+		})
+	}
+
+	return replicas, nil
+}
+
+// checkReplicas runs the initial health check against every replica
+func (de *DeploymentEngine) checkReplicas(ctx context.Context, deployment *Deployment, replicas []*Replica, healthCheck HealthCheckConfig, basePorts []PortMapping) error {
+	for _, replica := range replicas {
+		if err := de.performContainerHealthCheck(ctx, replica.ContainerID, replicaHealthCheck(healthCheck, basePorts, replica.Ports)); err != nil {
+			replica.Status = ReplicaUnhealthy
+			de.publishHealth(deployment, replica, 0, err)
+			return fmt.Errorf("replica %d: %w", replica.Index, err)
+		}
+
+		now := time.Now()
+		replica.LastHealthCheck = &now
+		replica.Status = ReplicaRunning
+		de.publishHealth(deployment, replica, 0, nil)
+	}
+
+	return nil
+}
+
+// probeReplica runs a single health probe against a replica and returns how
+// long it took to answer
+func (de *DeploymentEngine) probeReplica(ctx context.Context, deployment *Deployment, replica *Replica) (time.Duration, error) {
+	healthCheck := replicaHealthCheck(deployment.HealthCheck, deployment.Ports, replica.Ports)
+
+	result, err := de.lifecycleManager.GetContainerHealth(ctx, replica.ContainerID, convertHealthCheckConfig(healthCheck))
+	if err != nil {
+		return 0, err
+	}
+
+	if !result.Success {
+		return result.Duration, fmt.Errorf("health check failed: %s", result.Message)
+	}
+
+	return result.Duration, nil
+}
+
+// probeStats summarises the outcome of a number of health probes
+type probeStats struct {
+	probes   int
+	failures int
+	latency  time.Duration
+}
+
+func (ps *probeStats) record(latency time.Duration, err error) {
+	ps.probes++
+	ps.latency += latency
+	if err != nil {
+		ps.failures++
+	}
+}
+
+func (ps *probeStats) errorRate() float64 {
+	if ps.probes == 0 {
+		return 0
+	}
+	return float64(ps.failures) / float64(ps.probes)
+}
+
+func (ps *probeStats) averageLatency() time.Duration {
+	if ps.probes == 0 {
+		return 0
+	}
+	return ps.latency / time.Duration(ps.probes)
+}
+
+// removeReplicas stops and removes replica containers
+func (de *DeploymentEngine) removeReplicas(replicas []*Replica) {
+	for _, replica := range replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := de.dockerManager.StopContainer(ctx, replica.ContainerID, 10); err != nil {
+			logrus.Warnf("Failed to stop container %s: %v", replica.ContainerID, err)
+		}
+		if err := de.dockerManager.RemoveContainer(ctx, replica.ContainerID, true); err != nil {
+			logrus.Warnf("Failed to remove container %s: %v", replica.ContainerID, err)
+		}
+		cancel()
+		de.releaseContainer(replica.ContainerID)
+
+		replica.Status = ReplicaStopped
+	}
+}
+
+// setReplicas replaces the replicas of a deployment. The first replica is
+// also exposed through ContainerID and ContainerName.
+func (de *DeploymentEngine) setReplicas(deployment *Deployment, replicas []*Replica) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	deployment.Replicas = replicas
+	deployment.ContainerID = ""
+	deployment.ContainerName = ""
+	if len(replicas) > 0 {
+		deployment.ContainerID = replicas[0].ContainerID
+		deployment.ContainerName = replicas[0].ContainerName
+	}
+}
+
+// deploymentReplicas returns the replicas of a deployment. Deployments
+// recorded before replicas were tracked expose their single container.
+func deploymentReplicas(deployment *Deployment) []*Replica {
+	if len(deployment.Replicas) == 0 && deployment.ContainerID != "" {
+		deployment.Replicas = []*Replica{{
+			Index:         0,
+			ContainerID:   deployment.ContainerID,
+			ContainerName: deployment.ContainerName,
+			Status:        ReplicaRunning,
+			Ports:         deployment.Ports,
+			CreatedAt:     deployment.CreatedAt,
+		}}
+	}
+	return deployment.Replicas
+}
+
+// desiredReplicas returns how many replicas a deployment asks for, within
+// the bounds of its autoscaling policy
+func desiredReplicas(deployment *Deployment) int {
+	replicas := 1
+	if deployment.Config.Replicas > 0 {
+		replicas = deployment.Config.Replicas
+	}
+
+	if policy := deployment.Config.Autoscaling; policy.Enabled {
+		replicas = min(max(replicas, policy.MinReplicas), policy.MaxReplicas)
+	}
+	return replicas
+}
+
+// replicaContainerConfig derives the container configuration of one replica
+func replicaContainerConfig(base docker.ContainerConfig, index int, ports []PortMapping) docker.ContainerConfig {
+	containerConfig := base
+	if index > 0 {
+		containerConfig.Name = fmt.Sprintf("%s-%d", base.Name, index)
+	}
+	containerConfig.Ports = convertPortMappings(ports)
+
+	containerConfig.Labels = make(map[string]string, len(base.Labels)+1)
+	for key, value := range base.Labels {
+		containerConfig.Labels[key] = value
+	}
+	containerConfig.Labels["superagent.replica"] = strconv.Itoa(index)
+
+	return containerConfig
+}
+
+// allocateReplicaPorts picks the host ports of a replica. A replica that
+// replaces a released one of the same app and index takes over its ports, so
+// replicas keep their ports across updates. Otherwise the first replica binds
+// the configured ports. Only while it runs next to the deployment an update
+// replaces does it move up when that deployment still holds them; any other
+// holder is a port conflict. Every other replica gets the closest port above
+// that no known replica holds, so replicas never collide with each other or
+// other deployments. Ports bound outside the agent are left to the container
+// runtime to report.
+func (de *DeploymentEngine) allocateReplicaPorts(appID string, basePorts []PortMapping, index int, released map[string]bool, assigned map[int]bool) ([]PortMapping, error) {
+	ports := make([]PortMapping, len(basePorts))
+	copy(ports, basePorts)
+
+	reserved := de.reservedHostPorts(released)
+	previous := de.releasedReplicaPorts(appID, index, released)
+
+	for i, port := range ports {
+		if port.HostPort == 0 {
+			continue
+		}
+
+		if hostPort := previousHostPort(previous, port); hostPort != 0 {
+			if _, taken := reserved[hostPort]; !taken && !assigned[hostPort] {
+				ports[i].HostPort = hostPort
+				assigned[hostPort] = true
+				continue
+			}
+		}
+
+		if index == 0 {
+			holder, taken := reserved[port.HostPort]
+			if !taken && !assigned[port.HostPort] {
+				assigned[port.HostPort] = true
+				continue
+			}
+			if taken && (holder.appID != appID || !holder.updating) {
+				return nil, fmt.Errorf("host port %d is already bound by deployment %s of app %s", port.HostPort, holder.deploymentID, holder.appID)
+			}
+		}
+
+		hostPort := port.HostPort + index
+		for hostPort <= 65535 {
+			if _, taken := reserved[hostPort]; !taken && !assigned[hostPort] {
+				break
+			}
+			hostPort++
+		}
+
+		if hostPort > 65535 {
+			return nil, fmt.Errorf("no free host port above %d", port.HostPort)
+		}
+
+		ports[i].HostPort = hostPort
+		assigned[hostPort] = true
+	}
+
+	return ports, nil
+}
+
+// releasedReplicaPorts returns the ports of the released replica of an app
+// with the given index, nil when there is none
+func (de *DeploymentEngine) releasedReplicaPorts(appID string, index int, released map[string]bool) []PortMapping {
+	if len(released) == 0 {
+		return nil
+	}
+
+	de.mu.RLock()
+	defer de.mu.RUnlock()
+
+	for _, deployment := range de.deployments {
+		if deployment.AppID != appID {
+			continue
+		}
+		for _, replica := range deployment.Replicas {
+			if replica.Index == index && released[replica.ContainerID] {
+				return replica.Ports
+			}
+		}
+	}
+
+	return nil
+}
+
+// previousHostPort returns the host port a released replica bound for the
+// same container port, 0 when it bound none
+func previousHostPort(previous []PortMapping, port PortMapping) int {
+	for _, mapping := range previous {
+		if mapping.ContainerPort == port.ContainerPort && mapping.Protocol == port.Protocol && mapping.HostIP == port.HostIP {
+			return mapping.HostPort
+		}
+	}
+	return 0
+}
+
+// portHolder is the deployment whose replica binds a host port
+type portHolder struct {
+	appID        string
+	deploymentID string
+	updating     bool // being replaced by an update of the app
+}
+
+// reservedHostPorts returns the host ports bound by known replicas, except
+// those of released containers, along with the deployment that holds them
+func (de *DeploymentEngine) reservedHostPorts(released map[string]bool) map[int]portHolder {
+	de.mu.RLock()
+	defer de.mu.RUnlock()
+
+	reserved := make(map[int]portHolder)
+	for _, deployment := range de.deployments {
+		holder := portHolder{
+			appID:        deployment.AppID,
+			deploymentID: deployment.ID,
+			updating:     deployment.Status == StatusUpdating,
+		}
+		for _, replica := range deployment.Replicas {
+			if released[replica.ContainerID] || replica.Status == ReplicaStopped {
+				continue
+			}
+			for _, port := range replica.Ports {
+				if port.HostPort != 0 {
+					reserved[port.HostPort] = holder
+				}
+			}
+		}
+	}
+
+	return reserved
+}
+
+// replicaHealthCheck points a health check at the host port of a replica
+// that corresponds to the configured port
+func replicaHealthCheck(healthCheck HealthCheckConfig, basePorts, replicaPorts []PortMapping) HealthCheckConfig {
+	for i, port := range basePorts {
+		if i >= len(replicaPorts) {
+			break
+		}
+		if healthCheck.Port != 0 && (healthCheck.Port == port.HostPort || (port.HostPort == 0 && healthCheck.Port == port.ContainerPort)) {
+			healthCheck.Port = replicaPorts[i].HostPort
+			break
+		}
+	}
+	return healthCheck
+}
+
+func portMappingsFromDocker(ports []docker.PortMapping) []PortMapping {
+	mappings := make([]PortMapping, len(ports))
+	for i, port := range ports {
+		mappings[i] = PortMapping{
+			ContainerPort: port.ContainerPort,
+			HostPort:      port.HostPort,
+			Protocol:      port.Protocol,
+			HostIP:        port.HostIP,
+		}
+	}
+	return mappings
+}
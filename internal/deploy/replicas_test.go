@@ -0,0 +1,119 @@
+package deploy
+
+import (
+	"strings"
+	"testing"
+
+	"superagent/internal/deploy/docker"
+)
+
+// portEngine is an engine knowing the given deployments
+func portEngine(deployments ...*Deployment) *DeploymentEngine {
+	de := &DeploymentEngine{deployments: make(map[string]*Deployment)}
+	for _, deployment := range deployments {
+		de.deployments[deployment.ID] = deployment
+	}
+	return de
+}
+
+// portDeployment is a deployment whose replicas bind the given host ports,
+// one replica per port
+func portDeployment(id, appID string, status DeploymentStatus, hostPorts ...int) *Deployment {
+	deployment := &Deployment{ID: id, AppID: appID, Status: status}
+	for i, hostPort := range hostPorts {
+		deployment.Replicas = append(deployment.Replicas, &Replica{
+			Index:       i,
+			ContainerID: id + "-" + string(rune('a'+i)),
+			Status:      ReplicaRunning,
+			Ports:       []PortMapping{{ContainerPort: 80, HostPort: hostPort, Protocol: "tcp"}},
+		})
+	}
+	return deployment
+}
+
+func TestReplicaContainerConfig(t *testing.T) {
+	base := docker.ContainerConfig{Name: "web-1", Labels: map[string]string{"superagent.app": "web"}}
+	ports := []PortMapping{{ContainerPort: 80, HostPort: 8081, Protocol: "tcp"}}
+
+	first := replicaContainerConfig(base, 0, ports)
+	if first.Name != "web-1" || first.Labels["superagent.replica"] != "0" {
+		t.Errorf("first replica %s labelled %v, want the base name and replica 0", first.Name, first.Labels)
+	}
+
+	second := replicaContainerConfig(base, 2, ports)
+	if second.Name != "web-1-2" || second.Labels["superagent.replica"] != "2" || second.Labels["superagent.app"] != "web" {
+		t.Errorf("replica 2 %s labelled %v, want web-1-2 with the base labels", second.Name, second.Labels)
+	}
+	if len(second.Ports) != 1 || second.Ports[0].HostPort != 8081 {
+		t.Errorf("replica 2 ports %+v, want 8081", second.Ports)
+	}
+
+	// Replicas get labels of their own
+	if _, ok := base.Labels["superagent.replica"]; ok {
+		t.Errorf("replicaContainerConfig changed the labels of the base config")
+	}
+}
+
+func TestReplicaHealthCheck(t *testing.T) {
+	basePorts := []PortMapping{{ContainerPort: 80, HostPort: 8080}, {ContainerPort: 9090}}
+	replicaPorts := []PortMapping{{ContainerPort: 80, HostPort: 8082}, {ContainerPort: 9090}}
+
+	if got := replicaHealthCheck(HealthCheckConfig{Port: 8080}, basePorts, replicaPorts); got.Port != 8082 {
+		t.Errorf("health check port %d, want the replica's host port 8082", got.Port)
+	}
+	if got := replicaHealthCheck(HealthCheckConfig{Port: 9090}, basePorts, replicaPorts); got.Port != 0 {
+		t.Errorf("health check port %d, want the unpublished port left to the runtime", got.Port)
+	}
+	if got := replicaHealthCheck(HealthCheckConfig{Path: "/health"}, basePorts, replicaPorts); got.Port != 0 {
+		t.Errorf("health check port %d, want none", got.Port)
+	}
+}
+
+func TestAllocateReplicaPorts(t *testing.T) {
+	basePorts := []PortMapping{{ContainerPort: 80, HostPort: 8080, Protocol: "tcp"}, {ContainerPort: 9000, Protocol: "tcp"}}
+
+	// Another app holds 8082, a stopped replica's port is free again
+	other := portDeployment("d2", "api", StatusRunning, 8082)
+	stopped := portDeployment("d3", "docs", StatusStopped, 8081)
+	stopped.Replicas[0].Status = ReplicaStopped
+	de := portEngine(other, stopped)
+
+	assigned := make(map[int]bool)
+	var got []int
+	for index := 0; index < 3; index++ {
+		ports, err := de.allocateReplicaPorts("web", basePorts, index, nil, assigned)
+		if err != nil {
+			t.Fatalf("allocateReplicaPorts(%d): %v", index, err)
+		}
+		if ports[1].HostPort != 0 {
+			t.Errorf("replica %d publishes port 9000 on %d, want it unpublished", index, ports[1].HostPort)
+		}
+		got = append(got, ports[0].HostPort)
+	}
+
+	if got[0] != 8080 || got[1] != 8081 || got[2] != 8083 {
+		t.Errorf("host ports %v, want [8080 8081 8083]", got)
+	}
+	if basePorts[0].HostPort != 8080 {
+		t.Errorf("allocateReplicaPorts changed the configured ports")
+	}
+}
+
+func TestAllocateReplicaPortsConflict(t *testing.T) {
+	basePorts := []PortMapping{{ContainerPort: 80, HostPort: 8080, Protocol: "tcp"}}
+	de := portEngine(portDeployment("d2", "api", StatusRunning, 8080))
+
+	_, err := de.allocateReplicaPorts("web", basePorts, 0, nil, make(map[int]bool))
+	if err == nil || !strings.Contains(err.Error(), "host port 8080 is already bound by deployment d2 of app api") {
+		t.Fatalf("allocateReplicaPorts error %v, want a port conflict with d2", err)
+	}
+
+	// Once the holder's container is released the port is free
+	ports, err := de.allocateReplicaPorts("web", basePorts, 0, map[string]bool{"d2-a": true}, make(map[int]bool))
+	if err != nil {
+		t.Fatalf("allocateReplicaPorts with the holder released: %v", err)
+	}
+	if ports[0].HostPort != 8080 {
+		t.Errorf("host port %d, want 8080", ports[0].HostPort)
+	}
+}
@@ -0,0 +1,242 @@
+package resources
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"superagent/internal/config"
+)
+
+// HostCapacity is what the host offers containers: its CPU cores, memory
+// and disk as read from /proc and the filesystem, less the reservations
+// kept for the system and the agent
+type HostCapacity struct {
+	CPU        float64    `json:"cpu"`
+	Memory     int64      `json:"memory"`
+	Storage    int64      `json:"storage"`
+	Containers int        `json:"containers"`
+	Reserved   QuotaUsage `json:"reserved"`
+}
+
+// CapacityError reports a request the host cannot take on
+type CapacityError struct {
+	Resource  string `json:"resource"` // "cpu", "memory", "storage" or "containers"
+	Requested string `json:"requested"`
+	Allocated string `json:"allocated"`
+	Capacity  string `json:"capacity"`
+	Reserved  string `json:"reserved,omitempty"`
+}
+
+func (e *CapacityError) Error() string {
+	message := fmt.Sprintf("insufficient host capacity for %s: requested %s with %s of %s allocated",
+		e.Resource, e.Requested, e.Allocated, e.Capacity)
+	if e.Reserved != "" {
+		message += fmt.Sprintf(" (%s reserved for the host)", e.Reserved)
+	}
+	return message
+}
+
+// ReadHostCapacity reads the capacity of the host. Storage is that of the
+// filesystem holding path, where container data lives.
+func ReadHostCapacity(path string, cfg config.ResourcesConfig) (*HostCapacity, error) {
+	cpus := cpuCount()
+
+	memory, err := totalMemory()
+	if err != nil {
+		return nil, err
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return nil, fmt.Errorf("failed to read filesystem of %s: %w", path, err)
+	}
+	storage := int64(stat.Blocks) * int64(stat.Bsize)
+
+	reserved := QuotaUsage{
+		CPU:     reservedCPU(cfg.ReservedCPU, cpus),
+		Memory:  reservedBytes(cfg.ReservedMemory, memory),
+		Storage: reservedBytes(cfg.ReservedStorage, storage),
+	}
+
+	capacity := &HostCapacity{
+		CPU:        math.Max(float64(cpus)-reserved.CPU, 0),
+		Memory:     max(memory-reserved.Memory, 0),
+		Storage:    max(storage-reserved.Storage, 0),
+		Containers: cfg.MaxContainers,
+		Reserved:   reserved,
+	}
+	if capacity.Containers <= 0 {
+		capacity.Containers = math.MaxInt
+	}
+
+	return capacity, nil
+}
+
+// DefaultLimits reads the CPU and memory limits admission charges containers
+// that have none of their own. Settings that cannot be read charge nothing.
+func DefaultLimits(cfg config.DockerConfig) ResourceLimits {
+	var limits ResourceLimits
+
+	if cpus, err := strconv.ParseFloat(strings.TrimSpace(cfg.DefaultCPULimit), 64); err == nil && cpus > 0 {
+		limits.CPULimit = cpus
+	}
+
+	if memory, err := ParseSize(cfg.DefaultMemoryLimit); err == nil && memory > 0 {
+		limits.MemoryLimit = memory
+	}
+
+	return limits
+}
+
+// Charge returns what admission counts for a single container. A container
+// without a CPU or memory limit can take all the host has, so it is charged
+// the default limit instead of nothing.
+func Charge(usage QuotaUsage, defaults ResourceLimits) QuotaUsage {
+	if usage.CPU <= 0 {
+		usage.CPU = defaults.CPULimit
+	}
+	if usage.Memory <= 0 {
+		usage.Memory = defaults.MemoryLimit
+	}
+	return usage
+}
+
+// Admit checks that the host can take on the requested resources on top of
+// those already allocated. Both are expected as charged by Charge; amounts
+// the request does not ask for are not checked.
+func (c *HostCapacity) Admit(allocated, requested QuotaUsage) error {
+	if requested.CPU > 0 && allocated.CPU+requested.CPU > c.CPU {
+		return &CapacityError{
+			Resource:  "cpu",
+			Requested: fmt.Sprintf("%.2f cores", requested.CPU),
+			Allocated: fmt.Sprintf("%.2f cores", allocated.CPU),
+			Capacity:  fmt.Sprintf("%.2f cores", c.CPU),
+			Reserved:  fmt.Sprintf("%.2f cores", c.Reserved.CPU),
+		}
+	}
+
+	if requested.Memory > 0 && allocated.Memory+requested.Memory > c.Memory {
+		return &CapacityError{
+			Resource:  "memory",
+			Requested: FormatSize(requested.Memory),
+			Allocated: FormatSize(allocated.Memory),
+			Capacity:  FormatSize(c.Memory),
+			Reserved:  FormatSize(c.Reserved.Memory),
+		}
+	}
+
+	if requested.Storage > 0 && allocated.Storage+requested.Storage > c.Storage {
+		return &CapacityError{
+			Resource:  "storage",
+			Requested: FormatSize(requested.Storage),
+			Allocated: FormatSize(allocated.Storage),
+			Capacity:  FormatSize(c.Storage),
+			Reserved:  FormatSize(c.Reserved.Storage),
+		}
+	}
+
+	if requested.Containers > 0 && allocated.Containers+requested.Containers > c.Containers {
+		return &CapacityError{
+			Resource:  "containers",
+			Requested: strconv.Itoa(requested.Containers),
+			Allocated: strconv.Itoa(allocated.Containers),
+			Capacity:  strconv.Itoa(c.Containers),
+		}
+	}
+
+	return nil
+}
+
+// cpuCount counts the processors listed in /proc/cpuinfo
+func cpuCount() int {
+	file, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return runtime.NumCPU()
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "processor") {
+			count++
+		}
+	}
+
+	if count == 0 {
+		return runtime.NumCPU()
+	}
+	return count
+}
+
+// totalMemory reads the memory of the host from /proc/meminfo
+func totalMemory() (int64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read memory info: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kilobytes, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid MemTotal in /proc/meminfo: %w", err)
+			}
+			return kilobytes * 1024, nil
+		}
+	}
+
+	return 0, fmt.Errorf("MemTotal not found in /proc/meminfo")
+}
+
+// reservedCPU reads a CPU reservation, either a share of the cores or a
+// number of cores
+func reservedCPU(value string, cores int) float64 {
+	if share, ok := quotaShare(value); ok {
+		return float64(cores) * share
+	}
+
+	cpus, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || cpus < 0 {
+		return 0
+	}
+	return cpus
+}
+
+// reservedBytes reads a size reservation, either a share of total or an
+// absolute size
+func reservedBytes(value string, total int64) int64 {
+	if share, ok := quotaShare(value); ok {
+		return int64(float64(total) * share)
+	}
+
+	size, err := ParseSize(value)
+	if err != nil || size < 0 {
+		return 0
+	}
+	return size
+}
+
+// FormatSize formats a number of bytes for people
+func FormatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit && exp < 3; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(bytes)/float64(div), "KMGT"[exp])
+}
@@ -0,0 +1,113 @@
+package resources
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"superagent/internal/config"
+)
+
+func TestAdmit(t *testing.T) {
+	capacity := &HostCapacity{CPU: 4, Memory: 8 << 30, Storage: 100 << 30, Containers: 10, Reserved: QuotaUsage{CPU: 1, Memory: 1 << 30}}
+	allocated := QuotaUsage{CPU: 3, Memory: 4 << 30, Storage: 10 << 30, Containers: 9}
+
+	tests := []struct {
+		name      string
+		requested QuotaUsage
+		resource  string
+	}{
+		{"fits", QuotaUsage{CPU: 1, Memory: 4 << 30, Containers: 1}, ""},
+		{"cpu", QuotaUsage{CPU: 1.5, Containers: 1}, "cpu"},
+		{"memory", QuotaUsage{CPU: 0.5, Memory: 5 << 30, Containers: 1}, "memory"},
+		{"storage", QuotaUsage{Storage: 91 << 30}, "storage"},
+		{"containers", QuotaUsage{CPU: 0.5, Containers: 2}, "containers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := capacity.Admit(allocated, tt.requested)
+			if tt.resource == "" {
+				if err != nil {
+					t.Fatalf("Admit() = %v, want the request admitted", err)
+				}
+				return
+			}
+
+			var capacityErr *CapacityError
+			if !errors.As(err, &capacityErr) || capacityErr.Resource != tt.resource {
+				t.Fatalf("Admit() = %v, want a %s capacity error", err, tt.resource)
+			}
+		})
+	}
+}
+
+func TestCapacityErrorMessage(t *testing.T) {
+	capacity := &HostCapacity{CPU: 2, Memory: 1 << 30, Storage: 1 << 30, Containers: 10, Reserved: QuotaUsage{Memory: 512 << 20}}
+
+	err := capacity.Admit(QuotaUsage{Memory: 768 << 20}, QuotaUsage{Memory: 512 << 20})
+	want := "insufficient host capacity for memory: requested 512.0MB with 768.0MB of 1.0GB allocated (512.0MB reserved for the host)"
+	if err == nil || err.Error() != want {
+		t.Fatalf("Admit() = %v, want %q", err, want)
+	}
+}
+
+func TestCharge(t *testing.T) {
+	defaults := DefaultLimits(config.DockerConfig{DefaultCPULimit: "1", DefaultMemoryLimit: "1G"})
+	if defaults.CPULimit != 1 || defaults.MemoryLimit != 1<<30 {
+		t.Fatalf("default limits %+v, want 1 core and 1G", defaults)
+	}
+
+	// Containers without limits are charged the defaults
+	charged := Charge(QuotaUsage{Storage: 1 << 20, Containers: 1}, defaults)
+	if charged.CPU != 1 || charged.Memory != 1<<30 || charged.Storage != 1<<20 || charged.Containers != 1 {
+		t.Errorf("charged %+v, want the default CPU and memory", charged)
+	}
+
+	// Limits of their own are kept
+	charged = Charge(QuotaUsage{CPU: 0.25, Memory: 64 << 20, Containers: 1}, defaults)
+	if charged.CPU != 0.25 || charged.Memory != 64<<20 {
+		t.Errorf("charged %+v, want the container's own limits", charged)
+	}
+
+	// An unlimited request is checked against the host with the defaults
+	capacity := &HostCapacity{CPU: 0.5, Memory: 8 << 30, Storage: 1 << 30, Containers: 10}
+	if err := capacity.Admit(QuotaUsage{}, Charge(QuotaUsage{Containers: 1}, defaults)); err == nil || !strings.Contains(err.Error(), "for cpu") {
+		t.Errorf("Admit of an unlimited request = %v, want it charged a default core", err)
+	}
+}
+
+func TestDefaultLimitsUnreadable(t *testing.T) {
+	defaults := DefaultLimits(config.DockerConfig{DefaultCPULimit: "lots", DefaultMemoryLimit: ""})
+	if defaults.CPULimit != 0 || defaults.MemoryLimit != 0 {
+		t.Errorf("default limits %+v, want none", defaults)
+	}
+}
+
+func TestReservations(t *testing.T) {
+	if got := reservedCPU("25%", 8); got != 2 {
+		t.Errorf("reservedCPU(25%%, 8) = %v, want 2", got)
+	}
+	if got := reservedCPU("0.5", 8); got != 0.5 {
+		t.Errorf("reservedCPU(0.5, 8) = %v, want 0.5", got)
+	}
+	if got := reservedBytes("10%", 1000); got != 100 {
+		t.Errorf("reservedBytes(10%%, 1000) = %d, want 100", got)
+	}
+	if got := reservedBytes("512MB", 1<<40); got != 512<<20 {
+		t.Errorf("reservedBytes(512MB) = %d, want %d", got, 512<<20)
+	}
+	if got := reservedBytes("", 1<<40); got != 0 {
+		t.Errorf("reservedBytes of nothing = %d, want 0", got)
+	}
+}
+
+func TestReadHostCapacity(t *testing.T) {
+	capacity, err := ReadHostCapacity("/", config.ResourcesConfig{ReservedCPU: "100%"})
+	if err != nil {
+		t.Fatalf("ReadHostCapacity: %v", err)
+	}
+	if capacity.CPU != 0 || capacity.Memory <= 0 || capacity.Storage <= 0 || capacity.Containers <= 0 {
+		t.Errorf("capacity %+v, want all cores reserved and memory, storage and containers left", capacity)
+	}
+}
@@ -0,0 +1,224 @@
+package resources
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// historyTiers are the resolutions usage is kept at and for how long. Each
+// tier is filled from the samples recorded, so long windows are answered
+// from fewer, downsampled samples.
+var historyTiers = []struct {
+	resolution time.Duration
+	retention  time.Duration
+}{
+	{resolution: 0, retention: 2 * time.Hour}, // every sample as recorded
+	{resolution: 5 * time.Minute, retention: 24 * time.Hour},
+	{resolution: time.Hour, retention: 30 * 24 * time.Hour},
+}
+
+// maxRawSamples bounds the finest tier should samples come faster than
+// expected
+const maxRawSamples = 1024
+
+// UsageHistory is a bounded time series of resource usage per key, such as
+// a deployment. Downsampled samples keep the peak of each gauge, so limits
+// sized from them stay on the safe side.
+type UsageHistory struct {
+	series map[string]*usageSeries
+	mu     sync.RWMutex
+}
+
+// usageSeries holds the tiers of one key
+type usageSeries struct {
+	Tiers []*usageTier `json:"tiers"`
+}
+
+// usageTier holds the samples of one resolution, oldest first
+type usageTier struct {
+	Resolution time.Duration    `json:"resolution"`
+	Retention  time.Duration    `json:"retention"`
+	Samples    []*ResourceUsage `json:"samples"`
+	Bucket     *ResourceUsage   `json:"bucket,omitempty"` // the bucket still being filled
+}
+
+// NewUsageHistory creates an empty usage history
+func NewUsageHistory() *UsageHistory {
+	return &UsageHistory{
+		series: make(map[string]*usageSeries),
+	}
+}
+
+// Record adds a sample to the history of a key
+func (h *UsageHistory) Record(key string, usage ResourceUsage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	series, exists := h.series[key]
+	if !exists {
+		series = newUsageSeries()
+		h.series[key] = series
+	}
+
+	for _, tier := range series.Tiers {
+		tier.add(usage)
+	}
+}
+
+// Samples returns the samples of a key within the window up to now, from the
+// finest tier that covers it
+func (h *UsageHistory) Samples(key string, window time.Duration) []*ResourceUsage {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	series, exists := h.series[key]
+	if !exists {
+		return nil
+	}
+
+	tier := series.Tiers[len(series.Tiers)-1]
+	for _, candidate := range series.Tiers {
+		if candidate.Retention >= window {
+			tier = candidate
+			break
+		}
+	}
+
+	since := time.Now().Add(-window)
+	samples := make([]*ResourceUsage, 0, len(tier.Samples)+1)
+	for _, sample := range tier.Samples {
+		if !sample.Timestamp.Before(since) {
+			copied := *sample
+			samples = append(samples, &copied)
+		}
+	}
+	if tier.Bucket != nil && !tier.Bucket.Timestamp.Before(since) {
+		copied := *tier.Bucket
+		samples = append(samples, &copied)
+	}
+
+	return samples
+}
+
+// Remove drops the history of a key
+func (h *UsageHistory) Remove(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.series, key)
+}
+
+// Keys lists the keys with a history
+func (h *UsageHistory) Keys() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MarshalJSON encodes the history so it can be persisted
+func (h *UsageHistory) MarshalJSON() ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return json.Marshal(h.series)
+}
+
+// UnmarshalJSON restores a persisted history. Samples past their retention
+// are dropped.
+func (h *UsageHistory) UnmarshalJSON(data []byte) error {
+	var series map[string]*usageSeries
+	if err := json.Unmarshal(data, &series); err != nil {
+		return fmt.Errorf("failed to decode usage history: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.series = make(map[string]*usageSeries, len(series))
+	for key, stored := range series {
+		if stored == nil || len(stored.Tiers) != len(historyTiers) {
+			continue
+		}
+		for _, tier := range stored.Tiers {
+			tier.expire(time.Now())
+		}
+		h.series[key] = stored
+	}
+
+	return nil
+}
+
+// newUsageSeries creates the tiers of a key
+func newUsageSeries() *usageSeries {
+	series := &usageSeries{Tiers: make([]*usageTier, len(historyTiers))}
+	for i, tier := range historyTiers {
+		series.Tiers[i] = &usageTier{Resolution: tier.resolution, Retention: tier.retention}
+	}
+	return series
+}
+
+// add records a sample in a tier, closing the bucket it was filling when the
+// sample falls into the next one
+func (t *usageTier) add(usage ResourceUsage) {
+	if t.Resolution == 0 {
+		sample := usage
+		t.Samples = append(t.Samples, &sample)
+		if len(t.Samples) > maxRawSamples {
+			t.Samples = t.Samples[len(t.Samples)-maxRawSamples:]
+		}
+		t.expire(usage.Timestamp)
+		return
+	}
+
+	start := usage.Timestamp.Truncate(t.Resolution)
+	if t.Bucket != nil && !t.Bucket.Timestamp.Equal(start) {
+		t.Samples = append(t.Samples, t.Bucket)
+		t.Bucket = nil
+	}
+
+	if t.Bucket == nil {
+		bucket := usage
+		bucket.Timestamp = start
+		t.Bucket = &bucket
+	} else {
+		t.Bucket.merge(usage)
+	}
+
+	t.expire(usage.Timestamp)
+}
+
+// expire drops the samples older than the retention of a tier
+func (t *usageTier) expire(now time.Time) {
+	cutoff := now.Add(-t.Retention)
+
+	expired := 0
+	for expired < len(t.Samples) && t.Samples[expired].Timestamp.Before(cutoff) {
+		expired++
+	}
+	t.Samples = t.Samples[expired:]
+}
+
+// merge folds a sample into a downsampled one: gauges keep their peak and
+// counters their latest value
+func (u *ResourceUsage) merge(other ResourceUsage) {
+	u.CPUUsage = max(u.CPUUsage, other.CPUUsage)
+	u.MemoryUsage = max(u.MemoryUsage, other.MemoryUsage)
+	u.MemoryLimit = max(u.MemoryLimit, other.MemoryLimit)
+	u.SwapUsage = max(u.SwapUsage, other.SwapUsage)
+	u.ProcessCount = max(u.ProcessCount, other.ProcessCount)
+	u.ThreadCount = max(u.ThreadCount, other.ThreadCount)
+	u.FileDescriptors = max(u.FileDescriptors, other.FileDescriptors)
+	u.NetworkRx = other.NetworkRx
+	u.NetworkTx = other.NetworkTx
+	u.DiskRead = other.DiskRead
+	u.DiskWrite = other.DiskWrite
+}
@@ -3,12 +3,15 @@ package resources
 import (
 	"context"
 	"fmt"
+	"math"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"superagent/internal/config"
 	"superagent/internal/logging"
 
 	"github.com/sirupsen/logrus"
@@ -17,6 +20,7 @@ import (
 // ResourceManager handles resource management and monitoring
 type ResourceManager struct {
 	auditLogger *logging.AuditLogger
+	allocations map[string]*ResourceAllocation // ledger of running containers, by container ID
 	mu          sync.RWMutex
 }
 
@@ -90,6 +94,7 @@ type ResourceAllocation struct {
 func NewResourceManager(auditLogger *logging.AuditLogger) (*ResourceManager, error) {
 	rm := &ResourceManager{
 		auditLogger: auditLogger,
+		allocations: make(map[string]*ResourceAllocation),
 	}
 
 	auditLogger.LogEvent("RESOURCE_MANAGER_INITIALIZED", map[string]interface{}{})
@@ -165,6 +170,7 @@ func (rm *ResourceManager) AllocateResources(appID, containerID string, limits R
 		Bandwidth:   limits.NetworkLimit,
 		AllocatedAt: time.Now(),
 	}
+	rm.allocations[containerID] = allocation
 
 	rm.auditLogger.LogEvent("RESOURCES_ALLOCATED", map[string]interface{}{
 		"app_id":       appID,
@@ -186,7 +192,14 @@ func (rm *ResourceManager) DeallocateResources(containerID string) error {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 
+	allocation, exists := rm.allocations[containerID]
+	if !exists {
+		return fmt.Errorf("no resources allocated for container %s", containerID)
+	}
+	delete(rm.allocations, containerID)
+
 	rm.auditLogger.LogEvent("RESOURCES_DEALLOCATED", map[string]interface{}{
+		"app_id":       allocation.AppID,
 		"container_id": containerID,
 	})
 
@@ -195,6 +208,167 @@ func (rm *ResourceManager) DeallocateResources(containerID string) error {
 	return nil
 }
 
+// RestoreAllocations puts allocations read back from storage into the ledger
+func (rm *ResourceManager) RestoreAllocations(allocations []*ResourceAllocation) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	for _, allocation := range allocations {
+		rm.allocations[allocation.ContainerID] = allocation
+	}
+}
+
+// Allocations returns a copy of the ledger, ordered by app and container
+func (rm *ResourceManager) Allocations() []ResourceAllocation {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	allocations := make([]ResourceAllocation, 0, len(rm.allocations))
+	for _, allocation := range rm.allocations {
+		allocations = append(allocations, *allocation)
+	}
+
+	sort.Slice(allocations, func(i, j int) bool {
+		if allocations[i].AppID != allocations[j].AppID {
+			return allocations[i].AppID < allocations[j].AppID
+		}
+		return allocations[i].ContainerID < allocations[j].ContainerID
+	})
+
+	return allocations
+}
+
+// QuotaUsage sums up allocations counted against a quota
+type QuotaUsage struct {
+	CPU        float64 `json:"cpu"`
+	Memory     int64   `json:"memory"`
+	Storage    int64   `json:"storage"`
+	Containers int     `json:"containers"`
+}
+
+// Add counts the limits of a number of containers
+func (u *QuotaUsage) Add(limits ResourceLimits, containers int) {
+	u.CPU += limits.CPULimit * float64(containers)
+	u.Memory += limits.MemoryLimit * int64(containers)
+	u.Storage += limits.DiskLimit * int64(containers)
+	u.Containers += containers
+}
+
+// Merge adds another usage
+func (u *QuotaUsage) Merge(other QuotaUsage) {
+	u.CPU += other.CPU
+	u.Memory += other.Memory
+	u.Storage += other.Storage
+	u.Containers += other.Containers
+}
+
+// Usage sums up what the ledger holds for the given apps
+func (rm *ResourceManager) Usage(appIDs ...string) QuotaUsage {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	var usage QuotaUsage
+	for _, allocation := range rm.allocations {
+		for _, appID := range appIDs {
+			if allocation.AppID == appID {
+				usage.CPU += allocation.CPU
+				usage.Memory += allocation.Memory
+				usage.Storage += allocation.Storage
+				usage.Containers++
+				break
+			}
+		}
+	}
+	return usage
+}
+
+// TotalUsage sums up everything the ledger holds
+func (rm *ResourceManager) TotalUsage() QuotaUsage {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	var usage QuotaUsage
+	for _, allocation := range rm.allocations {
+		usage.CPU += allocation.CPU
+		usage.Memory += allocation.Memory
+		usage.Storage += allocation.Storage
+		usage.Containers++
+	}
+	return usage
+}
+
+// ConfiguredQuota derives a quota from the quota settings of an app or team.
+// Amounts that are unset or cannot be read do not limit.
+func (rm *ResourceManager) ConfiguredQuota(ctx context.Context, name string, cfg config.QuotaConfig) ResourceQuota {
+	quota := ResourceQuota{
+		AppID:         name,
+		MaxCPU:        math.MaxFloat64,
+		MaxMemory:     math.MaxInt64,
+		MaxStorage:    math.MaxInt64,
+		MaxBandwidth:  math.MaxInt64,
+		MaxContainers: math.MaxInt,
+	}
+	if cfg.Containers > 0 {
+		quota.MaxContainers = cfg.Containers
+	}
+
+	if cfg.CPU == "" && cfg.Memory == "" && cfg.Storage == "" {
+		return quota
+	}
+
+	info, err := rm.GetSystemResourceInfo(ctx)
+	if err != nil {
+		logrus.Warnf("Failed to read system resources: %v", err)
+		info = map[string]interface{}{}
+	}
+
+	cpuCores, _ := info["cpu_cores"].(int)
+	totalMemory, _ := info["total_memory"].(int64)
+	totalDisk, _ := info["total_disk"].(int64)
+
+	quota.MaxCPU = quotaCPU(cfg.CPU, cpuCores)
+	quota.MaxMemory = quotaBytes(cfg.Memory, totalMemory)
+	quota.MaxStorage = quotaBytes(cfg.Storage, totalDisk)
+
+	return quota
+}
+
+// CheckQuota reports every amount of a usage above its quota. Limited
+// amounts must be set on every container, or the usage could not be told.
+func CheckQuota(quota ResourceQuota, usage QuotaUsage, limits ResourceLimits) []error {
+	var errs []error
+
+	if quota.MaxCPU < math.MaxFloat64 {
+		if limits.CPULimit <= 0 {
+			errs = append(errs, fmt.Errorf("quota of %s limits CPU, so a cpu limit is required", quota.AppID))
+		} else if usage.CPU > quota.MaxCPU {
+			errs = append(errs, fmt.Errorf("CPU of %.2f cores exceeds quota %.2f of %s", usage.CPU, quota.MaxCPU, quota.AppID))
+		}
+	}
+
+	if quota.MaxMemory < math.MaxInt64 {
+		if limits.MemoryLimit <= 0 {
+			errs = append(errs, fmt.Errorf("quota of %s limits memory, so a memory limit is required", quota.AppID))
+		} else if usage.Memory > quota.MaxMemory {
+			errs = append(errs, fmt.Errorf("memory of %d bytes exceeds quota %d of %s", usage.Memory, quota.MaxMemory, quota.AppID))
+		}
+	}
+
+	if quota.MaxStorage < math.MaxInt64 {
+		if limits.DiskLimit <= 0 {
+			errs = append(errs, fmt.Errorf("quota of %s limits storage, so a disk limit is required", quota.AppID))
+		} else if usage.Storage > quota.MaxStorage {
+			errs = append(errs, fmt.Errorf("storage of %d bytes exceeds quota %d of %s", usage.Storage, quota.MaxStorage, quota.AppID))
+		}
+	}
+
+	if usage.Containers > quota.MaxContainers {
+		errs = append(errs, fmt.Errorf("%d containers exceed quota %d of %s", usage.Containers, quota.MaxContainers, quota.AppID))
+	}
+
+	return errs
+}
+
 // GetResourceUsage gets current resource usage for a container
 func (rm *ResourceManager) GetResourceUsage(ctx context.Context, containerID string) (*ResourceUsage, error) {
 	rm.mu.RLock()
@@ -234,10 +408,10 @@ func (rm *ResourceManager) GetResourceUsage(ctx context.Context, containerID str
 	// Parse memory usage
 	memParts := strings.Split(statsLine[1], "/")
 	if len(memParts) == 2 {
-		if memUsage, err := parseSize(strings.TrimSpace(memParts[0])); err == nil {
+		if memUsage, err := ParseSize(strings.TrimSpace(memParts[0])); err == nil {
 			usage.MemoryUsage = memUsage
 		}
-		if memLimit, err := parseSize(strings.TrimSpace(memParts[1])); err == nil {
+		if memLimit, err := ParseSize(strings.TrimSpace(memParts[1])); err == nil {
 			usage.MemoryLimit = memLimit
 		}
 	}
@@ -245,10 +419,10 @@ func (rm *ResourceManager) GetResourceUsage(ctx context.Context, containerID str
 	// Parse network I/O
 	netParts := strings.Split(statsLine[2], "/")
 	if len(netParts) == 2 {
-		if netRx, err := parseSize(strings.TrimSpace(netParts[0])); err == nil {
+		if netRx, err := ParseSize(strings.TrimSpace(netParts[0])); err == nil {
 			usage.NetworkRx = netRx
 		}
-		if netTx, err := parseSize(strings.TrimSpace(netParts[1])); err == nil {
+		if netTx, err := ParseSize(strings.TrimSpace(netParts[1])); err == nil {
 			usage.NetworkTx = netTx
 		}
 	}
@@ -256,10 +430,10 @@ func (rm *ResourceManager) GetResourceUsage(ctx context.Context, containerID str
 	// Parse block I/O
 	blockParts := strings.Split(statsLine[3], "/")
 	if len(blockParts) == 2 {
-		if diskRead, err := parseSize(strings.TrimSpace(blockParts[0])); err == nil {
+		if diskRead, err := ParseSize(strings.TrimSpace(blockParts[0])); err == nil {
 			usage.DiskRead = diskRead
 		}
-		if diskWrite, err := parseSize(strings.TrimSpace(blockParts[1])); err == nil {
+		if diskWrite, err := ParseSize(strings.TrimSpace(blockParts[1])); err == nil {
 			usage.DiskWrite = diskWrite
 		}
 	}
@@ -461,6 +635,118 @@ func (rm *ResourceManager) GetSystemResourceInfo(ctx context.Context) (map[strin
 	return info, nil
 }
 
+// HostQuota derives the quota of a single app from the agent's resource
+// settings. Each quota is either a share of the host such as "80%" or an
+// absolute amount such as "4" cores or "8GB"; quotas that are unset or
+// cannot be read do not limit.
+func (rm *ResourceManager) HostQuota(ctx context.Context, appID string, cfg config.ResourcesConfig) ResourceQuota {
+	info, err := rm.GetSystemResourceInfo(ctx)
+	if err != nil {
+		logrus.Warnf("Failed to read system resources: %v", err)
+		info = map[string]interface{}{}
+	}
+
+	cpuCores, _ := info["cpu_cores"].(int)
+	totalMemory, _ := info["total_memory"].(int64)
+	totalDisk, _ := info["total_disk"].(int64)
+
+	quota := ResourceQuota{
+		AppID:         appID,
+		MaxCPU:        math.MaxFloat64,
+		MaxMemory:     math.MaxInt64,
+		MaxStorage:    math.MaxInt64,
+		MaxBandwidth:  math.MaxInt64,
+		MaxContainers: cfg.MaxContainers,
+	}
+
+	quota.MaxCPU = quotaCPU(cfg.CPUQuota, cpuCores)
+	quota.MaxMemory = quotaBytes(cfg.MemoryQuota, totalMemory)
+	quota.MaxStorage = quotaBytes(cfg.StorageQuota, totalDisk)
+
+	if bandwidth, err := parseBandwidth(cfg.NetworkQuota); err == nil && bandwidth > 0 {
+		quota.MaxBandwidth = bandwidth
+	}
+
+	return quota
+}
+
+// quotaShare reads a quota given as a percentage of the host
+func quotaShare(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	if !strings.HasSuffix(value, "%") {
+		return 0, false
+	}
+
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || percent <= 0 {
+		return 0, false
+	}
+	return percent / 100, true
+}
+
+// quotaCPU reads a CPU quota, either a share of the cores or a number of
+// cores
+func quotaCPU(value string, cores int) float64 {
+	if share, ok := quotaShare(value); ok {
+		if cores > 0 {
+			return float64(cores) * share
+		}
+		return math.MaxFloat64
+	}
+
+	cpus, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || cpus <= 0 {
+		return math.MaxFloat64
+	}
+	return cpus
+}
+
+// quotaBytes reads a size quota, either a share of total or an absolute size
+func quotaBytes(value string, total int64) int64 {
+	if share, ok := quotaShare(value); ok {
+		if total > 0 {
+			return int64(float64(total) * share)
+		}
+		return math.MaxInt64
+	}
+
+	size, err := ParseSize(value)
+	if err != nil || size <= 0 {
+		return math.MaxInt64
+	}
+	return size
+}
+
+// parseBandwidth parses bandwidths like "1Gbps" or "100Mbps" into bytes per
+// second
+func parseBandwidth(bandwidthStr string) (int64, error) {
+	bandwidthStr = strings.ToUpper(strings.TrimSpace(bandwidthStr))
+	if bandwidthStr == "" {
+		return 0, nil
+	}
+
+	var multiplier float64 = 1
+	switch {
+	case strings.HasSuffix(bandwidthStr, "KBPS"):
+		multiplier = 1000
+	case strings.HasSuffix(bandwidthStr, "MBPS"):
+		multiplier = 1000 * 1000
+	case strings.HasSuffix(bandwidthStr, "GBPS"):
+		multiplier = 1000 * 1000 * 1000
+	case strings.HasSuffix(bandwidthStr, "BPS"):
+	default:
+		return 0, fmt.Errorf("unknown bandwidth unit: %s", bandwidthStr)
+	}
+	bandwidthStr = strings.TrimRight(bandwidthStr, "KMGBPS")
+
+	bits, err := strconv.ParseFloat(bandwidthStr, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse bandwidth: %w", err)
+	}
+
+	return int64(bits * multiplier / 8), nil
+}
+
 // CalculateResourceEfficiency calculates resource efficiency metrics
 func (rm *ResourceManager) CalculateResourceEfficiency(usage *ResourceUsage, limits ResourceLimits) map[string]float64 {
 	efficiency := make(map[string]float64)
@@ -485,49 +771,36 @@ func (rm *ResourceManager) CalculateResourceEfficiency(usage *ResourceUsage, lim
 	return efficiency
 }
 
-// OptimizeResourceLimits suggests optimized resource limits based on usage patterns
-func (rm *ResourceManager) OptimizeResourceLimits(usageHistory []*ResourceUsage, currentLimits ResourceLimits) ResourceLimits {
+// OptimizeResourceLimits suggests resource limits from a usage history. CPU
+// and memory are sized to the given percentile of the samples with a safety
+// margin, processes to the peak.
+func (rm *ResourceManager) OptimizeResourceLimits(usageHistory []*ResourceUsage, currentLimits ResourceLimits, percentile float64) ResourceLimits {
 	if len(usageHistory) == 0 {
 		return currentLimits
 	}
 
-	// Calculate usage statistics
-	var totalCPU, maxCPU float64
-	var totalMem, maxMem int64
-	var totalProc, maxProc int
+	cpu := make([]float64, 0, len(usageHistory))
+	memory := make([]float64, 0, len(usageHistory))
+	var maxProc int
 
 	for _, usage := range usageHistory {
-		totalCPU += usage.CPUUsage
-		if usage.CPUUsage > maxCPU {
-			maxCPU = usage.CPUUsage
-		}
-
-		totalMem += usage.MemoryUsage
-		if usage.MemoryUsage > maxMem {
-			maxMem = usage.MemoryUsage
-		}
-
-		totalProc += usage.ProcessCount
+		cpu = append(cpu, usage.CPUUsage)
+		memory = append(memory, float64(usage.MemoryUsage))
 		if usage.ProcessCount > maxProc {
 			maxProc = usage.ProcessCount
 		}
 	}
 
-count := float64(len(usageHistory))
-	_ = totalCPU / count // avgCPU not used
-	_ = float64(totalMem) / count // avgMem not used
-
-	// Optimize limits with safety margins
 	optimized := currentLimits
 
-	// CPU: Use 95th percentile with 20% safety margin
-	if maxCPU > 0 {
-		optimized.CPULimit = (maxCPU/100) * 1.2
+	// CPU: percentile with 20% safety margin, usage is in percent of a core
+	if cpuUsage := Percentile(cpu, percentile); cpuUsage > 0 {
+		optimized.CPULimit = math.Max((cpuUsage/100)*1.2, minCPULimit)
 	}
 
-	// Memory: Use maximum usage with 30% safety margin
-	if maxMem > 0 {
-		optimized.MemoryLimit = int64(float64(maxMem) * 1.3)
+	// Memory: percentile with 30% safety margin, as running out kills the container
+	if memUsage := Percentile(memory, percentile); memUsage > 0 {
+		optimized.MemoryLimit = max(int64(memUsage*1.3), minMemoryLimit)
 	}
 
 	// Process limit: Use maximum with 50% safety margin
@@ -538,8 +811,31 @@ count := float64(len(usageHistory))
 	return optimized
 }
 
-// parseSize parses size strings like "1.5GB", "512MB"
-func parseSize(sizeStr string) (int64, error) {
+// Smallest limits OptimizeResourceLimits suggests, below them containers
+// hardly start
+const (
+	minCPULimit    = 0.05
+	minMemoryLimit = 32 * 1024 * 1024
+)
+
+// Percentile returns the p-th percentile of values, interpolating between
+// the closest ranks
+func Percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	rank := math.Min(math.Max(p, 0), 100) / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// ParseSize parses size strings like "1.5GB", "512MB"
+func ParseSize(sizeStr string) (int64, error) {
 	sizeStr = strings.TrimSpace(sizeStr)
 	if sizeStr == "" {
 		return 0, nil
@@ -563,6 +859,10 @@ func parseSize(sizeStr string) (int64, error) {
 		sizeStr = strings.TrimSuffix(sizeStr, "TB")
 	} else if strings.HasSuffix(sizeStr, "B") {
 		sizeStr = strings.TrimSuffix(sizeStr, "B")
+	} else if unit := strings.IndexByte("KMGT", sizeStr[len(sizeStr)-1]); unit >= 0 {
+		// Short units as in "512M" or "1G"
+		multiplier = int64(1) << (10 * (unit + 1))
+		sizeStr = sizeStr[:len(sizeStr)-1]
 	}
 
 	size, err := strconv.ParseFloat(sizeStr, 64)
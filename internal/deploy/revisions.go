@@ -0,0 +1,489 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"superagent/internal/deploy/docker"
+	"superagent/internal/deploy/resources"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RevisionPending    = "pending"
+	RevisionSucceeded  = "succeeded"
+	RevisionFailed     = "failed"
+	RevisionCancelled  = "cancelled"
+	RevisionSuperseded = "superseded"
+	RevisionAborted    = "aborted"
+)
+
+// RevisionChange is a single difference between two revisions of an app.
+// Values of environment variables are never shown, only whether a variable
+// was added, removed or changed.
+type RevisionChange struct {
+	Field  string `json:"field"`
+	Change string `json:"change"` // "added", "removed" or "changed"
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// revisionSpec is what makes up a revision when comparing two of them
+type revisionSpec struct {
+	Version        string                   `json:"version"`
+	ImageID        string                   `json:"image_id"`
+	ImageDigest    string                   `json:"image_digest"`
+	SourceCommit   string                   `json:"source_commit"`
+	Source         DeploymentSource         `json:"source"`
+	Config         DeploymentConfig         `json:"config"`
+	ResourceLimits resources.ResourceLimits `json:"resource_limits"`
+	HealthCheck    HealthCheckConfig        `json:"health_check"`
+	Ports          []PortMapping            `json:"ports"`
+	Networks       []string                 `json:"networks"`
+	NetworkAliases []string                 `json:"network_aliases,omitempty"`
+	Volumes        []VolumeMapping          `json:"volumes"`
+	Labels         map[string]string        `json:"labels"`
+	Hooks          DeploymentHooks          `json:"hooks"`
+}
+
+// openRevision records a new deployment in the history of its app. Callers
+// must hold de.mu.
+func (de *DeploymentEngine) openRevision(deployment *Deployment, request *DeploymentRequest, redeploy *Revision) {
+	history := de.revisions[deployment.AppID]
+
+	number := 1
+	for _, revision := range history {
+		if revision.Number >= number {
+			number = revision.Number + 1
+		}
+	}
+
+	revision := &Revision{
+		Number:       number,
+		DeploymentID: deployment.ID,
+		AppID:        deployment.AppID,
+		Version:      deployment.Version,
+		Outcome:      RevisionPending,
+		TriggeredBy:  request.TriggeredBy,
+		Request:      *request,
+		Environment:  deployment.Environment,
+		HealthCheck:  deployment.HealthCheck,
+		CreatedAt:    deployment.CreatedAt,
+	}
+	if redeploy != nil {
+		revision.RedeployOf = redeploy.Number
+	}
+
+	deployment.Revision = number
+
+	history = append(history, revision)
+	if len(history) > maxRetainedRevisions {
+		history = history[len(history)-maxRetainedRevisions:]
+	}
+	de.revisions[deployment.AppID] = history
+
+	de.saveRevisions(deployment.AppID, history)
+}
+
+// recordRevision completes the revision of a successfully deployed
+// deployment with everything needed to bring it back later
+func (de *DeploymentEngine) recordRevision(deployment *Deployment, containerConfig docker.ContainerConfig) {
+	// Prefer the registry digest, which identifies the image beyond this host
+	digest := ""
+	ctx, cancel := context.WithTimeout(de.ctx, 30*time.Second)
+	if info, err := de.dockerManager.GetImageInfo(ctx, deployment.ImageID); err == nil {
+		digest = info.Digest
+	}
+	cancel()
+
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	revision := de.deploymentRevision(deployment)
+	if revision == nil {
+		// A deployment created before revisions were numbered
+		de.openRevision(deployment, &DeploymentRequest{AppID: deployment.AppID, Version: deployment.Version}, nil)
+		revision = de.deploymentRevision(deployment)
+	}
+
+	now := time.Now()
+	revision.Outcome = RevisionSucceeded
+	revision.SourceCommit = deployment.SourceCommit
+	revision.ImageID = deployment.ImageID
+	revision.ImageDigest = digest
+	revision.ContainerConfig = containerConfig
+	revision.Environment = deployment.Environment
+	revision.HealthCheck = deployment.HealthCheck
+	revision.DeployedAt = now
+	revision.FinishedAt = &now
+
+	de.saveRevisions(deployment.AppID, de.revisions[deployment.AppID])
+}
+
+// concludeRevision records how a deployment that did not succeed ended
+func (de *DeploymentEngine) concludeRevision(deployment *Deployment, cause error) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	revision := de.deploymentRevision(deployment)
+	if revision == nil || revision.Outcome != RevisionPending {
+		return
+	}
+
+	switch deployment.Status {
+	case StatusCancelled:
+		revision.Outcome = RevisionCancelled
+	case StatusSuperseded:
+		revision.Outcome = RevisionSuperseded
+	case StatusAborted:
+		revision.Outcome = RevisionAborted
+	default:
+		revision.Outcome = RevisionFailed
+	}
+	if cause != nil {
+		revision.Error = cause.Error()
+	}
+
+	now := time.Now()
+	revision.SourceCommit = deployment.SourceCommit
+	revision.ImageID = deployment.ImageID
+	revision.FinishedAt = &now
+
+	de.saveRevisions(deployment.AppID, de.revisions[deployment.AppID])
+}
+
+// deploymentRevision finds the revision of a deployment. Callers must hold
+// de.mu.
+func (de *DeploymentEngine) deploymentRevision(deployment *Deployment) *Revision {
+	if deployment.Revision == 0 {
+		return nil
+	}
+
+	for _, revision := range de.revisions[deployment.AppID] {
+		if revision.Number == deployment.Revision {
+			return revision
+		}
+	}
+	return nil
+}
+
+// ListRevisions returns the history of an app, oldest first
+func (de *DeploymentEngine) ListRevisions(appID string) ([]*Revision, error) {
+	de.mu.RLock()
+	defer de.mu.RUnlock()
+
+	history, exists := de.revisions[appID]
+	if !exists {
+		return nil, fmt.Errorf("no revisions found for app %s", appID)
+	}
+
+	revisions := make([]*Revision, len(history))
+	copy(revisions, history)
+	return revisions, nil
+}
+
+// GetRevision returns a revision from the history of an app
+func (de *DeploymentEngine) GetRevision(appID string, number int) (*Revision, error) {
+	de.mu.RLock()
+	defer de.mu.RUnlock()
+
+	return de.findRevision(appID, number)
+}
+
+// findRevision looks up a revision by number. Callers must hold de.mu.
+func (de *DeploymentEngine) findRevision(appID string, number int) (*Revision, error) {
+	for _, revision := range de.revisions[appID] {
+		if revision.Number == number {
+			return revision, nil
+		}
+	}
+	return nil, fmt.Errorf("revision %d of app %s not found", number, appID)
+}
+
+// DiffRevisions lists what changed from one revision of an app to another
+func (de *DeploymentEngine) DiffRevisions(appID string, from, to int) ([]RevisionChange, error) {
+	de.mu.RLock()
+	fromRevision, err := de.findRevision(appID, from)
+	if err != nil {
+		de.mu.RUnlock()
+		return nil, err
+	}
+	toRevision, err := de.findRevision(appID, to)
+	if err != nil {
+		de.mu.RUnlock()
+		return nil, err
+	}
+	de.mu.RUnlock()
+
+	fromFields, err := flattenSpec(revisionSpecOf(fromRevision))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revision %d: %w", from, err)
+	}
+	toFields, err := flattenSpec(revisionSpecOf(toRevision))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revision %d: %w", to, err)
+	}
+
+	fromEnv := environmentFields(fromRevision.Request.Hooks, revisionEnvironment(fromRevision))
+	toEnv := environmentFields(toRevision.Request.Hooks, revisionEnvironment(toRevision))
+
+	return diffSpecs(fromFields, toFields, fromEnv, toEnv), nil
+}
+
+// diffSpecs compares two flattened specs and their environments, keyed by
+// field name as environmentFields returns them. Environment values stay
+// hidden.
+func diffSpecs(fromFields, toFields, fromEnv, toEnv map[string]string) []RevisionChange {
+	changes := []RevisionChange{}
+	for field, value := range fromFields {
+		other, exists := toFields[field]
+		switch {
+		case !exists:
+			changes = append(changes, RevisionChange{Field: field, Change: "removed", From: value})
+		case other != value:
+			changes = append(changes, RevisionChange{Field: field, Change: "changed", From: value, To: other})
+		}
+	}
+	for field, value := range toFields {
+		if _, exists := fromFields[field]; !exists {
+			changes = append(changes, RevisionChange{Field: field, Change: "added", To: value})
+		}
+	}
+
+	for field, value := range fromEnv {
+		other, exists := toEnv[field]
+		switch {
+		case !exists:
+			changes = append(changes, RevisionChange{Field: field, Change: "removed"})
+		case other != value:
+			changes = append(changes, RevisionChange{Field: field, Change: "changed"})
+		}
+	}
+	for field := range toEnv {
+		if _, exists := fromEnv[field]; !exists {
+			changes = append(changes, RevisionChange{Field: field, Change: "added"})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes
+}
+
+// RedeployRevision deploys a past revision of an app again with the same
+// image, source commit and configuration
+func (de *DeploymentEngine) RedeployRevision(appID string, number int, triggeredBy string) (*Deployment, error) {
+	de.mu.RLock()
+	revision, err := de.findRevision(appID, number)
+	de.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := redeployRequest(revision, triggeredBy)
+	if err != nil {
+		return nil, err
+	}
+
+	deployment, err := de.createDeployment(request, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	de.auditLogger.LogEvent("REVISION_REDEPLOYED", map[string]interface{}{
+		"app_id":        appID,
+		"revision":      number,
+		"deployment_id": deployment.ID,
+		"new_revision":  deployment.Revision,
+		"image_id":      revision.ImageID,
+		"triggered_by":  triggeredBy,
+	})
+
+	return deployment, nil
+}
+
+// redeployRequest returns the request that deploys a past revision again
+func redeployRequest(revision *Revision, triggeredBy string) (*DeploymentRequest, error) {
+	if revision.Request.AppID == "" {
+		return nil, fmt.Errorf("revision %d of app %s predates recorded requests and cannot be redeployed", revision.Number, revision.AppID)
+	}
+
+	request := revision.Request
+	request.TriggeredBy = triggeredBy
+
+	// Should the image be gone, rebuild or pull exactly what ran before
+	switch {
+	case request.Source.Type == "git" && revision.SourceCommit != "":
+		request.Source.Commit = revision.SourceCommit
+	case request.Source.Type == "docker" && revision.ImageDigest != "":
+		request.Source.Repository = fmt.Sprintf("%s@%s", imageRepository(request.Source.Repository), revision.ImageDigest)
+		request.Source.Tag = ""
+	}
+
+	return &request, nil
+}
+
+// EnvironmentKeys lists the names of the environment variables of a revision
+func (r *Revision) EnvironmentKeys() []string {
+	keys := make([]string, 0, len(r.Environment))
+	for key := range revisionEnvironment(r) {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// revisionEnvironment returns the environment a revision was deployed with
+func revisionEnvironment(revision *Revision) map[string]string {
+	if revision.Environment != nil {
+		return revision.Environment
+	}
+	return revision.Request.Environment
+}
+
+// deployedRevisions keeps the revisions that were deployed successfully
+func deployedRevisions(history []*Revision) []*Revision {
+	deployed := make([]*Revision, 0, len(history))
+	for _, revision := range history {
+		if revision.Outcome == RevisionSucceeded {
+			deployed = append(deployed, revision)
+		}
+	}
+	return deployed
+}
+
+// revisionSpecOf collects the comparable parts of a revision
+func revisionSpecOf(revision *Revision) revisionSpec {
+	spec := requestSpec(&revision.Request)
+	spec.Version = revision.Version
+	spec.ImageID = revision.ImageID
+	spec.ImageDigest = revision.ImageDigest
+	spec.SourceCommit = revision.SourceCommit
+	spec.HealthCheck = revision.HealthCheck
+	return spec
+}
+
+// requestSpec collects the comparable parts of a deployment request
+func requestSpec(request *DeploymentRequest) revisionSpec {
+	return revisionSpec{
+		Version:        request.Version,
+		Source:         request.Source,
+		Config:         request.Config,
+		ResourceLimits: request.ResourceLimits,
+		HealthCheck:    request.HealthCheck,
+		Ports:          request.Ports,
+		Networks:       request.Networks,
+		NetworkAliases: request.NetworkAliases,
+		Volumes:        request.Volumes,
+		Labels:         request.Labels,
+		Hooks:          request.Hooks,
+	}
+}
+
+// environmentFields keys the environment of a deployment and those its hooks
+// add by the field names a diff shows them under
+func environmentFields(hooks DeploymentHooks, environment map[string]string) map[string]string {
+	fields := make(map[string]string, len(environment))
+	for key, value := range environment {
+		fields["environment."+key] = value
+	}
+
+	for phase, list := range map[string][]HookConfig{"pre_deploy": hooks.PreDeploy, "post_deploy": hooks.PostDeploy} {
+		for i, hook := range list {
+			for key, value := range hook.Environment {
+				fields[fmt.Sprintf("hooks.%s[%d].environment.%s", phase, i, key)] = value
+			}
+		}
+	}
+
+	return fields
+}
+
+// withoutEnvironment copies hooks leaving out their environment, which is
+// compared by environmentFields instead
+func withoutEnvironment(hooks []HookConfig) []HookConfig {
+	if len(hooks) == 0 {
+		return hooks
+	}
+
+	stripped := make([]HookConfig, len(hooks))
+	for i, hook := range hooks {
+		hook.Environment = nil
+		stripped[i] = hook
+	}
+	return stripped
+}
+
+// flattenSpec turns a spec into dotted field names and their values
+func flattenSpec(spec revisionSpec) (map[string]string, error) {
+	spec.Source.Auth = nil // never shown
+	spec.Hooks.PreDeploy = withoutEnvironment(spec.Hooks.PreDeploy)
+	spec.Hooks.PostDeploy = withoutEnvironment(spec.Hooks.PostDeploy)
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic map[string]interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	flattenValue("", generic, fields)
+	return fields, nil
+}
+
+// flattenValue adds the leaves of a decoded JSON value to fields. Lists are
+// compared as a whole.
+func flattenValue(prefix string, value interface{}, fields map[string]string) {
+	if object, ok := value.(map[string]interface{}); ok {
+		for key, child := range object {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+			flattenValue(name, child, fields)
+		}
+		return
+	}
+
+	if value == nil || reflect.ValueOf(value).IsZero() {
+		return // unset and empty values count as absent
+	}
+	if list, ok := value.([]interface{}); ok && len(list) == 0 {
+		return
+	}
+
+	if text, ok := value.(string); ok {
+		fields[prefix] = text
+		return
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		logrus.Debugf("Failed to encode revision field %s: %v", prefix, err)
+		return
+	}
+	fields[prefix] = string(data)
+}
+
+// imageRepository strips the tag or digest from an image reference
+func imageRepository(image string) string {
+	if at := strings.Index(image, "@"); at >= 0 {
+		return image[:at]
+	}
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		return image[:colon]
+	}
+	return image
+}
@@ -0,0 +1,171 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// changeList renders changes as "field change from->to" lines
+func changeList(changes []RevisionChange) string {
+	lines := make([]string, len(changes))
+	for i, change := range changes {
+		lines[i] = fmt.Sprintf("%s %s %s->%s", change.Field, change.Change, change.From, change.To)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDiffRevisions(t *testing.T) {
+	request := func(version string, replicas int, environment map[string]string, hookEnvironment map[string]string, auth string) DeploymentRequest {
+		return DeploymentRequest{
+			AppID:       "web",
+			Version:     version,
+			Source:      DeploymentSource{Type: "docker", Repository: "nginx", Auth: map[string]string{"password": auth}},
+			Config:      DeploymentConfig{Replicas: replicas, Strategy: "rolling"},
+			Environment: environment,
+			Hooks: DeploymentHooks{PreDeploy: []HookConfig{
+				{Name: "migrate", Command: []string{"migrate"}, Environment: hookEnvironment},
+			}},
+		}
+	}
+
+	from := &Revision{
+		Number:  1,
+		AppID:   "web",
+		Version: "1.0",
+		ImageID: "sha256:aaa",
+		Request: request("1.0", 2, nil, map[string]string{"DB_PASSWORD": "hunter2"}, "old-registry-password"),
+		Outcome: RevisionSucceeded,
+		Environment: map[string]string{
+			"API_KEY":   "secret:api-key",
+			"LOG_LEVEL": "info",
+			"REMOVED":   "gone-value",
+		},
+	}
+	to := &Revision{
+		Number:  2,
+		AppID:   "web",
+		Version: "1.1",
+		ImageID: "sha256:bbb",
+		Request: request("1.1", 3, nil, map[string]string{"DB_PASSWORD": "correct-horse"}, "new-registry-password"),
+		Outcome: RevisionSucceeded,
+		Environment: map[string]string{
+			"API_KEY":   "secret:api-key",
+			"LOG_LEVEL": "debug",
+			"ADDED":     "new-value",
+		},
+	}
+
+	de := &DeploymentEngine{revisions: map[string][]*Revision{"web": {from, to}}}
+
+	changes, err := de.DiffRevisions("web", 1, 2)
+	if err != nil {
+		t.Fatalf("DiffRevisions: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"config.replicas changed 2->3",
+		"environment.ADDED added ->",
+		"environment.LOG_LEVEL changed ->",
+		"environment.REMOVED removed ->",
+		"hooks.pre_deploy[0].environment.DB_PASSWORD changed ->",
+		"image_id changed sha256:aaa->sha256:bbb",
+		"version changed 1.0->1.1",
+	}, "\n")
+	if got := changeList(changes); got != want {
+		t.Fatalf("changes:\n%s\nwant:\n%s", got, want)
+	}
+
+	// No value of the environment, a hook or the source credentials shows
+	for _, change := range changes {
+		for _, value := range []string{"info", "debug", "gone-value", "new-value", "hunter2", "correct-horse", "registry-password", "secret:"} {
+			if strings.Contains(change.From, value) || strings.Contains(change.To, value) {
+				t.Errorf("change of %s shows %q", change.Field, value)
+			}
+		}
+	}
+
+	if _, err := de.DiffRevisions("web", 1, 3); err == nil {
+		t.Errorf("DiffRevisions with an unknown revision succeeded")
+	}
+}
+
+func TestDiffSpecsIdentical(t *testing.T) {
+	request := &DeploymentRequest{
+		AppID:       "web",
+		Version:     "1",
+		Source:      DeploymentSource{Type: "docker", Repository: "nginx"},
+		Environment: map[string]string{"A": "1"},
+		Hooks:       DeploymentHooks{PostDeploy: []HookConfig{{Name: "warm", Command: []string{"warm"}, Environment: map[string]string{"B": "2"}}}},
+	}
+
+	fields, err := flattenSpec(requestSpec(request))
+	if err != nil {
+		t.Fatalf("flattenSpec: %v", err)
+	}
+	env := environmentFields(request.Hooks, request.Environment)
+
+	if changes := diffSpecs(fields, fields, env, env); len(changes) != 0 {
+		t.Fatalf("identical specs differ:\n%s", changeList(changes))
+	}
+
+	// Flattening leaves the hooks of the request untouched
+	if request.Hooks.PostDeploy[0].Environment["B"] != "2" {
+		t.Fatalf("flattenSpec changed the hook environment of the request")
+	}
+
+	for field, value := range fields {
+		if strings.Contains(field, "environment") || strings.Contains(value, `"B"`) {
+			t.Errorf("flattened spec holds environment in %s: %s", field, value)
+		}
+	}
+}
+
+func TestFlattenSpecSkipsEmptyValues(t *testing.T) {
+	fields, err := flattenSpec(revisionSpec{
+		Version: "1",
+		Config:  DeploymentConfig{Replicas: 2},
+		Ports:   []PortMapping{},
+		Labels:  map[string]string{"team": "web"},
+	})
+	if err != nil {
+		t.Fatalf("flattenSpec: %v", err)
+	}
+
+	want := map[string]string{"version": "1", "config.replicas": "2", "labels.team": "web"}
+	if len(fields) != len(want) {
+		t.Fatalf("fields %v, want %v", fields, want)
+	}
+	for field, value := range want {
+		if fields[field] != value {
+			t.Errorf("field %s = %q, want %q", field, fields[field], value)
+		}
+	}
+}
+
+func TestRedeployRequest(t *testing.T) {
+	revision := &Revision{
+		Number:      3,
+		AppID:       "web",
+		ImageDigest: "sha256:ddd",
+		Request:     DeploymentRequest{AppID: "web", Source: DeploymentSource{Type: "docker", Repository: "registry.local:5000/web:1.2", Tag: "1.2"}},
+	}
+
+	request, err := redeployRequest(revision, "alice")
+	if err != nil {
+		t.Fatalf("redeployRequest: %v", err)
+	}
+	if request.Source.Repository != "registry.local:5000/web@sha256:ddd" || request.Source.Tag != "" {
+		t.Errorf("source %+v, want the image pinned to its digest", request.Source)
+	}
+	if request.TriggeredBy != "alice" {
+		t.Errorf("triggered by %q, want alice", request.TriggeredBy)
+	}
+	if revision.Request.Source.Tag != "1.2" {
+		t.Errorf("redeployRequest changed the recorded request")
+	}
+
+	if _, err := redeployRequest(&Revision{Number: 1, AppID: "web"}, "alice"); err == nil {
+		t.Errorf("redeployRequest of a revision without a request succeeded")
+	}
+}
@@ -0,0 +1,85 @@
+package deploy
+
+import (
+	"testing"
+
+	"superagent/internal/deploy/docker"
+)
+
+func TestPreviousRevision(t *testing.T) {
+	revision := func(version, imageID string) *Revision {
+		return &Revision{AppID: "web", Version: version, ImageID: imageID, Outcome: RevisionSucceeded}
+	}
+
+	de := &DeploymentEngine{revisions: map[string][]*Revision{
+		"web": {revision("1.0", "sha256:aaa"), revision("1.1", "sha256:bbb"), revision("1.2", "sha256:ccc")},
+	}}
+
+	tests := []struct {
+		name    string
+		imageID string
+		want    string
+	}{
+		{"latest revision", "sha256:ccc", "1.1"},
+		{"older revision", "sha256:bbb", "1.0"},
+		{"first revision", "sha256:aaa", ""},
+		{"not yet recorded", "sha256:ddd", "1.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := de.previousRevision(&Deployment{AppID: "web", ImageID: tt.imageID})
+
+			got := ""
+			if previous != nil {
+				got = previous.Version
+			}
+			if got != tt.want {
+				t.Errorf("previous revision %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if previous := de.previousRevision(&Deployment{AppID: "api", ImageID: "sha256:aaa"}); previous != nil {
+		t.Errorf("previous revision of an app without history = %s", previous.Version)
+	}
+}
+
+func TestSharesHostPorts(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []docker.PortMapping
+		want bool
+	}{
+		{"same port", []docker.PortMapping{{HostPort: 8080}}, []docker.PortMapping{{HostPort: 8080, Protocol: "tcp"}}, true},
+		{"other port", []docker.PortMapping{{HostPort: 8080}}, []docker.PortMapping{{HostPort: 8081}}, false},
+		{"other protocol", []docker.PortMapping{{HostPort: 53, Protocol: "udp"}}, []docker.PortMapping{{HostPort: 53}}, false},
+		{"unpublished", []docker.PortMapping{{ContainerPort: 80}}, []docker.PortMapping{{ContainerPort: 80}}, false},
+		{"none", nil, []docker.PortMapping{{HostPort: 8080}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sharesHostPorts(tt.a, tt.b); got != tt.want {
+				t.Errorf("sharesHostPorts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthCheckTimings(t *testing.T) {
+	if got := healthCheckPeriod(HealthCheckConfig{}); got.Seconds() != 30 {
+		t.Errorf("default period %s, want 30s", got)
+	}
+	if got := healthCheckPeriod(HealthCheckConfig{PeriodSeconds: 5}); got.Seconds() != 5 {
+		t.Errorf("period %s, want 5s", got)
+	}
+
+	// The initial delay comes on top of the timeout of every check
+	if got := healthCheckTimeout(HealthCheckConfig{InitialDelaySeconds: 5}); got.Seconds() != 15 {
+		t.Errorf("default timeout %s, want 15s", got)
+	}
+	if got := healthCheckTimeout(HealthCheckConfig{TimeoutSeconds: 3}); got.Seconds() != 3 {
+		t.Errorf("timeout %s, want 3s", got)
+	}
+}
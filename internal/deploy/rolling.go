@@ -0,0 +1,211 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"superagent/internal/deploy/docker"
+
+	"github.com/sirupsen/logrus"
+)
+
+// claimPreviousDeployment finds the running deployment of the same app that a
+// new deployment replaces and marks it as updating so nothing else touches it
+func (de *DeploymentEngine) claimPreviousDeployment(deployment *Deployment) *Deployment {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	var previous *Deployment
+	for _, candidate := range de.deployments {
+		if candidate.ID == deployment.ID || candidate.AppID != deployment.AppID || candidate.Status != StatusRunning {
+			continue
+		}
+		if previous == nil || candidate.CreatedAt.After(previous.CreatedAt) {
+			previous = candidate
+		}
+	}
+
+	if previous != nil {
+		previous.Status = StatusUpdating
+	}
+
+	return previous
+}
+
+// rollingUpdate replaces the replicas of the previous deployment with those
+// of the new one in batches. Each batch may stop up to MaxUnavailable old
+// replicas and start up to MaxSurge extra ones, and the next batch only
+// starts once the new replicas pass their health check. When a batch fails
+// or ProgressTimeout runs out, the new replicas are removed and the old ones
+// are started again.
+func (de *DeploymentEngine) rollingUpdate(ctx context.Context, previous, deployment *Deployment, containerConfig docker.ContainerConfig) error {
+	de.stopDeploymentMonitoring(previous.ID)
+	de.updateDeploymentStatus(previous, StatusUpdating)
+	de.addDeploymentLog(previous, "info", fmt.Sprintf("Rolling update to version %s started by deployment %s", deployment.Version, deployment.ID))
+
+	desired := desiredReplicas(deployment)
+	maxSurge, maxUnavailable := rollingBudget(deployment.Config, desired)
+
+	// Old replicas that still serve traffic
+	var remaining []*Replica
+	for _, replica := range deploymentReplicas(previous) {
+		if replica.Status != ReplicaStopped && replica.Status != ReplicaFailed {
+			remaining = append(remaining, replica)
+		}
+	}
+
+	var added []*Replica   // new replicas started so far
+	var retired []*Replica // old replicas stopped so far
+
+	de.addDeploymentLog(deployment, "info", fmt.Sprintf("Rolling update replacing %d replicas of deployment %s (max surge %d, max unavailable %d)", len(remaining), previous.ID, maxSurge, maxUnavailable))
+
+	for batch := 1; len(added) < desired; batch++ {
+		if err := ctx.Err(); err != nil {
+			return de.revertRollingUpdate(previous, deployment, added, retired, fmt.Errorf("progress deadline exceeded: %w", err))
+		}
+
+		// Take down what the unavailability budget allows before surging
+		stopCount := min(maxUnavailable, len(remaining))
+		for _, replica := range remaining[:stopCount] {
+			if err := de.stopReplica(replica); err != nil {
+				return de.revertRollingUpdate(previous, deployment, added, retired, err)
+			}
+			retired = append(retired, replica)
+		}
+		remaining = remaining[stopCount:]
+
+		count := min(desired-len(added), maxSurge+stopCount)
+		if len(remaining) == 0 {
+			// Nothing left to keep available
+			count = desired - len(added)
+		}
+
+		// New replicas take over the ports of the old ones they replace
+		released := make(map[string]bool, len(retired))
+		for _, replica := range retired {
+			released[replica.ContainerID] = true
+		}
+
+		batchReplicas, err := de.startReplicas(ctx, deployment, containerConfig, deployment.Ports, len(added), count, released)
+		if err != nil {
+			return de.revertRollingUpdate(previous, deployment, added, retired, fmt.Errorf("batch %d: %w", batch, err))
+		}
+
+		added = append(added, batchReplicas...)
+		de.setReplicas(deployment, added)
+
+		if deployment.HealthCheck.Enabled {
+			if err := de.checkReplicas(ctx, deployment, batchReplicas, deployment.HealthCheck, deployment.Ports); err != nil {
+				if ctx.Err() != nil {
+					err = fmt.Errorf("progress deadline exceeded: %w", err)
+				}
+				return de.revertRollingUpdate(previous, deployment, added, retired, fmt.Errorf("batch %d health check failed: %w", batch, err))
+			}
+		} else {
+			for _, replica := range batchReplicas {
+				replica.Status = ReplicaRunning
+			}
+		}
+
+		// Retire the old replicas the healthy batch stands in for
+		excess := min(len(remaining), len(remaining)+len(added)-desired)
+		for excess > 0 {
+			if err := de.stopReplica(remaining[0]); err != nil {
+				return de.revertRollingUpdate(previous, deployment, added, retired, err)
+			}
+			retired = append(retired, remaining[0])
+			remaining = remaining[1:]
+			excess--
+		}
+
+		de.addDeploymentLog(deployment, "info", fmt.Sprintf("Rolling update batch %d ready: %d/%d new replicas, %d old replicas left", batch, len(added), desired, len(remaining)))
+	}
+
+	// The previous deployment may have run more replicas than the new one wants
+	for _, replica := range remaining {
+		if err := de.stopReplica(replica); err != nil {
+			logrus.Warnf("Failed to stop container %s: %v", replica.ContainerID, err)
+		}
+		retired = append(retired, replica)
+	}
+
+	de.removeReplicas(retired)
+	de.setReplicas(previous, nil)
+
+	de.addDeploymentLog(previous, "info", fmt.Sprintf("Replaced by deployment %s (version %s)", deployment.ID, deployment.Version))
+	de.updateDeploymentStatus(previous, StatusStopped)
+
+	de.auditLogger.LogEvent("DEPLOYMENT_ROLLING_UPDATE", map[string]interface{}{
+		"deployment_id":          deployment.ID,
+		"previous_deployment_id": previous.ID,
+		"previous_version":       previous.Version,
+		"version":                deployment.Version,
+		"replicas":               len(added),
+		"max_surge":              maxSurge,
+		"max_unavailable":        maxUnavailable,
+	})
+
+	return nil
+}
+
+// revertRollingUpdate removes the new replicas of a failed rolling update and
+// brings the previous deployment back to where it was
+func (de *DeploymentEngine) revertRollingUpdate(previous, deployment *Deployment, added, retired []*Replica, cause error) error {
+	de.removeReplicas(added)
+	de.setReplicas(deployment, nil)
+
+	for _, replica := range retired {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := de.dockerManager.StartContainer(ctx, replica.ContainerID); err != nil {
+			logrus.Warnf("Failed to restart container %s after reverted rolling update: %v", replica.ContainerID, err)
+			replica.Status = ReplicaFailed
+		} else {
+			de.allocateContainer(previous.AppID, replica.ContainerID, previous.ResourceLimits)
+			replica.Status = ReplicaRunning
+			replica.HealthCheckFailures = 0
+		}
+		cancel()
+	}
+
+	de.addDeploymentLog(previous, "warn", fmt.Sprintf("Rolling update to version %s reverted: %v", deployment.Version, cause))
+	de.updateDeploymentStatus(previous, StatusRunning)
+	de.startDeploymentMonitoring(previous)
+
+	de.auditLogger.LogEvent("DEPLOYMENT_ROLLING_UPDATE_REVERTED", map[string]interface{}{
+		"deployment_id":          deployment.ID,
+		"previous_deployment_id": previous.ID,
+		"version":                deployment.Version,
+		"error":                  cause.Error(),
+	})
+
+	return fmt.Errorf("rolling update reverted: %w", cause)
+}
+
+// stopReplica stops the container of a replica without removing it
+func (de *DeploymentEngine) stopReplica(replica *Replica) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := de.dockerManager.StopContainer(ctx, replica.ContainerID, 10); err != nil {
+		return fmt.Errorf("failed to stop replica %d: %w", replica.Index, err)
+	}
+	de.releaseContainer(replica.ContainerID)
+
+	replica.Status = ReplicaStopped
+	return nil
+}
+
+// rollingBudget returns how many replicas a rolling update may add above and
+// take away below the desired count at a time
+func rollingBudget(config DeploymentConfig, desired int) (int, int) {
+	maxSurge := max(config.MaxSurge, 0)
+	maxUnavailable := min(max(config.MaxUnavailable, 0), desired)
+
+	// Without any budget the update could never make progress
+	if maxSurge == 0 && maxUnavailable == 0 {
+		maxSurge = 1
+	}
+
+	return maxSurge, maxUnavailable
+}
@@ -0,0 +1,64 @@
+package deploy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRollingBudget(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         DeploymentConfig
+		desired        int
+		surge, unavail int
+	}{
+		{"defaults surge by one", DeploymentConfig{}, 3, 1, 0},
+		{"surge only", DeploymentConfig{MaxSurge: 2}, 3, 2, 0},
+		{"unavailable only", DeploymentConfig{MaxUnavailable: 1}, 3, 0, 1},
+		{"both", DeploymentConfig{MaxSurge: 1, MaxUnavailable: 2}, 3, 1, 2},
+		{"unavailable capped at desired", DeploymentConfig{MaxUnavailable: 5}, 2, 0, 2},
+		{"negative values ignored", DeploymentConfig{MaxSurge: -1, MaxUnavailable: -1}, 3, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			surge, unavailable := rollingBudget(tt.config, tt.desired)
+			if surge != tt.surge || unavailable != tt.unavail {
+				t.Errorf("rollingBudget = surge %d, unavailable %d, want %d, %d", surge, unavailable, tt.surge, tt.unavail)
+			}
+		})
+	}
+}
+
+func TestAllocateReplicaPortsDuringUpdate(t *testing.T) {
+	basePorts := []PortMapping{{ContainerPort: 80, HostPort: 8080, Protocol: "tcp"}}
+
+	// The deployment being replaced still holds the configured port, so the
+	// surge replica moves up
+	previous := portDeployment("d1", "web", StatusUpdating, 8080, 8081)
+	de := portEngine(previous)
+
+	ports, err := de.allocateReplicaPorts("web", basePorts, 0, nil, make(map[int]bool))
+	if err != nil {
+		t.Fatalf("allocateReplicaPorts: %v", err)
+	}
+	if ports[0].HostPort != 8082 {
+		t.Errorf("surge replica on %d, want 8082", ports[0].HostPort)
+	}
+
+	// A replica replacing a retired one takes over its port
+	ports, err = de.allocateReplicaPorts("web", basePorts, 1, map[string]bool{"d1-b": true}, make(map[int]bool))
+	if err != nil {
+		t.Fatalf("allocateReplicaPorts: %v", err)
+	}
+	if ports[0].HostPort != 8081 {
+		t.Errorf("replacing replica on %d, want the retired replica's 8081", ports[0].HostPort)
+	}
+
+	// Without an update going on the port is in conflict, even within the app
+	previous.Status = StatusRunning
+	_, err = de.allocateReplicaPorts("web", basePorts, 0, nil, make(map[int]bool))
+	if err == nil || !strings.Contains(err.Error(), "already bound by deployment d1") {
+		t.Fatalf("allocateReplicaPorts error %v, want a port conflict with d1", err)
+	}
+}
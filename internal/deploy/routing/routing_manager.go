@@ -0,0 +1,300 @@
+package routing
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"superagent/internal/config"
+	"superagent/internal/logging"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// RoutingManager publishes application routes to Traefik through its file
+// provider. Routes owned by the agent are prefixed so that anything else in
+// the dynamic configuration file is left untouched.
+type RoutingManager struct {
+	config      config.TraefikConfig
+	auditLogger *logging.AuditLogger
+	mu          sync.Mutex
+}
+
+// Route describes where the traffic of an application goes
+type Route struct {
+	AppID   string   `json:"app_id"`
+	Color   string   `json:"color,omitempty"`
+	Servers []string `json:"servers"`
+}
+
+const (
+	routePrefix = "superagent-"
+
+	// Suffixes of the services a weighted route splits traffic between
+	stableSuffix = "-stable"
+	canarySuffix = "-canary"
+)
+
+// NewRoutingManager creates a new routing manager
+func NewRoutingManager(cfg config.TraefikConfig, auditLogger *logging.AuditLogger) (*RoutingManager, error) {
+	rm := &RoutingManager{
+		config:      cfg,
+		auditLogger: auditLogger,
+	}
+
+	auditLogger.LogEvent("ROUTING_MANAGER_INITIALIZED", map[string]interface{}{
+		"enabled":     rm.Enabled(),
+		"config_file": cfg.ConfigFile,
+	})
+
+	return rm, nil
+}
+
+// Enabled reports whether routes are written to Traefik
+func (rm *RoutingManager) Enabled() bool {
+	return rm.config.Enabled && rm.config.Provider == "file" && rm.config.ConfigFile != ""
+}
+
+// SetRoute points the router of an application at a new set of servers.
+// The dynamic configuration is replaced with a single rename so Traefik
+// never sees a mix of old and new servers.
+func (rm *RoutingManager) SetRoute(route Route) error {
+	if !rm.Enabled() {
+		logrus.Debugf("Traefik routing disabled, not switching route of app %s", route.AppID)
+		return nil
+	}
+
+	if len(route.Servers) == 0 {
+		return fmt.Errorf("route for app %s has no servers", route.AppID)
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	dynamic, err := rm.loadDynamicConfig()
+	if err != nil {
+		return err
+	}
+
+	routers, services := httpSection(dynamic)
+	name := routePrefix + route.AppID
+
+	routers[name] = rm.router(route.AppID)
+	services[name] = loadBalancer(route.Servers)
+	delete(services, name+stableSuffix)
+	delete(services, name+canarySuffix)
+
+	if err := rm.saveDynamicConfig(dynamic); err != nil {
+		return err
+	}
+
+	rm.auditLogger.LogEvent("ROUTE_UPDATED", map[string]interface{}{
+		"app_id":  route.AppID,
+		"color":   route.Color,
+		"servers": route.Servers,
+	})
+
+	return nil
+}
+
+// SetWeightedRoute splits the traffic of an application between a stable and
+// a canary set of servers. canaryWeight is the percentage of requests sent to
+// the canary.
+func (rm *RoutingManager) SetWeightedRoute(stable, canary Route, canaryWeight int) error {
+	if !rm.Enabled() {
+		logrus.Debugf("Traefik routing disabled, not splitting traffic of app %s", stable.AppID)
+		return nil
+	}
+
+	if len(stable.Servers) == 0 || len(canary.Servers) == 0 {
+		return fmt.Errorf("weighted route for app %s needs stable and canary servers", stable.AppID)
+	}
+
+	if canaryWeight < 0 || canaryWeight > 100 {
+		return fmt.Errorf("invalid canary weight %d for app %s", canaryWeight, stable.AppID)
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	dynamic, err := rm.loadDynamicConfig()
+	if err != nil {
+		return err
+	}
+
+	routers, services := httpSection(dynamic)
+	name := routePrefix + stable.AppID
+
+	routers[name] = rm.router(stable.AppID)
+	services[name+stableSuffix] = loadBalancer(stable.Servers)
+	services[name+canarySuffix] = loadBalancer(canary.Servers)
+	services[name] = map[string]interface{}{
+		"weighted": map[string]interface{}{
+			"services": []interface{}{
+				map[string]interface{}{"name": name + stableSuffix, "weight": 100 - canaryWeight},
+				map[string]interface{}{"name": name + canarySuffix, "weight": canaryWeight},
+			},
+		},
+	}
+
+	if err := rm.saveDynamicConfig(dynamic); err != nil {
+		return err
+	}
+
+	rm.auditLogger.LogEvent("ROUTE_WEIGHTS_UPDATED", map[string]interface{}{
+		"app_id":         stable.AppID,
+		"stable_color":   stable.Color,
+		"canary_color":   canary.Color,
+		"stable_servers": stable.Servers,
+		"canary_servers": canary.Servers,
+		"canary_weight":  canaryWeight,
+	})
+
+	return nil
+}
+
+// RemoveRoute removes the router and service of an application
+func (rm *RoutingManager) RemoveRoute(appID string) error {
+	if !rm.Enabled() {
+		return nil
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	dynamic, err := rm.loadDynamicConfig()
+	if err != nil {
+		return err
+	}
+
+	routers, services := httpSection(dynamic)
+	delete(routers, routePrefix+appID)
+	delete(services, routePrefix+appID)
+	delete(services, routePrefix+appID+stableSuffix)
+	delete(services, routePrefix+appID+canarySuffix)
+
+	if err := rm.saveDynamicConfig(dynamic); err != nil {
+		return err
+	}
+
+	rm.auditLogger.LogEvent("ROUTE_REMOVED", map[string]interface{}{
+		"app_id": appID,
+	})
+
+	return nil
+}
+
+// router builds the router of an application
+func (rm *RoutingManager) router(appID string) map[string]interface{} {
+	router := map[string]interface{}{
+		"rule":    rm.routeRule(appID),
+		"service": routePrefix + appID,
+	}
+	if rm.config.EnableTLS {
+		tls := map[string]interface{}{}
+		if rm.config.CertResolver != "" {
+			tls["certResolver"] = rm.config.CertResolver
+		}
+		router["tls"] = tls
+	}
+	if len(rm.config.Middlewares) > 0 {
+		router["middlewares"] = rm.config.Middlewares
+	}
+	return router
+}
+
+func (rm *RoutingManager) routeRule(appID string) string {
+	if rm.config.BaseDomain == "" {
+		return fmt.Sprintf("PathPrefix(`/%s`)", appID)
+	}
+	return fmt.Sprintf("Host(`%s.%s`)", appID, strings.TrimPrefix(rm.config.BaseDomain, "."))
+}
+
+func (rm *RoutingManager) loadDynamicConfig() (map[string]interface{}, error) {
+	dynamic := make(map[string]interface{})
+
+	data, err := os.ReadFile(rm.config.ConfigFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return dynamic, nil
+		}
+		return nil, fmt.Errorf("failed to read traefik config: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &dynamic); err != nil {
+		return nil, fmt.Errorf("failed to parse traefik config: %w", err)
+	}
+
+	if dynamic == nil {
+		dynamic = make(map[string]interface{})
+	}
+
+	return dynamic, nil
+}
+
+func (rm *RoutingManager) saveDynamicConfig(dynamic map[string]interface{}) error {
+	data, err := yaml.Marshal(dynamic)
+	if err != nil {
+		return fmt.Errorf("failed to marshal traefik config: %w", err)
+	}
+
+	// The directory is only created once a route is written, so an agent
+	// that never routes needs no access to it
+	if err := os.MkdirAll(filepath.Dir(rm.config.ConfigFile), 0755); err != nil {
+		return fmt.Errorf("failed to create traefik config directory: %w", err)
+	}
+
+	// Write to temporary file first
+	tempPath := rm.config.ConfigFile + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write traefik config: %w", err)
+	}
+
+	// Atomic rename
+	if err := os.Rename(tempPath, rm.config.ConfigFile); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to replace traefik config: %w", err)
+	}
+
+	return nil
+}
+
+// loadBalancer builds a load balanced service over a set of servers
+func loadBalancer(urls []string) map[string]interface{} {
+	servers := make([]interface{}, 0, len(urls))
+	for _, url := range urls {
+		servers = append(servers, map[string]interface{}{"url": url})
+	}
+	return map[string]interface{}{
+		"loadBalancer": map[string]interface{}{
+			"servers": servers,
+		},
+	}
+}
+
+// httpSection returns the HTTP routers and services of a dynamic
+// configuration, creating them when missing
+func httpSection(dynamic map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	httpConfig, ok := dynamic["http"].(map[string]interface{})
+	if !ok {
+		httpConfig = make(map[string]interface{})
+		dynamic["http"] = httpConfig
+	}
+
+	routers, ok := httpConfig["routers"].(map[string]interface{})
+	if !ok {
+		routers = make(map[string]interface{})
+		httpConfig["routers"] = routers
+	}
+
+	services, ok := httpConfig["services"].(map[string]interface{})
+	if !ok {
+		services = make(map[string]interface{})
+		httpConfig["services"] = services
+	}
+
+	return routers, services
+}
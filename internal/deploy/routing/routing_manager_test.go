@@ -0,0 +1,105 @@
+package routing
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"superagent/internal/config"
+	"superagent/internal/logging"
+
+	"gopkg.in/yaml.v3"
+)
+
+// newTestManager creates a routing manager writing to a file in a directory
+// that does not exist yet
+func newTestManager(t *testing.T) (*RoutingManager, string) {
+	dir := t.TempDir()
+
+	auditLogger, err := logging.NewAuditLogger(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatalf("NewAuditLogger: %v", err)
+	}
+	t.Cleanup(func() { auditLogger.Close() })
+
+	configFile := filepath.Join(dir, "traefik", "dynamic.yml")
+	rm, err := NewRoutingManager(config.TraefikConfig{Enabled: true, Provider: "file", ConfigFile: configFile}, auditLogger)
+	if err != nil {
+		t.Fatalf("NewRoutingManager: %v", err)
+	}
+	return rm, configFile
+}
+
+// readServices returns the services of the dynamic configuration
+func readServices(t *testing.T, configFile string) map[string]interface{} {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatalf("reading traefik config: %v", err)
+	}
+
+	var dynamic map[string]interface{}
+	if err := yaml.Unmarshal(data, &dynamic); err != nil {
+		t.Fatalf("parsing traefik config: %v", err)
+	}
+	_, services := httpSection(dynamic)
+	return services
+}
+
+func TestSetRouteCreatesConfigLazily(t *testing.T) {
+	rm, configFile := newTestManager(t)
+
+	if _, err := os.Stat(filepath.Dir(configFile)); !os.IsNotExist(err) {
+		t.Fatalf("config directory exists before any route was written: %v", err)
+	}
+
+	if err := rm.SetRoute(Route{AppID: "web", Color: "blue", Servers: []string{"http://127.0.0.1:8080"}}); err != nil {
+		t.Fatalf("SetRoute: %v", err)
+	}
+
+	services := readServices(t, configFile)
+	if _, ok := services["superagent-web"]; !ok {
+		t.Fatalf("services %v, want superagent-web", services)
+	}
+}
+
+func TestSetRouteKeepsForeignEntries(t *testing.T) {
+	rm, configFile := newTestManager(t)
+
+	if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	foreign := "http:\n  services:\n    dashboard:\n      loadBalancer:\n        servers:\n          - url: http://127.0.0.1:9000\n"
+	if err := os.WriteFile(configFile, []byte(foreign), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rm.SetRoute(Route{AppID: "web", Servers: []string{"http://127.0.0.1:8080"}}); err != nil {
+		t.Fatalf("SetRoute: %v", err)
+	}
+	if err := rm.RemoveRoute("web"); err != nil {
+		t.Fatalf("RemoveRoute: %v", err)
+	}
+
+	services := readServices(t, configFile)
+	if _, ok := services["superagent-web"]; ok {
+		t.Errorf("route of web left behind after RemoveRoute")
+	}
+	if _, ok := services["dashboard"]; !ok {
+		t.Errorf("services %v, want the dashboard service kept", services)
+	}
+}
+
+func TestSetRouteWithoutServers(t *testing.T) {
+	rm, _ := newTestManager(t)
+
+	if err := rm.SetRoute(Route{AppID: "web"}); err == nil {
+		t.Fatalf("SetRoute without servers succeeded")
+	}
+}
+
+func TestRoutingDisabled(t *testing.T) {
+	rm := &RoutingManager{config: config.TraefikConfig{Enabled: true, Provider: "file"}}
+	if rm.Enabled() {
+		t.Fatalf("routing enabled without a config file")
+	}
+}
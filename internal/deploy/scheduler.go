@@ -0,0 +1,305 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultMaxConcurrentOps caps concurrent builds and pulls when the agent
+// config does not
+const defaultMaxConcurrentOps = 5
+
+// errOperationSuperseded is returned to a queued deployment replaced by a
+// newer deployment of the same app before it started
+var errOperationSuperseded = errors.New("superseded by a newer deployment")
+
+// operationScheduler runs the operations on each app one at a time, in the
+// order they were submitted, and hands out the build slots shared by all
+// apps. A deployment still waiting in the queue of its app is dropped when a
+// newer deployment of the app is queued behind it.
+//
+// The queue fields of deployments are written under deploymentMu, the lock
+// guarding the deployments, which is always taken before mu.
+type operationScheduler struct {
+	queues       map[string]*appQueue
+	buildSlots   chan struct{}
+	deploymentMu sync.Locker
+	mu           sync.Mutex
+}
+
+// appQueue holds the operation running on an app and those waiting for it
+type appQueue struct {
+	active  *operation
+	waiting []*operation
+}
+
+// operation is a turn in the queue of an app
+type operation struct {
+	appID        string
+	deployment   *Deployment // nil for operations other than deployments
+	queuedAt     time.Time
+	ready        chan struct{}
+	superseded   chan struct{}
+	supersededBy string
+}
+
+func newOperationScheduler(maxConcurrentOps int, deploymentMu sync.Locker) *operationScheduler {
+	if maxConcurrentOps <= 0 {
+		maxConcurrentOps = defaultMaxConcurrentOps
+	}
+
+	return &operationScheduler{
+		queues:       make(map[string]*appQueue),
+		buildSlots:   make(chan struct{}, maxConcurrentOps),
+		deploymentMu: deploymentMu,
+	}
+}
+
+// enqueue adds an operation to the queue of an app. When the operation is a
+// deployment, deployments of the app still waiting are superseded by it and
+// returned. The caller holds deploymentMu.
+func (s *operationScheduler) enqueue(appID string, deployment *Deployment) (*operation, []*Deployment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op := &operation{
+		appID:      appID,
+		deployment: deployment,
+		queuedAt:   time.Now(),
+		ready:      make(chan struct{}),
+		superseded: make(chan struct{}),
+	}
+
+	queue, ok := s.queues[appID]
+	if !ok {
+		queue = &appQueue{}
+		s.queues[appID] = queue
+	}
+
+	var superseded []*Deployment
+	if deployment != nil {
+		kept := queue.waiting[:0]
+		for _, waiting := range queue.waiting {
+			if waiting.deployment == nil {
+				kept = append(kept, waiting)
+				continue
+			}
+			waiting.supersededBy = deployment.ID
+			waiting.deployment.QueuePosition = 0
+			close(waiting.superseded)
+			superseded = append(superseded, waiting.deployment)
+		}
+		queue.waiting = kept
+	}
+
+	if deployment != nil {
+		deployment.QueuedAt = &op.queuedAt
+	}
+
+	if queue.active == nil {
+		queue.active = op
+		close(op.ready)
+	} else {
+		queue.waiting = append(queue.waiting, op)
+	}
+	queue.updatePositions()
+
+	return op, superseded
+}
+
+// wait blocks until it is the operation's turn
+func (s *operationScheduler) wait(ctx context.Context, op *operation) error {
+	select {
+	case <-op.ready:
+		return nil
+	case <-op.superseded:
+		return errOperationSuperseded
+	case <-ctx.Done():
+		s.finish(op)
+		return ctx.Err()
+	}
+}
+
+// finish takes an operation out of the queue of its app and lets the next
+// one start
+func (s *operationScheduler) finish(op *operation) {
+	s.deploymentMu.Lock()
+	defer s.deploymentMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue, ok := s.queues[op.appID]
+	if !ok {
+		return
+	}
+
+	if queue.active == op {
+		queue.active = nil
+		if len(queue.waiting) > 0 {
+			queue.active = queue.waiting[0]
+			queue.waiting = queue.waiting[1:]
+			close(queue.active.ready)
+		}
+	} else {
+		for i, waiting := range queue.waiting {
+			if waiting == op {
+				queue.waiting = append(queue.waiting[:i], queue.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+
+	if queue.active == nil && len(queue.waiting) == 0 {
+		delete(s.queues, op.appID)
+		return
+	}
+	queue.updatePositions()
+}
+
+// updatePositions numbers the waiting deployments of an app from 1
+func (q *appQueue) updatePositions() {
+	if q.active != nil && q.active.deployment != nil {
+		q.active.deployment.QueuePosition = 0
+	}
+	for i, waiting := range q.waiting {
+		if waiting.deployment != nil {
+			waiting.deployment.QueuePosition = i + 1
+		}
+	}
+}
+
+// acquireBuildSlot blocks until a build or pull may start
+func (s *operationScheduler) acquireBuildSlot(ctx context.Context) error {
+	select {
+	case s.buildSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *operationScheduler) releaseBuildSlot() {
+	<-s.buildSlots
+}
+
+// lockApp waits until no other operation runs on an app. The returned
+// function releases the app again.
+func (de *DeploymentEngine) lockApp(appID string) (func(), error) {
+	de.mu.Lock()
+	op, _ := de.scheduler.enqueue(appID, nil)
+	de.mu.Unlock()
+
+	if err := de.scheduler.wait(de.ctx, op); err != nil {
+		return nil, fmt.Errorf("failed waiting for operations on app %s: %w", appID, err)
+	}
+
+	return func() { de.scheduler.finish(op) }, nil
+}
+
+// lockDeploymentApp waits until no other operation runs on the app of a
+// deployment
+func (de *DeploymentEngine) lockDeploymentApp(deploymentID string) (func(), error) {
+	de.mu.RLock()
+	deployment, exists := de.deployments[deploymentID]
+	de.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("deployment not found: %s", deploymentID)
+	}
+
+	return de.lockApp(deployment.AppID)
+}
+
+// queueDeployment puts a deployment in the queue of its app and drops the
+// queued deployments it supersedes. Callers must hold de.mu.
+func (de *DeploymentEngine) queueDeployment(deployment *Deployment) *operation {
+	op, superseded := de.scheduler.enqueue(deployment.AppID, deployment)
+
+	for _, previous := range superseded {
+		de.auditLogger.LogEvent("DEPLOYMENT_SUPERSEDED", map[string]interface{}{
+			"deployment_id": previous.ID,
+			"superseded_by": deployment.ID,
+		})
+	}
+
+	return op
+}
+
+// waitForTurn holds a deployment in the queue of its app until earlier
+// operations are done. It reports false when the deployment must not go
+// ahead.
+func (de *DeploymentEngine) waitForTurn(ctx context.Context, deployment *Deployment, op *operation) bool {
+	de.mu.RLock()
+	position := deployment.QueuePosition
+	de.mu.RUnlock()
+
+	if position > 0 {
+		de.addDeploymentLog(deployment, "info", fmt.Sprintf("Queued at position %d behind earlier operations on app %s", position, deployment.AppID))
+	}
+
+	err := de.scheduler.wait(ctx, op)
+	switch {
+	case err == nil:
+		de.mu.Lock()
+		deployment.QueuePosition = 0
+		deployment.QueueWait = time.Since(op.queuedAt)
+		de.mu.Unlock()
+		return true
+
+	case errors.Is(err, errOperationSuperseded):
+		de.addDeploymentLog(deployment, "info", fmt.Sprintf("Superseded by deployment %s before it started", op.supersededBy))
+		de.updateDeploymentStatus(deployment, StatusSuperseded)
+		de.concludeRevision(deployment, err)
+
+	case errors.Is(context.Cause(ctx), errDeploymentCancelled):
+		de.cleanupCancelledDeployment(deployment)
+
+	default:
+		// The agent is shutting down; the deployment stays pending and is
+		// picked up again on the next start
+		de.saveDeployment(deployment)
+	}
+
+	return false
+}
+
+// prepareImage builds or pulls the image of a deployment once one of the
+// shared build slots is free
+func (de *DeploymentEngine) prepareImage(ctx context.Context, deployment *Deployment) (string, error) {
+	// A redeployed revision runs the very image it ran before, as long as
+	// the image is still around
+	if deployment.ImageID != "" {
+		if _, err := de.dockerManager.GetImageInfo(ctx, deployment.ImageID); err == nil {
+			de.addBuildLog(deployment, "info", fmt.Sprintf("Reusing image %s", deployment.ImageID))
+			return deployment.ImageID, nil
+		}
+		de.addBuildLog(deployment, "warn", fmt.Sprintf("Image %s is gone, rebuilding it from source", deployment.ImageID))
+	}
+
+	waitStart := time.Now()
+	select {
+	case de.scheduler.buildSlots <- struct{}{}:
+	default:
+		de.addDeploymentLog(deployment, "info", fmt.Sprintf("Waiting for one of %d build slots", cap(de.scheduler.buildSlots)))
+		if err := de.scheduler.acquireBuildSlot(ctx); err != nil {
+			return "", err
+		}
+	}
+	defer de.scheduler.releaseBuildSlot()
+
+	de.mu.Lock()
+	deployment.QueueWait += time.Since(waitStart)
+	de.mu.Unlock()
+
+	switch deployment.Source.Type {
+	case "git":
+		return de.buildFromGit(ctx, deployment)
+	case "docker":
+		return de.pullDockerImage(ctx, deployment)
+	default:
+		return "", fmt.Errorf("unsupported source type: %s", deployment.Source.Type)
+	}
+}
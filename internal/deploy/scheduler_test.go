@@ -0,0 +1,176 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// opReady reports whether an operation may start
+func opReady(op *operation) bool {
+	select {
+	case <-op.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestSchedulerRunsOperationsInOrder(t *testing.T) {
+	s := newOperationScheduler(1, new(sync.Mutex))
+
+	first, _ := s.enqueue("app", nil)
+	second, _ := s.enqueue("app", nil)
+	other, _ := s.enqueue("other", nil)
+
+	if !opReady(first) || opReady(second) {
+		t.Fatalf("only the first operation on the app should be ready")
+	}
+	if !opReady(other) {
+		t.Fatalf("an operation on another app should not wait")
+	}
+
+	s.finish(first)
+	if !opReady(second) {
+		t.Fatalf("second operation not ready after the first finished")
+	}
+
+	s.finish(second)
+	s.finish(other)
+	if len(s.queues) != 0 {
+		t.Fatalf("%d queues left after all operations finished", len(s.queues))
+	}
+}
+
+func TestSchedulerNewerDeploymentSupersedesWaiting(t *testing.T) {
+	s := newOperationScheduler(1, new(sync.Mutex))
+
+	running := &Deployment{ID: "d1"}
+	waiting := &Deployment{ID: "d2"}
+	newer := &Deployment{ID: "d3"}
+
+	active, _ := s.enqueue("app", running)
+	queued, superseded := s.enqueue("app", waiting)
+	if len(superseded) != 0 {
+		t.Fatalf("running deployment was superseded")
+	}
+	if waiting.QueuePosition != 1 {
+		t.Fatalf("waiting deployment at position %d, want 1", waiting.QueuePosition)
+	}
+
+	// Operations other than deployments keep their place
+	lock, _ := s.enqueue("app", nil)
+
+	latest, superseded := s.enqueue("app", newer)
+	if len(superseded) != 1 || superseded[0] != waiting {
+		t.Fatalf("superseded %v, want the waiting deployment", superseded)
+	}
+	if queued.supersededBy != "d3" {
+		t.Fatalf("superseded by %q, want d3", queued.supersededBy)
+	}
+	if err := s.wait(context.Background(), queued); !errors.Is(err, errOperationSuperseded) {
+		t.Fatalf("wait of superseded deployment = %v, want %v", err, errOperationSuperseded)
+	}
+	if waiting.QueuePosition != 0 || newer.QueuePosition != 2 {
+		t.Fatalf("positions %d and %d, want 0 and 2", waiting.QueuePosition, newer.QueuePosition)
+	}
+
+	// The superseded deployment finishing does not disturb the queue
+	s.finish(queued)
+	if opReady(lock) {
+		t.Fatalf("queued operation started while the running one is active")
+	}
+
+	s.finish(active)
+	if !opReady(lock) || opReady(latest) {
+		t.Fatalf("the lock queued before the newer deployment should go first")
+	}
+	if newer.QueuePosition != 1 {
+		t.Fatalf("newer deployment at position %d, want 1", newer.QueuePosition)
+	}
+
+	s.finish(lock)
+	if err := s.wait(context.Background(), latest); err != nil {
+		t.Fatalf("wait of newer deployment = %v", err)
+	}
+}
+
+func TestSchedulerCancelWhileWaitingReleasesQueue(t *testing.T) {
+	s := newOperationScheduler(1, new(sync.Mutex))
+
+	active, _ := s.enqueue("app", nil)
+	cancelled, _ := s.enqueue("app", nil)
+	next, _ := s.enqueue("app", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.wait(ctx, cancelled) }()
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("wait = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("wait did not return after its context was cancelled")
+	}
+
+	// The cancelled operation left the queue, so the next one follows the
+	// active one directly
+	s.finish(active)
+	if !opReady(next) {
+		t.Fatalf("operation behind the cancelled one not ready")
+	}
+	if opReady(cancelled) {
+		t.Fatalf("cancelled operation was started")
+	}
+
+	s.finish(next)
+	if len(s.queues) != 0 {
+		t.Fatalf("%d queues left after all operations finished", len(s.queues))
+	}
+}
+
+func TestSchedulerBuildSlots(t *testing.T) {
+	s := newOperationScheduler(2, new(sync.Mutex))
+
+	for i := 0; i < 2; i++ {
+		if err := s.acquireBuildSlot(context.Background()); err != nil {
+			t.Fatalf("acquireBuildSlot %d: %v", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.acquireBuildSlot(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquireBuildSlot beyond the limit = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	acquired := make(chan error, 1)
+	go func() { acquired <- s.acquireBuildSlot(context.Background()) }()
+
+	select {
+	case <-acquired:
+		t.Fatalf("slot acquired while all slots are taken")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	s.releaseBuildSlot()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("acquireBuildSlot after release: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("waiting build slot not handed out after a release")
+	}
+}
+
+func TestSchedulerDefaultBuildSlots(t *testing.T) {
+	if got := cap(newOperationScheduler(0, new(sync.Mutex)).buildSlots); got != defaultMaxConcurrentOps {
+		t.Fatalf("build slots = %d, want %d", got, defaultMaxConcurrentOps)
+	}
+}
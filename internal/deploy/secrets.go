@@ -0,0 +1,307 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"superagent/internal/deploy/docker"
+)
+
+// SecretRefPrefix marks an environment value that names a secret. The
+// value of the secret replaces it when a container is created.
+const SecretRefPrefix = "secret://"
+
+var secretNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// SecretInfo describes a secret without its value
+type SecretInfo struct {
+	Name      string    `json:"name"`
+	Version   int       `json:"version"` // raised by every rotation
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UsedBy    []string  `json:"used_by,omitempty"` // deployments and jobs referencing it, filled in when read
+}
+
+// storedSecret is a secret as kept in the secure store
+type storedSecret struct {
+	SecretInfo
+	Value string `json:"value"`
+}
+
+// CreateSecret stores a new secret
+func (de *DeploymentEngine) CreateSecret(name, value, actor string) (*SecretInfo, error) {
+	if !secretNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("secret name %q must start with a letter or digit and contain only letters, digits, '_', '.' and '-'", name)
+	}
+	if value == "" {
+		return nil, fmt.Errorf("value of secret %s must not be empty", name)
+	}
+
+	de.secretsMu.Lock()
+	defer de.secretsMu.Unlock()
+
+	if _, err := de.store.LoadSecret(name); err == nil {
+		return nil, fmt.Errorf("secret %s already exists", name)
+	}
+
+	now := time.Now()
+	secret := storedSecret{
+		SecretInfo: SecretInfo{Name: name, Version: 1, CreatedAt: now, UpdatedAt: now},
+		Value:      value,
+	}
+	if err := de.storeSecret(&secret); err != nil {
+		return nil, err
+	}
+
+	de.auditLogger.LogSecurityEvent("SECRET_CREATED", true, map[string]interface{}{
+		"secret":       name,
+		"version":      secret.Version,
+		"triggered_by": actor,
+	})
+
+	return &secret.SecretInfo, nil
+}
+
+// RotateSecret replaces the value of a secret. Running containers keep the
+// value they were created with until they are redeployed.
+func (de *DeploymentEngine) RotateSecret(name, value, actor string) (*SecretInfo, error) {
+	if value == "" {
+		return nil, fmt.Errorf("value of secret %s must not be empty", name)
+	}
+
+	de.secretsMu.Lock()
+	defer de.secretsMu.Unlock()
+
+	secret, err := de.loadSecret(name)
+	if err != nil {
+		return nil, err
+	}
+
+	secret.Value = value
+	secret.Version++
+	secret.UpdatedAt = time.Now()
+	if err := de.storeSecret(secret); err != nil {
+		return nil, err
+	}
+
+	info := secret.SecretInfo
+	info.UsedBy = de.secretUsers(name)
+
+	de.auditLogger.LogSecurityEvent("SECRET_ROTATED", true, map[string]interface{}{
+		"secret":       name,
+		"version":      secret.Version,
+		"used_by":      info.UsedBy,
+		"triggered_by": actor,
+	})
+
+	return &info, nil
+}
+
+// DeleteSecret removes a secret no live deployment or job references
+func (de *DeploymentEngine) DeleteSecret(name, actor string) error {
+	de.secretsMu.Lock()
+	defer de.secretsMu.Unlock()
+
+	if _, err := de.loadSecret(name); err != nil {
+		return err
+	}
+
+	if users := de.secretUsers(name); len(users) > 0 {
+		return fmt.Errorf("secret %s is used by %s", name, strings.Join(users, ", "))
+	}
+
+	if err := de.store.DeleteSecret(name); err != nil {
+		return err
+	}
+
+	de.auditLogger.LogSecurityEvent("SECRET_DELETED", true, map[string]interface{}{
+		"secret":       name,
+		"triggered_by": actor,
+	})
+
+	return nil
+}
+
+// ListSecrets returns every secret without its value, ordered by name
+func (de *DeploymentEngine) ListSecrets() ([]*SecretInfo, error) {
+	records, err := de.store.ListSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := make([]*SecretInfo, 0, len(records))
+	for name, record := range records {
+		var secret storedSecret
+		if err := decodeState(record, &secret); err != nil {
+			return nil, fmt.Errorf("failed to decode secret %s: %w", name, err)
+		}
+
+		info := secret.SecretInfo
+		info.UsedBy = de.secretUsers(name)
+		secrets = append(secrets, &info)
+	}
+
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+
+	return secrets, nil
+}
+
+// createContainer creates a container once the secrets its environment
+// references are resolved. Only the container sees their values.
+func (de *DeploymentEngine) createContainer(ctx context.Context, config docker.ContainerConfig, consumer string) (string, error) {
+	environment, err := de.resolveSecrets(config.Environment, consumer)
+	if err != nil {
+		return "", err
+	}
+
+	config.Environment = environment
+	return de.dockerManager.CreateContainer(ctx, config)
+}
+
+// resolveSecrets returns a copy of an environment with secret references
+// replaced by their values. Every access is audited.
+func (de *DeploymentEngine) resolveSecrets(environment map[string]string, consumer string) (map[string]string, error) {
+	resolved := make(map[string]string, len(environment))
+	for key, value := range environment {
+		name, isRef := secretRef(value)
+		if !isRef {
+			resolved[key] = value
+			continue
+		}
+
+		secret, err := de.loadSecret(name)
+		if err != nil {
+			de.auditLogger.LogSecurityEvent("SECRET_ACCESS_FAILED", false, map[string]interface{}{
+				"secret":   name,
+				"variable": key,
+				"consumer": consumer,
+				"error":    err.Error(),
+			})
+			return nil, fmt.Errorf("failed to resolve %s: %w", key, err)
+		}
+
+		de.auditLogger.LogSecurityEvent("SECRET_ACCESSED", true, map[string]interface{}{
+			"secret":   name,
+			"version":  secret.Version,
+			"variable": key,
+			"consumer": consumer,
+		})
+		resolved[key] = secret.Value
+	}
+
+	return resolved, nil
+}
+
+// validateSecretRefs checks that the secrets an environment references exist
+func (de *DeploymentEngine) validateSecretRefs(environment map[string]string) []error {
+	var errs []error
+	for key, value := range environment {
+		name, isRef := secretRef(value)
+		if !isRef {
+			continue
+		}
+		if !secretNamePattern.MatchString(name) {
+			errs = append(errs, fmt.Errorf("%s references an invalid secret name %q", key, name))
+			continue
+		}
+		if _, err := de.store.LoadSecret(name); err != nil {
+			errs = append(errs, fmt.Errorf("%s references unknown secret %s", key, name))
+		}
+	}
+	return errs
+}
+
+// secretUsers lists the deployments that may still create containers and
+// the jobs whose environment references a secret
+func (de *DeploymentEngine) secretUsers(name string) []string {
+	de.mu.RLock()
+	defer de.mu.RUnlock()
+
+	var users []string
+	for _, deployment := range de.deployments {
+		switch deployment.Status {
+		case StatusStopped, StatusFailed, StatusAborted, StatusCancelled, StatusSuperseded:
+			continue
+		}
+		if referencesSecret(deployment.Environment, name) || hooksReferenceSecret(deployment.Hooks, name) {
+			users = append(users, "deployment "+deployment.ID)
+		}
+	}
+	for _, job := range de.jobs {
+		if referencesSecret(job.Spec.Environment, name) {
+			users = append(users, "job "+job.Spec.Name)
+		}
+	}
+
+	sort.Strings(users)
+	return users
+}
+
+// loadSecret reads a secret from the secure store
+func (de *DeploymentEngine) loadSecret(name string) (*storedSecret, error) {
+	record, err := de.store.LoadSecret(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret storedSecret
+	if err := decodeState(record, &secret); err != nil {
+		return nil, fmt.Errorf("failed to decode secret %s: %w", name, err)
+	}
+	return &secret, nil
+}
+
+// storeSecret writes a secret to the secure store
+func (de *DeploymentEngine) storeSecret(secret *storedSecret) error {
+	record, err := encodeState(secret)
+	if err != nil {
+		return fmt.Errorf("failed to encode secret %s: %w", secret.Name, err)
+	}
+	return de.store.StoreSecret(secret.Name, record)
+}
+
+// secretRef returns the name of the secret an environment value references
+func secretRef(value string) (string, bool) {
+	return strings.CutPrefix(value, SecretRefPrefix)
+}
+
+// buildArgs returns the environment passed to image builds. Secrets are left
+// out so they never end up in the image history.
+func buildArgs(environment map[string]string) map[string]string {
+	args := make(map[string]string, len(environment))
+	for key, value := range environment {
+		if _, isRef := secretRef(value); !isRef {
+			args[key] = value
+		}
+	}
+	return args
+}
+
+// referencesSecret reports whether an environment references a secret
+func referencesSecret(environment map[string]string, name string) bool {
+	for _, value := range environment {
+		if ref, isRef := secretRef(value); isRef && ref == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hooksReferenceSecret reports whether the environment of a hook references
+// a secret
+func hooksReferenceSecret(hooks DeploymentHooks, name string) bool {
+	for _, phase := range [][]HookConfig{hooks.PreDeploy, hooks.PostDeploy} {
+		for _, hook := range phase {
+			if referencesSecret(hook.Environment, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
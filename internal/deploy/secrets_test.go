@@ -0,0 +1,133 @@
+package deploy
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"superagent/internal/storage"
+)
+
+// secretsEngine is an engine with a secure store in a temporary directory
+func secretsEngine(t *testing.T) *DeploymentEngine {
+	t.Helper()
+
+	auditLogger := testAuditLogger(t)
+	store, err := storage.NewSecureStore(filepath.Join(t.TempDir(), "store.enc"), "test-key", auditLogger)
+	if err != nil {
+		t.Fatalf("NewSecureStore: %v", err)
+	}
+
+	return &DeploymentEngine{
+		store:       store,
+		auditLogger: auditLogger,
+		deployments: make(map[string]*Deployment),
+		jobs:        make(map[string]*Job),
+	}
+}
+
+func TestSecretLifecycle(t *testing.T) {
+	de := secretsEngine(t)
+
+	info, err := de.CreateSecret("db-password", "hunter2", "alice")
+	if err != nil {
+		t.Fatalf("CreateSecret: %v", err)
+	}
+	if info.Version != 1 {
+		t.Errorf("version %d, want 1", info.Version)
+	}
+	if _, err := de.CreateSecret("db-password", "other", "alice"); err == nil {
+		t.Errorf("creating an existing secret succeeded")
+	}
+
+	environment := map[string]string{"DB_PASSWORD": SecretRefPrefix + "db-password", "LOG_LEVEL": "info"}
+	resolved, err := de.resolveSecrets(environment, "deployment d1")
+	if err != nil {
+		t.Fatalf("resolveSecrets: %v", err)
+	}
+	if resolved["DB_PASSWORD"] != "hunter2" || resolved["LOG_LEVEL"] != "info" {
+		t.Errorf("resolved environment %v, want the secret value filled in", resolved)
+	}
+	if environment["DB_PASSWORD"] != SecretRefPrefix+"db-password" {
+		t.Errorf("resolveSecrets changed the environment it was given")
+	}
+
+	// A live deployment using the secret is told about rotations and keeps
+	// it from being deleted
+	de.deployments["d1"] = &Deployment{ID: "d1", Status: StatusRunning, Environment: environment}
+
+	info, err = de.RotateSecret("db-password", "correct-horse", "alice")
+	if err != nil {
+		t.Fatalf("RotateSecret: %v", err)
+	}
+	if info.Version != 2 || strings.Join(info.UsedBy, ",") != "deployment d1" {
+		t.Errorf("rotated to version %d used by %v, want version 2 used by d1", info.Version, info.UsedBy)
+	}
+	if resolved, _ := de.resolveSecrets(environment, "deployment d1"); resolved["DB_PASSWORD"] != "correct-horse" {
+		t.Errorf("resolved %q after rotation, want the new value", resolved["DB_PASSWORD"])
+	}
+
+	if err := de.DeleteSecret("db-password", "alice"); err == nil || !strings.Contains(err.Error(), "used by deployment d1") {
+		t.Fatalf("DeleteSecret of a used secret = %v, want it refused", err)
+	}
+
+	de.deployments["d1"].Status = StatusStopped
+	if err := de.DeleteSecret("db-password", "alice"); err != nil {
+		t.Fatalf("DeleteSecret: %v", err)
+	}
+	if _, err := de.resolveSecrets(environment, "deployment d1"); err == nil {
+		t.Errorf("resolving a deleted secret succeeded")
+	}
+}
+
+func TestCreateSecretInvalid(t *testing.T) {
+	de := secretsEngine(t)
+
+	if _, err := de.CreateSecret("-bad", "value", "alice"); err == nil {
+		t.Errorf("secret with an invalid name created")
+	}
+	if _, err := de.CreateSecret("empty", "", "alice"); err == nil {
+		t.Errorf("secret without a value created")
+	}
+}
+
+func TestValidateSecretRefs(t *testing.T) {
+	de := secretsEngine(t)
+	if _, err := de.CreateSecret("api-key", "value", "alice"); err != nil {
+		t.Fatalf("CreateSecret: %v", err)
+	}
+
+	errs := de.validateSecretRefs(map[string]string{
+		"API_KEY": SecretRefPrefix + "api-key",
+		"TOKEN":   SecretRefPrefix + "missing",
+		"BAD":     SecretRefPrefix + "../etc",
+		"PLAIN":   "value",
+	})
+
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	got := strings.Join(messages, "\n")
+	if len(errs) != 2 || !strings.Contains(got, "TOKEN references unknown secret missing") || !strings.Contains(got, `BAD references an invalid secret name "../etc"`) {
+		t.Errorf("errors:\n%s\nwant the unknown and the invalid reference", got)
+	}
+}
+
+func TestBuildArgsLeaveOutSecrets(t *testing.T) {
+	args := buildArgs(map[string]string{"VERSION": "1.2", "TOKEN": SecretRefPrefix + "token"})
+	if len(args) != 1 || args["VERSION"] != "1.2" {
+		t.Errorf("build args %v, want only VERSION", args)
+	}
+}
+
+func TestHooksReferenceSecret(t *testing.T) {
+	hooks := DeploymentHooks{PostDeploy: []HookConfig{{Name: "notify", Environment: map[string]string{"TOKEN": SecretRefPrefix + "token"}}}}
+
+	if !hooksReferenceSecret(hooks, "token") {
+		t.Errorf("secret used by a post-deploy hook not found")
+	}
+	if hooksReferenceSecret(hooks, "other") {
+		t.Errorf("unused secret reported as referenced")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"superagent/internal/config"
+	"superagent/internal/deploy/resources"
 	"superagent/internal/logging"
 
 	"github.com/docker/docker/api/types"
@@ -29,12 +30,17 @@ type ContainerManager struct {
 	auditLogger *logging.AuditLogger
 	logStreamer *logging.LogStreamer
 	containers  map[string]*ContainerInfo
+	admit       AdmissionController
 	mu          sync.RWMutex
 	ctx         context.Context
 	cancel      context.CancelFunc
 	wg          sync.WaitGroup
 }
 
+// AdmissionController decides whether the host can take on the requested
+// resources on top of the containers this manager runs
+type AdmissionController func(ctx context.Context, running, requested resources.QuotaUsage) error
+
 // ContainerInfo holds information about a running container
 type ContainerInfo struct {
 	ID          string                 `json:"id"`
@@ -209,7 +215,7 @@ func (cm *ContainerManager) DeployContainer(ctx context.Context, spec *Deploymen
 	}
 
 	// Check resource availability
-	if err := cm.checkResourceAvailability(spec); err != nil {
+	if err := cm.checkResourceAvailability(ctx, spec); err != nil {
 		cm.auditLogger.LogDeploymentEventWithContext(ctx, "DEPLOY_RESOURCE_CHECK_FAILED", spec.Name, false, map[string]interface{}{
 			"error": err.Error(),
 		})
@@ -262,7 +268,8 @@ func (cm *ContainerManager) DeployContainer(ctx context.Context, spec *Deploymen
 		return nil, fmt.Errorf("failed to get container info: %w", err)
 	}
 
-	// Store container info
+	// Store container info with the limits it was admitted with
+	containerInfo.Resources = spec.Resources
 	cm.mu.Lock()
 	cm.containers[resp.ID] = containerInfo
 	cm.mu.Unlock()
@@ -575,10 +582,55 @@ func (cm *ContainerManager) validateResourceLimits(limits *ResourceLimits) error
 }
 
 // checkResourceAvailability checks if resources are available for deployment
-func (cm *ContainerManager) checkResourceAvailability(spec *DeploymentSpec) error {
-	// Get current resource usage
-	// TODO: Implement resource usage checking
-	return nil
+func (cm *ContainerManager) checkResourceAvailability(ctx context.Context, spec *DeploymentSpec) error {
+	// Containers without limits are charged the default ones
+	defaults := resources.DefaultLimits(cm.config.Docker)
+
+	cm.mu.RLock()
+	admit := cm.admit
+	var running resources.QuotaUsage
+	for _, info := range cm.containers {
+		if info.Status == "stopped" || info.State == "exited" || info.State == "dead" {
+			continue
+		}
+		running.Merge(resources.Charge(resourceUsage(info.Resources), defaults))
+	}
+	cm.mu.RUnlock()
+
+	if admit == nil {
+		return nil
+	}
+
+	return admit(ctx, running, resources.Charge(resourceUsage(spec.Resources), defaults))
+}
+
+// SetAdmissionController registers the check containers must pass before
+// they are deployed
+func (cm *ContainerManager) SetAdmissionController(admit AdmissionController) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.admit = admit
+}
+
+// resourceUsage returns what a container with the given limits takes from
+// the host
+func resourceUsage(limits ResourceLimits) resources.QuotaUsage {
+	usage := resources.QuotaUsage{
+		Memory:     limits.Memory,
+		Storage:    limits.DiskQuota,
+		Containers: 1,
+	}
+
+	if limits.CPUQuota > 0 {
+		period := limits.CPUPeriod
+		if period <= 0 {
+			period = 100000 // the default CFS period
+		}
+		usage.CPU = float64(limits.CPUQuota) / float64(period)
+	}
+
+	return usage
 }
 
 // pullImage pulls a Docker image
@@ -864,7 +916,7 @@ func (cm *ContainerManager) streamContainerLogs(ctx context.Context, containerID
 		return
 	}
 
-	logReader := logging.NewContainerLogReader(containerID, reader, cm.logStreamer)
+	logReader := logging.NewContainerLogReader(containerID, "", reader, cm.logStreamer)
 	logReader.Start()
 
 	// Wait for context cancellation
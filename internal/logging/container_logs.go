@@ -0,0 +1,557 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultLogPageSize is how many entries a page holds unless asked
+	// otherwise
+	DefaultLogPageSize = 1000
+
+	// MaxLogPageSize bounds the entries of a page and of a tail
+	MaxLogPageSize = 10000
+
+	// logFollowBufferSize is how many entries a follower may fall behind
+	// before further entries are dropped for it
+	logFollowBufferSize = 256
+)
+
+// ContainerLogStore keeps the output of the containers of each deployment
+// in files of its own. A file is rotated once it reaches the maximum size
+// and only the newest files are kept, which caps the disk a deployment's
+// logs take.
+type ContainerLogStore struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+	logs     map[string]*deploymentLog
+	closed   bool
+	mu       sync.Mutex
+}
+
+// deploymentLog is the log of one deployment: numbered segment files, the
+// newest of which is written to
+type deploymentLog struct {
+	dir       string
+	segment   int
+	file      *os.File
+	size      int64
+	followers map[uint64]chan LogEntry
+	nextID    uint64
+	mu        sync.Mutex
+}
+
+// LogQuery selects the entries of a deployment log to read
+type LogQuery struct {
+	Tail  int       // entries from the end; 0 reads forward from the token or the start
+	Since time.Time // zero for no lower bound
+	Until time.Time // zero for no upper bound
+	Limit int       // entries per page when reading forward
+	Token string    // where the previous page ended
+}
+
+// LogPage is a page of a deployment log. NextToken reads on from where the
+// page ended, including entries written after it was read.
+type LogPage struct {
+	Entries   []LogEntry `json:"entries"`
+	HasMore   bool       `json:"has_more"`
+	NextToken string     `json:"next_token"`
+}
+
+// logPosition is the place of a line in a deployment log
+type logPosition struct {
+	segment int
+	offset  int64
+}
+
+// NewContainerLogStore creates a store keeping the logs under dir, in files
+// of up to maxSize bytes of which maxFiles are kept per deployment
+func NewContainerLogStore(dir string, maxSize int64, maxFiles int) (*ContainerLogStore, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("container log file size must be positive")
+	}
+	if maxFiles < 1 {
+		maxFiles = 1
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create container log directory: %w", err)
+	}
+
+	return &ContainerLogStore{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		logs:     make(map[string]*deploymentLog),
+	}, nil
+}
+
+// Sink returns a sink appending the entries it receives to the log of a
+// deployment
+func (s *ContainerLogStore) Sink(deploymentID string) LogSink {
+	return &deploymentSink{store: s, deploymentID: deploymentID}
+}
+
+// Append adds an entry to the log of a deployment and passes it to the
+// followers of the log
+func (s *ContainerLogStore) Append(deploymentID string, entry LogEntry) error {
+	dl, err := s.log(deploymentID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode log entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	if dl.file == nil {
+		if err := dl.open(); err != nil {
+			return err
+		}
+	}
+
+	if dl.size > 0 && dl.size+int64(len(data)) > s.maxSize {
+		if err := dl.rotate(s.maxFiles); err != nil {
+			return err
+		}
+	}
+
+	written, err := dl.file.Write(data)
+	dl.size += int64(written)
+	if err != nil {
+		return fmt.Errorf("failed to write container log: %w", err)
+	}
+
+	for _, follower := range dl.followers {
+		select {
+		case follower <- entry:
+		default:
+			logrus.Debugf("Dropping log entry of deployment %s for slow follower", deploymentID)
+		}
+	}
+
+	return nil
+}
+
+// Read returns a page of the log of a deployment
+func (s *ContainerLogStore) Read(deploymentID string, query LogQuery) (*LogPage, error) {
+	dl, err := s.log(deploymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Tail > 0 && query.Token == "" {
+		return dl.tail(query), nil
+	}
+
+	from := logPosition{}
+	if query.Token != "" {
+		from, err = parseLogToken(query.Token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultLogPageSize
+	}
+	limit = min(limit, MaxLogPageSize)
+
+	page := &LogPage{Entries: []LogEntry{}}
+	next := from
+	dl.scan(from, func(entry LogEntry, end logPosition) bool {
+		if !query.Matches(entry) {
+			next = end
+			return true
+		}
+		if len(page.Entries) == limit {
+			page.HasMore = true
+			return false
+		}
+		page.Entries = append(page.Entries, entry)
+		next = end
+		return true
+	})
+	page.NextToken = next.String()
+
+	return page, nil
+}
+
+// Follow returns the last tail entries of the log of a deployment and a
+// channel receiving the entries appended after them. The returned function
+// stops following and closes the channel.
+func (s *ContainerLogStore) Follow(deploymentID string, tail int) ([]LogEntry, <-chan LogEntry, func(), error) {
+	dl, err := s.log(deploymentID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	entries := make(chan LogEntry, logFollowBufferSize)
+
+	// Appends wait, so no entry falls between the tail and the channel
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	var backlog []LogEntry
+	if tail > 0 {
+		backlog = dl.tail(LogQuery{Tail: tail}).Entries
+	}
+
+	id := dl.nextID
+	dl.nextID++
+	dl.followers[id] = entries
+
+	return backlog, entries, func() {
+		dl.mu.Lock()
+		defer dl.mu.Unlock()
+
+		if _, ok := dl.followers[id]; ok {
+			delete(dl.followers, id)
+			close(entries)
+		}
+	}, nil
+}
+
+// LastTimestamp returns when a container last wrote to the log of a
+// deployment, zero when it never did
+func (s *ContainerLogStore) LastTimestamp(deploymentID, containerID string) time.Time {
+	dl, err := s.log(deploymentID)
+	if err != nil {
+		return time.Time{}
+	}
+
+	segments := dl.segments()
+	for i := len(segments) - 1; i >= 0; i-- {
+		var last time.Time
+		dl.scanSegment(segments[i], 0, func(entry LogEntry, _ logPosition) bool {
+			if entry.ContainerID == containerID && entry.Timestamp.After(last) {
+				last = entry.Timestamp
+			}
+			return true
+		})
+		if !last.IsZero() {
+			return last
+		}
+	}
+
+	return time.Time{}
+}
+
+// Remove deletes the log of a deployment and ends its followers
+func (s *ContainerLogStore) Remove(deploymentID string) error {
+	if err := validateLogName(deploymentID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	dl, exists := s.logs[deploymentID]
+	delete(s.logs, deploymentID)
+	s.mu.Unlock()
+
+	if exists {
+		dl.close()
+	}
+
+	if err := os.RemoveAll(filepath.Join(s.dir, deploymentID)); err != nil {
+		return fmt.Errorf("failed to remove container logs of %s: %w", deploymentID, err)
+	}
+	return nil
+}
+
+// Close closes the files of the store and ends all followers
+func (s *ContainerLogStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for deploymentID, dl := range s.logs {
+		dl.close()
+		delete(s.logs, deploymentID)
+	}
+	s.closed = true
+
+	return nil
+}
+
+// log returns the log of a deployment
+func (s *ContainerLogStore) log(deploymentID string) (*deploymentLog, error) {
+	if err := validateLogName(deploymentID); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, fmt.Errorf("container log store is closed")
+	}
+
+	dl, exists := s.logs[deploymentID]
+	if !exists {
+		dl = &deploymentLog{
+			dir:       filepath.Join(s.dir, deploymentID),
+			followers: make(map[uint64]chan LogEntry),
+		}
+		s.logs[deploymentID] = dl
+	}
+
+	return dl, nil
+}
+
+// open opens the newest segment for writing. The caller holds dl.mu.
+func (dl *deploymentLog) open() error {
+	if err := os.MkdirAll(dl.dir, 0750); err != nil {
+		return fmt.Errorf("failed to create container log directory: %w", err)
+	}
+
+	dl.segment = 1
+	if segments := dl.segments(); len(segments) > 0 {
+		dl.segment = segments[len(segments)-1]
+	}
+
+	file, err := os.OpenFile(dl.segmentPath(dl.segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open container log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat container log: %w", err)
+	}
+
+	dl.file = file
+	dl.size = info.Size()
+	return nil
+}
+
+// rotate starts a new segment and removes those past the number kept. The
+// caller holds dl.mu.
+func (dl *deploymentLog) rotate(maxFiles int) error {
+	if err := dl.file.Close(); err != nil {
+		logrus.Warnf("Failed to close container log %s: %v", dl.file.Name(), err)
+	}
+	dl.file = nil
+
+	file, err := os.OpenFile(dl.segmentPath(dl.segment+1), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to rotate container log: %w", err)
+	}
+	dl.segment++
+	dl.file = file
+	dl.size = 0
+
+	for _, segment := range dl.segments() {
+		if segment > dl.segment-maxFiles {
+			break
+		}
+		if err := os.Remove(dl.segmentPath(segment)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logrus.Warnf("Failed to remove rotated container log: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// close closes the segment being written and ends the followers
+func (dl *deploymentLog) close() {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	if dl.file != nil {
+		dl.file.Close()
+		dl.file = nil
+	}
+
+	for id, follower := range dl.followers {
+		delete(dl.followers, id)
+		close(follower)
+	}
+}
+
+// tail returns the last entries matching a query
+func (dl *deploymentLog) tail(query LogQuery) *LogPage {
+	size := min(query.Tail, MaxLogPageSize)
+
+	ring := make([]LogEntry, 0, size)
+	start := 0
+	next := logPosition{}
+	dl.scan(logPosition{}, func(entry LogEntry, end logPosition) bool {
+		next = end
+		if !query.Matches(entry) {
+			return true
+		}
+		if len(ring) < size {
+			ring = append(ring, entry)
+		} else {
+			ring[start] = entry
+			start = (start + 1) % size
+		}
+		return true
+	})
+
+	entries := append(ring[start:len(ring):len(ring)], ring[:start]...)
+	return &LogPage{Entries: entries, NextToken: next.String()}
+}
+
+// scan passes the entries from a position on to fn, with the position after
+// each, until fn returns false. A position in a segment that was rotated
+// away reads from the oldest segment kept.
+func (dl *deploymentLog) scan(from logPosition, fn func(LogEntry, logPosition) bool) {
+	for _, segment := range dl.segments() {
+		if segment < from.segment {
+			continue
+		}
+
+		offset := int64(0)
+		if segment == from.segment {
+			offset = from.offset
+		}
+
+		if !dl.scanSegment(segment, offset, fn) {
+			return
+		}
+	}
+}
+
+// scanSegment passes the entries of a segment from an offset on to fn. It
+// returns false once fn does.
+func (dl *deploymentLog) scanSegment(segment int, offset int64, fn func(LogEntry, logPosition) bool) bool {
+	file, err := os.Open(dl.segmentPath(segment))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logrus.Warnf("Failed to open container log: %v", err)
+		}
+		return true
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		logrus.Warnf("Failed to seek container log: %v", err)
+		return true
+	}
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// A line without its newline is still being written
+			return true
+		}
+		offset += int64(len(line))
+
+		var entry LogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			continue
+		}
+
+		if !fn(entry, logPosition{segment: segment, offset: offset}) {
+			return false
+		}
+	}
+}
+
+// segments lists the numbers of the segments on disk, oldest first
+func (dl *deploymentLog) segments() []int {
+	files, err := os.ReadDir(dl.dir)
+	if err != nil {
+		return nil
+	}
+
+	var segments []int
+	for _, file := range files {
+		name, found := strings.CutSuffix(file.Name(), ".log")
+		if !found {
+			continue
+		}
+		if segment, err := strconv.Atoi(name); err == nil && segment > 0 {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Ints(segments)
+
+	return segments
+}
+
+// validateLogName rejects deployment IDs that would not name a directory
+// within the store
+func validateLogName(deploymentID string) error {
+	if deploymentID == "" || deploymentID == "." || deploymentID == ".." || strings.ContainsAny(deploymentID, `/\`) {
+		return fmt.Errorf("invalid deployment ID: %q", deploymentID)
+	}
+	return nil
+}
+
+func (dl *deploymentLog) segmentPath(segment int) string {
+	return filepath.Join(dl.dir, fmt.Sprintf("%06d.log", segment))
+}
+
+// Matches reports whether an entry falls within the time bounds of a query
+func (q LogQuery) Matches(entry LogEntry) bool {
+	return q.Covers(entry.Timestamp)
+}
+
+// Covers reports whether a point in time falls within the bounds of a query
+func (q LogQuery) Covers(timestamp time.Time) bool {
+	if !q.Since.IsZero() && timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && timestamp.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+func (p logPosition) String() string {
+	return fmt.Sprintf("%d-%d", p.segment, p.offset)
+}
+
+// parseLogToken reads the position a page token stands for
+func parseLogToken(token string) (logPosition, error) {
+	segment, offset, found := strings.Cut(token, "-")
+	if !found {
+		return logPosition{}, fmt.Errorf("invalid log token: %q", token)
+	}
+
+	position := logPosition{}
+	var err error
+	if position.segment, err = strconv.Atoi(segment); err != nil || position.segment < 0 {
+		return logPosition{}, fmt.Errorf("invalid log token: %q", token)
+	}
+	if position.offset, err = strconv.ParseInt(offset, 10, 64); err != nil || position.offset < 0 {
+		return logPosition{}, fmt.Errorf("invalid log token: %q", token)
+	}
+
+	return position, nil
+}
+
+// deploymentSink appends the entries it receives to the log of a deployment
+type deploymentSink struct {
+	store        *ContainerLogStore
+	deploymentID string
+}
+
+func (ds *deploymentSink) StreamLog(entry LogEntry) {
+	if err := ds.store.Append(ds.deploymentID, entry); err != nil {
+		logrus.Warnf("Failed to store container log of deployment %s: %v", ds.deploymentID, err)
+	}
+}
@@ -0,0 +1,404 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testEntry is an entry whose encoded line has the same length for every i
+// below 1000, so tests can size segments in lines
+func testEntry(base time.Time, i int) LogEntry {
+	return LogEntry{
+		Timestamp:   base.Add(time.Duration(i) * time.Second),
+		Level:       "info",
+		Message:     fmt.Sprintf("line %03d", i),
+		Source:      "container",
+		ContainerID: "c1",
+	}
+}
+
+// lineSize is the size of the line an entry takes in a segment
+func lineSize(t *testing.T, entry LogEntry) int64 {
+	t.Helper()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatalf("failed to encode entry: %v", err)
+	}
+	return int64(len(data)) + 1
+}
+
+// newTestStore creates a store whose segments hold linesPerSegment lines
+func newTestStore(t *testing.T, base time.Time, linesPerSegment, maxFiles int) (*ContainerLogStore, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	store, err := NewContainerLogStore(dir, lineSize(t, testEntry(base, 0))*int64(linesPerSegment), maxFiles)
+	if err != nil {
+		t.Fatalf("NewContainerLogStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store, dir
+}
+
+func appendEntries(t *testing.T, store *ContainerLogStore, base time.Time, from, to int) {
+	t.Helper()
+
+	for i := from; i < to; i++ {
+		if err := store.Append("d1", testEntry(base, i)); err != nil {
+			t.Fatalf("Append %d: %v", i, err)
+		}
+	}
+}
+
+func messages(entries []LogEntry) []string {
+	result := make([]string, len(entries))
+	for i, entry := range entries {
+		result[i] = entry.Message
+	}
+	return result
+}
+
+func lineRange(from, to int) []string {
+	var result []string
+	for i := from; i < to; i++ {
+		result = append(result, fmt.Sprintf("line %03d", i))
+	}
+	return result
+}
+
+func assertMessages(t *testing.T, got []LogEntry, want []string) {
+	t.Helper()
+
+	if fmt.Sprint(messages(got)) != fmt.Sprint(want) {
+		t.Fatalf("got entries %v, want %v", messages(got), want)
+	}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "d1", "*.log"))
+	if err != nil {
+		t.Fatalf("failed to list segments: %v", err)
+	}
+	for i, file := range files {
+		files[i] = filepath.Base(file)
+	}
+	return files
+}
+
+func TestContainerLogStoreRotation(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, dir := newTestStore(t, base, 4, 3)
+
+	appendEntries(t, store, base, 0, 10)
+
+	// 10 lines of 4 per segment fill segments 1 and 2 and start segment 3
+	if got, want := fmt.Sprint(segmentFiles(t, dir)), "[000001.log 000002.log 000003.log]"; got != want {
+		t.Fatalf("segments %s, want %s", got, want)
+	}
+
+	page, err := store.Read("d1", LogQuery{})
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	assertMessages(t, page.Entries, lineRange(0, 10))
+
+	// Two more segments push the oldest two out
+	appendEntries(t, store, base, 10, 18)
+
+	if got, want := fmt.Sprint(segmentFiles(t, dir)), "[000003.log 000004.log 000005.log]"; got != want {
+		t.Fatalf("segments %s, want %s", got, want)
+	}
+
+	page, err = store.Read("d1", LogQuery{})
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	assertMessages(t, page.Entries, lineRange(8, 18))
+}
+
+func TestContainerLogStoreReopensNewestSegment(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, dir := newTestStore(t, base, 4, 3)
+
+	appendEntries(t, store, base, 0, 6)
+	store.Close()
+
+	reopened, err := NewContainerLogStore(dir, lineSize(t, testEntry(base, 0))*4, 3)
+	if err != nil {
+		t.Fatalf("NewContainerLogStore: %v", err)
+	}
+	defer reopened.Close()
+
+	appendEntries(t, reopened, base, 6, 9)
+
+	// Segment 2 held 2 lines, takes 2 more and rotates once
+	if got, want := fmt.Sprint(segmentFiles(t, dir)), "[000001.log 000002.log 000003.log]"; got != want {
+		t.Fatalf("segments %s, want %s", got, want)
+	}
+
+	page, err := reopened.Read("d1", LogQuery{})
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	assertMessages(t, page.Entries, lineRange(0, 9))
+
+	if got, want := reopened.LastTimestamp("d1", "c1"), base.Add(8*time.Second); !got.Equal(want) {
+		t.Fatalf("LastTimestamp %v, want %v", got, want)
+	}
+	if got := reopened.LastTimestamp("d1", "other"); !got.IsZero() {
+		t.Fatalf("LastTimestamp of unknown container %v, want zero", got)
+	}
+}
+
+func TestContainerLogStorePaging(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, _ := newTestStore(t, base, 4, 10)
+
+	appendEntries(t, store, base, 0, 10)
+
+	var got []LogEntry
+	query := LogQuery{Limit: 3}
+	pages := 0
+	for {
+		page, err := store.Read("d1", query)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		pages++
+		got = append(got, page.Entries...)
+
+		if !page.HasMore {
+			if page.NextToken == "" {
+				t.Fatalf("last page has no token to read on from")
+			}
+			query.Token = page.NextToken
+			break
+		}
+		if len(page.Entries) != 3 {
+			t.Fatalf("page %d has %d entries, want 3", pages, len(page.Entries))
+		}
+		query.Token = page.NextToken
+	}
+
+	if pages != 4 {
+		t.Fatalf("read %d pages, want 4", pages)
+	}
+	assertMessages(t, got, lineRange(0, 10))
+
+	// The last token reads on from there once more is written
+	appendEntries(t, store, base, 10, 12)
+	page, err := store.Read("d1", query)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	assertMessages(t, page.Entries, lineRange(10, 12))
+}
+
+func TestContainerLogStoreResumeAfterRotation(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, _ := newTestStore(t, base, 4, 3)
+
+	appendEntries(t, store, base, 0, 6)
+
+	page, err := store.Read("d1", LogQuery{Limit: 5})
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	assertMessages(t, page.Entries, lineRange(0, 5))
+
+	// The page ended in segment 2, which is rotated but still kept
+	appendEntries(t, store, base, 6, 11)
+
+	page, err = store.Read("d1", LogQuery{Token: page.NextToken})
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	assertMessages(t, page.Entries, lineRange(5, 11))
+}
+
+func TestContainerLogStorePrunedToken(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, _ := newTestStore(t, base, 4, 2)
+
+	appendEntries(t, store, base, 0, 3)
+
+	page, err := store.Read("d1", LogQuery{Limit: 2})
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	token := page.NextToken
+
+	// Segments 1 and 2 are pruned, the token points into segment 1
+	appendEntries(t, store, base, 3, 16)
+
+	page, err = store.Read("d1", LogQuery{Token: token})
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	// Reading resumes at the oldest line kept
+	assertMessages(t, page.Entries, lineRange(8, 16))
+}
+
+func TestContainerLogStoreTail(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, _ := newTestStore(t, base, 4, 10)
+
+	appendEntries(t, store, base, 0, 10)
+
+	tests := []struct {
+		name  string
+		query LogQuery
+		want  []string
+	}{
+		{"tail across segments", LogQuery{Tail: 6}, lineRange(4, 10)},
+		{"tail longer than the log", LogQuery{Tail: 50}, lineRange(0, 10)},
+		{"tail within bounds", LogQuery{Tail: 2, Until: base.Add(5 * time.Second)}, lineRange(4, 6)},
+		{"forward within bounds", LogQuery{Since: base.Add(7 * time.Second)}, lineRange(7, 10)},
+		{"forward from a token ignores tail", LogQuery{Tail: 2, Token: "1-0"}, lineRange(0, 10)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := store.Read("d1", tt.query)
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			assertMessages(t, page.Entries, tt.want)
+			if page.HasMore {
+				t.Fatalf("page has more, want none")
+			}
+		})
+	}
+
+	// A tail's token reads on after the newest line
+	page, err := store.Read("d1", LogQuery{Tail: 3})
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	appendEntries(t, store, base, 10, 11)
+
+	page, err = store.Read("d1", LogQuery{Token: page.NextToken})
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	assertMessages(t, page.Entries, lineRange(10, 11))
+}
+
+func TestContainerLogStoreInvalidToken(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, _ := newTestStore(t, base, 4, 3)
+
+	for _, token := range []string{"garbage", "1", "-1-0", "1--5", "a-0", "1-b"} {
+		if _, err := store.Read("d1", LogQuery{Token: token}); err == nil {
+			t.Errorf("Read with token %q succeeded, want an error", token)
+		}
+	}
+}
+
+func TestContainerLogStorePartialLine(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, dir := newTestStore(t, base, 100, 3)
+
+	appendEntries(t, store, base, 0, 2)
+
+	// A line still being written is not read, nor skipped over by the token
+	file, err := os.OpenFile(filepath.Join(dir, "d1", "000001.log"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("failed to open segment: %v", err)
+	}
+	if _, err := file.WriteString(`{"message":"half`); err != nil {
+		t.Fatalf("failed to write partial line: %v", err)
+	}
+	file.Close()
+
+	page, err := store.Read("d1", LogQuery{})
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	assertMessages(t, page.Entries, lineRange(0, 2))
+
+	if got, want := page.NextToken, fmt.Sprintf("1-%d", 2*lineSize(t, testEntry(base, 0))); got != want {
+		t.Fatalf("token %s, want %s", got, want)
+	}
+}
+
+func TestContainerLogStoreFollow(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, _ := newTestStore(t, base, 4, 10)
+
+	appendEntries(t, store, base, 0, 5)
+
+	backlog, entries, stop, err := store.Follow("d1", 2)
+	if err != nil {
+		t.Fatalf("Follow: %v", err)
+	}
+	assertMessages(t, backlog, lineRange(3, 5))
+
+	appendEntries(t, store, base, 5, 7)
+	assertMessages(t, []LogEntry{<-entries, <-entries}, lineRange(5, 7))
+
+	// A follower that falls behind misses entries instead of blocking
+	appendEntries(t, store, base, 7, 7+logFollowBufferSize+10)
+	if got := len(entries); got != logFollowBufferSize {
+		t.Fatalf("follower holds %d entries, want %d", got, logFollowBufferSize)
+	}
+
+	stop()
+	for range entries {
+	}
+	stop()
+
+	// Removing the log ends its followers
+	_, entries, _, err = store.Follow("d1", 0)
+	if err != nil {
+		t.Fatalf("Follow: %v", err)
+	}
+	if err := store.Remove("d1"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, open := <-entries; open {
+		t.Fatalf("follower channel still open after Remove")
+	}
+}
+
+func TestContainerLogStoreRejectsPathNames(t *testing.T) {
+	store, err := NewContainerLogStore(t.TempDir(), 1024, 2)
+	if err != nil {
+		t.Fatalf("NewContainerLogStore: %v", err)
+	}
+	defer store.Close()
+
+	for _, id := range []string{"", ".", "..", "../d1", `a\b`} {
+		if err := store.Append(id, LogEntry{Message: "x"}); err == nil {
+			t.Errorf("Append to %q succeeded, want an error", id)
+		}
+		if err := store.Remove(id); err == nil {
+			t.Errorf("Remove of %q succeeded, want an error", id)
+		}
+	}
+}
+
+func TestSplitLogTimestamp(t *testing.T) {
+	timestamp, line := splitLogTimestamp("2024-01-02T03:04:05.123456789Z hello world")
+	if want := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC); !timestamp.Equal(want) {
+		t.Errorf("timestamp %v, want %v", timestamp, want)
+	}
+	if line != "hello world" {
+		t.Errorf("line %q, want %q", line, "hello world")
+	}
+
+	before := time.Now()
+	timestamp, line = splitLogTimestamp("no timestamp here")
+	if timestamp.Before(before) || line != "no timestamp here" {
+		t.Errorf("got %v %q, want the time read and the whole line", timestamp, line)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -78,12 +79,19 @@ type LogEntry struct {
 	Tags        []string               `json:"tags,omitempty"`
 }
 
+// LogSink receives log entries, such as the backend streamer or the
+// container log store
+type LogSink interface {
+	StreamLog(entry LogEntry)
+}
+
 // ContainerLogReader handles reading container logs
 type ContainerLogReader struct {
 	containerID string
+	stream      string // "stdout", "stderr" or empty when both are mixed
 	reader      io.ReadCloser
 	scanner     *bufio.Scanner
-	streamer    *LogStreamer
+	sink        LogSink
 	ctx         context.Context
 	cancel      context.CancelFunc
 	wg          sync.WaitGroup
@@ -502,15 +510,23 @@ func (ls *LogStreamer) Close() error {
 	return nil
 }
 
-// NewContainerLogReader creates a new container log reader
-func NewContainerLogReader(containerID string, reader io.ReadCloser, streamer *LogStreamer) *ContainerLogReader {
+// maxLogLineSize bounds a single line read from a container
+const maxLogLineSize = 1024 * 1024
+
+// NewContainerLogReader creates a new container log reader. Stream names the
+// output the reader carries, if it carries only one.
+func NewContainerLogReader(containerID, stream string, reader io.ReadCloser, sink LogSink) *ContainerLogReader {
 	ctx, cancel := context.WithCancel(context.Background())
 
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineSize)
+
 	return &ContainerLogReader{
 		containerID: containerID,
+		stream:      stream,
 		reader:      reader,
-		scanner:     bufio.NewScanner(reader),
-		streamer:    streamer,
+		scanner:     scanner,
+		sink:        sink,
 		ctx:         ctx,
 		cancel:      cancel,
 	}
@@ -533,17 +549,23 @@ func (clr *ContainerLogReader) readLogs() {
 			return
 		default:
 			if clr.scanner.Scan() {
-				line := clr.scanner.Text()
+				timestamp, line := splitLogTimestamp(clr.scanner.Text())
 				if line != "" {
 					entry := LogEntry{
-						Timestamp:   time.Now().UTC(),
+						Timestamp:   timestamp,
 						Level:       "info",
 						Message:     line,
 						Source:      "container",
 						ContainerID: clr.containerID,
 						AgentID:     getAgentID(),
 					}
-					clr.streamer.StreamLog(entry)
+					if clr.stream != "" {
+						entry.Fields = map[string]interface{}{"stream": clr.stream}
+					}
+					if clr.stream == "stderr" {
+						entry.Level = "error"
+					}
+					clr.sink.StreamLog(entry)
 				}
 			} else {
 				// Scanner error or EOF
@@ -562,6 +584,22 @@ func (clr *ContainerLogReader) Stop() {
 	clr.wg.Wait()
 }
 
+// Wait blocks until the container logs end
+func (clr *ContainerLogReader) Wait() {
+	clr.wg.Wait()
+}
+
+// splitLogTimestamp takes the timestamp the runtime prefixes a line with off
+// it. Lines without one are stamped with the time they were read.
+func splitLogTimestamp(line string) (time.Time, string) {
+	if prefix, rest, found := strings.Cut(line, " "); found {
+		if timestamp, err := time.Parse(time.RFC3339Nano, prefix); err == nil {
+			return timestamp.UTC(), rest
+		}
+	}
+	return time.Now().UTC(), line
+}
+
 // getAgentID returns the agent ID (hostname by default)
 func getAgentID() string {
 	if hostname, err := os.Hostname(); err == nil {
@@ -46,6 +46,9 @@ type DeploymentMetrics struct {
 	DiskUsage        int64     `json:"disk_usage"`
 	RestartCount     int       `json:"restart_count"`
 	HealthCheckCount int       `json:"health_check_count"`
+	ErrorRate        float64   `json:"error_rate"`
+	AverageLatency   time.Duration `json:"average_latency"`
+	DriftedContainers int      `json:"drifted_containers"`
 	LastUpdated      time.Time `json:"last_updated"`
 }
 
@@ -63,6 +66,15 @@ type SystemMetrics struct {
 	diskUsageGauge   prometheus.GaugeVec
 	networkRxGauge   prometheus.GaugeVec
 	networkTxGauge   prometheus.GaugeVec
+	errorRateGauge   prometheus.GaugeVec
+	latencyGauge     prometheus.GaugeVec
+	driftGauge       prometheus.GaugeVec
+
+	// Autoscaler metrics
+	autoscaleCurrentGauge     prometheus.GaugeVec
+	autoscaleDesiredGauge     prometheus.GaugeVec
+	autoscaleUtilizationGauge prometheus.GaugeVec
+	autoscaleEventsTotal      prometheus.CounterVec
 
 	// Health check metrics
 	healthCheckTotal     prometheus.CounterVec
@@ -274,6 +286,30 @@ func (m *Monitor) RecordDeploymentMetrics(deploymentID string, metrics Deploymen
 	m.systemMetrics.networkRxGauge.With(labels).Set(float64(metrics.NetworkRx))
 	m.systemMetrics.networkTxGauge.With(labels).Set(float64(metrics.NetworkTx))
 	m.systemMetrics.diskUsageGauge.With(labels).Set(float64(metrics.DiskUsage))
+	m.systemMetrics.errorRateGauge.With(labels).Set(metrics.ErrorRate)
+	m.systemMetrics.latencyGauge.With(labels).Set(metrics.AverageLatency.Seconds())
+	m.systemMetrics.driftGauge.With(labels).Set(float64(metrics.DriftedContainers))
+}
+
+// RecordAutoscaling records what the autoscaler sees of a deployment
+func (m *Monitor) RecordAutoscaling(deploymentID string, current, desired int, cpuUtilization, memoryUtilization float64) {
+	labels := prometheus.Labels{"deployment_id": deploymentID}
+
+	m.systemMetrics.autoscaleCurrentGauge.With(labels).Set(float64(current))
+	m.systemMetrics.autoscaleDesiredGauge.With(labels).Set(float64(desired))
+	m.systemMetrics.autoscaleUtilizationGauge.With(prometheus.Labels{"deployment_id": deploymentID, "resource": "cpu"}).Set(cpuUtilization)
+	m.systemMetrics.autoscaleUtilizationGauge.With(prometheus.Labels{"deployment_id": deploymentID, "resource": "memory"}).Set(memoryUtilization)
+}
+
+// RecordScaleEvent records a scaling decision the autoscaler carried out
+func (m *Monitor) RecordScaleEvent(appID, direction, result string) {
+	labels := prometheus.Labels{
+		"app_id":    appID,
+		"direction": direction,
+		"result":    result,
+	}
+
+	m.systemMetrics.autoscaleEventsTotal.With(labels).Inc()
 }
 
 // RecordAPIRequest records API request metrics
@@ -381,6 +417,39 @@ func (m *Monitor) initSystemMetrics() {
 			Help: "Network transmitted bytes for deployments",
 		}, []string{"deployment_id"}),
 
+		errorRateGauge: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "superagent_deployment_error_rate",
+			Help: "Share of failed health probes for deployments",
+		}, []string{"deployment_id"}),
+
+		latencyGauge: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "superagent_deployment_latency_seconds",
+			Help: "Average health probe latency in seconds for deployments",
+		}, []string{"deployment_id"}),
+
+		driftGauge: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "superagent_deployment_drifted_containers",
+			Help: "Containers of deployments that no longer match their spec",
+		}, []string{"deployment_id"}),
+
+		// Autoscaler metrics
+		autoscaleCurrentGauge: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "superagent_autoscaler_current_replicas",
+			Help: "Replicas of autoscaled deployments",
+		}, []string{"deployment_id"}),
+		autoscaleDesiredGauge: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "superagent_autoscaler_desired_replicas",
+			Help: "Replicas the autoscaler wants for deployments",
+		}, []string{"deployment_id"}),
+		autoscaleUtilizationGauge: *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "superagent_autoscaler_utilization_percent",
+			Help: "Average utilization of the replicas of autoscaled deployments",
+		}, []string{"deployment_id", "resource"}),
+		autoscaleEventsTotal: *prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "superagent_autoscaler_scale_events_total",
+			Help: "Total number of scaling decisions the autoscaler carried out",
+		}, []string{"app_id", "direction", "result"}),
+
 		// Health check metrics
 		healthCheckTotal: *prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "superagent_health_checks_total",
@@ -444,6 +513,13 @@ func (m *Monitor) initSystemMetrics() {
 		m.systemMetrics.diskUsageGauge,
 		m.systemMetrics.networkRxGauge,
 		m.systemMetrics.networkTxGauge,
+		m.systemMetrics.errorRateGauge,
+		m.systemMetrics.latencyGauge,
+		m.systemMetrics.driftGauge,
+		m.systemMetrics.autoscaleCurrentGauge,
+		m.systemMetrics.autoscaleDesiredGauge,
+		m.systemMetrics.autoscaleUtilizationGauge,
+		m.systemMetrics.autoscaleEventsTotal,
 		m.systemMetrics.healthCheckTotal,
 		m.systemMetrics.healthCheckSuccessful,
 		m.systemMetrics.healthCheckDuration,
@@ -623,6 +699,14 @@ func (m *Monitor) RemoveDeploymentMetrics(deploymentID string) {
 	m.systemMetrics.networkRxGauge.Delete(labels)
 	m.systemMetrics.networkTxGauge.Delete(labels)
 	m.systemMetrics.diskUsageGauge.Delete(labels)
+	m.systemMetrics.errorRateGauge.Delete(labels)
+	m.systemMetrics.latencyGauge.Delete(labels)
+	m.systemMetrics.driftGauge.Delete(labels)
+	m.systemMetrics.autoscaleCurrentGauge.Delete(labels)
+	m.systemMetrics.autoscaleDesiredGauge.Delete(labels)
+	for _, resource := range []string{"cpu", "memory"} {
+		m.systemMetrics.autoscaleUtilizationGauge.Delete(prometheus.Labels{"deployment_id": deploymentID, "resource": resource})
+	}
 }
 
 // GetMetricsPort returns the metrics server port
@@ -302,6 +302,538 @@ func (s *SecureStore) ListDeployments() ([]string, error) {
 	return deploymentIDs, nil
 }
 
+// StoreRevisionHistory stores the deployed revision history of an application
+func (s *SecureStore) StoreRevisionHistory(appID string, history []map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return fmt.Errorf("failed to load data: %w", err)
+	}
+
+	if data.Data["revisions"] == nil {
+		data.Data["revisions"] = make(map[string]interface{})
+	}
+
+	revisions, ok := data.Data["revisions"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid revisions data format")
+	}
+
+	revisions[appID] = history
+	data.Timestamp = time.Now()
+
+	if err := s.saveData(data); err != nil {
+		s.auditLogger.LogSecurityEvent("REVISION_HISTORY_STORE_FAILED", false, map[string]interface{}{
+			"app_id": appID,
+			"error":  err.Error(),
+		})
+		return fmt.Errorf("failed to store revision history: %w", err)
+	}
+
+	return nil
+}
+
+// LoadRevisionHistory loads the deployed revision history of every application
+func (s *SecureStore) LoadRevisionHistory() (map[string][]map[string]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data: %w", err)
+	}
+
+	history := make(map[string][]map[string]interface{})
+
+	revisions, exists := data.Data["revisions"]
+	if !exists {
+		return history, nil
+	}
+
+	revisionsMap, ok := revisions.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid revisions data format")
+	}
+
+	for appID, entries := range revisionsMap {
+		entryList, ok := entries.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid revision history format for app %s", appID)
+		}
+
+		for _, entry := range entryList {
+			if revision, ok := entry.(map[string]interface{}); ok {
+				history[appID] = append(history[appID], revision)
+			}
+		}
+	}
+
+	return history, nil
+}
+
+// StoreGroupState stores the state of an application group
+func (s *SecureStore) StoreGroupState(appID string, state map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return fmt.Errorf("failed to load data: %w", err)
+	}
+
+	if data.Data["groups"] == nil {
+		data.Data["groups"] = make(map[string]interface{})
+	}
+
+	groups, ok := data.Data["groups"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid groups data format")
+	}
+
+	groups[appID] = state
+	data.Timestamp = time.Now()
+
+	if err := s.saveData(data); err != nil {
+		s.auditLogger.LogSecurityEvent("GROUP_STATE_STORE_FAILED", false, map[string]interface{}{
+			"app_id": appID,
+			"error":  err.Error(),
+		})
+		return fmt.Errorf("failed to store group state: %w", err)
+	}
+
+	return nil
+}
+
+// LoadGroupStates loads the state of every application group
+func (s *SecureStore) LoadGroupStates() (map[string]map[string]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data: %w", err)
+	}
+
+	states := make(map[string]map[string]interface{})
+
+	groups, exists := data.Data["groups"]
+	if !exists {
+		return states, nil
+	}
+
+	groupsMap, ok := groups.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid groups data format")
+	}
+
+	for appID, state := range groupsMap {
+		stateMap, ok := state.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid group state format for app %s", appID)
+		}
+		states[appID] = stateMap
+	}
+
+	return states, nil
+}
+
+// DeleteGroupState removes the state of an application group
+func (s *SecureStore) DeleteGroupState(appID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return fmt.Errorf("failed to load data: %w", err)
+	}
+
+	groups, exists := data.Data["groups"]
+	if !exists {
+		return nil
+	}
+
+	groupsMap, ok := groups.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid groups data format")
+	}
+
+	delete(groupsMap, appID)
+	data.Timestamp = time.Now()
+
+	if err := s.saveData(data); err != nil {
+		s.auditLogger.LogSecurityEvent("GROUP_STATE_DELETE_FAILED", false, map[string]interface{}{
+			"app_id": appID,
+			"error":  err.Error(),
+		})
+		return fmt.Errorf("failed to delete group state: %w", err)
+	}
+
+	return nil
+}
+
+// StoreJobState stores a job with its run history
+func (s *SecureStore) StoreJobState(name string, state map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return fmt.Errorf("failed to load data: %w", err)
+	}
+
+	if data.Data["jobs"] == nil {
+		data.Data["jobs"] = make(map[string]interface{})
+	}
+
+	jobs, ok := data.Data["jobs"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid jobs data format")
+	}
+
+	jobs[name] = state
+	data.Timestamp = time.Now()
+
+	if err := s.saveData(data); err != nil {
+		s.auditLogger.LogSecurityEvent("JOB_STATE_STORE_FAILED", false, map[string]interface{}{
+			"job": name,
+			"error":  err.Error(),
+		})
+		return fmt.Errorf("failed to store job state: %w", err)
+	}
+
+	return nil
+}
+
+// LoadJobStates loads every job with its run history
+func (s *SecureStore) LoadJobStates() (map[string]map[string]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data: %w", err)
+	}
+
+	states := make(map[string]map[string]interface{})
+
+	jobs, exists := data.Data["jobs"]
+	if !exists {
+		return states, nil
+	}
+
+	jobsMap, ok := jobs.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid jobs data format")
+	}
+
+	for name, state := range jobsMap {
+		stateMap, ok := state.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid job state format of job %s", name)
+		}
+		states[name] = stateMap
+	}
+
+	return states, nil
+}
+
+// DeleteJobState removes a job with its run history
+func (s *SecureStore) DeleteJobState(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return fmt.Errorf("failed to load data: %w", err)
+	}
+
+	jobs, exists := data.Data["jobs"]
+	if !exists {
+		return nil
+	}
+
+	jobsMap, ok := jobs.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid jobs data format")
+	}
+
+	delete(jobsMap, name)
+	data.Timestamp = time.Now()
+
+	if err := s.saveData(data); err != nil {
+		s.auditLogger.LogSecurityEvent("JOB_STATE_DELETE_FAILED", false, map[string]interface{}{
+			"job": name,
+			"error":  err.Error(),
+		})
+		return fmt.Errorf("failed to delete job state: %w", err)
+	}
+
+	return nil
+}
+
+// StoreSecret stores a named secret. The caller audits access to it.
+func (s *SecureStore) StoreSecret(name string, secret map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return fmt.Errorf("failed to load data: %w", err)
+	}
+
+	if data.Data["secrets"] == nil {
+		data.Data["secrets"] = make(map[string]interface{})
+	}
+
+	secrets, ok := data.Data["secrets"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid secrets data format")
+	}
+
+	secrets[name] = secret
+	data.Timestamp = time.Now()
+
+	if err := s.saveData(data); err != nil {
+		s.auditLogger.LogSecurityEvent("SECRET_STORE_FAILED", false, map[string]interface{}{
+			"secret": name,
+			"error":  err.Error(),
+		})
+		return fmt.Errorf("failed to store secret: %w", err)
+	}
+
+	return nil
+}
+
+// LoadSecret loads a named secret
+func (s *SecureStore) LoadSecret(name string) (map[string]interface{}, error) {
+	secrets, err := s.ListSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, exists := secrets[name]
+	if !exists {
+		return nil, fmt.Errorf("secret not found: %s", name)
+	}
+
+	return secret, nil
+}
+
+// ListSecrets loads every secret
+func (s *SecureStore) ListSecrets() (map[string]map[string]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data: %w", err)
+	}
+
+	result := make(map[string]map[string]interface{})
+
+	secrets, exists := data.Data["secrets"]
+	if !exists {
+		return result, nil
+	}
+
+	secretsMap, ok := secrets.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid secrets data format")
+	}
+
+	for name, secret := range secretsMap {
+		secretMap, ok := secret.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid format of secret %s", name)
+		}
+		result[name] = secretMap
+	}
+
+	return result, nil
+}
+
+// DeleteSecret removes a named secret
+func (s *SecureStore) DeleteSecret(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return fmt.Errorf("failed to load data: %w", err)
+	}
+
+	secrets, exists := data.Data["secrets"]
+	if !exists {
+		return nil
+	}
+
+	secretsMap, ok := secrets.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid secrets data format")
+	}
+
+	delete(secretsMap, name)
+	data.Timestamp = time.Now()
+
+	if err := s.saveData(data); err != nil {
+		s.auditLogger.LogSecurityEvent("SECRET_DELETE_FAILED", false, map[string]interface{}{
+			"secret": name,
+			"error":  err.Error(),
+		})
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+
+	return nil
+}
+
+// StoreAllocation stores the resources allocated to a container
+func (s *SecureStore) StoreAllocation(containerID string, allocation map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return fmt.Errorf("failed to load data: %w", err)
+	}
+
+	if data.Data["allocations"] == nil {
+		data.Data["allocations"] = make(map[string]interface{})
+	}
+
+	allocations, ok := data.Data["allocations"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid allocations data format")
+	}
+
+	allocations[containerID] = allocation
+	data.Timestamp = time.Now()
+
+	if err := s.saveData(data); err != nil {
+		s.auditLogger.LogSecurityEvent("ALLOCATION_STORE_FAILED", false, map[string]interface{}{
+			"container_id": containerID,
+			"error":        err.Error(),
+		})
+		return fmt.Errorf("failed to store allocation: %w", err)
+	}
+
+	return nil
+}
+
+// LoadAllocations loads the allocation ledger
+func (s *SecureStore) LoadAllocations() (map[string]map[string]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data: %w", err)
+	}
+
+	allocations := make(map[string]map[string]interface{})
+
+	stored, exists := data.Data["allocations"]
+	if !exists {
+		return allocations, nil
+	}
+
+	allocationsMap, ok := stored.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid allocations data format")
+	}
+
+	for containerID, allocation := range allocationsMap {
+		allocationMap, ok := allocation.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid allocation format for container %s", containerID)
+		}
+		allocations[containerID] = allocationMap
+	}
+
+	return allocations, nil
+}
+
+// DeleteAllocation removes the resources allocated to a container
+func (s *SecureStore) DeleteAllocation(containerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return fmt.Errorf("failed to load data: %w", err)
+	}
+
+	allocations, exists := data.Data["allocations"]
+	if !exists {
+		return nil
+	}
+
+	allocationsMap, ok := allocations.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid allocations data format")
+	}
+
+	delete(allocationsMap, containerID)
+	data.Timestamp = time.Now()
+
+	if err := s.saveData(data); err != nil {
+		s.auditLogger.LogSecurityEvent("ALLOCATION_DELETE_FAILED", false, map[string]interface{}{
+			"container_id": containerID,
+			"error":        err.Error(),
+		})
+		return fmt.Errorf("failed to delete allocation: %w", err)
+	}
+
+	return nil
+}
+
+// StoreUsageHistory stores the resource usage history of the deployments
+func (s *SecureStore) StoreUsageHistory(history map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return fmt.Errorf("failed to load data: %w", err)
+	}
+
+	data.Data["usage_history"] = history
+	data.Timestamp = time.Now()
+
+	if err := s.saveData(data); err != nil {
+		s.auditLogger.LogSecurityEvent("USAGE_HISTORY_STORE_FAILED", false, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return fmt.Errorf("failed to store usage history: %w", err)
+	}
+
+	return nil
+}
+
+// LoadUsageHistory loads the resource usage history of the deployments
+func (s *SecureStore) LoadUsageHistory() (map[string]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := s.loadData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data: %w", err)
+	}
+
+	history, exists := data.Data["usage_history"]
+	if !exists {
+		return make(map[string]interface{}), nil
+	}
+
+	historyMap, ok := history.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid usage history data format")
+	}
+
+	return historyMap, nil
+}
+
 // StoreConfig stores configuration data
 func (s *SecureStore) StoreConfig(config map[string]interface{}) error {
 	s.mu.Lock()